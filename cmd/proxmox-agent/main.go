@@ -1,35 +1,210 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/junlov/proxmox-ai/internal/actions"
 	"github.com/junlov/proxmox-ai/internal/config"
 	"github.com/junlov/proxmox-ai/internal/policy"
 	"github.com/junlov/proxmox-ai/internal/proxmox"
 	"github.com/junlov/proxmox-ai/internal/server"
+	"github.com/junlov/proxmox-ai/internal/tracing"
 )
 
 func main() {
 	configPath := flag.String("config", "./config.example.json", "path to JSON config")
+	selfTest := flag.Bool("self-test", false, "check every environment's credentials at startup and exit if any are rejected")
 	flag.Parse()
 
-	cfg, err := config.Load(*configPath)
+	cfg, warnings, err := config.Load(*configPath, config.WithKnownActions(proxmox.IsKnownAction))
 	if err != nil {
 		log.Fatalf("load config: %v", err)
 	}
+	logConfigWarnings(warnings)
 
-	client, err := proxmox.NewAPIClient(cfg.Environments)
+	var tracer *tracing.Tracer
+	if cfg.TraceLogPath != "" {
+		traceFile, err := os.OpenFile(cfg.TraceLogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			log.Fatalf("open trace log: %v", err)
+		}
+		defer traceFile.Close()
+		tracer = tracing.New(tracing.NewJSONExporter(traceFile))
+	}
+
+	clientOpts := []proxmox.ClientOption{
+		proxmox.WithMaxResponseBytes(cfg.MaxUpstreamResponseBytes),
+		proxmox.WithBusyStatusCodes(cfg.BusyStatusCodes),
+		proxmox.WithTracer(tracer),
+	}
+	if cfg.DebugCapturePath != "" {
+		captureFile, err := os.OpenFile(cfg.DebugCapturePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			log.Fatalf("open debug capture log: %v", err)
+		}
+		defer captureFile.Close()
+		log.Printf("debug capture enabled; upstream exchanges are written to %s", cfg.DebugCapturePath)
+		clientOpts = append(clientOpts, proxmox.WithDebugCaptureLog(captureFile))
+	}
+
+	client, err := proxmox.NewAPIClient(cfg.Environments, clientOpts...)
 	if err != nil {
 		log.Fatalf("initialize proxmox client: %v", err)
 	}
-	engine := policy.NewEngine()
-	runner := actions.NewRunner(engine, client, cfg.AuditLogPath)
+	if *selfTest {
+		runSelfTest(client)
+	}
+	engine := policy.NewEngine(
+		policy.WithRequiredTicket(cfg.ApprovalTicketMinRisk, cfg.ApprovalTicketActions),
+		policy.WithDenyMessage(cfg.DenyMessageTemplate),
+		policy.WithReadOnlyEnvironments(cfg.ReadOnlyEnvironments()),
+		policy.WithApprovalTTLs(cfg.ApprovalTTLs()),
+		policy.WithTagRules(cfg.EnvironmentTags(), tagRules(cfg.TagPolicies)),
+	)
+	var auditKey []byte
+	if cfg.AuditSigningKeyRef != "" {
+		key, err := proxmox.ResolveSecretRef(cfg.AuditSigningKeyRef)
+		if err != nil {
+			log.Fatalf("resolve audit signing key: %v", err)
+		}
+		auditKey = []byte(key)
+	}
+	runner := actions.NewRunner(engine, client, cfg.AuditLogPath,
+		actions.WithApprovalBinding(cfg.RequireApprovalBinding),
+		actions.WithTargetExistenceCheck(cfg.TargetExistenceCheck),
+		actions.WithPlanImpact(cfg.PlanImpact),
+		actions.WithCloneIDCheck(cfg.CloneIDCheck),
+		actions.WithProtectionCheck(cfg.ProtectionCheckEnabled()),
+		actions.WithDefaultNodes(cfg.DefaultNodes()),
+		actions.WithAuditFsync(cfg.AuditFsync),
+		actions.WithAuditFormat(cfg.AuditFormat),
+		actions.WithAuditSigningKey(auditKey),
+		actions.WithAuditReads(cfg.ReadAuditingEnabled()),
+		actions.WithNodeCheck(cfg.RejectUnknownNodes, time.Duration(cfg.NodeCacheTTLSeconds)*time.Second),
+		actions.WithTracer(tracer),
+	)
 
 	srv := server.New(cfg, runner)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if cfg.PendingDeleteReapIntervalSeconds > 0 {
+		go reapPendingDeletes(ctx, actions.NewReaper(runner, cfg.PendingDeleteGrace()), cfg,
+			time.Duration(cfg.PendingDeleteReapIntervalSeconds)*time.Second)
+	}
+	go reloadOnHangup(ctx, *configPath, engine)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+		// Close the audit log only after in-flight requests have drained.
+		if err := runner.Close(); err != nil {
+			log.Printf("close audit log: %v", err)
+		}
+	}()
+
 	log.Printf("starting proxmox-agent on %s", cfg.ListenAddr)
 	if err := srv.Start(); err != nil {
 		log.Fatalf("server exited: %v", err)
 	}
+	<-stopped
+}
+
+func tagRules(policies map[string]config.TagPolicy) map[string]policy.TagRule {
+	rules := make(map[string]policy.TagRule, len(policies))
+	for tag, p := range policies {
+		rules[tag] = policy.TagRule{MinRisk: p.MinRisk, RequireTicket: p.RequireTicket, ReadOnly: p.ReadOnly}
+	}
+	return rules
+}
+
+// reloadOnHangup re-reads the config on SIGHUP and applies the policy
+// settings that can change at runtime: each environment's read_only flag,
+// its tags and the tag_policies rules. A config that fails to load leaves
+// the running settings untouched.
+func reloadOnHangup(ctx context.Context, configPath string, engine *policy.Engine) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+		}
+		cfg, warnings, err := config.Load(configPath, config.WithKnownActions(proxmox.IsKnownAction))
+		if err != nil {
+			log.Printf("reload config: %v", err)
+			continue
+		}
+		logConfigWarnings(warnings)
+		readOnly := cfg.ReadOnlyEnvironments()
+		engine.SetReadOnlyEnvironments(readOnly)
+		engine.SetTagRules(cfg.EnvironmentTags(), tagRules(cfg.TagPolicies))
+		log.Printf("reloaded config; read-only environments: %v; tag policies: %d", readOnly, len(cfg.TagPolicies))
+	}
+}
+
+func logConfigWarnings(warnings []string) {
+	for _, warning := range warnings {
+		log.Printf("WARNING: %s", warning)
+	}
+}
+
+func reapPendingDeletes(ctx context.Context, reaper *actions.Reaper, cfg config.Config, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, env := range cfg.Environments {
+			results, err := reaper.Reap(env.Name)
+			if err != nil {
+				log.Printf("reap pending deletes in %q: %v", env.Name, err)
+				continue
+			}
+			for _, res := range results {
+				if res.Error != "" {
+					log.Printf("reap %s in %q: %s", res.Target, env.Name, res.Error)
+				} else if res.Deleted {
+					log.Printf("reaped %s in %q (pending since %s)", res.Target, env.Name, res.PendingSince.Format(time.RFC3339))
+				}
+			}
+		}
+	}
+}
+
+// runSelfTest logs each environment's self-test outcome and exits when any
+// environment rejected the agent's credentials. Unreachable clusters are
+// only logged: they may come up after the agent does.
+func runSelfTest(client *proxmox.APIClient) {
+	rejected := 0
+	for _, result := range client.SelfTest() {
+		switch {
+		case result.Err == nil:
+			log.Printf("self-test: environment %q ok", result.Environment)
+		case result.AuthRejected:
+			rejected++
+			log.Printf("self-test: environment %q rejected credentials: %v", result.Environment, result.Err)
+		default:
+			log.Printf("self-test: environment %q unreachable: %v", result.Environment, result.Err)
+		}
+	}
+	if rejected > 0 {
+		log.Fatalf("self-test: %d environment(s) rejected credentials", rejected)
+	}
 }
@@ -0,0 +1,135 @@
+package actions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// DefaultApprovalBindingTTL is how long a planned approval binding waits
+// for its apply before it is evicted.
+const DefaultApprovalBindingTTL = 24 * time.Hour
+
+// maxPlanBindings bounds how many approval bindings, and separately how
+// many plan transactions, are remembered at once, so callers cannot grow
+// either table without limit by planning.
+const maxPlanBindings = 100000
+
+// ErrTooManyPlans is returned when a plan would be remembered for its
+// apply but the table is full of unexpired entries.
+var ErrTooManyPlans = errors.New("too many plans awaiting apply")
+
+// approvalBindings remembers which request fingerprint each approval
+// (approver + ticket) was planned for, so a request cannot be altered between
+// approval and apply. A binding is consumed by the apply it allows and
+// evicted unused after ttl.
+type approvalBindings struct {
+	ttl time.Duration
+	max int
+	now func() time.Time
+
+	mu       sync.Mutex
+	bindings map[string]approvalBinding
+}
+
+type approvalBinding struct {
+	fingerprint string
+	planned     time.Time
+}
+
+func newApprovalBindings() *approvalBindings {
+	return &approvalBindings{
+		ttl:      DefaultApprovalBindingTTL,
+		max:      maxPlanBindings,
+		now:      time.Now,
+		bindings: make(map[string]approvalBinding),
+	}
+}
+
+func (b *approvalBindings) Record(req proxmox.ActionRequest) error {
+	key := approvalKey(req)
+	if key == "" {
+		return nil
+	}
+	fingerprint, err := requestFingerprint(req)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	for k, binding := range b.bindings {
+		if now.Sub(binding.planned) >= b.ttl {
+			delete(b.bindings, k)
+		}
+	}
+	if _, ok := b.bindings[key]; !ok && len(b.bindings) >= b.max {
+		return ErrTooManyPlans
+	}
+	b.bindings[key] = approvalBinding{fingerprint: fingerprint, planned: now}
+	return nil
+}
+
+// Check returns a denial reason, or "" when the request carries no approval
+// metadata or matches the planned fingerprint. A matching binding is
+// consumed unless req is a dry run, so each plan allows one apply.
+func (b *approvalBindings) Check(req proxmox.ActionRequest) string {
+	key := approvalKey(req)
+	if key == "" {
+		return ""
+	}
+	fingerprint, err := requestFingerprint(req)
+	if err != nil {
+		return "unable to fingerprint request for approval binding"
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	planned, ok := b.bindings[key]
+	if !ok || b.now().Sub(planned.planned) >= b.ttl {
+		delete(b.bindings, key)
+		return "approval was not granted on a plan for this request"
+	}
+	if planned.fingerprint != fingerprint {
+		return "approval was granted for a different request; re-run plan"
+	}
+	if !req.DryRun {
+		delete(b.bindings, key)
+	}
+	return ""
+}
+
+func approvalKey(req proxmox.ActionRequest) string {
+	approvedBy := strings.TrimSpace(req.ApprovedBy)
+	if approvedBy == "" {
+		return ""
+	}
+	return req.Environment + "|" + approvedBy + "|" + strings.TrimSpace(req.ApprovalTicket)
+}
+
+// requestFingerprint hashes the fields that define what a request does.
+// Approval metadata and dry_run are excluded so a dry-run plan binds the
+// later real apply.
+func requestFingerprint(req proxmox.ActionRequest) (string, error) {
+	b, err := json.Marshal(struct {
+		Environment string             `json:"environment"`
+		Action      proxmox.ActionType `json:"action"`
+		Target      string             `json:"target"`
+		Params      map[string]any     `json:"params,omitempty"`
+	}{
+		Environment: req.Environment,
+		Action:      req.Action,
+		Target:      req.Target,
+		Params:      req.Params,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,146 @@
+package actions
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/policy"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+func bindingTestRequest(newID int) proxmox.ActionRequest {
+	return proxmox.ActionRequest{
+		Environment:    "home",
+		Action:         proxmox.ActionDeleteVM,
+		Target:         "vm/101",
+		Params:         map[string]any{"node": "pve", "purge": newID},
+		ApprovedBy:     "ops-user",
+		ApprovalTicket: "CHG-1",
+	}
+}
+
+func TestApplyWithApprovalBindingAcceptsMatchingFingerprint(t *testing.T) {
+	client := &fakeClient{}
+	runner := NewRunner(policy.NewEngine(), client, "", WithApprovalBinding(true))
+
+	if _, err := runner.Plan(bindingTestRequest(1)); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if _, err := runner.Apply(bindingTestRequest(1)); err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	if client.calls != 1 {
+		t.Fatalf("expected one execution call, got %d", client.calls)
+	}
+}
+
+func TestApplyWithApprovalBindingRejectsMismatchedFingerprint(t *testing.T) {
+	client := &fakeClient{}
+	runner := NewRunner(policy.NewEngine(), client, "", WithApprovalBinding(true))
+
+	if _, err := runner.Plan(bindingTestRequest(1)); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	_, err := runner.Apply(bindingTestRequest(0))
+	if err == nil {
+		t.Fatal("expected apply with altered params to be denied")
+	}
+	if !strings.Contains(err.Error(), "approval was granted for a different request") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.calls != 0 {
+		t.Fatalf("expected no execution call, got %d", client.calls)
+	}
+}
+
+func TestApplyWithApprovalBindingRejectsUnplannedApproval(t *testing.T) {
+	client := &fakeClient{}
+	runner := NewRunner(policy.NewEngine(), client, "", WithApprovalBinding(true))
+
+	if _, err := runner.Apply(bindingTestRequest(1)); err == nil {
+		t.Fatal("expected apply without a bound plan to be denied")
+	}
+	if client.calls != 0 {
+		t.Fatalf("expected no execution call, got %d", client.calls)
+	}
+}
+
+func TestInlinePlanDoesNotBindApproval(t *testing.T) {
+	client := &fakeClient{}
+	runner := NewRunner(policy.NewEngine(), client, "", WithApprovalBinding(true))
+
+	if _, err := runner.PlanInline(bindingTestRequest(1)); err != nil {
+		t.Fatalf("PlanInline returned error: %v", err)
+	}
+	if _, err := runner.Apply(bindingTestRequest(1)); err == nil || !strings.Contains(err.Error(), "approval was not granted on a plan") {
+		t.Fatalf("expected an inline plan not to satisfy the binding, got %v", err)
+	}
+	if client.calls != 0 {
+		t.Fatalf("expected no execution call, got %d", client.calls)
+	}
+}
+
+func TestApprovalBindingIsConsumedAndExpires(t *testing.T) {
+	client := &fakeClient{}
+	runner := NewRunner(policy.NewEngine(), client, "", WithApprovalBinding(true))
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	runner.approvals.now = func() time.Time { return now }
+
+	if _, err := runner.Plan(bindingTestRequest(1)); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if _, err := runner.Apply(bindingTestRequest(1)); err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	if _, err := runner.Apply(bindingTestRequest(1)); err == nil || !strings.Contains(err.Error(), "approval was not granted on a plan") {
+		t.Fatalf("expected the binding to be consumed by the first apply, got %v", err)
+	}
+
+	if _, err := runner.Plan(bindingTestRequest(1)); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	now = now.Add(DefaultApprovalBindingTTL)
+	if _, err := runner.Apply(bindingTestRequest(1)); err == nil {
+		t.Fatal("expected an expired binding to be rejected")
+	}
+	if len(runner.approvals.bindings) != 0 {
+		t.Fatalf("expected the expired binding to be evicted, got %d", len(runner.approvals.bindings))
+	}
+	if client.calls != 1 {
+		t.Fatalf("expected exactly one execution call, got %d", client.calls)
+	}
+}
+
+func TestPlanRefusesBindingsBeyondCapacity(t *testing.T) {
+	runner := NewRunner(policy.NewEngine(), &fakeClient{}, "", WithApprovalBinding(true))
+	runner.approvals.max = 1
+
+	if _, err := runner.Plan(bindingTestRequest(1)); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	// Re-planning the same approval replaces its binding.
+	if _, err := runner.Plan(bindingTestRequest(2)); err != nil {
+		t.Fatalf("re-plan returned error: %v", err)
+	}
+	other := bindingTestRequest(1)
+	other.ApprovalTicket = "CHG-2"
+	if _, err := runner.Plan(other); !errors.Is(err, ErrTooManyPlans) {
+		t.Fatalf("expected a full binding table to refuse, got %v", err)
+	}
+}
+
+func TestPlanRefusesTransactionsBeyondCapacity(t *testing.T) {
+	runner := NewRunner(policy.NewEngine(), &fakeClient{}, "")
+	runner.transactions.max = 1
+	req := proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/101", Params: map[string]any{"node": "pve"}, TransactionID: "tx-1"}
+
+	if _, err := runner.Plan(req); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	req.TransactionID = "tx-2"
+	if _, err := runner.Plan(req); !errors.Is(err, ErrTooManyPlans) {
+		t.Fatalf("expected a full transaction table to refuse, got %v", err)
+	}
+}
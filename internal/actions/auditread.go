@@ -0,0 +1,114 @@
+package actions
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/junlov/proxmox-ai/internal/policy"
+)
+
+// ErrAuditDisabled is returned when reading the audit log of a runner that
+// was created without one.
+var ErrAuditDisabled = errors.New("audit log is not configured")
+
+// AuditRecord is the part of an audit record that exports report.
+type AuditRecord struct {
+	TS       string          `json:"ts"`
+	Kind     string          `json:"kind"`
+	Actor    string          `json:"actor"`
+	Decision policy.Decision `json:"decision"`
+	Request  struct {
+		Environment string `json:"environment"`
+		Action      string `json:"action"`
+		Target      string `json:"target"`
+	} `json:"request"`
+}
+
+// AuditRecords calls fn for each record in the runner's audit log, oldest
+// first, in either audit format. A log that does not exist yet has no
+// records. It stops at the first error from fn.
+func (r *Runner) AuditRecords(fn func(AuditRecord) error) error {
+	if r.auditTo == "" {
+		return ErrAuditDisabled
+	}
+	err := scanAuditLines(r.auditTo, func(n int, line []byte) error {
+		var record AuditRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("audit record on line %d: %w", n, err)
+		}
+		return fn(record)
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// scanAuditLines calls fn with each record line of the audit log at path and
+// its 1-based line number. Array-format logs put one record per line between
+// the brackets; the brackets and record separators are skipped. A path
+// ending in .gz, as logrotate leaves compressed copies, is decompressed.
+func scanAuditLines(path string, fn func(n int, line []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSuffix(bytes.TrimSpace(scanner.Bytes()), []byte(","))
+		if len(line) == 0 || bytes.Equal(line, []byte("[")) || bytes.Equal(line, []byte("]")) {
+			continue
+		}
+		if err := fn(n, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// rotatedAuditLogs returns the copies of the audit log at path that
+// logrotate left beside it, such as path.1, path.2.gz or path-20260101.
+func rotatedAuditLogs(path string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(path)
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasPrefix(name, base+".") || strings.HasPrefix(name, base+"-")) {
+			continue
+		}
+		files = append(files, filepath.Join(filepath.Dir(path), name))
+	}
+	return files, nil
+}
+
+// AuditHealth reports the audit writer's backlog and failures, or
+// ErrAuditDisabled when the runner keeps no audit log.
+func (r *Runner) AuditHealth() (AuditHealth, error) {
+	if r.auditLog == nil {
+		return AuditHealth{}, ErrAuditDisabled
+	}
+	return r.auditLog.health(), nil
+}
@@ -0,0 +1,84 @@
+package actions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// auditSigField is appended as the last field of a signed record. The MAC
+// covers the previous record's signature followed by the record exactly as
+// serialized without it, so verification needs no re-encoding and a removed
+// or reordered record breaks the chain. Each file starts a new chain.
+const auditSigField = `,"sig":"`
+
+var auditSigSuffix = regexp.MustCompile(`,"sig":"([0-9a-f]{64})"}$`)
+
+// WithAuditSigningKey signs each audit record with HMAC-SHA256 under key,
+// chained to the record before it, and stores the hex signature in the
+// record's "sig" field.
+func WithAuditSigningKey(key []byte) Option {
+	return func(r *Runner) {
+		if len(key) > 0 {
+			r.auditKey = key
+		}
+	}
+}
+
+// signAuditLine appends the signature chained to prev to line, a JSON object
+// without trailing newline, and returns the signed line and its signature.
+func signAuditLine(line []byte, prev string, key []byte) ([]byte, string) {
+	mac := auditMAC(line, prev, key)
+	signed := make([]byte, 0, len(line)+len(auditSigField)+len(mac)+2)
+	signed = append(signed, line[:len(line)-1]...)
+	signed = append(signed, auditSigField...)
+	signed = append(signed, mac...)
+	return append(signed, '"', '}'), mac
+}
+
+func auditMAC(line []byte, prev string, key []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(prev))
+	h.Write(line)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lastAuditSig returns the signature of the last record in the audit log at
+// path, or "" when the log is empty or its last record is unsigned.
+func lastAuditSig(path string) (string, error) {
+	var sig string
+	err := scanAuditLines(path, func(_ int, line []byte) error {
+		sig = ""
+		if match := auditSigSuffix.FindSubmatch(line); match != nil {
+			sig = string(match[1])
+		}
+		return nil
+	})
+	return sig, err
+}
+
+// VerifyAuditSignatures checks every record in the audit log at path against
+// key and the record before it. It fails on the first unsigned, altered or
+// out-of-chain record, naming its line.
+func VerifyAuditSignatures(path string, key []byte) error {
+	if len(key) == 0 {
+		return errors.New("audit signing key is empty")
+	}
+	var prev string
+	return scanAuditLines(path, func(n int, line []byte) error {
+		match := auditSigSuffix.FindSubmatchIndex(line)
+		if match == nil {
+			return fmt.Errorf("audit record on line %d is not signed", n)
+		}
+		sig := line[match[2]:match[3]]
+		unsigned := append(line[:match[0]:match[0]], '}')
+		if !hmac.Equal([]byte(auditMAC(unsigned, prev, key)), sig) {
+			return fmt.Errorf("audit record on line %d fails signature verification", n)
+		}
+		prev = string(sig)
+		return nil
+	})
+}
@@ -0,0 +1,106 @@
+package actions
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/policy"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+func writeSignedAuditLog(t *testing.T, key []byte) string {
+	t.Helper()
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	runner := NewRunner(policy.NewEngine(), &fakeClient{}, auditPath, WithAuditSigningKey(key))
+	req := proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/101", Params: map[string]any{"node": "pve"}}
+	if _, err := runner.Plan(req); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if _, err := runner.Apply(req); err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	if err := runner.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	return auditPath
+}
+
+func TestVerifyAuditSignaturesAcceptsSignedLog(t *testing.T) {
+	key := []byte("audit-key")
+	auditPath := writeSignedAuditLog(t, key)
+
+	if err := VerifyAuditSignatures(auditPath, key); err != nil {
+		t.Fatalf("expected signed log to verify, got %v", err)
+	}
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit file: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("signed record is not valid JSON: %v", err)
+		}
+		if sig, _ := record["sig"].(string); len(sig) != 64 {
+			t.Fatalf("expected hex sig in record, got %v", record["sig"])
+		}
+	}
+	if err := VerifyAuditSignatures(auditPath, []byte("other-key")); err == nil {
+		t.Fatal("expected verification with the wrong key to fail")
+	}
+}
+
+func TestVerifyAuditSignaturesDetectsTamperedRecord(t *testing.T) {
+	key := []byte("audit-key")
+	auditPath := writeSignedAuditLog(t, key)
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit file: %v", err)
+	}
+	tampered := bytes.Replace(b, []byte(`"vm/101"`), []byte(`"vm/102"`), 1)
+	if err := os.WriteFile(auditPath, tampered, 0o600); err != nil {
+		t.Fatalf("write audit file: %v", err)
+	}
+
+	err = VerifyAuditSignatures(auditPath, key)
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("expected line 1 to fail verification, got %v", err)
+	}
+}
+
+func TestVerifyAuditSignaturesChainsAcrossRestartAndDetectsRemoval(t *testing.T) {
+	key := []byte("audit-key")
+	auditPath := writeSignedAuditLog(t, key)
+	runner := NewRunner(policy.NewEngine(), &fakeClient{}, auditPath, WithAuditSigningKey(key))
+	req := proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStopVM, Target: "vm/101", Params: map[string]any{"node": "pve"}}
+	if _, err := runner.Plan(req); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if err := runner.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if err := VerifyAuditSignatures(auditPath, key); err != nil {
+		t.Fatalf("expected the chain to continue across a restart, got %v", err)
+	}
+
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit file: %v", err)
+	}
+	lines := strings.SplitAfter(strings.TrimSpace(string(b)), "\n")
+	if len(lines) < 3 {
+		t.Fatalf("expected at least 3 records, got %d", len(lines))
+	}
+	removed := lines[0] + strings.Join(lines[2:], "")
+	if err := os.WriteFile(auditPath, []byte(removed), 0o600); err != nil {
+		t.Fatalf("write audit file: %v", err)
+	}
+	err = VerifyAuditSignatures(auditPath, key)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected the record after the removed one to fail, got %v", err)
+	}
+}
@@ -0,0 +1,375 @@
+package actions
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errAuditClosed = errors.New("audit writer is closed")
+
+// Audit log formats for WithAuditFormat.
+const (
+	AuditFormatNDJSON = "ndjson"
+	AuditFormatArray  = "array"
+)
+
+// WithAuditFormat selects how records are laid out in the audit log:
+// AuditFormatNDJSON (the default) writes one JSON object per line,
+// AuditFormatArray keeps the file a valid JSON array, one record per line.
+func WithAuditFormat(format string) Option {
+	return func(r *Runner) {
+		r.auditArray = format == AuditFormatArray
+	}
+}
+
+type auditWrite struct {
+	line []byte
+	done chan error
+}
+
+// auditWriter serializes audit records through one goroutine that owns the
+// file handle. Writers block until their record is written (and synced, when
+// enabled); records queued together share a single fsync. Before each batch
+// the writer checks whether the log was rotated and, if so, reopens path.
+// Signing happens here too, so the signature chain follows file order.
+type auditWriter struct {
+	path  string
+	fsync bool
+	array bool
+	key   []byte
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan auditWrite
+	exited chan struct{}
+
+	file *os.File
+	// In array mode, end is the offset just past the last record (or the
+	// opening bracket) and empty reports whether there are no records yet.
+	end   int64
+	empty bool
+	// prevSig is the signature of the last record in the open file.
+	prevSig string
+
+	// pending counts records handed to Write and not yet answered, so it
+	// includes writers blocked on a full queue. failed counts records whose
+	// write or sync failed; lastWrite is the UnixNano of the last success.
+	pending   atomic.Int64
+	failed    atomic.Int64
+	lastWrite atomic.Int64
+	// beforeFlush, when set, runs ahead of each batch; tests use it to
+	// stall the writer.
+	beforeFlush func()
+}
+
+// AuditHealth reports how the audit writer is keeping up.
+type AuditHealth struct {
+	// QueueDepth is the number of records waiting to be written.
+	QueueDepth int64
+	// QueueCapacity is how many records queue before writers block.
+	QueueCapacity int
+	// FailedWrites counts records that could not be written or synced.
+	FailedWrites int64
+	// LastWrite is when a record was last written; zero if never.
+	LastWrite time.Time
+}
+
+func (w *auditWriter) health() AuditHealth {
+	h := AuditHealth{
+		QueueDepth:    w.pending.Load(),
+		QueueCapacity: cap(w.queue),
+		FailedWrites:  w.failed.Load(),
+	}
+	if last := w.lastWrite.Load(); last != 0 {
+		h.LastWrite = time.Unix(0, last).UTC()
+	}
+	return h
+}
+
+// newAuditWriter starts a writer for path; a non-nil key signs each record
+// and a non-nil beforeFlush runs ahead of each batch.
+func newAuditWriter(path string, fsync, array bool, key []byte, beforeFlush func()) *auditWriter {
+	w := &auditWriter{
+		path:        path,
+		fsync:       fsync,
+		array:       array,
+		key:         key,
+		queue:       make(chan auditWrite, 64),
+		exited:      make(chan struct{}),
+		beforeFlush: beforeFlush,
+	}
+	go w.run()
+	return w
+}
+
+// Write appends line, which must be a complete newline-terminated record.
+func (w *auditWriter) Write(line []byte) error {
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return errAuditClosed
+	}
+	done := make(chan error, 1)
+	w.pending.Add(1)
+	w.queue <- auditWrite{line: line, done: done}
+	w.mu.RUnlock()
+	return <-done
+}
+
+// Close flushes queued records, syncs and closes the file. Later writes fail.
+func (w *auditWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+	<-w.exited
+	if w.file == nil {
+		return nil
+	}
+	syncErr := w.file.Sync()
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return syncErr
+}
+
+func (w *auditWriter) run() {
+	defer close(w.exited)
+	for first := range w.queue {
+		batch := []auditWrite{first}
+	drain:
+		for {
+			select {
+			case next, ok := <-w.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		w.flush(batch)
+	}
+}
+
+func (w *auditWriter) flush(batch []auditWrite) {
+	if w.beforeFlush != nil {
+		w.beforeFlush()
+	}
+	errs := make([]error, len(batch))
+	if w.file != nil && w.rotated() {
+		// logrotate moved or truncated the file; finish with the old handle
+		// and start writing to whatever is at path now.
+		w.file.Close()
+		w.file = nil
+	}
+	if err := w.open(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+	} else {
+		for i, item := range batch {
+			errs[i] = w.writeRecord(item.line)
+		}
+		if w.fsync {
+			if err := w.file.Sync(); err != nil {
+				for i := range errs {
+					if errs[i] == nil {
+						errs[i] = err
+					}
+				}
+			}
+		}
+	}
+	for i, item := range batch {
+		if errs[i] != nil {
+			w.failed.Add(1)
+		} else {
+			w.lastWrite.Store(time.Now().UnixNano())
+		}
+		w.pending.Add(-1)
+		item.done <- errs[i]
+	}
+}
+
+// writeRecord signs line when a key is set and writes it, advancing the
+// signature chain only once the record is in the file.
+func (w *auditWriter) writeRecord(line []byte) error {
+	if w.key == nil {
+		return w.write(line)
+	}
+	signed, sig := signAuditLine(bytes.TrimRight(line, "\n"), w.prevSig, w.key)
+	if err := w.write(append(signed, '\n')); err != nil {
+		return err
+	}
+	w.prevSig = sig
+	return nil
+}
+
+func (w *auditWriter) write(line []byte) error {
+	if !w.array {
+		_, err := w.file.Write(line)
+		return err
+	}
+	// Overwrite the closing bracket with the record and a new bracket, so
+	// the file is a complete array again after every write. The write only
+	// ever grows the file, so nothing of the old tail survives it.
+	sep := ",\n"
+	if w.empty {
+		sep = "\n"
+	}
+	record := bytes.TrimRight(line, "\n")
+	buf := make([]byte, 0, len(sep)+len(record)+3)
+	buf = append(append(append(buf, sep...), record...), "\n]\n"...)
+	if _, err := w.file.WriteAt(buf, w.end); err != nil {
+		return err
+	}
+	w.end += int64(len(sep) + len(record))
+	w.empty = false
+	return nil
+}
+
+// rotated reports whether path no longer names the open file, or, in array
+// mode, whether the file was truncated underneath the writer.
+func (w *auditWriter) rotated() bool {
+	open, err := w.file.Stat()
+	if err != nil {
+		return true
+	}
+	current, err := os.Stat(w.path)
+	if err != nil {
+		return true
+	}
+	if !os.SameFile(open, current) {
+		return true
+	}
+	return w.array && current.Size() < w.end
+}
+
+func (w *auditWriter) open() error {
+	if w.file != nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(w.path), 0o755); err != nil {
+		return err
+	}
+	if w.array {
+		if err := w.openArray(); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		w.file = f
+	}
+	if w.key == nil {
+		return nil
+	}
+	// Continue the chain a previous run or handle left in this file.
+	sig, err := lastAuditSig(w.path)
+	if err != nil {
+		w.file.Close()
+		w.file = nil
+		return err
+	}
+	w.prevSig = sig
+	return nil
+}
+
+// openArray opens the log for read-write and finds the end of the last
+// complete record a previous run left, starting a new array in an empty
+// file. Whatever follows that record, such as a record cut short by a
+// crash, is replaced with the closing bracket.
+func (w *auditWriter) openArray() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if info.Size() == 0 {
+		if _, err := f.Write([]byte("[\n]\n")); err != nil {
+			f.Close()
+			return err
+		}
+		w.file, w.end, w.empty = f, 1, true
+		return nil
+	}
+	end, empty, err := lastArrayRecordEnd(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("audit log %s: %w", w.path, err)
+	}
+	if _, err := f.WriteAt([]byte("\n]\n"), end); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Truncate(end + 3); err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.end, w.empty = f, end, empty
+	return nil
+}
+
+// lastArrayRecordEnd reads an array-format log, one record per line after
+// the opening bracket, and returns the offset just past the last complete
+// record (or the bracket) and whether there are no records.
+func lastArrayRecordEnd(r io.Reader) (end int64, empty bool, err error) {
+	reader := bufio.NewReader(r)
+	var offset int64
+	opened := false
+	empty = true
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		start := offset
+		offset += int64(len(line))
+		trimmed := bytes.TrimSpace(line)
+		switch {
+		case len(trimmed) == 0:
+		case !opened:
+			if !bytes.Equal(trimmed, []byte("[")) {
+				return 0, false, errors.New("not a JSON array")
+			}
+			opened = true
+			end = start + int64(bytes.IndexByte(line, '[')) + 1
+		default:
+			record := bytes.TrimSuffix(trimmed, []byte(","))
+			if len(record) == 0 || record[0] != '{' || !json.Valid(record) {
+				// The closing bracket or a torn record: the array ends
+				// with the record before it.
+				return end, empty, nil
+			}
+			end = start + int64(bytes.Index(line, record)+len(record))
+			empty = false
+		}
+		if readErr == io.EOF {
+			if !opened {
+				return 0, false, errors.New("not a JSON array")
+			}
+			return end, empty, nil
+		}
+		if readErr != nil {
+			return 0, false, readErr
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package actions
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/policy"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// writeAuditRecords runs n applies through a fresh runner writing to path.
+func writeAuditRecords(t *testing.T, path string, n int, opts ...Option) {
+	t.Helper()
+	runner := NewRunner(policy.NewEngine(), &fakeClient{}, path, opts...)
+	for i := 0; i < n; i++ {
+		req := proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/101", Params: map[string]any{"node": "pve"}}
+		if _, err := runner.Apply(req); err != nil {
+			t.Fatalf("Apply returned error: %v", err)
+		}
+	}
+	if err := runner.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+}
+
+func TestAuditLogNDJSONHasOneRecordPerLine(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	writeAuditRecords(t, auditPath, 3)
+
+	f, err := os.Open(auditPath)
+	if err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	defer f.Close()
+	records := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("line %d is not a JSON object: %v", records+1, err)
+		}
+		records++
+	}
+	if records != 3 {
+		t.Fatalf("expected 3 records, got %d", records)
+	}
+}
+
+func TestAuditLogArrayStaysValidAcrossWritesAndRestarts(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	key := []byte("audit-key")
+	writeAuditRecords(t, auditPath, 3, WithAuditFormat(AuditFormatArray), WithAuditSigningKey(key))
+	writeAuditRecords(t, auditPath, 2, WithAuditFormat(AuditFormatArray), WithAuditSigningKey(key))
+
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	var records []map[string]any
+	if err := json.Unmarshal(b, &records); err != nil {
+		t.Fatalf("audit log is not a JSON array: %v\n%s", err, b)
+	}
+	if len(records) != 5 || records[4]["kind"] != "apply" {
+		t.Fatalf("expected 5 apply records, got %d", len(records))
+	}
+	if !strings.HasPrefix(string(b), "[\n{") || !strings.HasSuffix(string(b), "}\n]\n") {
+		t.Fatalf("expected one record per line between brackets, got %q", b)
+	}
+	if err := VerifyAuditSignatures(auditPath, key); err != nil {
+		t.Fatalf("expected array log to verify, got %v", err)
+	}
+}
+
+func TestAuditLogArrayRecoversFromTornRecord(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	key := []byte("audit-key")
+	writeAuditRecords(t, auditPath, 2, WithAuditFormat(AuditFormatArray), WithAuditSigningKey(key))
+	// Simulate a crash part-way through the next write.
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	torn := strings.TrimSuffix(string(b), "\n]\n") + ",\n{\"kind\":\"ap"
+	if err := os.WriteFile(auditPath, []byte(torn), 0o644); err != nil {
+		t.Fatalf("write torn audit log: %v", err)
+	}
+
+	writeAuditRecords(t, auditPath, 1, WithAuditFormat(AuditFormatArray), WithAuditSigningKey(key))
+
+	b, err = os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	var records []map[string]any
+	if err := json.Unmarshal(b, &records); err != nil {
+		t.Fatalf("audit log is not a JSON array after recovery: %v\n%s", err, b)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected the torn record dropped and 3 records kept, got %d", len(records))
+	}
+	if err := VerifyAuditSignatures(auditPath, key); err != nil {
+		t.Fatalf("expected recovered log to verify, got %v", err)
+	}
+}
+
+func TestAuditLogArrayRefusesNDJSONFile(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	writeAuditRecords(t, auditPath, 1)
+
+	runner := NewRunner(policy.NewEngine(), &fakeClient{}, auditPath, WithAuditFormat(AuditFormatArray))
+	defer runner.Close()
+	_, err := runner.Apply(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/101", Params: map[string]any{"node": "pve"}})
+	if err == nil || !strings.Contains(err.Error(), "not a JSON array") {
+		t.Fatalf("expected array mode to refuse an NDJSON log, got %v", err)
+	}
+}
+
+func TestAuditHealthReportsBacklogWhileWriterStalled(t *testing.T) {
+	release := make(chan struct{})
+	w := newAuditWriter(filepath.Join(t.TempDir(), "audit.log"), false, false, nil, func() { <-release })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.Write([]byte("{}\n")); err != nil {
+				t.Errorf("Write returned error: %v", err)
+			}
+		}()
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for w.health().QueueDepth < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if h := w.health(); h.QueueDepth != 3 || !h.LastWrite.IsZero() {
+		t.Fatalf("expected 3 queued records and no write yet, got %+v", h)
+	}
+
+	close(release)
+	wg.Wait()
+	h := w.health()
+	if h.QueueDepth != 0 || h.FailedWrites != 0 || h.LastWrite.IsZero() {
+		t.Fatalf("expected a drained queue after the writer resumed, got %+v", h)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+}
+
+func TestAuditHealthCountsFailedWrites(t *testing.T) {
+	blocker := filepath.Join(t.TempDir(), "not-a-dir")
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatalf("write blocker file: %v", err)
+	}
+	w := newAuditWriter(filepath.Join(blocker, "audit.log"), false, false, nil, nil)
+	defer w.Close()
+	if err := w.Write([]byte("{}\n")); err == nil {
+		t.Fatal("expected write under a regular file to fail")
+	}
+	if h := w.health(); h.FailedWrites != 1 || h.QueueDepth != 0 {
+		t.Fatalf("expected one failed write, got %+v", h)
+	}
+}
+
+func TestAuditWriterReopensRotatedLog(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	w := newAuditWriter(auditPath, false, false, nil, nil)
+	defer w.Close()
+	if err := w.Write([]byte("{\"n\":1}\n")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if err := os.Rename(auditPath, auditPath+".1"); err != nil {
+		t.Fatalf("rotate audit log: %v", err)
+	}
+	if err := w.Write([]byte("{\"n\":2}\n")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	for path, want := range map[string]string{auditPath + ".1": "{\"n\":1}\n", auditPath: "{\"n\":2}\n"} {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		if string(b) != want {
+			t.Fatalf("expected %s to hold %q, got %q", path, want, b)
+		}
+	}
+}
+
+func TestAuditWriterArrayRestartsAfterTruncation(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	w := newAuditWriter(auditPath, false, true, nil, nil)
+	defer w.Close()
+	for i := 0; i < 2; i++ {
+		if err := w.Write([]byte("{}\n")); err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+	}
+	if err := os.Truncate(auditPath, 0); err != nil {
+		t.Fatalf("truncate audit log: %v", err)
+	}
+	if err := w.Write([]byte("{\"n\":3}\n")); err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	if string(b) != "[\n{\"n\":3}\n]\n" {
+		t.Fatalf("expected a fresh array after copytruncate, got %q", b)
+	}
+}
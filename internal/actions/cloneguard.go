@@ -0,0 +1,45 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// ErrVMIDExists is matched by errors.Is when a clone was refused because
+// its newid is already in use.
+var ErrVMIDExists = errors.New("vmid already exists")
+
+// WithCloneIDCheck makes apply list the cluster's guests first and refuse
+// a clone whose newid is already taken on any node, instead of letting
+// Proxmox fail it late. It costs one extra upstream read.
+func WithCloneIDCheck(enabled bool) Option {
+	return func(r *Runner) {
+		r.cloneIDCheck = enabled
+	}
+}
+
+// checkCloneID is best effort: only a guest that is confirmed to hold newid
+// stops the clone; a failed or unreadable listing lets it proceed. VMs and
+// containers share one ID space, so either kind conflicts.
+func (r *Runner) checkCloneID(req proxmox.ActionRequest) error {
+	newID, _ := proxmox.DescribeRequest(req)["newid"].(int64)
+	if newID <= 0 {
+		return nil
+	}
+	result, err := r.client.Execute(followUpRead(req, proxmox.ActionReadInventory, "inventory/all", false))
+	if err != nil {
+		return nil
+	}
+	items, _ := result.Data.([]any)
+	for _, item := range items {
+		resource, _ := item.(map[string]any)
+		if vmid, _ := resource["vmid"].(float64); int64(vmid) != newID {
+			continue
+		}
+		node, _ := resource["node"].(string)
+		return fmt.Errorf("%w: guest %d is already on node %q", ErrVMIDExists, newID, node)
+	}
+	return nil
+}
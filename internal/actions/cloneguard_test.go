@@ -0,0 +1,65 @@
+package actions
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/policy"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// inventoryClient answers read_inventory with guests and records every
+// action it is asked to run.
+type inventoryClient struct {
+	guests  []any
+	actions []proxmox.ActionType
+}
+
+func (c *inventoryClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	c.actions = append(c.actions, req.Action)
+	if req.Action == proxmox.ActionReadInventory {
+		return proxmox.ActionResult{Status: "ok", Data: c.guests}, nil
+	}
+	return proxmox.ActionResult{Status: "submitted"}, nil
+}
+
+func TestApplyRefusesCloneOntoVMIDUsedOnAnotherNode(t *testing.T) {
+	client := &inventoryClient{guests: []any{
+		map[string]any{"type": "qemu", "vmid": float64(100), "node": "pve1"},
+		map[string]any{"type": "lxc", "vmid": float64(200), "node": "pve3"},
+	}}
+	runner := NewRunner(policy.NewEngine(), client, "", WithCloneIDCheck(true))
+
+	_, err := runner.Apply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionCloneVM,
+		Target:      "vm/100",
+		Params:      map[string]any{"node": "pve1", "newid": float64(200), "target": "pve2"},
+	})
+	if !errors.Is(err, ErrVMIDExists) {
+		t.Fatalf("expected ErrVMIDExists, got %v", err)
+	}
+	if len(client.actions) != 1 || client.actions[0] != proxmox.ActionReadInventory {
+		t.Fatalf("expected only the cluster listing, got %v", client.actions)
+	}
+}
+
+func TestApplyClonesWhenNewVMIDIsFree(t *testing.T) {
+	client := &inventoryClient{guests: []any{
+		map[string]any{"type": "qemu", "vmid": float64(100), "node": "pve1"},
+	}}
+	runner := NewRunner(policy.NewEngine(), client, "", WithCloneIDCheck(true))
+
+	_, err := runner.Apply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionCloneVM,
+		Target:      "vm/100",
+		Params:      map[string]any{"node": "pve1", "newid": float64(200)},
+	})
+	if err != nil {
+		t.Fatalf("expected the clone to proceed, got %v", err)
+	}
+	if len(client.actions) != 2 || client.actions[1] != proxmox.ActionCloneVM {
+		t.Fatalf("expected the cluster listing and the clone, got %v", client.actions)
+	}
+}
@@ -0,0 +1,36 @@
+package actions
+
+import (
+	"strings"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// WithDefaultNodes sets, per environment name, the node a VM action on a
+// vm/<id> target runs against when it does not name params.node.
+func WithDefaultNodes(nodes map[string]string) Option {
+	return func(r *Runner) {
+		if len(nodes) > 0 {
+			r.defaultNode = nodes
+		}
+	}
+}
+
+// applyDefaultNode fills params.node from the environment's default node.
+// The caller's params map is copied, not modified. Requests that already
+// name a node, or target node/vmid, are left alone.
+func (r *Runner) applyDefaultNode(req *proxmox.ActionRequest) {
+	node, ok := r.defaultNode[req.Environment]
+	if !ok || !proxmox.IsVMAction(req.Action) || !strings.HasPrefix(strings.TrimSpace(req.Target), "vm/") {
+		return
+	}
+	if current, _ := req.Params["node"].(string); strings.TrimSpace(current) != "" {
+		return
+	}
+	params := make(map[string]any, len(req.Params)+1)
+	for k, v := range req.Params {
+		params[k] = v
+	}
+	params["node"] = node
+	req.Params = params
+}
@@ -0,0 +1,66 @@
+package actions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+	"github.com/junlov/proxmox-ai/internal/policy"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+func TestApplyUsesEnvironmentDefaultNode(t *testing.T) {
+	var gotPath string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		_, _ = w.Write([]byte(`{"data":"UPID:pve:0001"}`))
+	}))
+	defer upstream.Close()
+	t.Setenv("PVE_TEST_SECRET", "default-node-secret")
+
+	cfg := config.Config{Environments: []config.Environment{{
+		Name:           "home",
+		BaseURL:        upstream.URL,
+		TokenID:        "root@pam!agent",
+		TokenSecretEnv: "PVE_TEST_SECRET",
+		DefaultNode:    "pve",
+	}}}
+	client, err := proxmox.NewAPIClient(cfg.Environments)
+	if err != nil {
+		t.Fatalf("NewAPIClient returned error: %v", err)
+	}
+	runner := NewRunner(policy.NewEngine(), client, "", WithDefaultNodes(cfg.DefaultNodes()))
+
+	params := map[string]any{}
+	_, err = runner.Apply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionStartVM,
+		Target:      "vm/101",
+		Params:      params,
+	})
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	if gotPath != "/api2/json/nodes/pve/qemu/101/status/start" {
+		t.Fatalf("expected start on the default node, got %q", gotPath)
+	}
+	if _, ok := params["node"]; ok {
+		t.Fatal("caller's params must not be modified")
+	}
+}
+
+func TestApplyWithoutDefaultNodeStillRequiresNode(t *testing.T) {
+	t.Setenv("PVE_TEST_SECRET", "unused")
+	client, err := proxmox.NewAPIClient([]config.Environment{{Name: "home", BaseURL: "https://proxmox.example.com", TokenID: "root@pam!agent", TokenSecretEnv: "PVE_TEST_SECRET"}})
+	if err != nil {
+		t.Fatalf("NewAPIClient returned error: %v", err)
+	}
+	runner := NewRunner(policy.NewEngine(), client, "", WithDefaultNodes(map[string]string{"other": "pve"}))
+
+	_, err = runner.Apply(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/101"})
+	if err == nil || !strings.Contains(err.Error(), "missing params.node") {
+		t.Fatalf("expected missing params.node error, got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// Target existence check modes for WithTargetExistenceCheck.
+const (
+	TargetCheckAnnotate = "annotate"
+	TargetCheckRequire  = "require"
+)
+
+// WithTargetExistenceCheck makes plan read the target VM first. "annotate"
+// reports target_exists on the plan; "require" also denies the plan when the
+// VM is missing. Any other value leaves the check off.
+func WithTargetExistenceCheck(mode string) Option {
+	return func(r *Runner) {
+		switch mode {
+		case TargetCheckAnnotate, TargetCheckRequire:
+			r.targetCheck = mode
+		}
+	}
+}
+
+// targetExists is best effort: it returns nil when existence could not be
+// determined, so an unreachable cluster does not block planning.
+func (r *Runner) targetExists(req proxmox.ActionRequest) *bool {
+	_, err := r.readTargetVM(req)
+	exists := err == nil
+	if err != nil && !isNotFound(err) {
+		return nil
+	}
+	return &exists
+}
+
+func (r *Runner) applyTargetCheck(req proxmox.ActionRequest, resp *PlanResponse) {
+	if r.targetCheck == "" || !proxmox.IsVMAction(req.Action) {
+		return
+	}
+	resp.TargetExists = r.targetExists(req)
+	if r.targetCheck == TargetCheckRequire && resp.TargetExists != nil && !*resp.TargetExists {
+		resp.Decision.Allowed = false
+		resp.Decision.Reason = fmt.Sprintf("target %s does not exist", req.Target)
+	}
+}
+
+// isNotFound recognizes a missing VM.
+func isNotFound(err error) bool {
+	return errors.Is(err, proxmox.ErrNotFound)
+}
@@ -0,0 +1,193 @@
+package actions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+	"github.com/junlov/proxmox-ai/internal/redact"
+	"github.com/junlov/proxmox-ai/internal/tracing"
+)
+
+// DefaultHoldTTL is how long a held request waits for approval before it is
+// dropped.
+const DefaultHoldTTL = 24 * time.Hour
+
+// maxHeldRequests bounds how many requests wait for approval at once, so
+// callers cannot grow the hold table without limit.
+const maxHeldRequests = 1000
+
+var (
+	// ErrHoldNotFound is returned when releasing an unknown or expired hold.
+	ErrHoldNotFound = errors.New("held request not found")
+	// ErrTooManyHolds is returned when an apply would be held but the hold
+	// table is full.
+	ErrTooManyHolds = errors.New("too many requests held for approval")
+)
+
+// HeldError reports that an apply lacking approval was held, as requested
+// with hold=true, instead of being denied outright.
+type HeldError struct {
+	ID     string
+	Reason string
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("request held for approval as %s: %s", e.ID, e.Reason)
+}
+
+// HeldRequest is a request waiting for out-of-band approval.
+type HeldRequest struct {
+	ID        string                `json:"id"`
+	Request   proxmox.ActionRequest `json:"request"`
+	Actor     string                `json:"actor"`
+	Reason    string                `json:"reason"`
+	HeldAt    time.Time             `json:"held_at"`
+	ExpiresAt time.Time             `json:"expires_at"`
+}
+
+// Approval is what an approver supplies to release a held request.
+type Approval struct {
+	ApprovedBy     string `json:"approved_by"`
+	ApprovalTicket string `json:"approval_ticket,omitempty"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+type heldRequests struct {
+	mu      sync.Mutex
+	entries map[string]HeldRequest
+	ttl     time.Duration
+	max     int
+	now     func() time.Time
+}
+
+func newHeldRequests(ttl time.Duration) *heldRequests {
+	return &heldRequests{entries: make(map[string]HeldRequest), ttl: ttl, max: maxHeldRequests, now: time.Now}
+}
+
+func (h *heldRequests) add(req proxmox.ActionRequest, reason string) (HeldRequest, error) {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	now := h.now().UTC()
+	// The capture, trace and context belong to the submitting HTTP request,
+	// whose context is cancelled long before anyone releases the hold.
+	req.Capture = nil
+	req.Trace = tracing.SpanContext{}
+	req.Context = nil
+	req.Hold = false
+	held := HeldRequest{
+		ID:        hex.EncodeToString(b),
+		Request:   req,
+		Actor:     req.Actor,
+		Reason:    reason,
+		HeldAt:    now,
+		ExpiresAt: now.Add(h.ttl),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expireLocked()
+	if len(h.entries) >= h.max {
+		return HeldRequest{}, ErrTooManyHolds
+	}
+	h.entries[held.ID] = held
+	return held, nil
+}
+
+func (h *heldRequests) get(id string) (HeldRequest, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expireLocked()
+	held, ok := h.entries[id]
+	return held, ok
+}
+
+// take removes and returns a hold, so concurrent releases run it once.
+func (h *heldRequests) take(id string) (HeldRequest, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expireLocked()
+	held, ok := h.entries[id]
+	delete(h.entries, id)
+	return held, ok
+}
+
+func (h *heldRequests) list() []HeldRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expireLocked()
+	out := make([]HeldRequest, 0, len(h.entries))
+	for _, held := range h.entries {
+		held.Request.Params = redact.Map(held.Request.Params, redact.SensitiveKeys)
+		out = append(out, held)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].HeldAt.Before(out[j].HeldAt) })
+	return out
+}
+
+func (h *heldRequests) expireLocked() {
+	now := h.now()
+	for id, held := range h.entries {
+		if !now.Before(held.ExpiresAt) {
+			delete(h.entries, id)
+		}
+	}
+}
+
+// shouldHold reports whether a denied apply is one that approval alone
+// would unblock and the caller asked to hold.
+func shouldHold(req proxmox.ActionRequest, requiresApproval bool) bool {
+	return req.Hold && requiresApproval && strings.TrimSpace(req.ApprovedBy) == ""
+}
+
+// HeldRequests lists requests waiting for approval, oldest first, with
+// sensitive params masked.
+func (r *Runner) HeldRequests() []HeldRequest {
+	return r.holds.list()
+}
+
+// Release applies a held request with the given approval. The hold is kept
+// when policy still denies the approved request, e.g. for a missing ticket,
+// so the approver can retry.
+func (r *Runner) Release(id string, approval Approval, releasedBy string) (ApplyResponse, error) {
+	held, ok := r.holds.get(id)
+	if !ok {
+		return ApplyResponse{}, ErrHoldNotFound
+	}
+	req := held.Request
+	req.ApprovedBy = approval.ApprovedBy
+	req.ApprovalTicket = approval.ApprovalTicket
+	if approval.Reason != "" {
+		req.Reason = approval.Reason
+	}
+	decision, err := r.policy.EvaluateForApply(req)
+	if err != nil {
+		return ApplyResponse{}, err
+	}
+	extra := map[string]any{"hold_id": id, "released_by": releasedBy}
+	if !decision.Allowed {
+		if err := r.audit("release_denied", req, decision, nil, extra); err != nil {
+			return ApplyResponse{}, err
+		}
+		return ApplyResponse{}, fmt.Errorf("request denied by policy: %s", decision.Reason)
+	}
+	if _, ok := r.holds.take(id); !ok {
+		return ApplyResponse{}, ErrHoldNotFound
+	}
+	if err := r.audit("release", req, decision, nil, extra); err != nil {
+		return ApplyResponse{}, err
+	}
+	// Releasing approves exactly the held request, which is what approval
+	// binding otherwise proves through a plan.
+	if r.approvals != nil {
+		if err := r.approvals.Record(req); err != nil {
+			return ApplyResponse{}, err
+		}
+	}
+	return r.Apply(req)
+}
@@ -0,0 +1,155 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/policy"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+	"github.com/junlov/proxmox-ai/internal/redact"
+)
+
+func TestRunnerHoldsUnapprovedDeleteAndReleasesIt(t *testing.T) {
+	client := &fakeClient{}
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	runner := NewRunner(policy.NewEngine(), client, auditPath, WithApprovalBinding(true))
+	defer runner.Close()
+
+	_, err := runner.Apply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionDeleteVM,
+		Target:      "vm/101",
+		Params:      map[string]any{"node": "pve"},
+		Hold:        true,
+	})
+	var held *HeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("expected HeldError, got %v", err)
+	}
+	if client.calls != 0 {
+		t.Fatalf("expected held request not to execute, got %d calls", client.calls)
+	}
+	if pending := runner.HeldRequests(); len(pending) != 1 || pending[0].ID != held.ID {
+		t.Fatalf("expected the hold to be listed, got %+v", pending)
+	}
+
+	resp, err := runner.Release(held.ID, Approval{ApprovedBy: "ops-lead", Reason: "approved in change review"}, "approver-agent")
+	if err != nil {
+		t.Fatalf("Release returned error: %v", err)
+	}
+	if client.calls != 1 || resp.Request.ApprovedBy != "ops-lead" {
+		t.Fatalf("expected release to execute approved request, got %d calls, %+v", client.calls, resp.Request)
+	}
+	if _, err := runner.Release(held.ID, Approval{ApprovedBy: "ops-lead"}, "approver-agent"); !errors.Is(err, ErrHoldNotFound) {
+		t.Fatalf("expected second release to find no hold, got %v", err)
+	}
+
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit file: %v", err)
+	}
+	for _, kind := range []string{`"kind":"apply_held"`, `"kind":"release"`, `"released_by":"approver-agent"`, `"kind":"apply"`} {
+		if !strings.Contains(string(b), kind) {
+			t.Fatalf("expected audit log to contain %s, got %s", kind, b)
+		}
+	}
+}
+
+// contextClient fails like the real client when the request's context is
+// already cancelled.
+type contextClient struct {
+	calls int
+}
+
+func (c *contextClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	if req.Context != nil {
+		if err := req.Context.Err(); err != nil {
+			return proxmox.ActionResult{}, err
+		}
+	}
+	c.calls++
+	return proxmox.ActionResult{Status: "submitted"}, nil
+}
+
+func TestReleaseOutlivesTheSubmittingRequestContext(t *testing.T) {
+	client := &contextClient{}
+	runner := NewRunner(policy.NewEngine(), client, "")
+	defer runner.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := runner.Apply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionDeleteVM,
+		Target:      "vm/101",
+		Params:      map[string]any{"node": "pve"},
+		Hold:        true,
+		Context:     ctx,
+	})
+	var held *HeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("expected HeldError, got %v", err)
+	}
+	// The submitting HTTP request has returned.
+	cancel()
+
+	if _, err := runner.Release(held.ID, Approval{ApprovedBy: "ops-lead"}, "approver-agent"); err != nil {
+		t.Fatalf("expected release to run after the submitter's context ended, got %v", err)
+	}
+	if client.calls != 1 {
+		t.Fatalf("expected the released delete to execute, got %d calls", client.calls)
+	}
+}
+
+func TestRunnerDeniesWithoutHoldFlag(t *testing.T) {
+	runner := NewRunner(policy.NewEngine(), &fakeClient{}, "")
+	_, err := runner.Apply(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionDeleteVM, Target: "vm/101", Params: map[string]any{"node": "pve"}})
+	var held *HeldError
+	if err == nil || errors.As(err, &held) {
+		t.Fatalf("expected plain denial, got %v", err)
+	}
+	if len(runner.HeldRequests()) != 0 {
+		t.Fatal("expected nothing to be held")
+	}
+}
+
+func TestHeldRequestsMaskSensitiveParams(t *testing.T) {
+	runner := NewRunner(policy.NewEngine(), &fakeClient{}, "")
+	_, err := runner.Apply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionDeleteVM,
+		Target:      "vm/101",
+		Params:      map[string]any{"node": "pve", "cipassword": "hunter2"},
+		Hold:        true,
+	})
+	var held *HeldError
+	if !errors.As(err, &held) {
+		t.Fatalf("expected HeldError, got %v", err)
+	}
+	pending := runner.HeldRequests()
+	if len(pending) != 1 || pending[0].Request.Params["cipassword"] != redact.Placeholder || pending[0].Request.Params["node"] != "pve" {
+		t.Fatalf("expected listed params to be masked, got %+v", pending)
+	}
+	if stored, _ := runner.holds.get(held.ID); stored.Request.Params["cipassword"] != "hunter2" {
+		t.Fatalf("expected the hold itself to keep the real params, got %v", stored.Request.Params)
+	}
+}
+
+func TestRunnerRefusesHoldsBeyondCapacity(t *testing.T) {
+	runner := NewRunner(policy.NewEngine(), &fakeClient{}, "")
+	runner.holds.max = 1
+	req := proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionDeleteVM, Target: "vm/101", Params: map[string]any{"node": "pve"}, Hold: true}
+	var held *HeldError
+	if _, err := runner.Apply(req); !errors.As(err, &held) {
+		t.Fatalf("expected the first request to be held, got %v", err)
+	}
+	if _, err := runner.Apply(req); !errors.Is(err, ErrTooManyHolds) {
+		t.Fatalf("expected a full hold table to refuse, got %v", err)
+	}
+	if len(runner.HeldRequests()) != 1 {
+		t.Fatal("expected only the first hold to be kept")
+	}
+}
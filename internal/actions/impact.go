@@ -0,0 +1,41 @@
+package actions
+
+import (
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// WithPlanImpact makes plan attach a best-effort impact summary to
+// high-risk requests: for delete_vm whether the VM is running and how many
+// snapshots it has. A lookup that fails is left out of the summary.
+func WithPlanImpact(enabled bool) Option {
+	return func(r *Runner) {
+		r.planImpact = enabled
+	}
+}
+
+func (r *Runner) applyImpact(req proxmox.ActionRequest, resp *PlanResponse) {
+	if !r.planImpact || resp.Decision.RiskLevel != "high" {
+		return
+	}
+	var impact map[string]any
+	switch req.Action {
+	case proxmox.ActionDeleteVM:
+		impact = r.vmDeleteImpact(req)
+	}
+	if len(impact) > 0 {
+		resp.Impact = impact
+	}
+}
+
+func (r *Runner) vmDeleteImpact(req proxmox.ActionRequest) map[string]any {
+	impact := map[string]any{}
+	if current, err := r.readTargetVM(req); err == nil {
+		impact["running"] = vmStatus(current.Data) == "running"
+	}
+	if result, err := r.client.Execute(followUpRead(req, proxmox.ActionReadVMSnapshots, req.Target, true)); err == nil {
+		if snapshots, ok := result.Data.([]proxmox.Snapshot); ok {
+			impact["snapshots"] = len(snapshots)
+		}
+	}
+	return impact
+}
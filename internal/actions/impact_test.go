@@ -0,0 +1,42 @@
+package actions
+
+import (
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/policy"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+type vmImpactClient struct{}
+
+func (c *vmImpactClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	if req.Action == proxmox.ActionReadVMSnapshots {
+		return proxmox.ActionResult{Status: "ok", Data: []proxmox.Snapshot{{Name: "before-upgrade"}, {Name: "current"}}}, nil
+	}
+	return proxmox.ActionResult{Status: "ok", Data: map[string]any{"status": "running"}}, nil
+}
+
+func TestPlanImpactReportsDeletedVMStateAndSnapshots(t *testing.T) {
+	runner := NewRunner(policy.NewEngine(), &vmImpactClient{}, "", WithPlanImpact(true))
+
+	resp, err := runner.Plan(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionDeleteVM, Target: "vm/101", Params: map[string]any{"node": "pve1"}})
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if resp.Impact["running"] != true || resp.Impact["snapshots"] != 2 {
+		t.Fatalf("expected a running VM with 2 snapshots, got %+v", resp.Impact)
+	}
+}
+
+func TestPlanImpactOffByDefault(t *testing.T) {
+	client := &fakeClient{}
+	runner := NewRunner(policy.NewEngine(), client, "")
+
+	resp, err := runner.Plan(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionDeleteVM, Target: "vm/101", Params: map[string]any{"node": "pve1"}})
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if resp.Impact != nil || client.calls != 0 {
+		t.Fatalf("expected no impact lookup, impact=%v calls=%d", resp.Impact, client.calls)
+	}
+}
@@ -0,0 +1,104 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// ErrUnknownNode is returned when a request names a node the cluster does
+// not have.
+var ErrUnknownNode = errors.New("unknown node")
+
+// DefaultNodeCacheTTL is how long a cluster's node list is reused.
+const DefaultNodeCacheTTL = 30 * time.Second
+
+// WithNodeCheck makes plan and apply reject requests naming a node that is
+// not in the environment's node list, which is fetched via read_nodes and
+// cached for ttl (DefaultNodeCacheTTL when zero).
+func WithNodeCheck(enabled bool, ttl time.Duration) Option {
+	return func(r *Runner) {
+		if !enabled {
+			return
+		}
+		if ttl <= 0 {
+			ttl = DefaultNodeCacheTTL
+		}
+		r.nodes = &nodeCache{ttl: ttl, now: time.Now, entries: map[string]nodeCacheEntry{}}
+	}
+}
+
+type nodeCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	now     func() time.Time
+	entries map[string]nodeCacheEntry
+}
+
+type nodeCacheEntry struct {
+	names     map[string]bool
+	fetchedAt time.Time
+}
+
+// checkNodes is best effort like the target existence check: when the node
+// list cannot be read the request proceeds and fails upstream if it must.
+func (r *Runner) checkNodes(req proxmox.ActionRequest) error {
+	if r.nodes == nil {
+		return nil
+	}
+	nodes := proxmox.RequestNodes(req)
+	if len(nodes) == 0 {
+		return nil
+	}
+	known, ok := r.knownNodes(req.Environment)
+	if !ok {
+		return nil
+	}
+	for _, node := range nodes {
+		if !known[node] {
+			return fmt.Errorf("%w %q in environment %q", ErrUnknownNode, node, req.Environment)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) knownNodes(environment string) (map[string]bool, bool) {
+	c := r.nodes
+	c.mu.Lock()
+	entry, ok := c.entries[environment]
+	c.mu.Unlock()
+	if ok && c.now().Sub(entry.fetchedAt) < c.ttl {
+		return entry.names, true
+	}
+
+	resp, err := r.client.Execute(proxmox.ActionRequest{
+		Environment: environment,
+		Action:      proxmox.ActionReadNodes,
+		Target:      "nodes/all",
+	})
+	if err != nil {
+		return nil, false
+	}
+	items, ok := resp.Data.([]any)
+	if !ok {
+		return nil, false
+	}
+	names := make(map[string]bool, len(items))
+	for _, item := range items {
+		if resource, ok := item.(map[string]any); ok {
+			if name, _ := resource["node"].(string); name != "" {
+				names[name] = true
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	c.entries[environment] = nodeCacheEntry{names: names, fetchedAt: c.now()}
+	c.mu.Unlock()
+	return names, true
+}
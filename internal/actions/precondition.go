@@ -0,0 +1,76 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// ErrPreconditionFailed is matched by errors.Is when apply was skipped
+// because the VM was not in the state the request expected.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+type PreconditionError struct {
+	Expected string
+	Current  string
+}
+
+func (e *PreconditionError) Error() string {
+	return fmt.Sprintf("precondition failed: expected VM status %q, current status is %q", e.Expected, e.Current)
+}
+
+func (e *PreconditionError) Is(target error) bool {
+	return target == ErrPreconditionFailed
+}
+
+// checkExpectedStatus reads the VM's current status and compares it with
+// req.ExpectStatus. It returns a *PreconditionError on mismatch.
+func (r *Runner) checkExpectedStatus(req proxmox.ActionRequest) error {
+	result, err := r.readTargetVM(req)
+	if err != nil {
+		return fmt.Errorf("read current VM status: %w", err)
+	}
+	current := vmStatus(result.Data)
+	if !strings.EqualFold(current, strings.TrimSpace(req.ExpectStatus)) {
+		return &PreconditionError{Expected: req.ExpectStatus, Current: current}
+	}
+	return nil
+}
+
+// readTargetVM reads the current status of the VM that req targets.
+func (r *Runner) readTargetVM(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	return r.client.Execute(followUpRead(req, proxmox.ActionReadVM, req.Target, true))
+}
+
+// followUpRead builds a read the runner makes on behalf of req. It runs
+// under req's trace, context and token identity so it nests under the
+// caller's span and authenticates as the caller chose; withNode carries
+// params.node over for reads of req's own target.
+func followUpRead(req proxmox.ActionRequest, action proxmox.ActionType, target string, withNode bool) proxmox.ActionRequest {
+	read := proxmox.ActionRequest{
+		Environment:   req.Environment,
+		Action:        action,
+		Target:        target,
+		RequestID:     req.RequestID,
+		Trace:         req.Trace,
+		Context:       req.Context,
+		TokenIdentity: req.TokenIdentity,
+	}
+	if node, ok := req.Params["node"]; ok && withNode {
+		read.Params = map[string]any{"node": node}
+	}
+	return read
+}
+
+func vmStatus(data any) string {
+	switch typed := data.(type) {
+	case map[string]any:
+		status, _ := typed["status"].(string)
+		return status
+	case map[string]string:
+		return typed["status"]
+	}
+	return ""
+}
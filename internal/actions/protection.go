@@ -0,0 +1,55 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// ErrVMProtected is matched by errors.Is when a delete was refused because
+// the VM has Proxmox's protection flag set.
+var ErrVMProtected = errors.New("vm is protected")
+
+// WithProtectionCheck makes apply read a VM's config before deleting it and
+// refuse the delete when protection is set, with a message that says how to
+// clear it. It costs one extra upstream read per delete.
+func WithProtectionCheck(enabled bool) Option {
+	return func(r *Runner) {
+		r.protectCheck = enabled
+	}
+}
+
+// checkProtection reads the target VM's config and refuses the delete when
+// protection is set. Unlike the clone check it fails closed: a delete goes
+// ahead only once the config read shows the VM is unprotected.
+func (r *Runner) checkProtection(req proxmox.ActionRequest) error {
+	result, err := r.client.Execute(followUpRead(req, proxmox.ActionReadVMConfig, req.Target, true))
+	if err != nil {
+		return fmt.Errorf("read VM config before delete: %w", err)
+	}
+	config, _ := result.Data.(map[string]any)
+	if isProtected(config["protection"]) {
+		return fmt.Errorf("%w: clear protection on %s with set_vm_config before deleting it", ErrVMProtected, req.Target)
+	}
+	return nil
+}
+
+// isProtected accepts the forms Proxmox uses for the flag: 1 as a JSON
+// number or string, or a boolean.
+func isProtected(value any) bool {
+	switch typed := value.(type) {
+	case bool:
+		return typed
+	case float64:
+		return typed == 1
+	case int:
+		return typed == 1
+	case int64:
+		return typed == 1
+	case string:
+		return strings.TrimSpace(typed) == "1"
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package actions
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/policy"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// protectedVMClient keeps one VM's protection flag, which set_vm_config
+// changes and read_vm_config reports as Proxmox does (0/1).
+type protectedVMClient struct {
+	protection bool
+	deleted    bool
+}
+
+func (c *protectedVMClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	switch req.Action {
+	case proxmox.ActionReadVMConfig:
+		flag := float64(0)
+		if c.protection {
+			flag = 1
+		}
+		return proxmox.ActionResult{Status: "ok", Data: map[string]any{"name": "web", "protection": flag}}, nil
+	case proxmox.ActionSetVMConfig:
+		c.protection, _ = req.Params["protection"].(bool)
+	case proxmox.ActionDeleteVM:
+		c.deleted = true
+	}
+	return proxmox.ActionResult{Status: "accepted"}, nil
+}
+
+func TestApplyRefusesDeleteOfProtectedVMUntilCleared(t *testing.T) {
+	client := &protectedVMClient{protection: true}
+	runner := NewRunner(policy.NewEngine(), client, "", WithProtectionCheck(true))
+	del := proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionDeleteVM,
+		Target:      "vm/100",
+		Params:      map[string]any{"node": "pve1"},
+		ApprovedBy:  "ops-user",
+	}
+
+	if _, err := runner.Apply(del); !errors.Is(err, ErrVMProtected) {
+		t.Fatalf("expected ErrVMProtected, got %v", err)
+	}
+	if client.deleted {
+		t.Fatal("expected the protected VM not to be deleted")
+	}
+
+	if _, err := runner.Apply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionSetVMConfig,
+		Target:      "vm/100",
+		Params:      map[string]any{"node": "pve1", "protection": false},
+	}); err != nil {
+		t.Fatalf("clearing protection returned error: %v", err)
+	}
+	if _, err := runner.Apply(del); err != nil {
+		t.Fatalf("expected delete to proceed once unprotected, got %v", err)
+	}
+	if !client.deleted {
+		t.Fatal("expected the unprotected VM to be deleted")
+	}
+}
+
+func TestApplyDeleteSkipsProtectionReadByDefault(t *testing.T) {
+	client := &protectedVMClient{protection: true}
+	runner := NewRunner(policy.NewEngine(), client, "")
+
+	if _, err := runner.Apply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionDeleteVM,
+		Target:      "vm/100",
+		Params:      map[string]any{"node": "pve1"},
+		ApprovedBy:  "ops-user",
+	}); err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	if !client.deleted {
+		t.Fatal("expected delete to reach the client when the check is off")
+	}
+}
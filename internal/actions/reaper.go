@@ -0,0 +1,207 @@
+package actions
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/policy"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// ReaperActor is the actor recorded on deletes the reaper performs. The
+// approver is the one recorded on the soft delete.
+const ReaperActor = "pending-delete-reaper"
+
+// softDeleteTagSkew is how far a VM's pending-delete timestamp may precede
+// the audit record of the soft delete that set it.
+const softDeleteTagSkew = 5 * time.Minute
+
+// Reaper completes two-phase deletes: it destroys VMs that this agent
+// soft-deleted with approval once the grace period has passed. The
+// pending-delete tag only nominates candidates; anyone able to edit tags can
+// set it, so a VM is reaped only when the audit log holds an approved soft
+// delete of it that set that tag.
+type Reaper struct {
+	runner *Runner
+	grace  time.Duration
+	now    func() time.Time
+}
+
+func NewReaper(runner *Runner, grace time.Duration) *Reaper {
+	return &Reaper{runner: runner, grace: grace, now: time.Now}
+}
+
+// ReapResult reports what happened to one pending-delete VM.
+type ReapResult struct {
+	Target       string    `json:"target"`
+	Node         string    `json:"node"`
+	PendingSince time.Time `json:"pending_since"`
+	Deleted      bool      `json:"deleted"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Reap deletes every VM in environment whose approved soft delete is older
+// than the grace period. Each delete goes through plan and apply, so it is
+// audited and subject to policy like any other request. VMs still inside the
+// grace window are reported with Deleted false; tagged VMs with no matching
+// soft delete in the audit log are reported with an Error and left alone.
+func (r *Reaper) Reap(environment string) ([]ReapResult, error) {
+	softDeletes, err := r.approvedSoftDeletes(environment)
+	if err != nil {
+		return nil, err
+	}
+	inventory, err := r.runner.Apply(proxmox.ActionRequest{
+		Environment: environment,
+		Action:      proxmox.ActionReadInventory,
+		Target:      "inventory/all",
+		Params:      map[string]any{"pending_delete": true},
+		Actor:       ReaperActor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	items, ok := inventory.Result.Data.([]any)
+	if !ok {
+		return nil, fmt.Errorf("unexpected inventory response format")
+	}
+
+	now := r.now()
+	var results []ReapResult
+	for _, item := range items {
+		resource, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		if kind, _ := resource["type"].(string); kind != "qemu" {
+			continue
+		}
+		tags, _ := resource["tags"].(string)
+		since, ok := proxmox.PendingDeleteSince(tags)
+		if !ok {
+			continue
+		}
+		vmid, _ := resource["vmid"].(float64)
+		node, _ := resource["node"].(string)
+		result := ReapResult{
+			Target:       fmt.Sprintf("vm/%d", int64(vmid)),
+			Node:         node,
+			PendingSince: since,
+		}
+		soft, ok := softDeletes[int64(vmid)]
+		if !ok || since.Before(soft.at.Add(-softDeleteTagSkew)) || since.After(soft.at.Add(time.Second)) {
+			result.Error = "no approved soft delete in the audit log matches the pending-delete tag"
+			results = append(results, result)
+			continue
+		}
+		result.PendingSince = soft.at
+		if now.Sub(soft.at) < r.grace {
+			results = append(results, result)
+			continue
+		}
+		if err := r.delete(environment, result.Target, node, soft); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Deleted = true
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// softDelete is an approved soft delete found in the audit log.
+type softDelete struct {
+	at             time.Time
+	approvedBy     string
+	approvalTicket string
+}
+
+// approvedSoftDeletes returns, by VMID, the latest soft delete the runner
+// applied in environment with an approval. It reads the rotated copies of
+// the audit log too, so a rotation inside the grace period does not strand
+// a VM; a soft delete whose file has since been removed is not found.
+func (r *Reaper) approvedSoftDeletes(environment string) (map[int64]softDelete, error) {
+	if r.runner.auditTo == "" {
+		return nil, fmt.Errorf("reaping pending deletes needs the audit log: %w", ErrAuditDisabled)
+	}
+	files, err := rotatedAuditLogs(r.runner.auditTo)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	found := map[int64]softDelete{}
+	for _, file := range append(files, r.runner.auditTo) {
+		err := r.scanSoftDeletes(file, environment, found)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return found, nil
+}
+
+// scanSoftDeletes adds the approved soft deletes in environment recorded in
+// the audit log file to found, keeping the latest per VMID.
+func (r *Reaper) scanSoftDeletes(file, environment string, found map[int64]softDelete) error {
+	return scanAuditLines(file, func(n int, line []byte) error {
+		var record struct {
+			TS       string                `json:"ts"`
+			Kind     string                `json:"kind"`
+			Decision policy.Decision       `json:"decision"`
+			Request  proxmox.ActionRequest `json:"request"`
+		}
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("audit record on line %d: %w", n, err)
+		}
+		req := record.Request
+		if record.Kind != "apply" || !record.Decision.Allowed || req.Environment != environment ||
+			req.Action != proxmox.ActionDeleteVM || req.DryRun || strings.TrimSpace(req.ApprovedBy) == "" {
+			return nil
+		}
+		if !proxmox.IsSoftDelete(req) {
+			return nil
+		}
+		_, rawID, ok := strings.Cut(req.Target, "/")
+		if !ok {
+			return nil
+		}
+		vmid, err := strconv.ParseInt(rawID, 10, 64)
+		if err != nil {
+			return nil
+		}
+		at, err := time.Parse(time.RFC3339, record.TS)
+		if err != nil {
+			return nil
+		}
+		if prev, ok := found[vmid]; !ok || at.After(prev.at) {
+			found[vmid] = softDelete{at: at, approvedBy: req.ApprovedBy, approvalTicket: req.ApprovalTicket}
+		}
+		return nil
+	})
+}
+
+// delete destroys target under the approval, and ticket, of its soft
+// delete, so a policy that demands a ticket admits the second phase too.
+func (r *Reaper) delete(environment, target, node string, soft softDelete) error {
+	req := proxmox.ActionRequest{
+		Environment:    environment,
+		Action:         proxmox.ActionDeleteVM,
+		Target:         target,
+		Params:         map[string]any{"node": node},
+		ApprovedBy:     soft.approvedBy,
+		ApprovalTicket: soft.approvalTicket,
+		Reason:         "pending-delete grace period elapsed",
+		Actor:          ReaperActor,
+	}
+	plan, err := r.runner.Plan(req)
+	if err != nil {
+		return err
+	}
+	if !plan.Decision.Allowed {
+		return fmt.Errorf("plan denied: %s", plan.Decision.Reason)
+	}
+	_, err = r.runner.Apply(req)
+	return err
+}
@@ -0,0 +1,30 @@
+package actions
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/junlov/proxmox-ai/internal/redact"
+)
+
+var (
+	tokenHeaderPattern     = regexp.MustCompile(`PVEAPIToken=\S+`)
+	sensitiveAssignPattern = assignPattern(redact.SensitiveKeys)
+)
+
+// assignPattern matches key=value or "key": "value" pairs whose key contains
+// one of keys, capturing the key, the separator and the value.
+func assignPattern(keys []string) *regexp.Regexp {
+	quoted := make([]string, len(keys))
+	for i, key := range keys {
+		quoted[i] = regexp.QuoteMeta(key)
+	}
+	return regexp.MustCompile(`(?i)\b([A-Za-z0-9_-]*(?:` + strings.Join(quoted, "|") + `))(["']?\s*[=:]\s*["']?)([^\s&"',}]+)`)
+}
+
+// redactErrorMessage masks credentials that upstream errors can echo back,
+// such as form values or an Authorization header, before they are audited.
+func redactErrorMessage(msg string) string {
+	msg = tokenHeaderPattern.ReplaceAllString(msg, "PVEAPIToken="+redact.Placeholder)
+	return sensitiveAssignPattern.ReplaceAllString(msg, "${1}${2}"+redact.Placeholder)
+}
@@ -2,81 +2,428 @@ package actions
 
 import (
 	"encoding/json"
-	"fmt"
-	"os"
-	"path/filepath"
+	"errors"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/junlov/proxmox-ai/internal/policy"
 	"github.com/junlov/proxmox-ai/internal/proxmox"
+	"github.com/junlov/proxmox-ai/internal/redact"
+	"github.com/junlov/proxmox-ai/internal/tracing"
 )
 
 type PlanResponse struct {
 	Request  proxmox.ActionRequest `json:"request"`
 	Decision policy.Decision       `json:"decision"`
+	Details  map[string]any        `json:"details,omitempty"`
+	// TransactionID echoes req.TransactionID so the caller can pass it on
+	// to the matching apply.
+	TransactionID string `json:"transaction_id,omitempty"`
+	// TargetExists is set only when the target existence check is enabled
+	// and existence could be determined.
+	TargetExists *bool `json:"target_exists,omitempty"`
+	// Impact summarizes what a high-risk request would disrupt. It is set
+	// only with WithPlanImpact, and only with what could be looked up.
+	Impact map[string]any `json:"impact,omitempty"`
+	Timing
 }
 
 type ApplyResponse struct {
 	Request  proxmox.ActionRequest `json:"request"`
 	Decision policy.Decision       `json:"decision"`
 	Result   proxmox.ActionResult  `json:"result"`
+	// TransactionID echoes req.TransactionID.
+	TransactionID string `json:"transaction_id,omitempty"`
+	Timing
+}
+
+// Timing records when the runner started and finished handling a request.
+// For apply, the window covers the upstream Proxmox call.
+type Timing struct {
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	DurationMs  int64     `json:"duration_ms"`
+}
+
+func startTiming() Timing {
+	return Timing{StartedAt: time.Now().UTC()}
+}
+
+func (t Timing) complete() Timing {
+	t.CompletedAt = time.Now().UTC()
+	t.DurationMs = t.CompletedAt.Sub(t.StartedAt).Milliseconds()
+	return t
+}
+
+// phaseTimer accumulates the durations of one runner phase for /metrics.
+type phaseTimer struct {
+	count atomic.Int64
+	nanos atomic.Int64
+}
+
+func (p *phaseTimer) observe(t Timing) {
+	p.count.Add(1)
+	p.nanos.Add(int64(t.CompletedAt.Sub(t.StartedAt)))
+}
+
+// PhaseTiming is how many times a phase ran and for how long in total.
+type PhaseTiming struct {
+	Count int64
+	Total time.Duration
+}
+
+// Timings reports the accumulated plan and apply durations. Apply covers
+// only the upstream call, matching ApplyResponse.Timing.
+func (r *Runner) Timings() (plan, apply PhaseTiming) {
+	return PhaseTiming{Count: r.planTimer.count.Load(), Total: time.Duration(r.planTimer.nanos.Load())},
+		PhaseTiming{Count: r.applyTimer.count.Load(), Total: time.Duration(r.applyTimer.nanos.Load())}
 }
 
 type Runner struct {
 	policy  *policy.Engine
 	client  proxmox.Client
 	auditTo string
+
+	approvals    *approvalBindings
+	targetCheck  string
+	planImpact   bool
+	cloneIDCheck bool
+	protectCheck bool
+	defaultNode  map[string]string
+	auditFsync   bool
+	auditArray   bool
+	auditKey     []byte
+	skipReads    bool
+	nodes        *nodeCache
+	holds        *heldRequests
+	transactions *planTransactions
+	auditLog     *auditWriter
+	tracer       *tracing.Tracer
+
+	planTimer  phaseTimer
+	applyTimer phaseTimer
+}
+
+// Option configures optional Runner behavior.
+type Option func(*Runner)
+
+// WithApprovalBinding makes apply reject approval metadata unless a plan was
+// made for the identical request with the same approver and ticket.
+func WithApprovalBinding(enabled bool) Option {
+	return func(r *Runner) {
+		if enabled {
+			r.approvals = newApprovalBindings()
+		}
+	}
+}
+
+// WithTracer records each plan and apply as a span. The span is passed to
+// the client through ActionRequest.Trace so upstream calls nest under it.
+func WithTracer(t *tracing.Tracer) Option {
+	return func(r *Runner) {
+		r.tracer = t
+	}
+}
+
+func NewRunner(policyEngine *policy.Engine, client proxmox.Client, auditPath string, opts ...Option) *Runner {
+	r := &Runner{policy: policyEngine, client: client, auditTo: auditPath, holds: newHeldRequests(DefaultHoldTTL), transactions: newPlanTransactions()}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if auditPath != "" {
+		r.auditLog = newAuditWriter(auditPath, r.auditFsync, r.auditArray, r.auditKey, nil)
+	}
+	return r
+}
+
+// WithAuditFsync syncs the audit log to disk after each batch of records.
+func WithAuditFsync(enabled bool) Option {
+	return func(r *Runner) {
+		r.auditFsync = enabled
+	}
+}
+
+// WithAuditReads controls whether successful low-risk reads are audited.
+// Denied or failed reads and every mutation are audited either way.
+func WithAuditReads(enabled bool) Option {
+	return func(r *Runner) {
+		r.skipReads = !enabled
+	}
 }
 
-func NewRunner(policyEngine *policy.Engine, client proxmox.Client, auditPath string) *Runner {
-	return &Runner{policy: policyEngine, client: client, auditTo: auditPath}
+// Close flushes and closes the audit log. Plan and apply fail afterwards
+// when auditing is enabled.
+func (r *Runner) Close() error {
+	if r.auditLog == nil {
+		return nil
+	}
+	return r.auditLog.Close()
+}
+
+// DefaultRisk reports the policy's default risk tier for action.
+func (r *Runner) DefaultRisk(action proxmox.ActionType) (risk string, requiresApproval bool) {
+	return r.policy.DefaultRisk(action)
 }
 
 func (r *Runner) Plan(req proxmox.ActionRequest) (PlanResponse, error) {
+	return r.plan(req, true)
+}
+
+// PlanInline plans req as the first step of an apply made in the same
+// call, as reads and batch items are. It is audited like any plan but
+// records no approval binding, so it cannot vouch for its own apply.
+func (r *Runner) PlanInline(req proxmox.ActionRequest) (PlanResponse, error) {
+	return r.plan(req, false)
+}
+
+func (r *Runner) plan(req proxmox.ActionRequest, bind bool) (_ PlanResponse, err error) {
+	span := r.startSpan("plan", &req)
+	defer func() { endSpan(span, err) }()
+	timing := startTiming()
+	r.applyDefaultNode(&req)
+	if err := r.checkNodes(req); err != nil {
+		return PlanResponse{}, err
+	}
 	decision, err := r.policy.EvaluateForPlan(req)
 	if err != nil {
 		return PlanResponse{}, err
 	}
-	if err := r.audit("plan", req, decision, nil); err != nil {
-		return PlanResponse{}, err
+	setDecisionAttributes(span, decision)
+	resp := PlanResponse{Request: req, Decision: decision, Details: proxmox.DescribeRequest(req), TransactionID: req.TransactionID}
+	r.applyTargetCheck(req, &resp)
+	r.applyImpact(req, &resp)
+	var extra map[string]any
+	if resp.TargetExists != nil {
+		extra = map[string]any{"target_exists": *resp.TargetExists}
+	}
+	if resp.Impact != nil {
+		if extra == nil {
+			extra = map[string]any{}
+		}
+		extra["impact"] = resp.Impact
+	}
+	if !r.skipReadAudit(req, resp.Decision) {
+		if err := r.audit("plan", req, resp.Decision, nil, extra); err != nil {
+			return PlanResponse{}, err
+		}
+	}
+	if bind && r.approvals != nil {
+		if err := r.approvals.Record(req); err != nil {
+			return PlanResponse{}, err
+		}
 	}
-	return PlanResponse{Request: req, Decision: decision}, nil
+	if bind {
+		if err := r.transactions.record(req); err != nil {
+			return PlanResponse{}, err
+		}
+	}
+	resp.Timing = timing.complete()
+	r.planTimer.observe(resp.Timing)
+	return resp, nil
+}
+
+// ErrApprovalRequired is matched by errors.Is when apply was denied because
+// the request lacks an approval it needs, so approving it could succeed.
+var ErrApprovalRequired = errors.New("approval required")
+
+// PolicyDeniedError is returned when policy denies an apply.
+type PolicyDeniedError struct {
+	Reason string
+	// ApprovalRequired is set when the request was denied for lacking
+	// approval rather than blocked outright.
+	ApprovalRequired bool
+}
+
+func (e *PolicyDeniedError) Error() string {
+	return "request denied by policy: " + e.Reason
 }
 
-func (r *Runner) Apply(req proxmox.ActionRequest) (ApplyResponse, error) {
+func (e *PolicyDeniedError) Is(target error) bool {
+	return e.ApprovalRequired && target == ErrApprovalRequired
+}
+
+func (r *Runner) Apply(req proxmox.ActionRequest) (_ ApplyResponse, err error) {
+	span := r.startSpan("apply", &req)
+	defer func() { endSpan(span, err) }()
+	r.applyDefaultNode(&req)
+	if err := r.checkNodes(req); err != nil {
+		return ApplyResponse{}, err
+	}
 	decision, err := r.policy.EvaluateForApply(req)
 	if err != nil {
 		return ApplyResponse{}, err
 	}
+	if err := r.transactions.check(req); err != nil {
+		decision.Allowed = false
+		decision.Reason = err.Error()
+		if auditErr := r.audit("apply_denied", req, decision, nil, nil); auditErr != nil {
+			return ApplyResponse{}, auditErr
+		}
+		return ApplyResponse{}, err
+	}
+	if decision.Allowed && r.approvals != nil {
+		if reason := r.approvals.Check(req); reason != "" {
+			decision.Allowed = false
+			decision.Reason = reason
+		}
+	}
+	setDecisionAttributes(span, decision)
 	if !decision.Allowed {
-		if err := r.audit("apply_denied", req, decision, nil); err != nil {
+		if shouldHold(req, decision.RequiresApproval) {
+			held, err := r.holds.add(req, decision.Reason)
+			if err != nil {
+				if auditErr := r.audit("apply_denied", req, decision, nil, map[string]any{"hold_error": err.Error()}); auditErr != nil {
+					return ApplyResponse{}, auditErr
+				}
+				return ApplyResponse{}, err
+			}
+			if err := r.audit("apply_held", req, decision, nil, map[string]any{"hold_id": held.ID}); err != nil {
+				return ApplyResponse{}, err
+			}
+			return ApplyResponse{}, &HeldError{ID: held.ID, Reason: decision.Reason}
+		}
+		if err := r.audit("apply_denied", req, decision, nil, nil); err != nil {
 			return ApplyResponse{}, err
 		}
-		return ApplyResponse{}, fmt.Errorf("request denied by policy: %s", decision.Reason)
+		return ApplyResponse{}, &PolicyDeniedError{
+			Reason:           decision.Reason,
+			ApprovalRequired: decision.RequiresApproval && strings.TrimSpace(req.ApprovedBy) == "",
+		}
 	}
+	if req.ExpectStatus != "" && !req.DryRun {
+		if err := r.checkExpectedStatus(req); err != nil {
+			var precondition *PreconditionError
+			if errors.As(err, &precondition) {
+				if auditErr := r.audit("apply_precondition_failed", req, decision, nil, map[string]any{
+					"expected_status": precondition.Expected,
+					"current_status":  precondition.Current,
+				}); auditErr != nil {
+					return ApplyResponse{}, auditErr
+				}
+			}
+			return ApplyResponse{}, err
+		}
+	}
+	if r.cloneIDCheck && req.Action == proxmox.ActionCloneVM && !req.DryRun {
+		if err := r.checkCloneID(req); err != nil {
+			if auditErr := r.audit("apply_conflict", req, decision, nil, map[string]any{"error": err.Error()}); auditErr != nil {
+				return ApplyResponse{}, auditErr
+			}
+			return ApplyResponse{}, err
+		}
+	}
+	if r.protectCheck && req.Action == proxmox.ActionDeleteVM && !req.DryRun {
+		if err := r.checkProtection(req); err != nil {
+			if auditErr := r.audit("apply_conflict", req, decision, nil, map[string]any{"error": err.Error()}); auditErr != nil {
+				return ApplyResponse{}, auditErr
+			}
+			return ApplyResponse{}, err
+		}
+	}
+	timing := startTiming()
 	result, err := r.client.Execute(req)
+	timing = timing.complete()
+	r.applyTimer.observe(timing)
 	if err != nil {
-		return ApplyResponse{}, err
+		execErr := &proxmox.ExecuteError{
+			Environment: req.Environment,
+			Action:      req.Action,
+			Target:      req.Target,
+			Err:         err,
+		}
+		extra := r.upstreamAuditFields(req)
+		if extra == nil {
+			extra = map[string]any{}
+		}
+		extra["error"] = redactErrorMessage(execErr.Error())
+		// The execution error is what the caller needs; an audit write
+		// failure here must not mask it.
+		_ = r.audit("apply_failed", req, decision, nil, extra)
+		return ApplyResponse{}, execErr
 	}
-	if err := r.audit("apply", req, decision, &result); err != nil {
-		return ApplyResponse{}, err
+	if !r.skipReadAudit(req, decision) {
+		if err := r.audit("apply", req, decision, &result, r.upstreamAuditFields(req)); err != nil {
+			return ApplyResponse{}, err
+		}
 	}
-	return ApplyResponse{Request: req, Decision: decision, Result: result}, nil
+	return ApplyResponse{Request: req, Decision: decision, Result: result, TransactionID: req.TransactionID, Timing: timing}, nil
 }
 
-func (r *Runner) audit(kind string, req proxmox.ActionRequest, decision policy.Decision, result *proxmox.ActionResult) error {
-	if r.auditTo == "" {
+// skipReadAudit reports whether a successful record for req may be left out
+// of the audit log. A read whose risk was raised (e.g. by min_risk) is still
+// audited.
+func (r *Runner) skipReadAudit(req proxmox.ActionRequest, decision policy.Decision) bool {
+	return r.skipReads && proxmox.IsReadAction(req.Action) && decision.RiskLevel == "low"
+}
+
+// AuditSharedRead records a read answered with the outcome of an identical
+// read run for the request sharedWith, so every caller appears in the audit
+// log. err is that read's error, if it failed.
+func (r *Runner) AuditSharedRead(req proxmox.ActionRequest, decision policy.Decision, sharedWith string, err error) error {
+	if err == nil && r.skipReadAudit(req, decision) {
 		return nil
 	}
-	if err := os.MkdirAll(filepath.Dir(r.auditTo), 0o755); err != nil {
-		return err
+	extra := map[string]any{"shared_with": sharedWith}
+	if err != nil {
+		extra["error"] = redactErrorMessage(err.Error())
+	}
+	return r.audit("read_shared", req, decision, nil, extra)
+}
+
+// startSpan opens a span for req, keyed to its request ID, and points
+// req.Trace at it so the client's upstream span becomes a child.
+func (r *Runner) startSpan(name string, req *proxmox.ActionRequest) *tracing.ActiveSpan {
+	span := r.tracer.Start(name, tracing.SpanContext{TraceID: tracing.TraceIDFromRequestID(req.RequestID)})
+	if span == nil {
+		return nil
+	}
+	span.SetAttribute("action", string(req.Action))
+	span.SetAttribute("target", req.Target)
+	span.SetAttribute("environment", req.Environment)
+	span.SetAttribute("dry_run", req.DryRun)
+	if req.RequestID != "" {
+		span.SetAttribute("request_id", req.RequestID)
 	}
-	f, err := os.OpenFile(r.auditTo, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	req.Trace = span.Context()
+	return span
+}
+
+func setDecisionAttributes(span *tracing.ActiveSpan, decision policy.Decision) {
+	span.SetAttribute("risk", decision.RiskLevel)
+	span.SetAttribute("allowed", decision.Allowed)
+}
+
+func endSpan(span *tracing.ActiveSpan, err error) {
+	span.RecordError(err)
+	span.End()
+}
+
+// upstreamAuditFields records the resolved Proxmox call for forensics, which
+// matters most for storage/firewall edits whose endpoint comes from params.
+func (r *Runner) upstreamAuditFields(req proxmox.ActionRequest) map[string]any {
+	resolve := proxmox.ResolveEndpoint
+	if resolver, ok := r.client.(proxmox.EndpointResolver); ok {
+		resolve = resolver.ResolveEndpoint
+	}
+	method, endpoint, err := resolve(req)
 	if err != nil {
-		return err
+		return nil
 	}
-	defer f.Close()
+	return map[string]any{
+		"upstream": map[string]string{
+			"method":   method,
+			"endpoint": endpoint,
+		},
+	}
+}
 
+func (r *Runner) audit(kind string, req proxmox.ActionRequest, decision policy.Decision, result *proxmox.ActionResult, extra map[string]any) error {
+	if r.auditLog == nil {
+		return nil
+	}
+
+	req.Params = redact.Map(req.Params, redact.SensitiveKeys)
 	record := map[string]any{
 		"ts":       time.Now().UTC().Format(time.RFC3339),
 		"kind":     kind,
@@ -84,9 +431,30 @@ func (r *Runner) audit(kind string, req proxmox.ActionRequest, decision policy.D
 		"request":  req,
 		"decision": decision,
 	}
+	if req.OnBehalfOf != "" {
+		record["on_behalf_of"] = req.OnBehalfOf
+	}
+	if req.TokenIdentity != "" {
+		record["token_identity"] = req.TokenIdentity
+	}
+	if req.ClientIP != "" {
+		record["client_ip"] = req.ClientIP
+	}
+	if req.RequestID != "" {
+		record["request_id"] = req.RequestID
+	}
+	if req.TransactionID != "" {
+		record["transaction_id"] = req.TransactionID
+	}
 	if result != nil {
 		record["result"] = result
 	}
-	enc := json.NewEncoder(f)
-	return enc.Encode(record)
+	for k, v := range extra {
+		record[k] = v
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return r.auditLog.Write(append(line, '\n'))
 }
@@ -1,14 +1,26 @@
 package actions
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/junlov/proxmox-ai/internal/config"
 	"github.com/junlov/proxmox-ai/internal/policy"
 	"github.com/junlov/proxmox-ai/internal/proxmox"
+	"github.com/junlov/proxmox-ai/internal/tracing"
 )
 
 type fakeClient struct {
@@ -88,6 +100,7 @@ func TestRunnerWritesAuditRecordsForPlanDeniedAndApply(t *testing.T) {
 	client := &fakeClient{}
 	auditPath := filepath.Join(t.TempDir(), "audit.log")
 	runner := NewRunner(policy.NewEngine(), client, auditPath)
+	defer runner.Close()
 
 	_, err := runner.Plan(proxmox.ActionRequest{
 		Environment: "home",
@@ -152,6 +165,7 @@ func TestRunnerAuditIncludesActorIdentity(t *testing.T) {
 	client := &fakeClient{}
 	auditPath := filepath.Join(t.TempDir(), "audit.log")
 	runner := NewRunner(policy.NewEngine(), client, auditPath)
+	defer runner.Close()
 
 	_, err := runner.Plan(proxmox.ActionRequest{
 		Environment: "home",
@@ -177,3 +191,743 @@ func TestRunnerAuditIncludesActorIdentity(t *testing.T) {
 		t.Fatalf("expected actor %q, got %q", "test-agent", record.Actor)
 	}
 }
+
+func TestRunnerAuditIncludesClientIP(t *testing.T) {
+	client := &fakeClient{}
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	runner := NewRunner(policy.NewEngine(), client, auditPath)
+	defer runner.Close()
+
+	_, err := runner.Plan(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionReadVM,
+		Target:      "vm/101",
+		Actor:       "test-agent",
+		ClientIP:    "198.51.100.7",
+	})
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit file: %v", err)
+	}
+	var record struct {
+		ClientIP string `json:"client_ip"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(b))), &record); err != nil {
+		t.Fatalf("decode audit record: %v", err)
+	}
+	if record.ClientIP != "198.51.100.7" {
+		t.Fatalf("expected client_ip %q, got %q", "198.51.100.7", record.ClientIP)
+	}
+}
+
+func TestRunnerAuditIncludesResolvedUpstreamEndpoint(t *testing.T) {
+	client := &fakeClient{}
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	runner := NewRunner(policy.NewEngine(), client, auditPath)
+	defer runner.Close()
+
+	_, err := runner.Apply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionFirewallEdit,
+		Target:      "firewall/cluster",
+		Params: map[string]any{
+			"endpoint": "/api2/json/cluster/firewall/rules",
+			"action":   "ACCEPT",
+		},
+		ApprovedBy: "ops-user",
+	})
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit file: %v", err)
+	}
+	var record struct {
+		Kind     string `json:"kind"`
+		Upstream struct {
+			Method   string `json:"method"`
+			Endpoint string `json:"endpoint"`
+		} `json:"upstream"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(b))), &record); err != nil {
+		t.Fatalf("decode audit record: %v", err)
+	}
+	if record.Kind != "apply" {
+		t.Fatalf("unexpected audit kind: %q", record.Kind)
+	}
+	if record.Upstream.Method != "POST" || record.Upstream.Endpoint != "/api2/json/cluster/firewall/rules" {
+		t.Fatalf("unexpected upstream in audit: %+v", record.Upstream)
+	}
+}
+
+func TestPlanAndApplyRecordTiming(t *testing.T) {
+	runner := NewRunner(policy.NewEngine(), &fakeClient{}, "")
+	req := proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/101"}
+
+	plan, err := runner.Plan(req)
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	applied, err := runner.Apply(req)
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	for name, timing := range map[string]Timing{"plan": plan.Timing, "apply": applied.Timing} {
+		if timing.StartedAt.IsZero() || timing.CompletedAt.IsZero() {
+			t.Fatalf("%s: expected timestamps to be set, got %+v", name, timing)
+		}
+		if timing.CompletedAt.Before(timing.StartedAt) {
+			t.Fatalf("%s: completed_at %v is before started_at %v", name, timing.CompletedAt, timing.StartedAt)
+		}
+		if timing.DurationMs < 0 {
+			t.Fatalf("%s: negative duration %d", name, timing.DurationMs)
+		}
+	}
+}
+
+type statusClient struct {
+	status  string
+	actions []proxmox.ActionType
+}
+
+func (c *statusClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	c.actions = append(c.actions, req.Action)
+	if req.Action == proxmox.ActionReadVM {
+		return proxmox.ActionResult{Status: "ok", Data: map[string]any{"status": c.status}}, nil
+	}
+	return proxmox.ActionResult{Status: "accepted"}, nil
+}
+
+func TestApplyWithMatchingExpectStatusProceeds(t *testing.T) {
+	client := &statusClient{status: "stopped"}
+	runner := NewRunner(policy.NewEngine(), client, "")
+
+	_, err := runner.Apply(proxmox.ActionRequest{
+		Environment:  "home",
+		Action:       proxmox.ActionStartVM,
+		Target:       "vm/101",
+		Params:       map[string]any{"node": "pve1"},
+		ExpectStatus: "stopped",
+	})
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	if len(client.actions) != 2 || client.actions[0] != proxmox.ActionReadVM || client.actions[1] != proxmox.ActionStartVM {
+		t.Fatalf("expected status read then start, got %v", client.actions)
+	}
+}
+
+func TestApplyWithMismatchedExpectStatusDoesNotExecute(t *testing.T) {
+	client := &statusClient{status: "running"}
+	runner := NewRunner(policy.NewEngine(), client, "")
+
+	_, err := runner.Apply(proxmox.ActionRequest{
+		Environment:  "home",
+		Action:       proxmox.ActionStartVM,
+		Target:       "vm/101",
+		Params:       map[string]any{"node": "pve1"},
+		ExpectStatus: "stopped",
+	})
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("expected precondition failure, got %v", err)
+	}
+	if len(client.actions) != 1 || client.actions[0] != proxmox.ActionReadVM {
+		t.Fatalf("expected only the status read, got %v", client.actions)
+	}
+}
+
+type missingVMClient struct {
+	calls int
+}
+
+func (c *missingVMClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	c.calls++
+	return proxmox.ActionResult{}, &proxmox.APIError{StatusCode: http.StatusNotFound, Method: http.MethodGet, Endpoint: "/api2/json/nodes/pve1/qemu/999/status/current", Message: "not found"}
+}
+
+func TestPlanAnnotatesMissingTarget(t *testing.T) {
+	client := &missingVMClient{}
+	runner := NewRunner(policy.NewEngine(), client, "", WithTargetExistenceCheck(TargetCheckAnnotate))
+
+	resp, err := runner.Plan(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/999", Params: map[string]any{"node": "pve1"}})
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if resp.TargetExists == nil || *resp.TargetExists {
+		t.Fatalf("expected target_exists false, got %v", resp.TargetExists)
+	}
+	if !resp.Decision.Allowed {
+		t.Fatal("annotate mode must not deny the plan")
+	}
+	raw, _ := json.Marshal(resp)
+	if !strings.Contains(string(raw), `"target_exists":false`) {
+		t.Fatalf("expected target_exists:false in JSON, got %s", raw)
+	}
+}
+
+func TestPlanRequireModeDeniesMissingTarget(t *testing.T) {
+	runner := NewRunner(policy.NewEngine(), &missingVMClient{}, "", WithTargetExistenceCheck(TargetCheckRequire))
+
+	resp, err := runner.Plan(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/999", Params: map[string]any{"node": "pve1"}})
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if resp.Decision.Allowed || !strings.Contains(resp.Decision.Reason, "does not exist") {
+		t.Fatalf("expected plan denied for missing target, got %+v", resp.Decision)
+	}
+}
+
+func TestPlanSkipsExistenceCheckByDefault(t *testing.T) {
+	client := &missingVMClient{}
+	runner := NewRunner(policy.NewEngine(), client, "")
+
+	resp, err := runner.Plan(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/999", Params: map[string]any{"node": "pve1"}})
+	if err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if client.calls != 0 || resp.TargetExists != nil {
+		t.Fatalf("expected no existence read by default, calls=%d target_exists=%v", client.calls, resp.TargetExists)
+	}
+}
+
+type failingClient struct{}
+
+func (failingClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	return proxmox.ActionResult{}, &proxmox.APIError{StatusCode: http.StatusInternalServerError, Method: http.MethodPost, Endpoint: "/api2/json/nodes/pve1/qemu/101/status/start", Message: "VM is locked (backup)"}
+}
+
+func TestApplyFailureReturnsExecuteErrorAndAuditsIt(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	runner := NewRunner(policy.NewEngine(), failingClient{}, auditPath)
+	defer runner.Close()
+	req := proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/101", Params: map[string]any{"node": "pve1"}}
+
+	_, err := runner.Apply(req)
+
+	var execErr *proxmox.ExecuteError
+	if !errors.As(err, &execErr) {
+		t.Fatalf("expected *proxmox.ExecuteError, got %T: %v", err, err)
+	}
+	if execErr.Action != proxmox.ActionStartVM || execErr.Target != "vm/101" || execErr.Environment != "home" {
+		t.Fatalf("unexpected execute error fields: %+v", execErr)
+	}
+	var apiErr *proxmox.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected wrapped APIError, got %v", err)
+	}
+
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit file: %v", err)
+	}
+	var record struct {
+		Kind    string `json:"kind"`
+		Error   string `json:"error"`
+		Request struct {
+			Action string `json:"action"`
+		} `json:"request"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(b))), &record); err != nil {
+		t.Fatalf("decode audit record: %v", err)
+	}
+	if record.Kind != "apply_failed" || record.Request.Action != string(proxmox.ActionStartVM) {
+		t.Fatalf("unexpected audit record: %+v", record)
+	}
+	if !strings.Contains(record.Error, "VM is locked") {
+		t.Fatalf("expected error in audit record, got %q", record.Error)
+	}
+}
+
+type leakyFailingClient struct{}
+
+func (leakyFailingClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	return proxmox.ActionResult{}, &proxmox.APIError{StatusCode: http.StatusBadRequest, Method: http.MethodPost, Endpoint: "/api2/json/nodes/pve1/qemu/101/config", Message: `parameter verification failed: cipassword=hunter2 rejected; ticket=PVE:root@pam:4EEC61E2::sig; header PVEAPIToken=root@pam!agent=abc123`}
+}
+
+func TestApplyFailedAuditRedactsErrorMessage(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	runner := NewRunner(policy.NewEngine(), leakyFailingClient{}, auditPath)
+	defer runner.Close()
+
+	_, err := runner.Apply(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/101", Params: map[string]any{"node": "pve1"}})
+	if err == nil || !strings.Contains(err.Error(), "parameter verification failed") {
+		t.Fatalf("expected execution error to propagate, got %v", err)
+	}
+
+	b, readErr := os.ReadFile(auditPath)
+	if readErr != nil {
+		t.Fatalf("read audit file: %v", readErr)
+	}
+	line := string(b)
+	if !strings.Contains(line, `"kind":"apply_failed"`) {
+		t.Fatalf("expected apply_failed record, got %s", line)
+	}
+	if strings.Contains(line, "hunter2") || strings.Contains(line, "abc123") || strings.Contains(line, "4EEC61E2") {
+		t.Fatalf("expected secrets redacted from audit record, got %s", line)
+	}
+	if !strings.Contains(line, "cipassword=[REDACTED]") {
+		t.Fatalf("expected redaction marker in audit record, got %s", line)
+	}
+}
+
+func TestAuditRedactsSensitiveParams(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	runner := NewRunner(policy.NewEngine(), &fakeClient{}, auditPath)
+	defer runner.Close()
+
+	params := map[string]any{"node": "pve1", "cipassword": "hunter2"}
+	if _, err := runner.Plan(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/101", Params: params}); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit file: %v", err)
+	}
+	if line := string(b); strings.Contains(line, "hunter2") || !strings.Contains(line, `"cipassword":"[REDACTED]"`) {
+		t.Fatalf("expected cipassword redacted from audit record, got %s", line)
+	}
+	if params["cipassword"] != "hunter2" {
+		t.Fatalf("expected caller params to be left alone, got %v", params["cipassword"])
+	}
+}
+
+func TestConcurrentAppliesWriteIntactAuditRecords(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	runner := NewRunner(policy.NewEngine(), &countingClient{}, auditPath, WithAuditFsync(true))
+	defer runner.Close()
+
+	const applies = 64
+	bigReason := strings.Repeat("maintenance window ", 512)
+	var wg sync.WaitGroup
+	for i := 0; i < applies; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := runner.Apply(proxmox.ActionRequest{
+				Environment: "home",
+				Action:      proxmox.ActionStartVM,
+				Target:      fmt.Sprintf("vm/%d", 100+i),
+				Params:      map[string]any{"node": "pve1"},
+				Reason:      bigReason,
+			})
+			if err != nil {
+				t.Errorf("Apply returned error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := runner.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit file: %v", err)
+	}
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	if len(lines) != applies {
+		t.Fatalf("expected %d audit records, got %d", applies, len(lines))
+	}
+	seen := map[string]bool{}
+	for i, line := range lines {
+		var record struct {
+			Kind    string                `json:"kind"`
+			Request proxmox.ActionRequest `json:"request"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("record %d is not intact JSON: %v", i, err)
+		}
+		if record.Kind != "apply" || record.Request.Reason != bigReason {
+			t.Fatalf("record %d is incomplete: kind=%q reason length=%d", i, record.Kind, len(record.Request.Reason))
+		}
+		seen[record.Request.Target] = true
+	}
+	if len(seen) != applies {
+		t.Fatalf("expected %d distinct targets, got %d", applies, len(seen))
+	}
+}
+
+func TestAuditAfterCloseFails(t *testing.T) {
+	runner := NewRunner(policy.NewEngine(), &countingClient{}, filepath.Join(t.TempDir(), "audit.log"))
+	defer runner.Close()
+	if err := runner.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+	if _, err := runner.Plan(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionReadVM, Target: "vm/101"}); err == nil {
+		t.Fatal("expected plan to fail once the audit log is closed")
+	}
+}
+
+type countingClient struct {
+	calls atomic.Int32
+}
+
+func (c *countingClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	c.calls.Add(1)
+	return proxmox.ActionResult{Status: "accepted"}, nil
+}
+
+func TestApplyEmitsSpanWithUpstreamChild(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":"UPID:node1:0001"}`))
+	}))
+	defer upstream.Close()
+	t.Setenv("PVE_TEST_SECRET", "trace-secret")
+
+	exporter := &tracing.InMemoryExporter{}
+	tracer := tracing.New(exporter)
+	client, err := proxmox.NewAPIClient([]config.Environment{{
+		Name:           "home",
+		BaseURL:        upstream.URL,
+		TokenID:        "root@pam!agent",
+		TokenSecretEnv: "PVE_TEST_SECRET",
+	}}, proxmox.WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("NewAPIClient returned error: %v", err)
+	}
+	runner := NewRunner(policy.NewEngine(), client, "", WithTracer(tracer))
+
+	_, err = runner.Apply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionStartVM,
+		Target:      "node1/101",
+		RequestID:   "req-123",
+	})
+	if err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("expected upstream and apply spans, got %+v", spans)
+	}
+	child, apply := spans[0], spans[1]
+	if apply.Name != "apply" || child.Name != "proxmox.request" {
+		t.Fatalf("unexpected span names %q and %q", apply.Name, child.Name)
+	}
+	if apply.TraceID != tracing.TraceIDFromRequestID("req-123") {
+		t.Fatalf("expected trace ID derived from request ID, got %q", apply.TraceID)
+	}
+	want := map[string]any{
+		"action":      "start_vm",
+		"target":      "node1/101",
+		"environment": "home",
+		"risk":        "medium",
+		"allowed":     true,
+		"request_id":  "req-123",
+	}
+	for key, value := range want {
+		if apply.Attributes[key] != value {
+			t.Fatalf("apply span attribute %q = %v, want %v", key, apply.Attributes[key], value)
+		}
+	}
+	if _, ok := apply.Attributes["duration_ms"]; !ok {
+		t.Fatalf("expected duration_ms on apply span, got %v", apply.Attributes)
+	}
+	if child.TraceID != apply.TraceID || child.ParentSpanID != apply.SpanID {
+		t.Fatalf("expected upstream span to be a child of apply, got %+v", child)
+	}
+	if child.Attributes["http.method"] != http.MethodPost {
+		t.Fatalf("unexpected upstream span attributes: %v", child.Attributes)
+	}
+}
+
+func TestTargetExistenceReadNestsUnderPlanSpan(t *testing.T) {
+	exporter := &tracing.InMemoryExporter{}
+	tracer := tracing.New(exporter)
+	client := &traceRecordingClient{}
+	runner := NewRunner(policy.NewEngine(), client, "", WithTracer(tracer), WithTargetExistenceCheck(TargetCheckAnnotate))
+
+	if _, err := runner.Plan(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionStartVM,
+		Target:      "node1/101",
+		RequestID:   "req-456",
+	}); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	spans := exporter.Spans()
+	if len(spans) != 1 || spans[0].Name != "plan" {
+		t.Fatalf("expected a single plan span, got %+v", spans)
+	}
+	if len(client.traces) != 1 || client.traces[0].SpanID != spans[0].SpanID || client.traces[0].TraceID != spans[0].TraceID {
+		t.Fatalf("expected the existence read to carry the plan span, got %+v", client.traces)
+	}
+}
+
+type traceRecordingClient struct {
+	traces []tracing.SpanContext
+}
+
+func (c *traceRecordingClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	c.traces = append(c.traces, req.Trace)
+	return proxmox.ActionResult{Status: "ok", Data: map[string]any{"status": "running"}}, nil
+}
+
+// pendingDeleteClient tags VMs on a soft delete the way Proxmox would and
+// records hard deletes. forged VMs carry a pending-delete tag nobody
+// audited.
+type pendingDeleteClient struct {
+	mu       sync.Mutex
+	tags     map[int64]string
+	forged   map[int64]string
+	deleted  []string
+	approved []string
+}
+
+func (c *pendingDeleteClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case req.Action == proxmox.ActionReadInventory:
+		var items []any
+		for _, tagged := range []map[int64]string{c.tags, c.forged} {
+			for vmid, tags := range tagged {
+				items = append(items, map[string]any{"vmid": float64(vmid), "node": "node1", "type": "qemu", "tags": tags})
+			}
+		}
+		return proxmox.ActionResult{Status: "ok", Data: items}, nil
+	case proxmox.IsSoftDelete(req):
+		vmid, _ := strconv.ParseInt(strings.TrimPrefix(req.Target, "vm/"), 10, 64)
+		c.tags[vmid] = fmt.Sprintf("pending-delete;pending-delete-%d", time.Now().Unix())
+	case req.Action == proxmox.ActionDeleteVM:
+		c.deleted = append(c.deleted, req.Target)
+		c.approved = append(c.approved, req.ApprovedBy)
+	}
+	return proxmox.ActionResult{Status: "accepted"}, nil
+}
+
+func TestReaperDeletesOnlyAuditedSoftDeletesAfterGraceWindow(t *testing.T) {
+	client := &pendingDeleteClient{
+		tags:   map[int64]string{},
+		forged: map[int64]string{103: "pending-delete;pending-delete-1000"},
+	}
+	runner := NewRunner(policy.NewEngine(), client, filepath.Join(t.TempDir(), "audit.log"), WithApprovalBinding(true))
+	defer runner.Close()
+	soft := proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionDeleteVM,
+		Target:      "vm/101",
+		Params:      map[string]any{"node": "node1", "soft": true},
+		ApprovedBy:  "ops-user",
+	}
+	if _, err := runner.Plan(soft); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if _, err := runner.Apply(soft); err != nil {
+		t.Fatalf("soft delete Apply returned error: %v", err)
+	}
+
+	reaper := NewReaper(runner, time.Hour)
+	reaper.now = func() time.Time { return time.Now().Add(30 * time.Minute) }
+	results, err := reaper.Reap("home")
+	if err != nil {
+		t.Fatalf("Reap returned error: %v", err)
+	}
+	if len(client.deleted) != 0 || len(results) != 2 {
+		t.Fatalf("expected nothing reaped inside the grace window, got %v (%+v)", client.deleted, results)
+	}
+
+	reaper.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	results, err = reaper.Reap("home")
+	if err != nil {
+		t.Fatalf("Reap returned error: %v", err)
+	}
+	if len(client.deleted) != 1 || client.deleted[0] != "vm/101" || client.approved[0] != "ops-user" {
+		t.Fatalf("expected only vm/101 deleted under the soft delete's approval, got %v by %v", client.deleted, client.approved)
+	}
+	for _, res := range results {
+		if res.Target == "vm/103" && (res.Deleted || res.Error == "") {
+			t.Fatalf("expected the forged tag to be refused, got %+v", res)
+		}
+	}
+}
+
+func TestReaperRecognizesSoftDeleteSentAsString(t *testing.T) {
+	client := &pendingDeleteClient{tags: map[int64]string{}}
+	runner := NewRunner(policy.NewEngine(), client, filepath.Join(t.TempDir(), "audit.log"))
+	defer runner.Close()
+	if _, err := runner.Apply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionDeleteVM,
+		Target:      "vm/101",
+		Params:      map[string]any{"node": "node1", "soft": "true"},
+		ApprovedBy:  "ops-user",
+	}); err != nil {
+		t.Fatalf("soft delete Apply returned error: %v", err)
+	}
+
+	reaper := NewReaper(runner, time.Hour)
+	reaper.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	results, err := reaper.Reap("home")
+	if err != nil {
+		t.Fatalf("Reap returned error: %v", err)
+	}
+	if len(client.deleted) != 1 || client.deleted[0] != "vm/101" {
+		t.Fatalf("expected the string-flagged soft delete to be reaped, got %v (%+v)", client.deleted, results)
+	}
+}
+
+func TestReaperCarriesTheSoftDeleteTicket(t *testing.T) {
+	client := &pendingDeleteClient{tags: map[int64]string{}}
+	engine := policy.NewEngine(policy.WithRequiredTicket("", []string{"delete_vm"}))
+	runner := NewRunner(engine, client, filepath.Join(t.TempDir(), "audit.log"))
+	defer runner.Close()
+	if _, err := runner.Apply(proxmox.ActionRequest{
+		Environment:    "home",
+		Action:         proxmox.ActionDeleteVM,
+		Target:         "vm/101",
+		Params:         map[string]any{"node": "node1", "soft": true},
+		ApprovedBy:     "ops-user",
+		ApprovalTicket: "CHG-1234",
+	}); err != nil {
+		t.Fatalf("soft delete Apply returned error: %v", err)
+	}
+
+	reaper := NewReaper(runner, time.Hour)
+	reaper.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	results, err := reaper.Reap("home")
+	if err != nil {
+		t.Fatalf("Reap returned error: %v", err)
+	}
+	if len(client.deleted) != 1 || client.deleted[0] != "vm/101" {
+		t.Fatalf("expected the ticketed soft delete to be reaped, got %v (%+v)", client.deleted, results)
+	}
+}
+
+func TestReaperReadsRotatedAuditLogs(t *testing.T) {
+	client := &pendingDeleteClient{tags: map[int64]string{}}
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	runner := NewRunner(policy.NewEngine(), client, auditPath)
+	if _, err := runner.Apply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionDeleteVM,
+		Target:      "vm/101",
+		Params:      map[string]any{"node": "node1", "soft": true},
+		ApprovedBy:  "ops-user",
+	}); err != nil {
+		t.Fatalf("soft delete Apply returned error: %v", err)
+	}
+	runner.Close()
+
+	// Rotate the log the way logrotate's compress option leaves it.
+	raw, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(raw)
+	gz.Close()
+	if err := os.WriteFile(auditPath+".1.gz", buf.Bytes(), 0o600); err != nil {
+		t.Fatalf("write rotated log: %v", err)
+	}
+	if err := os.Remove(auditPath); err != nil {
+		t.Fatalf("remove audit log: %v", err)
+	}
+
+	runner = NewRunner(policy.NewEngine(), client, auditPath)
+	defer runner.Close()
+	reaper := NewReaper(runner, time.Hour)
+	reaper.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	results, err := reaper.Reap("home")
+	if err != nil {
+		t.Fatalf("Reap returned error: %v", err)
+	}
+	if len(client.deleted) != 1 || client.deleted[0] != "vm/101" {
+		t.Fatalf("expected the soft delete from the rotated log to be reaped, got %v (%+v)", client.deleted, results)
+	}
+}
+
+func TestReaperRequiresAuditLog(t *testing.T) {
+	reaper := NewReaper(NewRunner(policy.NewEngine(), &pendingDeleteClient{}, ""), time.Hour)
+	if _, err := reaper.Reap("home"); !errors.Is(err, ErrAuditDisabled) {
+		t.Fatalf("expected ErrAuditDisabled, got %v", err)
+	}
+}
+
+func TestAuditReadsDisabledSkipsReadsButAuditsDeletes(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	runner := NewRunner(policy.NewEngine(), &fakeClient{}, auditPath, WithAuditReads(false))
+	defer runner.Close()
+
+	read := proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionReadVM, Target: "node1/101"}
+	if _, err := runner.Plan(read); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	if _, err := runner.Apply(read); err != nil {
+		t.Fatalf("Apply returned error: %v", err)
+	}
+	if b, _ := os.ReadFile(auditPath); len(strings.TrimSpace(string(b))) != 0 {
+		t.Fatalf("expected no audit records for reads, got %s", b)
+	}
+
+	if _, err := runner.Apply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionDeleteVM,
+		Target:      "node1/101",
+		ApprovedBy:  "ops-user",
+	}); err != nil {
+		t.Fatalf("delete Apply returned error: %v", err)
+	}
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"action":"delete_vm"`) {
+		t.Fatalf("expected a single delete record, got %q", lines)
+	}
+}
+
+type nodeListClient struct {
+	nodeReads int
+	executed  int
+}
+
+func (c *nodeListClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	if req.Action == proxmox.ActionReadNodes {
+		c.nodeReads++
+		return proxmox.ActionResult{Status: "ok", Data: []any{
+			map[string]any{"type": "node", "node": "pve1"},
+			map[string]any{"type": "node", "node": "pve2"},
+		}}, nil
+	}
+	c.executed++
+	return proxmox.ActionResult{Status: "accepted"}, nil
+}
+
+func TestNodeCheckRejectsUnknownNodeAndCachesList(t *testing.T) {
+	client := &nodeListClient{}
+	runner := NewRunner(policy.NewEngine(), client, "", WithNodeCheck(true, time.Minute))
+
+	_, err := runner.Plan(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "pve-1/101"})
+	if !errors.Is(err, ErrUnknownNode) || !strings.Contains(err.Error(), `"pve-1"`) {
+		t.Fatalf("expected unknown node error, got %v", err)
+	}
+	if _, err := runner.Apply(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "pve1/101"}); err != nil {
+		t.Fatalf("expected known node to be accepted, got %v", err)
+	}
+	_, err = runner.Apply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionMigrateVM,
+		Target:      "pve1/101",
+		Params:      map[string]any{"target": "pve3"},
+		ApprovedBy:  "ops-user",
+	})
+	if !errors.Is(err, ErrUnknownNode) {
+		t.Fatalf("expected unknown migrate destination to be rejected, got %v", err)
+	}
+	if client.executed != 1 {
+		t.Fatalf("expected only the known-node request to execute, got %d", client.executed)
+	}
+	if client.nodeReads != 1 {
+		t.Fatalf("expected the node list to be fetched once, got %d", client.nodeReads)
+	}
+}
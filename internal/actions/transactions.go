@@ -0,0 +1,82 @@
+package actions
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// ErrTransactionMismatch is returned when an apply names the transaction of
+// a plan made for a different request.
+var ErrTransactionMismatch = errors.New("transaction was planned for a different request")
+
+// planTransactions remembers the request fingerprint each plan's
+// transaction ID was issued for, so an apply cannot claim a plan's
+// transaction while doing something else. Entries are evicted after
+// DefaultApprovalBindingTTL, and at most maxPlanBindings are kept.
+type planTransactions struct {
+	ttl time.Duration
+	max int
+	now func() time.Time
+
+	mu      sync.Mutex
+	planned map[string]approvalBinding
+}
+
+func newPlanTransactions() *planTransactions {
+	return &planTransactions{
+		ttl:     DefaultApprovalBindingTTL,
+		max:     maxPlanBindings,
+		now:     time.Now,
+		planned: make(map[string]approvalBinding),
+	}
+}
+
+func (t *planTransactions) record(req proxmox.ActionRequest) error {
+	if req.TransactionID == "" {
+		return nil
+	}
+	fingerprint, err := requestFingerprint(req)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	for id, planned := range t.planned {
+		if now.Sub(planned.planned) >= t.ttl {
+			delete(t.planned, id)
+		}
+	}
+	// Refusing rather than evicting: an evicted transaction would let its
+	// apply through unchecked.
+	if _, ok := t.planned[req.TransactionID]; !ok && len(t.planned) >= t.max {
+		return ErrTooManyPlans
+	}
+	t.planned[req.TransactionID] = approvalBinding{fingerprint: fingerprint, planned: now}
+	return nil
+}
+
+// check returns ErrTransactionMismatch when req's transaction was planned
+// for another request. Transactions no plan recorded pass unchecked.
+func (t *planTransactions) check(req proxmox.ActionRequest) error {
+	if req.TransactionID == "" {
+		return nil
+	}
+	t.mu.Lock()
+	planned, ok := t.planned[req.TransactionID]
+	t.mu.Unlock()
+	if !ok || t.now().Sub(planned.planned) >= t.ttl {
+		return nil
+	}
+	fingerprint, err := requestFingerprint(req)
+	if err != nil {
+		return err
+	}
+	if fingerprint != planned.fingerprint {
+		return ErrTransactionMismatch
+	}
+	return nil
+}
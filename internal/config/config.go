@@ -3,45 +3,621 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
+	"time"
 )
 
+var (
+	headerNamePattern   = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+	fingerprintPattern  = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+	placeholderPattern  = regexp.MustCompile(`\{[^}]*\}?`)
+	endpointPlaceholder = map[string]bool{"{node}": true, "{vmid}": true}
+)
+
+func validateEndpointTemplate(tmpl string) error {
+	if !strings.HasPrefix(tmpl, "/") {
+		return fmt.Errorf("template must start with /")
+	}
+	for _, placeholder := range placeholderPattern.FindAllString(tmpl, -1) {
+		if !endpointPlaceholder[placeholder] {
+			return fmt.Errorf("unsupported placeholder %q; use {node} or {vmid}", placeholder)
+		}
+	}
+	if strings.Count(tmpl, "{") != strings.Count(tmpl, "}") {
+		return fmt.Errorf("unbalanced braces in template")
+	}
+	return nil
+}
+
+// ValidateExtraHeaders checks extra_headers names and values. Authorization
+// is reserved: the agent always sends its own token.
+func ValidateExtraHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		if !headerNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if strings.EqualFold(name, "Authorization") {
+			return fmt.Errorf("header %q cannot be overridden", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("header %q value must not contain line breaks", name)
+		}
+	}
+	return nil
+}
+
 type Environment struct {
 	Name           string `json:"name"`
 	BaseURL        string `json:"base_url"`
 	TokenID        string `json:"token_id"`
 	TokenSecretEnv string `json:"token_secret_env"`
+	// TokenSecretRef takes precedence over TokenSecretEnv and names the
+	// secret as "<scheme>:<ref>", e.g. "env:PVE_SECRET" or "file:/run/secrets/pve".
+	TokenSecretRef string `json:"token_secret_ref,omitempty"`
+	// APIBasePath overrides the "/api2/json" prefix for clusters reached
+	// through a path-rewriting gateway.
+	APIBasePath string `json:"api_base_path,omitempty"`
+	// TLSFingerprint pins the SHA-256 fingerprint of the cluster's leaf
+	// certificate (hex, colons optional) for self-signed clusters. When set,
+	// only that certificate is accepted; otherwise the system CAs apply.
+	TLSFingerprint string `json:"tls_fingerprint_sha256,omitempty"`
+	// EndpointOverrides maps an action name to an API path template, relative
+	// to the API base path, for clusters whose paths differ from upstream.
+	// Templates may use {node} and {vmid}.
+	EndpointOverrides map[string]string `json:"endpoint_overrides,omitempty"`
+	// ExtraHeaders are added to every request sent to this environment, for
+	// gateways that expect e.g. X-Gateway-Key. Authorization is reserved.
+	ExtraHeaders map[string]string `json:"extra_headers,omitempty"`
+	// AllowInsecureHTTP permits an http:// base URL, which sends the API
+	// token in the clear. Meant for local test clusters only.
+	AllowInsecureHTTP bool `json:"allow_insecure_http,omitempty"`
+	// ReadOnly freezes the environment to reads: every mutating action is
+	// denied. Re-read on SIGHUP, so it can be flipped during an incident.
+	ReadOnly bool `json:"read_only,omitempty"`
+	// DefaultNode is used as params.node for vm/<id> targets that omit it,
+	// for single-node environments.
+	DefaultNode string `json:"default_node,omitempty"`
+	// Tags label the environment for tag-scoped policy, e.g.
+	// ["production"]; see Config.TagPolicies.
+	Tags []string `json:"tags,omitempty"`
+	// TokenIdentities are additional API tokens for this environment, by
+	// name. A request authorized with the admin token may select one so
+	// Proxmox attributes the call to that token instead of TokenID.
+	TokenIdentities map[string]TokenIdentity `json:"token_identities,omitempty"`
+}
+
+// TokenIdentity is a named Proxmox API token an environment can act as.
+// Its secret is resolved like the environment's own.
+type TokenIdentity struct {
+	TokenID        string `json:"token_id"`
+	TokenSecretEnv string `json:"token_secret_env,omitempty"`
+	TokenSecretRef string `json:"token_secret_ref,omitempty"`
+}
+
+// TagPolicy is a policy rule applied to every environment carrying a tag.
+type TagPolicy struct {
+	// MinRisk raises mutating actions to at least this tier, which also
+	// makes them require approval. Reads are unaffected.
+	MinRisk string `json:"min_risk,omitempty"`
+	// RequireTicket makes approvals in these environments carry an
+	// approval_ticket.
+	RequireTicket bool `json:"require_ticket,omitempty"`
+	// ReadOnly denies every mutating action, like Environment.ReadOnly.
+	ReadOnly bool `json:"read_only,omitempty"`
 }
 
 type Config struct {
 	ListenAddr   string        `json:"listen_addr"`
 	AuditLogPath string        `json:"audit_log_path"`
 	Environments []Environment `json:"environments"`
+	// TrustedProxies lists CIDRs of reverse proxies whose X-Forwarded-For
+	// header is honored when resolving the client IP.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+	// AllowedSourceCIDRs, when set, refuses every request whose client IP
+	// (after trusted proxy resolution) falls outside these ranges.
+	AllowedSourceCIDRs []string `json:"allowed_source_cidrs,omitempty"`
+	// DefaultDryRunActions lists actions that run as dry-run unless the
+	// request explicitly sets "dry_run": false.
+	DefaultDryRunActions []string `json:"default_dry_run_actions,omitempty"`
+	// StrictTransportSecurity adds an HSTS header to every response. Only
+	// enable it when the agent is served over TLS (directly or via a proxy).
+	StrictTransportSecurity bool `json:"strict_transport_security,omitempty"`
+	// MaxBatchItems caps how many requests a single batch or bulk call may
+	// carry; oversized calls are rejected before anything executes.
+	MaxBatchItems int `json:"max_batch_items,omitempty"`
+	// MaxEnvironments makes Load fail when more environments are configured,
+	// to catch accidental config explosions. Zero means no hard cap.
+	MaxEnvironments int `json:"max_environments,omitempty"`
+	// EnvironmentWarnThreshold logs a warning at load when more environments
+	// are configured; zero selects DefaultEnvironmentWarnThreshold.
+	EnvironmentWarnThreshold int `json:"environment_warn_threshold,omitempty"`
+	// RequireApprovalBinding rejects apply approvals that were not granted
+	// on a plan for the identical request. Each plan allows one apply and
+	// lapses unused after a day.
+	RequireApprovalBinding bool `json:"require_approval_binding,omitempty"`
+	// Listener timeouts in seconds; zero selects the defaults below.
+	ReadHeaderTimeoutSeconds int `json:"read_header_timeout_seconds,omitempty"`
+	ReadTimeoutSeconds       int `json:"read_timeout_seconds,omitempty"`
+	WriteTimeoutSeconds      int `json:"write_timeout_seconds,omitempty"`
+	IdleTimeoutSeconds       int `json:"idle_timeout_seconds,omitempty"`
+	// MaxUpstreamResponseBytes caps Proxmox response bodies; zero keeps the
+	// client default.
+	MaxUpstreamResponseBytes int64 `json:"max_upstream_response_bytes,omitempty"`
+	// BusyStatusCodes are upstream statuses meaning "cluster busy": reads
+	// back off and retry, writes fail fast with 503. Empty selects the
+	// client defaults (429 and 596).
+	BusyStatusCodes []int `json:"busy_status_codes,omitempty"`
+	// AuthTokenFile, when set, is read for the API bearer token instead of
+	// PROXMOX_AGENT_API_TOKEN, at startup and on every token reload.
+	AuthTokenFile string `json:"auth_token_file,omitempty"`
+	// TokenRotationOverlapSeconds is how long the previous API token stays
+	// valid after a reload; zero selects DefaultTokenRotationOverlap.
+	TokenRotationOverlapSeconds int `json:"token_rotation_overlap_seconds,omitempty"`
+	// ApprovalRetryAfterSeconds is the Retry-After suggested on applies
+	// denied for lacking approval; zero selects DefaultApprovalRetryAfter.
+	// Advisory only: nothing stops an earlier retry.
+	ApprovalRetryAfterSeconds int `json:"approval_retry_after_seconds,omitempty"`
+	// TargetExistenceCheck makes plan read the target VM first: "annotate"
+	// reports target_exists, "require" also denies plans for missing VMs.
+	// Empty or "off" skips the extra upstream read.
+	TargetExistenceCheck string `json:"target_existence_check,omitempty"`
+	// PlanImpact makes plans for high-risk actions look up what they would
+	// disrupt (whether a deleted VM is running and how many snapshots it
+	// has) and report it as impact. Off by default: it costs upstream reads.
+	PlanImpact bool `json:"plan_impact,omitempty"`
+	// CloneIDCheck makes clone_vm apply check first that params.newid is not
+	// used by any guest in the cluster, answering 409 if it is. Off by
+	// default to save the extra upstream read.
+	CloneIDCheck bool `json:"clone_newid_check,omitempty"`
+	// RequireMigrateOnline rejects migrate_vm requests that do not set
+	// params.online, so live vs offline migration is always a deliberate
+	// choice rather than the Proxmox default.
+	RequireMigrateOnline bool `json:"require_migrate_online,omitempty"`
+	// ProtectionCheck makes delete_vm apply read the VM config first and
+	// refuse, with 409, to delete a VM whose protection flag is set. Unset
+	// means true: one extra read per delete is cheap next to losing a VM.
+	ProtectionCheck *bool `json:"delete_protection_check,omitempty"`
+	// TraceLogPath, when set, enables tracing: every plan/apply and its
+	// upstream call is appended there as a JSON span (see package tracing;
+	// the format is not OTLP).
+	TraceLogPath string `json:"trace_log_path,omitempty"`
+	// PendingDeleteGraceSeconds is how long a soft-deleted VM is kept before
+	// the reaper destroys it; zero selects DefaultPendingDeleteGrace.
+	PendingDeleteGraceSeconds int `json:"pending_delete_grace_seconds,omitempty"`
+	// PendingDeleteReapIntervalSeconds enables the reaper, which checks every
+	// environment for expired pending-delete VMs at this interval. Zero
+	// leaves it off; expired VMs can still be deleted explicitly.
+	PendingDeleteReapIntervalSeconds int `json:"pending_delete_reap_interval_seconds,omitempty"`
+	// DebugCapturePath, when set, appends every upstream request and raw
+	// response, with credentials redacted, to this file. Diagnostic only;
+	// leave it empty in normal operation.
+	DebugCapturePath string `json:"debug_capture_path,omitempty"`
+	// AuditFsync syncs the audit log to disk after each batch of records.
+	AuditFsync bool `json:"audit_fsync,omitempty"`
+	// AuditFormat is "ndjson" (default, one record per line) or "array",
+	// which keeps the audit log a valid JSON array.
+	AuditFormat string `json:"audit_format,omitempty"`
+	// AuditSigningKeyRef names the HMAC key that signs each audit record, as
+	// "env:NAME" or "file:/path". Empty leaves records unsigned.
+	AuditSigningKeyRef string `json:"audit_signing_key_ref,omitempty"`
+	// AuditReads records low-risk read actions in the audit log. Unset means
+	// true; mutations are audited regardless.
+	AuditReads *bool `json:"audit_reads,omitempty"`
+	// RequireIdempotencyKey rejects apply and batch requests that carry no
+	// Idempotency-Key header. Plans and reads are exempt.
+	RequireIdempotencyKey bool `json:"require_idempotency_key,omitempty"`
+	// StrictAuth refuses to start the server while no API auth token is
+	// configured, instead of only logging a warning.
+	StrictAuth bool `json:"strict_auth,omitempty"`
+	// MaxApprovalValiditySeconds caps how far in the future an approval's
+	// expires_at may lie; zero selects DefaultMaxApprovalValidity.
+	MaxApprovalValiditySeconds int `json:"max_approval_validity_seconds,omitempty"`
+	// RejectUnknownNodes checks node names in requests against the cluster's
+	// node list, cached for NodeCacheTTLSeconds (default 30), and rejects
+	// typos up front instead of failing deep in the Proxmox API.
+	RejectUnknownNodes  bool `json:"reject_unknown_nodes,omitempty"`
+	NodeCacheTTLSeconds int  `json:"node_cache_ttl_seconds,omitempty"`
+	// ApprovalTicketMinRisk ("low", "medium" or "high") and
+	// ApprovalTicketActions make apply require an approval_ticket, not just
+	// approved_by, for requests at or above that risk and for those actions.
+	ApprovalTicketMinRisk string   `json:"approval_ticket_min_risk,omitempty"`
+	ApprovalTicketActions []string `json:"approval_ticket_actions,omitempty"`
+	// TagPolicies maps an environment tag to the rule applied to every
+	// environment carrying it, e.g. {"production": {"min_risk": "high"}}.
+	// An environment with several tags gets the strictest of their rules.
+	TagPolicies map[string]TagPolicy `json:"tag_policies,omitempty"`
+	// ApprovalTTLSeconds gives approvals for an action a default validity
+	// window when the request has no expires_at, e.g. {"stop_vm": 900}. The
+	// window starts when the approval is first presented.
+	ApprovalTTLSeconds map[string]int `json:"approval_ttl_seconds,omitempty"`
+	// DenyMessageTemplate is appended to policy denial reasons, e.g. "see
+	// https://wiki.example/change for {action} ({risk})". Placeholders:
+	// {action}, {risk}, {target}, {environment}.
+	DenyMessageTemplate string `json:"deny_message_template,omitempty"`
+	// LoadShedMaxInFlight, when positive, sheds low-priority requests with
+	// 503 while more requests than this are in flight. LoadShedPriorities
+	// maps action names to "low" or "high"; reads default to low and
+	// everything else to high.
+	LoadShedMaxInFlight int               `json:"load_shed_max_in_flight,omitempty"`
+	LoadShedPriorities  map[string]string `json:"load_shed_priorities,omitempty"`
+	// ReadDedupWindowMillis, when positive, lets identical reads from the
+	// same actor that arrive within this many milliseconds of each other
+	// share one upstream call. Requests carrying an Idempotency-Key are
+	// never deduplicated. This absorbs retries; it is not a cache.
+	ReadDedupWindowMillis int `json:"read_dedup_window_ms,omitempty"`
+	// SnapshotPrunePrefix marks the snapshots /v1/vm/snapshots/prune may
+	// delete; snapshots without it are never touched. Pruning is refused
+	// while it is empty.
+	SnapshotPrunePrefix string `json:"snapshot_prune_prefix,omitempty"`
+	// SlowRequestThresholdMs logs requests that take longer than this many
+	// milliseconds at level=warn instead of info. Zero disables the warning.
+	SlowRequestThresholdMs int `json:"slow_request_threshold_ms,omitempty"`
+	// UIEnabled serves the built-in dashboard at /ui.
+	UIEnabled bool `json:"ui_enabled,omitempty"`
+	// MetricsEnabled serves request counters at /metrics. A scrape must
+	// carry PROXMOX_AGENT_METRICS_TOKEN as its bearer token or come from one
+	// of MetricsAllowedCIDRs; MetricsOpen lifts both checks.
+	MetricsEnabled      bool     `json:"metrics_enabled,omitempty"`
+	MetricsAllowedCIDRs []string `json:"metrics_allowed_cidrs,omitempty"`
+	MetricsOpen         bool     `json:"metrics_open,omitempty"`
+}
+
+const (
+	DefaultReadHeaderTimeout = 5 * time.Second
+	DefaultReadTimeout       = 30 * time.Second
+	DefaultWriteTimeout      = 60 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+)
+
+// ServerTimeouts returns the listener timeouts with defaults applied.
+func (c Config) ServerTimeouts() (readHeader, read, write, idle time.Duration) {
+	pick := func(seconds int, fallback time.Duration) time.Duration {
+		if seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		return fallback
+	}
+	return pick(c.ReadHeaderTimeoutSeconds, DefaultReadHeaderTimeout),
+		pick(c.ReadTimeoutSeconds, DefaultReadTimeout),
+		pick(c.WriteTimeoutSeconds, DefaultWriteTimeout),
+		pick(c.IdleTimeoutSeconds, DefaultIdleTimeout)
+}
+
+// Load-shedding priorities for load_shed_priorities.
+const (
+	PriorityLow  = "low"
+	PriorityHigh = "high"
+)
+
+// ApprovalTTLs returns approval_ttl_seconds as durations.
+func (c Config) ApprovalTTLs() map[string]time.Duration {
+	ttls := make(map[string]time.Duration, len(c.ApprovalTTLSeconds))
+	for action, seconds := range c.ApprovalTTLSeconds {
+		ttls[action] = time.Duration(seconds) * time.Second
+	}
+	return ttls
+}
+
+// ReadOnlyEnvironments returns the names of environments marked read_only.
+func (c Config) ReadOnlyEnvironments() []string {
+	var names []string
+	for _, env := range c.Environments {
+		if env.ReadOnly {
+			names = append(names, env.Name)
+		}
+	}
+	return names
+}
+
+// EnvironmentTags maps environment names to their tags, for the
+// environments that have any.
+func (c Config) EnvironmentTags() map[string][]string {
+	tags := map[string][]string{}
+	for _, env := range c.Environments {
+		if len(env.Tags) > 0 {
+			tags[env.Name] = env.Tags
+		}
+	}
+	return tags
+}
+
+// DefaultNodes maps environment names to their default_node, for the
+// environments that set one.
+func (c Config) DefaultNodes() map[string]string {
+	nodes := map[string]string{}
+	for _, env := range c.Environments {
+		if node := strings.TrimSpace(env.DefaultNode); node != "" {
+			nodes[env.Name] = node
+		}
+	}
+	return nodes
+}
+
+// DefaultTokenRotationOverlap is used when token_rotation_overlap_seconds is
+// not configured.
+const DefaultTokenRotationOverlap = 60 * time.Second
+
+// TokenRotationOverlap returns the configured overlap with the default applied.
+func (c Config) TokenRotationOverlap() time.Duration {
+	if c.TokenRotationOverlapSeconds > 0 {
+		return time.Duration(c.TokenRotationOverlapSeconds) * time.Second
+	}
+	return DefaultTokenRotationOverlap
+}
+
+// DefaultApprovalRetryAfter is used when approval_retry_after_seconds is not
+// configured.
+const DefaultApprovalRetryAfter = 5 * time.Minute
+
+// ApprovalRetryAfter returns the configured approval retry hint with the
+// default applied.
+func (c Config) ApprovalRetryAfter() time.Duration {
+	if c.ApprovalRetryAfterSeconds > 0 {
+		return time.Duration(c.ApprovalRetryAfterSeconds) * time.Second
+	}
+	return DefaultApprovalRetryAfter
+}
+
+// DefaultPendingDeleteGrace is used when pending_delete_grace_seconds is not
+// configured.
+const DefaultPendingDeleteGrace = 24 * time.Hour
+
+// PendingDeleteGrace returns the configured grace period with the default
+// applied.
+func (c Config) PendingDeleteGrace() time.Duration {
+	if c.PendingDeleteGraceSeconds > 0 {
+		return time.Duration(c.PendingDeleteGraceSeconds) * time.Second
+	}
+	return DefaultPendingDeleteGrace
+}
+
+// ReadAuditingEnabled reports whether reads are audited, which is the
+// default when audit_reads is not set.
+func (c Config) ReadAuditingEnabled() bool {
+	return c.AuditReads == nil || *c.AuditReads
+}
+
+// ProtectionCheckEnabled reports whether deletes check the protection flag
+// first, which is the default when delete_protection_check is not set.
+func (c Config) ProtectionCheckEnabled() bool {
+	return c.ProtectionCheck == nil || *c.ProtectionCheck
+}
+
+// DefaultMaxApprovalValidity is used when max_approval_validity_seconds is
+// not configured.
+const DefaultMaxApprovalValidity = 24 * time.Hour
+
+func (c Config) MaxApprovalValidity() time.Duration {
+	if c.MaxApprovalValiditySeconds > 0 {
+		return time.Duration(c.MaxApprovalValiditySeconds) * time.Second
+	}
+	return DefaultMaxApprovalValidity
+}
+
+// DefaultMaxBatchItems is used when max_batch_items is not configured.
+const DefaultMaxBatchItems = 50
+
+// DefaultEnvironmentWarnThreshold is used when environment_warn_threshold is
+// not configured.
+const DefaultEnvironmentWarnThreshold = 50
+
+// checkBaseURLScheme rejects a plain-HTTP base_url unless the environment
+// allows it, and returns a warning when it does.
+func checkBaseURLScheme(env Environment) (string, error) {
+	u, err := url.Parse(env.BaseURL)
+	if err != nil {
+		return "", fmt.Errorf("base_url for environment %q: %w", env.Name, err)
+	}
+	if !strings.EqualFold(u.Scheme, "http") {
+		return "", nil
+	}
+	if !env.AllowInsecureHTTP {
+		return "", fmt.Errorf("base_url for environment %q uses plain http; set allow_insecure_http to permit it", env.Name)
+	}
+	return fmt.Sprintf("environment %q talks to Proxmox over plain HTTP; its API token is sent unencrypted", env.Name), nil
+}
+
+// LoadOption adjusts how Load validates a config.
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	knownAction func(string) bool
 }
 
-func Load(path string) (Config, error) {
+// WithKnownActions makes Load reject action names that known does not
+// recognize, so a typo in an action list fails startup instead of being
+// silently ignored. config cannot import the action table itself.
+func WithKnownActions(known func(string) bool) LoadOption {
+	return func(o *loadOptions) {
+		o.knownAction = known
+	}
+}
+
+// Load reads and validates the config at path. Problems that do not stop
+// the agent from running are returned as warnings for the caller to report.
+func Load(path string, opts ...LoadOption) (Config, []string, error) {
 	var cfg Config
+	var warnings []string
+	var o loadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
 
 	b, err := os.ReadFile(path)
 	if err != nil {
-		return cfg, err
+		return cfg, nil, err
 	}
 	if err := json.Unmarshal(b, &cfg); err != nil {
-		return cfg, err
+		return cfg, nil, err
 	}
 	if cfg.ListenAddr == "" {
-		return cfg, fmt.Errorf("listen_addr is required")
+		return cfg, nil, fmt.Errorf("listen_addr is required")
 	}
 	if len(cfg.Environments) == 0 {
-		return cfg, fmt.Errorf("at least one environment is required")
+		return cfg, nil, fmt.Errorf("at least one environment is required")
+	}
+	if cfg.MaxEnvironments < 0 || cfg.EnvironmentWarnThreshold < 0 {
+		return cfg, nil, fmt.Errorf("max_environments and environment_warn_threshold must not be negative")
+	}
+	if cfg.MaxEnvironments > 0 && len(cfg.Environments) > cfg.MaxEnvironments {
+		return cfg, nil, fmt.Errorf("%d environments configured, more than max_environments (%d)", len(cfg.Environments), cfg.MaxEnvironments)
+	}
+	if cfg.EnvironmentWarnThreshold == 0 {
+		cfg.EnvironmentWarnThreshold = DefaultEnvironmentWarnThreshold
+	}
+	if len(cfg.Environments) > cfg.EnvironmentWarnThreshold {
+		warnings = append(warnings, fmt.Sprintf("%d environments configured, more than environment_warn_threshold (%d); each adds upstream clients and auth checks", len(cfg.Environments), cfg.EnvironmentWarnThreshold))
 	}
 	for _, env := range cfg.Environments {
-		if env.Name == "" || env.BaseURL == "" || env.TokenID == "" || env.TokenSecretEnv == "" {
-			return cfg, fmt.Errorf("invalid environment config for %q", env.Name)
+		if env.Name == "" || env.BaseURL == "" || env.TokenID == "" || (env.TokenSecretEnv == "" && env.TokenSecretRef == "") {
+			return cfg, nil, fmt.Errorf("invalid environment config for %q", env.Name)
+		}
+		warning, err := checkBaseURLScheme(env)
+		if err != nil {
+			return cfg, nil, err
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+		if env.APIBasePath != "" && !strings.HasPrefix(env.APIBasePath, "/") {
+			return cfg, nil, fmt.Errorf("api_base_path for environment %q must start with /", env.Name)
+		}
+		for action, tmpl := range env.EndpointOverrides {
+			if o.knownAction != nil && !o.knownAction(action) {
+				return cfg, nil, fmt.Errorf("endpoint_overrides for environment %q names unknown action %q", env.Name, action)
+			}
+			if err := validateEndpointTemplate(tmpl); err != nil {
+				return cfg, nil, fmt.Errorf("endpoint_overrides[%q] for environment %q: %w", action, env.Name, err)
+			}
+		}
+		if err := ValidateExtraHeaders(env.ExtraHeaders); err != nil {
+			return cfg, nil, fmt.Errorf("extra_headers for environment %q: %w", env.Name, err)
+		}
+		if env.TLSFingerprint != "" && !fingerprintPattern.MatchString(strings.ReplaceAll(env.TLSFingerprint, ":", "")) {
+			return cfg, nil, fmt.Errorf("tls_fingerprint_sha256 for environment %q must be a SHA-256 hex digest", env.Name)
+		}
+		for name, identity := range env.TokenIdentities {
+			if strings.TrimSpace(name) == "" || identity.TokenID == "" || (identity.TokenSecretEnv == "" && identity.TokenSecretRef == "") {
+				return cfg, nil, fmt.Errorf("invalid token_identities[%q] for environment %q", name, env.Name)
+			}
+		}
+	}
+	for _, cidr := range cfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return cfg, nil, fmt.Errorf("invalid trusted_proxies entry %q: %w", cidr, err)
+		}
+	}
+	for _, cidr := range cfg.AllowedSourceCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return cfg, nil, fmt.Errorf("invalid allowed_source_cidrs entry %q: %w", cidr, err)
 		}
 	}
+	for _, cidr := range cfg.MetricsAllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return cfg, nil, fmt.Errorf("invalid metrics_allowed_cidrs entry %q: %w", cidr, err)
+		}
+	}
+	if cfg.ReadHeaderTimeoutSeconds < 0 || cfg.ReadTimeoutSeconds < 0 || cfg.WriteTimeoutSeconds < 0 || cfg.IdleTimeoutSeconds < 0 {
+		return cfg, nil, fmt.Errorf("listener timeouts must not be negative")
+	}
+	if cfg.MaxUpstreamResponseBytes < 0 {
+		return cfg, nil, fmt.Errorf("max_upstream_response_bytes must not be negative")
+	}
+	for _, code := range cfg.BusyStatusCodes {
+		if code < 400 || code > 599 {
+			return cfg, nil, fmt.Errorf("busy_status_codes entry %d must be a 4xx or 5xx status", code)
+		}
+	}
+	if cfg.SlowRequestThresholdMs < 0 {
+		return cfg, nil, fmt.Errorf("slow_request_threshold_ms must not be negative")
+	}
+	switch cfg.AuditFormat {
+	case "", "ndjson", "array":
+	default:
+		return cfg, nil, fmt.Errorf("audit_format must be ndjson or array")
+	}
+	switch cfg.TargetExistenceCheck {
+	case "", "off", "annotate", "require":
+	default:
+		return cfg, nil, fmt.Errorf("target_existence_check must be one of off, annotate, or require")
+	}
+	if cfg.PendingDeleteGraceSeconds < 0 || cfg.PendingDeleteReapIntervalSeconds < 0 {
+		return cfg, nil, fmt.Errorf("pending_delete settings must not be negative")
+	}
+	switch cfg.ApprovalTicketMinRisk {
+	case "", "low", "medium", "high":
+	default:
+		return cfg, nil, fmt.Errorf("approval_ticket_min_risk must be one of low, medium, or high")
+	}
+	for tag, rule := range cfg.TagPolicies {
+		switch rule.MinRisk {
+		case "", "low", "medium", "high":
+		default:
+			return cfg, nil, fmt.Errorf("tag_policies[%q].min_risk must be one of low, medium, or high", tag)
+		}
+	}
+	if o.knownAction != nil {
+		for _, action := range cfg.DefaultDryRunActions {
+			if !o.knownAction(strings.TrimSpace(action)) {
+				return cfg, nil, fmt.Errorf("default_dry_run_actions names unknown action %q", action)
+			}
+		}
+		for _, action := range cfg.ApprovalTicketActions {
+			if !o.knownAction(strings.TrimSpace(action)) {
+				return cfg, nil, fmt.Errorf("approval_ticket_actions names unknown action %q", action)
+			}
+		}
+		for action := range cfg.ApprovalTTLSeconds {
+			if !o.knownAction(action) {
+				return cfg, nil, fmt.Errorf("approval_ttl_seconds names unknown action %q", action)
+			}
+		}
+		for action := range cfg.LoadShedPriorities {
+			if !o.knownAction(action) {
+				return cfg, nil, fmt.Errorf("load_shed_priorities names unknown action %q", action)
+			}
+		}
+	}
+	if cfg.LoadShedMaxInFlight < 0 {
+		return cfg, nil, fmt.Errorf("load_shed_max_in_flight must not be negative")
+	}
+	if cfg.ReadDedupWindowMillis < 0 {
+		return cfg, nil, fmt.Errorf("read_dedup_window_ms must not be negative")
+	}
+	for action, priority := range cfg.LoadShedPriorities {
+		if priority != PriorityLow && priority != PriorityHigh {
+			return cfg, nil, fmt.Errorf("load_shed_priorities[%q] must be low or high", action)
+		}
+	}
+	for action, seconds := range cfg.ApprovalTTLSeconds {
+		if seconds <= 0 {
+			return cfg, nil, fmt.Errorf("approval_ttl_seconds[%q] must be positive", action)
+		}
+	}
+	if cfg.NodeCacheTTLSeconds < 0 {
+		return cfg, nil, fmt.Errorf("node_cache_ttl_seconds must not be negative")
+	}
+	if cfg.MaxApprovalValiditySeconds < 0 {
+		return cfg, nil, fmt.Errorf("max_approval_validity_seconds must not be negative")
+	}
+	if cfg.TokenRotationOverlapSeconds < 0 {
+		return cfg, nil, fmt.Errorf("token_rotation_overlap_seconds must not be negative")
+	}
+	if cfg.ApprovalRetryAfterSeconds < 0 {
+		return cfg, nil, fmt.Errorf("approval_retry_after_seconds must not be negative")
+	}
+	if cfg.MaxBatchItems < 0 {
+		return cfg, nil, fmt.Errorf("max_batch_items must not be negative")
+	}
+	if cfg.MaxBatchItems == 0 {
+		cfg.MaxBatchItems = DefaultMaxBatchItems
+	}
 	if cfg.AuditLogPath == "" {
 		cfg.AuditLogPath = "./data/audit.log"
 	}
-	return cfg, nil
+	return cfg, warnings, nil
 }
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadRejectsPlainHTTPBaseURLByDefault(t *testing.T) {
+	path := writeConfig(t, `{
+		"listen_addr": ":8080",
+		"environments": [{"name":"lab","base_url":"http://10.0.0.5:8006","token_id":"root@pam!agent","token_secret_env":"PVE_SECRET"}]
+	}`)
+
+	_, _, err := Load(path)
+	if err == nil || !strings.Contains(err.Error(), "allow_insecure_http") {
+		t.Fatalf("expected plain http to be rejected, got %v", err)
+	}
+}
+
+func TestLoadAcceptsPlainHTTPWhenAllowed(t *testing.T) {
+	path := writeConfig(t, `{
+		"listen_addr": ":8080",
+		"environments": [{"name":"lab","base_url":"http://10.0.0.5:8006","token_id":"root@pam!agent","token_secret_env":"PVE_SECRET","allow_insecure_http":true}]
+	}`)
+
+	cfg, warnings, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if !cfg.Environments[0].AllowInsecureHTTP {
+		t.Fatalf("expected allow_insecure_http to be kept, got %+v", cfg.Environments[0])
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "plain HTTP") {
+		t.Fatalf("expected a plain HTTP warning, got %q", warnings)
+	}
+}
+
+func environmentsConfig(n int, extra string) string {
+	envs := make([]string, n)
+	for i := range envs {
+		envs[i] = fmt.Sprintf(`{"name":"env%d","base_url":"https://10.0.0.%d:8006","token_id":"root@pam!agent","token_secret_env":"PVE_SECRET"}`, i, i+1)
+	}
+	return `{"listen_addr": ":8080",` + extra + `"environments": [` + strings.Join(envs, ",") + `]}`
+}
+
+func TestLoadWarnsAboveEnvironmentThreshold(t *testing.T) {
+	_, warnings, err := Load(writeConfig(t, environmentsConfig(3, `"environment_warn_threshold": 3,`)))
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("expected no warning at the threshold, got %q", warnings)
+	}
+	_, warnings, err = Load(writeConfig(t, environmentsConfig(4, `"environment_warn_threshold": 3,`)))
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "4 environments configured") {
+		t.Fatalf("expected a warning above the threshold, got %q", warnings)
+	}
+}
+
+func TestLoadRejectsEnvironmentsAboveHardCap(t *testing.T) {
+	if _, _, err := Load(writeConfig(t, environmentsConfig(2, `"max_environments": 2,`))); err != nil {
+		t.Fatalf("expected the cap itself to be allowed: %v", err)
+	}
+	_, _, err := Load(writeConfig(t, environmentsConfig(3, `"max_environments": 2,`)))
+	if err == nil || !strings.Contains(err.Error(), "max_environments (2)") {
+		t.Fatalf("expected hard-cap error, got %v", err)
+	}
+}
+
+func TestLoadRejectsUnknownDefaultDryRunAction(t *testing.T) {
+	known := WithKnownActions(func(name string) bool { return name == "delete_vm" })
+	if _, _, err := Load(writeConfig(t, environmentsConfig(1, `"default_dry_run_actions": ["delete_vm"],`)), known); err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	_, _, err := Load(writeConfig(t, environmentsConfig(1, `"default_dry_run_actions": ["delete_mv"],`)), known)
+	if err == nil || !strings.Contains(err.Error(), `"delete_mv"`) {
+		t.Fatalf("expected a misspelled action to be rejected, got %v", err)
+	}
+}
+
+func TestLoadRejectsUnknownActionNames(t *testing.T) {
+	known := WithKnownActions(func(name string) bool { return name == "delete_vm" })
+	for _, tc := range []struct {
+		field string
+		extra string
+	}{
+		{"approval_ticket_actions", `"approval_ticket_actions": ["delete_mv"],`},
+		{"approval_ttl_seconds", `"approval_ttl_seconds": {"delete_mv": 60},`},
+		{"load_shed_priorities", `"load_shed_priorities": {"delete_mv": "high"},`},
+	} {
+		_, _, err := Load(writeConfig(t, environmentsConfig(1, tc.extra)), known)
+		if err == nil || !strings.Contains(err.Error(), tc.field) || !strings.Contains(err.Error(), `"delete_mv"`) {
+			t.Fatalf("expected %s naming an unknown action to be rejected, got %v", tc.field, err)
+		}
+	}
+
+	override := `{"listen_addr": ":8080", "environments": [{"name":"home","base_url":"https://10.0.0.1:8006","token_id":"root@pam!agent","token_secret_env":"PVE_SECRET","endpoint_overrides":{"delete_mv":"/nodes/{node}/qemu/{vmid}"}}]}`
+	_, _, err := Load(writeConfig(t, override), known)
+	if err == nil || !strings.Contains(err.Error(), "endpoint_overrides") || !strings.Contains(err.Error(), `"delete_mv"`) {
+		t.Fatalf("expected endpoint_overrides naming an unknown action to be rejected, got %v", err)
+	}
+}
+
+func TestProtectionCheckDefaultsOn(t *testing.T) {
+	if !(Config{}).ProtectionCheckEnabled() {
+		t.Fatalf("expected the delete protection check to default on")
+	}
+	off := false
+	if (Config{ProtectionCheck: &off}).ProtectionCheckEnabled() {
+		t.Fatalf("expected delete_protection_check=false to turn the check off")
+	}
+}
@@ -1,7 +1,13 @@
 package policy
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/junlov/proxmox-ai/internal/proxmox"
 )
@@ -10,13 +16,174 @@ type Decision struct {
 	Allowed          bool   `json:"allowed"`
 	RiskLevel        string `json:"risk_level"`
 	RequiresApproval bool   `json:"requires_approval"`
+	RequiresTicket   bool   `json:"requires_ticket,omitempty"`
 	Reason           string `json:"reason"`
+	// ApprovalExpiresAt is when the request's approval stops being valid:
+	// its expires_at, or the action's default TTL counted from when the
+	// approval was first granted for this exact request.
+	ApprovalExpiresAt string `json:"approval_expires_at,omitempty"`
 }
 
-type Engine struct{}
+type Engine struct {
+	ticketMinRisk string
+	ticketActions map[proxmox.ActionType]bool
+	denyTemplate  string
 
-func NewEngine() *Engine {
-	return &Engine{}
+	approvalTTLs map[proxmox.ActionType]time.Duration
+	now          func() time.Time
+
+	mu       sync.RWMutex
+	readOnly map[string]bool
+	// envRules holds, per environment name, the merged rule of its tags.
+	envRules map[string]envRule
+	// approvalWindows records, per approval and request fingerprint, when
+	// the approval's default TTL ends. Entries are kept for
+	// approvalWindowRetention past that so a lapsed approval stays lapsed.
+	approvalWindows map[string]time.Time
+}
+
+// approvalWindowRetention is how long an expired approval window is kept
+// before it is evicted.
+const approvalWindowRetention = 24 * time.Hour
+
+// Option configures optional Engine behavior.
+type Option func(*Engine)
+
+// WithRequiredTicket makes apply demand an approval_ticket, not just an
+// approver, for requests at or above minRisk and for the listed actions.
+// An empty minRisk and no actions leave tickets optional.
+func WithRequiredTicket(minRisk string, actions []string) Option {
+	return func(e *Engine) {
+		if riskRank(minRisk) > 0 {
+			e.ticketMinRisk = minRisk
+		}
+		for _, action := range actions {
+			if e.ticketActions == nil {
+				e.ticketActions = map[proxmox.ActionType]bool{}
+			}
+			e.ticketActions[proxmox.ActionType(action)] = true
+		}
+	}
+}
+
+// WithDenyMessage appends tmpl to the reason of every denial, e.g. to point
+// at a change process. {action}, {risk}, {target} and {environment} are
+// replaced with the request's values.
+func WithDenyMessage(tmpl string) Option {
+	return func(e *Engine) {
+		e.denyTemplate = strings.TrimSpace(tmpl)
+	}
+}
+
+// WithReadOnlyEnvironments denies every mutating action in the named
+// environments while still allowing reads.
+func WithReadOnlyEnvironments(names []string) Option {
+	return func(e *Engine) {
+		e.SetReadOnlyEnvironments(names)
+	}
+}
+
+// SetReadOnlyEnvironments replaces the set of read-only environments; it is
+// safe to call while requests are being evaluated.
+func (e *Engine) SetReadOnlyEnvironments(names []string) {
+	readOnly := make(map[string]bool, len(names))
+	for _, name := range names {
+		readOnly[name] = true
+	}
+	e.mu.Lock()
+	e.readOnly = readOnly
+	e.mu.Unlock()
+}
+
+func (e *Engine) isReadOnly(environment string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.readOnly[environment]
+}
+
+// TagRule is a policy rule applied to every environment carrying a tag.
+type TagRule struct {
+	// MinRisk raises mutating actions to at least this tier.
+	MinRisk string
+	// RequireTicket demands an approval_ticket wherever approval is needed.
+	RequireTicket bool
+	// ReadOnly denies every mutating action.
+	ReadOnly bool
+}
+
+// envRule is the strictest combination of an environment's tag rules;
+// riskTag names the tag its MinRisk came from, for the decision reason.
+type envRule struct {
+	TagRule
+	riskTag string
+}
+
+// WithTagRules applies rules to environments by tag, so e.g. every
+// environment tagged "production" gets the same stricter policy without
+// being named. tags maps environment names to their tags.
+func WithTagRules(tags map[string][]string, rules map[string]TagRule) Option {
+	return func(e *Engine) {
+		e.SetTagRules(tags, rules)
+	}
+}
+
+// SetTagRules replaces the tag rules and environment tags WithTagRules set;
+// it is safe to call while requests are being evaluated.
+func (e *Engine) SetTagRules(tags map[string][]string, rules map[string]TagRule) {
+	envRules := map[string]envRule{}
+	for env, envTags := range tags {
+		var merged envRule
+		for _, tag := range envTags {
+			rule, ok := rules[tag]
+			if !ok {
+				continue
+			}
+			if riskRank(rule.MinRisk) > riskRank(merged.MinRisk) {
+				merged.MinRisk = rule.MinRisk
+				merged.riskTag = tag
+			}
+			merged.RequireTicket = merged.RequireTicket || rule.RequireTicket
+			merged.ReadOnly = merged.ReadOnly || rule.ReadOnly
+		}
+		if merged != (envRule{}) {
+			envRules[env] = merged
+		}
+	}
+	e.mu.Lock()
+	e.envRules = envRules
+	e.mu.Unlock()
+}
+
+func (e *Engine) envRule(environment string) envRule {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.envRules[environment]
+}
+
+// WithApprovalTTLs gives approvals for the listed actions a default
+// validity window, used when a request carries no expires_at. The window
+// starts when the approval is first granted, by an allowed plan or apply,
+// for the same request.
+func WithApprovalTTLs(ttls map[string]time.Duration) Option {
+	return func(e *Engine) {
+		for action, ttl := range ttls {
+			if ttl <= 0 {
+				continue
+			}
+			if e.approvalTTLs == nil {
+				e.approvalTTLs = map[proxmox.ActionType]time.Duration{}
+			}
+			e.approvalTTLs[proxmox.ActionType(action)] = ttl
+		}
+	}
+}
+
+func NewEngine(opts ...Option) *Engine {
+	e := &Engine{now: time.Now, approvalWindows: map[string]time.Time{}}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
 }
 
 func (e *Engine) EvaluateForPlan(req proxmox.ActionRequest) (Decision, error) {
@@ -27,31 +194,162 @@ func (e *Engine) EvaluateForApply(req proxmox.ActionRequest) (Decision, error) {
 	return e.evaluate(req, true)
 }
 
-func (e *Engine) evaluate(req proxmox.ActionRequest, enforceApproval bool) (Decision, error) {
-	risk := "low"
-	requiresApproval := false
-	reason := "read/safe operation"
+// DefaultRisk returns the risk tier and approval requirement an action has
+// before any per-request adjustment such as min_risk.
+func (e *Engine) DefaultRisk(action proxmox.ActionType) (risk string, requiresApproval bool) {
+	risk, requiresApproval, _ = defaultRisk(action)
+	return risk, requiresApproval
+}
 
-	switch req.Action {
+func defaultRisk(action proxmox.ActionType) (risk string, requiresApproval bool, reason string) {
+	switch action {
 	case proxmox.ActionDeleteVM, proxmox.ActionMigrateVM, proxmox.ActionStorageEdit, proxmox.ActionFirewallEdit:
-		risk = "high"
-		requiresApproval = true
-		reason = "high-impact operation"
+		return "high", true, "high-impact operation"
 	case proxmox.ActionStopVM:
-		risk = "medium"
+		return "medium", true, "service-impacting operation"
+	case proxmox.ActionDeleteSnapshot:
+		return "medium", true, "destroys a restore point"
+	case proxmox.ActionStartVM, proxmox.ActionSnapshotVM, proxmox.ActionCloneVM, proxmox.ActionSetVMConfig:
+		return "medium", false, "state-changing operation"
+	}
+	return "low", false, "read/safe operation"
+}
+
+func (e *Engine) evaluate(req proxmox.ActionRequest, enforceApproval bool) (Decision, error) {
+	risk, requiresApproval, reason := defaultRisk(req.Action)
+	rule := e.envRule(req.Environment)
+	mutating := !proxmox.IsReadAction(req.Action)
+	if mutating && riskRank(rule.MinRisk) > riskRank(risk) {
+		risk = rule.MinRisk
+		requiresApproval = true
+		reason = fmt.Sprintf("risk raised to %s by environment tag %q", risk, rule.riskTag)
+	}
+
+	// Callers may raise the risk tier of a request but never lower it; a
+	// raised tier always forces approval.
+	if riskRank(req.MinRisk) > riskRank(risk) {
+		risk = req.MinRisk
 		requiresApproval = true
-		reason = "service-impacting operation"
-	case proxmox.ActionStartVM, proxmox.ActionSnapshotVM, proxmox.ActionCloneVM:
-		risk = "medium"
-		reason = "state-changing operation"
+		reason = fmt.Sprintf("risk raised to %s by request", risk)
 	}
 
+	if mutating && (e.isReadOnly(req.Environment) || rule.ReadOnly) {
+		return Decision{Allowed: false, RiskLevel: risk, Reason: e.denyReason(req, risk, "environment is read-only")}, nil
+	}
+
+	requiresTicket := e.requiresTicket(req.Action, risk) || (rule.RequireTicket && requiresApproval)
+	approvalExpiry := e.approvalExpiry(req)
+	var approvalExpiresAt string
+	if !approvalExpiry.IsZero() {
+		approvalExpiresAt = approvalExpiry.UTC().Format(time.RFC3339)
+		if enforceApproval && !e.now().Before(approvalExpiry) {
+			return Decision{Allowed: false, RiskLevel: risk, RequiresApproval: requiresApproval, RequiresTicket: requiresTicket, ApprovalExpiresAt: approvalExpiresAt,
+				Reason: e.denyReason(req, risk, "approval expired at "+approvalExpiresAt)}, nil
+		}
+	}
 	if requiresApproval && enforceApproval && req.ApprovedBy == "" {
-		return Decision{Allowed: false, RiskLevel: risk, RequiresApproval: true, Reason: "approval required before apply"}, nil
+		return Decision{Allowed: false, RiskLevel: risk, RequiresApproval: true, RequiresTicket: requiresTicket, Reason: e.denyReason(req, risk, "approval required before apply")}, nil
+	}
+	if requiresTicket && enforceApproval && strings.TrimSpace(req.ApprovalTicket) == "" {
+		return Decision{Allowed: false, RiskLevel: risk, RequiresApproval: requiresApproval, RequiresTicket: true, Reason: e.denyReason(req, risk, "approval ticket required")}, nil
 	}
 	if req.Environment == "" || req.Target == "" {
 		return Decision{}, fmt.Errorf("environment and target are required")
 	}
+	e.startApprovalWindow(req)
+
+	return Decision{Allowed: true, RiskLevel: risk, RequiresApproval: requiresApproval, RequiresTicket: requiresTicket, Reason: reason, ApprovalExpiresAt: approvalExpiresAt}, nil
+}
+
+// approvalExpiry returns when req's approval lapses, or the zero time when
+// it carries no approval or the approval does not expire. An approval whose
+// default TTL has not started yet would lapse one TTL from now.
+func (e *Engine) approvalExpiry(req proxmox.ActionRequest) time.Time {
+	if strings.TrimSpace(req.ApprovedBy) == "" {
+		return time.Time{}
+	}
+	if raw := strings.TrimSpace(req.ExpiresAt); raw != "" {
+		expires, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return time.Time{}
+		}
+		return expires
+	}
+	ttl, ok := e.approvalTTLs[req.Action]
+	if !ok {
+		return time.Time{}
+	}
+	e.mu.RLock()
+	expires, ok := e.approvalWindows[approvalWindowKey(req)]
+	e.mu.RUnlock()
+	if !ok {
+		return e.now().Add(ttl)
+	}
+	return expires
+}
+
+// startApprovalWindow starts the default TTL of req's approval once it is
+// granted, unless it is already running, and evicts windows that lapsed
+// more than approvalWindowRetention ago.
+func (e *Engine) startApprovalWindow(req proxmox.ActionRequest) {
+	if strings.TrimSpace(req.ApprovedBy) == "" || strings.TrimSpace(req.ExpiresAt) != "" {
+		return
+	}
+	ttl, ok := e.approvalTTLs[req.Action]
+	if !ok {
+		return
+	}
+	key := approvalWindowKey(req)
+	now := e.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for k, expires := range e.approvalWindows {
+		if now.Sub(expires) >= approvalWindowRetention {
+			delete(e.approvalWindows, k)
+		}
+	}
+	if _, ok := e.approvalWindows[key]; !ok {
+		e.approvalWindows[key] = now.Add(ttl)
+	}
+}
 
-	return Decision{Allowed: true, RiskLevel: risk, RequiresApproval: requiresApproval, Reason: reason}, nil
+// approvalWindowKey binds an approval (approver and ticket) to the request
+// it approves, so the same approver's window for one request does not
+// carry over to a request with other params.
+func approvalWindowKey(req proxmox.ActionRequest) string {
+	params, _ := json.Marshal(req.Params)
+	sum := sha256.Sum256(params)
+	return strings.Join([]string{req.Environment, string(req.Action), req.Target, hex.EncodeToString(sum[:]), strings.TrimSpace(req.ApprovedBy), strings.TrimSpace(req.ApprovalTicket)}, "|")
+}
+
+func (e *Engine) denyReason(req proxmox.ActionRequest, risk, reason string) string {
+	if e.denyTemplate == "" {
+		return reason
+	}
+	return reason + ": " + strings.NewReplacer(
+		"{action}", string(req.Action),
+		"{risk}", risk,
+		"{target}", req.Target,
+		"{environment}", req.Environment,
+	).Replace(e.denyTemplate)
+}
+
+func (e *Engine) requiresTicket(action proxmox.ActionType, risk string) bool {
+	if e.ticketActions[action] {
+		return true
+	}
+	return e.ticketMinRisk != "" && riskRank(risk) >= riskRank(e.ticketMinRisk)
+}
+
+func riskRank(risk string) int {
+	switch risk {
+	case "low":
+		return 1
+	case "medium":
+		return 2
+	case "high":
+		return 3
+	default:
+		return 0
+	}
 }
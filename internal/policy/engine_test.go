@@ -1,7 +1,9 @@
 package policy
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/junlov/proxmox-ai/internal/proxmox"
 )
@@ -200,3 +202,273 @@ func TestEvaluateValidationErrors(t *testing.T) {
 		t.Fatal("expected validation error for missing environment")
 	}
 }
+
+func TestEvaluateMinRiskRaisesStartToHigh(t *testing.T) {
+	engine := NewEngine()
+	req := proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionStartVM,
+		Target:      "vm/101",
+		MinRisk:     "high",
+	}
+	decision, err := engine.EvaluateForApply(req)
+	if err != nil {
+		t.Fatalf("EvaluateForApply returned error: %v", err)
+	}
+	if decision.RiskLevel != "high" {
+		t.Fatalf("unexpected risk level: %q", decision.RiskLevel)
+	}
+	if !decision.RequiresApproval || decision.Allowed {
+		t.Fatalf("expected raised risk to require approval, got %+v", decision)
+	}
+}
+
+func TestEvaluateMinRiskCannotLowerDelete(t *testing.T) {
+	engine := NewEngine()
+	decision, err := engine.EvaluateForApply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionDeleteVM,
+		Target:      "vm/101",
+		MinRisk:     "low",
+	})
+	if err != nil {
+		t.Fatalf("EvaluateForApply returned error: %v", err)
+	}
+	if decision.RiskLevel != "high" {
+		t.Fatalf("expected delete to stay high risk, got %q", decision.RiskLevel)
+	}
+	if decision.Allowed {
+		t.Fatal("expected delete without approval to remain denied")
+	}
+}
+
+func TestRequiredTicketDeniesHighRiskApplyWithoutTicket(t *testing.T) {
+	engine := NewEngine(WithRequiredTicket("high", []string{string(proxmox.ActionStopVM)}))
+	del := proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionDeleteVM,
+		Target:      "vm/101",
+		ApprovedBy:  "ops-user",
+	}
+
+	plan, err := engine.EvaluateForPlan(del)
+	if err != nil {
+		t.Fatalf("EvaluateForPlan returned error: %v", err)
+	}
+	if !plan.Allowed || !plan.RequiresTicket {
+		t.Fatalf("expected plan to be allowed and flag the ticket, got %+v", plan)
+	}
+
+	denied, err := engine.EvaluateForApply(del)
+	if err != nil {
+		t.Fatalf("EvaluateForApply returned error: %v", err)
+	}
+	if denied.Allowed || denied.Reason != "approval ticket required" {
+		t.Fatalf("expected apply without a ticket to be denied, got %+v", denied)
+	}
+
+	del.ApprovalTicket = "CHG-1234"
+	if allowed, _ := engine.EvaluateForApply(del); !allowed.Allowed {
+		t.Fatalf("expected apply with a ticket to be allowed, got %+v", allowed)
+	}
+
+	stop := proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStopVM, Target: "vm/101", ApprovedBy: "ops-user"}
+	if decision, _ := engine.EvaluateForApply(stop); decision.Allowed {
+		t.Fatalf("expected listed medium-risk action to need a ticket, got %+v", decision)
+	}
+	start := proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/101"}
+	if decision, _ := engine.EvaluateForApply(start); !decision.Allowed || decision.RequiresTicket {
+		t.Fatalf("expected unlisted medium-risk action to stay ticket-free, got %+v", decision)
+	}
+}
+
+func TestDenyMessageTemplateIsAppendedToReason(t *testing.T) {
+	engine := NewEngine(WithDenyMessage("file a change at https://change.example/new?action={action}&risk={risk}"))
+
+	decision, err := engine.EvaluateForApply(proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionDeleteVM,
+		Target:      "vm/101",
+	})
+	if err != nil {
+		t.Fatalf("EvaluateForApply returned error: %v", err)
+	}
+	want := "approval required before apply: file a change at https://change.example/new?action=delete_vm&risk=high"
+	if decision.Allowed || decision.Reason != want {
+		t.Fatalf("unexpected decision: %+v", decision)
+	}
+
+	if plain, _ := NewEngine().EvaluateForApply(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionDeleteVM, Target: "vm/101"}); plain.Reason != "approval required before apply" {
+		t.Fatalf("expected the default reason without a template, got %q", plain.Reason)
+	}
+}
+
+func TestReadOnlyEnvironmentAllowsReadsAndDeniesMutations(t *testing.T) {
+	engine := NewEngine(WithReadOnlyEnvironments([]string{"home"}))
+
+	read, err := engine.EvaluateForApply(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionReadInventory, Target: "inventory/all"})
+	if err != nil || !read.Allowed {
+		t.Fatalf("expected inventory read to be allowed, got %+v (%v)", read, err)
+	}
+	start, err := engine.EvaluateForApply(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/101"})
+	if err != nil {
+		t.Fatalf("EvaluateForApply returned error: %v", err)
+	}
+	if start.Allowed || start.Reason != "environment is read-only" {
+		t.Fatalf("expected start_vm to be denied as read-only, got %+v", start)
+	}
+	if other, _ := engine.EvaluateForApply(proxmox.ActionRequest{Environment: "lab", Action: proxmox.ActionStartVM, Target: "vm/101"}); !other.Allowed {
+		t.Fatalf("expected other environments to be unaffected, got %+v", other)
+	}
+
+	engine.SetReadOnlyEnvironments(nil)
+	if start, _ := engine.EvaluateForApply(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/101"}); !start.Allowed {
+		t.Fatalf("expected start_vm to be allowed after lifting read-only, got %+v", start)
+	}
+}
+
+func TestApprovalWithoutExpiryInheritsActionTTL(t *testing.T) {
+	engine := NewEngine(WithApprovalTTLs(map[string]time.Duration{"delete_vm": 5 * time.Minute}))
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	engine.now = func() time.Time { return now }
+	req := proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionDeleteVM, Target: "vm/101", ApprovedBy: "ops-lead"}
+
+	plan, err := engine.EvaluateForPlan(req)
+	if err != nil {
+		t.Fatalf("EvaluateForPlan returned error: %v", err)
+	}
+	if plan.ApprovalExpiresAt != "2026-03-01T12:05:00Z" {
+		t.Fatalf("expected plan to surface the effective expiry, got %+v", plan)
+	}
+
+	now = now.Add(4 * time.Minute)
+	if apply, _ := engine.EvaluateForApply(req); !apply.Allowed {
+		t.Fatalf("expected approval to be valid within its TTL, got %+v", apply)
+	}
+
+	now = now.Add(2 * time.Minute)
+	apply, err := engine.EvaluateForApply(req)
+	if err != nil {
+		t.Fatalf("EvaluateForApply returned error: %v", err)
+	}
+	if apply.Allowed || !strings.Contains(apply.Reason, "approval expired") {
+		t.Fatalf("expected approval to be expired after its TTL, got %+v", apply)
+	}
+
+	req.ExpiresAt = "2026-03-01T13:00:00Z"
+	if apply, _ := engine.EvaluateForApply(req); !apply.Allowed || apply.ApprovalExpiresAt != req.ExpiresAt {
+		t.Fatalf("expected explicit expires_at to take precedence, got %+v", apply)
+	}
+}
+
+func TestTagRulesApplyToEveryTaggedEnvironment(t *testing.T) {
+	engine := NewEngine(WithTagRules(
+		map[string][]string{
+			"prod-eu": {"production"},
+			"prod-us": {"production", "customer-facing"},
+			"lab":     {"scratch"},
+		},
+		map[string]TagRule{"production": {MinRisk: "high", RequireTicket: true}},
+	))
+
+	for _, env := range []string{"prod-eu", "prod-us"} {
+		req := proxmox.ActionRequest{Environment: env, Action: proxmox.ActionStartVM, Target: "vm/101"}
+		decision, err := engine.EvaluateForApply(req)
+		if err != nil {
+			t.Fatalf("%s: EvaluateForApply returned error: %v", env, err)
+		}
+		if decision.Allowed || decision.RiskLevel != "high" || !decision.RequiresApproval {
+			t.Fatalf("%s: expected start_vm raised to high and denied without approval, got %+v", env, decision)
+		}
+
+		req.ApprovedBy = "ops-user"
+		decision, _ = engine.EvaluateForApply(req)
+		if decision.Allowed || !strings.Contains(decision.Reason, "approval ticket required") {
+			t.Fatalf("%s: expected a ticket to be required, got %+v", env, decision)
+		}
+
+		req.ApprovalTicket = "CHG-1234"
+		decision, _ = engine.EvaluateForApply(req)
+		if !decision.Allowed {
+			t.Fatalf("%s: expected approved and ticketed request to be allowed, got %+v", env, decision)
+		}
+
+		read, _ := engine.EvaluateForApply(proxmox.ActionRequest{Environment: env, Action: proxmox.ActionReadVM, Target: "vm/101"})
+		if !read.Allowed || read.RiskLevel != "low" {
+			t.Fatalf("%s: expected reads to be unaffected, got %+v", env, read)
+		}
+	}
+
+	decision, _ := engine.EvaluateForApply(proxmox.ActionRequest{Environment: "lab", Action: proxmox.ActionStartVM, Target: "vm/101"})
+	if !decision.Allowed || decision.RiskLevel != "medium" {
+		t.Fatalf("expected untagged-rule environment to keep default policy, got %+v", decision)
+	}
+}
+
+func TestTagRuleReadOnlyDeniesMutations(t *testing.T) {
+	engine := NewEngine(WithTagRules(
+		map[string][]string{"dr-site": {"frozen"}},
+		map[string]TagRule{"frozen": {ReadOnly: true}},
+	))
+	decision, _ := engine.EvaluateForApply(proxmox.ActionRequest{Environment: "dr-site", Action: proxmox.ActionStartVM, Target: "vm/101"})
+	if decision.Allowed || !strings.Contains(decision.Reason, "read-only") {
+		t.Fatalf("expected tagged environment to be read-only, got %+v", decision)
+	}
+}
+
+func TestSetTagRulesReplacesRulesAtRuntime(t *testing.T) {
+	engine := NewEngine(WithTagRules(
+		map[string][]string{"dr-site": {"frozen"}},
+		map[string]TagRule{"frozen": {ReadOnly: true}},
+	))
+	req := proxmox.ActionRequest{Environment: "dr-site", Action: proxmox.ActionStartVM, Target: "vm/101"}
+
+	engine.SetTagRules(map[string][]string{"dr-site": {"standby"}}, map[string]TagRule{"frozen": {ReadOnly: true}})
+	if decision, _ := engine.EvaluateForApply(req); !decision.Allowed {
+		t.Fatalf("expected dropping the tag to lift the freeze, got %+v", decision)
+	}
+
+	engine.SetTagRules(map[string][]string{"dr-site": {"standby"}}, map[string]TagRule{"standby": {ReadOnly: true}})
+	if decision, _ := engine.EvaluateForApply(req); decision.Allowed || !strings.Contains(decision.Reason, "read-only") {
+		t.Fatalf("expected the new rule to freeze the environment, got %+v", decision)
+	}
+}
+
+func TestApprovalTTLStartsOnlyWhenGrantedForTheSameRequest(t *testing.T) {
+	engine := NewEngine(
+		WithApprovalTTLs(map[string]time.Duration{"delete_vm": 5 * time.Minute}),
+		WithRequiredTicket("", []string{"delete_vm"}),
+	)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	engine.now = func() time.Time { return now }
+	req := proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionDeleteVM, Target: "vm/101", ApprovedBy: "ops-lead", Params: map[string]any{"node": "pve"}}
+
+	if apply, _ := engine.EvaluateForApply(req); apply.Allowed {
+		t.Fatalf("expected apply without a ticket to be denied, got %+v", apply)
+	}
+	if len(engine.approvalWindows) != 0 {
+		t.Fatalf("expected a denied approval not to start its window, got %v", engine.approvalWindows)
+	}
+
+	req.ApprovalTicket = "CHG-1"
+	now = now.Add(time.Hour)
+	if apply, _ := engine.EvaluateForApply(req); !apply.Allowed || apply.ApprovalExpiresAt != "2026-03-01T13:05:00Z" {
+		t.Fatalf("expected the window to start when approval is granted, got %+v", apply)
+	}
+
+	now = now.Add(10 * time.Minute)
+	other := req
+	other.Params = map[string]any{"node": "pve2"}
+	if apply, _ := engine.EvaluateForApply(other); !apply.Allowed {
+		t.Fatalf("expected a different request to get its own window, got %+v", apply)
+	}
+	if apply, _ := engine.EvaluateForApply(req); apply.Allowed {
+		t.Fatalf("expected the first request's approval to have lapsed, got %+v", apply)
+	}
+
+	now = now.Add(approvalWindowRetention + time.Hour)
+	engine.startApprovalWindow(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionDeleteVM, Target: "vm/102", ApprovedBy: "ops-lead"})
+	if len(engine.approvalWindows) != 1 {
+		t.Fatalf("expected lapsed windows to be evicted, got %v", engine.approvalWindows)
+	}
+}
@@ -0,0 +1,122 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// CapacityUsage sums physical capacity, current usage, and guest allocation.
+// CPU values are core counts; memory and storage values are bytes.
+type CapacityUsage struct {
+	CPUTotal         int64   `json:"cpu_total"`
+	CPUUsed          float64 `json:"cpu_used"`
+	CPUAllocated     int64   `json:"cpu_allocated"`
+	MemTotalBytes    int64   `json:"mem_total_bytes"`
+	MemUsedBytes     int64   `json:"mem_used_bytes"`
+	MemAllocated     int64   `json:"mem_allocated_bytes"`
+	StorageTotal     int64   `json:"storage_total_bytes"`
+	StorageUsed      int64   `json:"storage_used_bytes"`
+	Guests           int     `json:"guests"`
+	RunningGuests    int     `json:"running_guests"`
+	OnlineNodes      int     `json:"online_nodes,omitempty"`
+	StoragesReported int     `json:"storages"`
+}
+
+type NodeCapacity struct {
+	Node   string `json:"node"`
+	Status string `json:"status,omitempty"`
+	CapacityUsage
+}
+
+type ClusterCapacity struct {
+	Totals CapacityUsage  `json:"totals"`
+	Nodes  []NodeCapacity `json:"nodes"`
+}
+
+type clusterResource struct {
+	ID      string  `json:"id"`
+	Type    string  `json:"type"`
+	Node    string  `json:"node"`
+	Status  string  `json:"status"`
+	Storage string  `json:"storage"`
+	Shared  int     `json:"shared"`
+	CPU     float64 `json:"cpu"`
+	MaxCPU  float64 `json:"maxcpu"`
+	Mem     int64   `json:"mem"`
+	MaxMem  int64   `json:"maxmem"`
+	Disk    int64   `json:"disk"`
+	MaxDisk int64   `json:"maxdisk"`
+}
+
+// decodeClusterCapacity aggregates a full /cluster/resources listing. Shared
+// storage is reported once per node by Proxmox, so it is counted per node but
+// only once in the cluster totals.
+func decodeClusterCapacity(data json.RawMessage) (ClusterCapacity, error) {
+	var resources []clusterResource
+	if err := json.Unmarshal(data, &resources); err != nil {
+		return ClusterCapacity{}, fmt.Errorf("decode cluster resources: %w", err)
+	}
+
+	var out ClusterCapacity
+	nodes := map[string]*NodeCapacity{}
+	nodeFor := func(name string) *NodeCapacity {
+		n, ok := nodes[name]
+		if !ok {
+			n = &NodeCapacity{Node: name}
+			nodes[name] = n
+		}
+		return n
+	}
+	sharedSeen := map[string]bool{}
+
+	for _, res := range resources {
+		switch res.Type {
+		case "node":
+			n := nodeFor(res.Node)
+			n.Status = res.Status
+			n.CPUTotal += int64(res.MaxCPU)
+			n.CPUUsed += res.CPU * res.MaxCPU
+			n.MemTotalBytes += res.MaxMem
+			n.MemUsedBytes += res.Mem
+			out.Totals.CPUTotal += int64(res.MaxCPU)
+			out.Totals.CPUUsed += res.CPU * res.MaxCPU
+			out.Totals.MemTotalBytes += res.MaxMem
+			out.Totals.MemUsedBytes += res.Mem
+			if res.Status == "online" {
+				out.Totals.OnlineNodes++
+			}
+		case "qemu", "lxc":
+			n := nodeFor(res.Node)
+			for _, usage := range []*CapacityUsage{&n.CapacityUsage, &out.Totals} {
+				usage.CPUAllocated += int64(res.MaxCPU)
+				usage.MemAllocated += res.MaxMem
+				usage.Guests++
+				if res.Status == "running" {
+					usage.RunningGuests++
+				}
+			}
+		case "storage":
+			n := nodeFor(res.Node)
+			n.StorageTotal += res.MaxDisk
+			n.StorageUsed += res.Disk
+			n.StoragesReported++
+			if res.Shared == 1 {
+				if sharedSeen[res.Storage] {
+					continue
+				}
+				sharedSeen[res.Storage] = true
+			}
+			out.Totals.StorageTotal += res.MaxDisk
+			out.Totals.StorageUsed += res.Disk
+			out.Totals.StoragesReported++
+		}
+	}
+
+	out.Nodes = make([]NodeCapacity, 0, len(nodes))
+	for _, n := range nodes {
+		out.Nodes = append(out.Nodes, *n)
+	}
+	sort.Slice(out.Nodes, func(i, j int) bool { return out.Nodes[i].Node < out.Nodes[j].Node })
+	return out, nil
+}
@@ -0,0 +1,165 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/junlov/proxmox-ai/internal/redact"
+)
+
+// maxCapturedBody bounds how much of each body a capture keeps.
+const maxCapturedBody = 64 << 10
+
+const redacted = redact.Placeholder
+
+// Exchange is one upstream HTTP attempt as seen on the wire, with
+// credentials and sensitive fields redacted.
+type Exchange struct {
+	Method       string            `json:"method"`
+	Endpoint     string            `json:"endpoint"`
+	Headers      map[string]string `json:"headers"`
+	RequestBody  string            `json:"request_body,omitempty"`
+	StatusCode   int               `json:"status_code,omitempty"`
+	ResponseBody string            `json:"response_body,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// Capture collects the exchanges made for one request. Set it on
+// ActionRequest.Capture to opt in; it is safe for concurrent use.
+type Capture struct {
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+func NewCapture() *Capture {
+	return &Capture{}
+}
+
+func (c *Capture) record(ex Exchange) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.exchanges = append(c.exchanges, ex)
+	c.mu.Unlock()
+}
+
+// Exchanges returns the recorded exchanges in the order they happened.
+func (c *Capture) Exchanges() []Exchange {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Exchange(nil), c.exchanges...)
+}
+
+// WithDebugCaptureLog writes every upstream exchange, redacted, to w as JSON
+// lines. It is meant for short diagnostic sessions, never for steady state.
+func WithDebugCaptureLog(w io.Writer) ClientOption {
+	return func(c *APIClient) {
+		if w != nil {
+			c.captureLog = &captureLog{w: w}
+		}
+	}
+}
+
+type captureLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *captureLog) record(ex Exchange) {
+	if l == nil {
+		return
+	}
+	line, err := json.Marshal(ex)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(append(line, '\n'))
+}
+
+func (c *APIClient) capturing(capture *Capture) bool {
+	return capture != nil || c.captureLog != nil
+}
+
+func (c *APIClient) recordExchange(capture *Capture, ex Exchange) {
+	capture.record(ex)
+	c.captureLog.record(ex)
+}
+
+func newExchange(req *http.Request, endpoint, body string) Exchange {
+	headers := make(map[string]string, len(req.Header))
+	for name := range req.Header {
+		value := req.Header.Get(name)
+		if strings.EqualFold(name, "Authorization") {
+			value = redactAuthHeader(value)
+		} else if isSensitiveKey(name) {
+			value = redacted
+		}
+		headers[name] = value
+	}
+	return Exchange{
+		Method:      req.Method,
+		Endpoint:    endpoint,
+		Headers:     headers,
+		RequestBody: redactFormBody(body),
+	}
+}
+
+// redactAuthHeader keeps the token ID, which identifies the credential in
+// use, and drops the secret.
+func redactAuthHeader(value string) string {
+	if i := strings.LastIndex(value, "="); i >= 0 && strings.HasPrefix(value, "PVEAPIToken=") && i > len("PVEAPIToken=") {
+		return value[:i+1] + redacted
+	}
+	return redacted
+}
+
+func isSensitiveKey(key string) bool {
+	return redact.IsSensitive(key, redact.SensitiveKeys)
+}
+
+func redactFormBody(body string) string {
+	if body == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(body)
+	if err != nil {
+		return redacted
+	}
+	for key := range values {
+		if isSensitiveKey(key) {
+			values[key] = []string{redacted}
+		}
+	}
+	return truncateCaptured(values.Encode())
+}
+
+// redactResponseBody masks sensitive keys anywhere in a JSON response. Bodies
+// that are not JSON are kept as-is.
+func redactResponseBody(body []byte) string {
+	var decoded any
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return truncateCaptured(string(body))
+	}
+	out, err := json.Marshal(redact.Value(decoded, redact.SensitiveKeys))
+	if err != nil {
+		return redacted
+	}
+	return truncateCaptured(string(out))
+}
+
+func truncateCaptured(s string) string {
+	if len(s) <= maxCapturedBody {
+		return s
+	}
+	return s[:maxCapturedBody] + "...[truncated]"
+}
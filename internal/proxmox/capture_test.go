@@ -0,0 +1,59 @@
+package proxmox
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCaptureRecordsExchangeAndRedactsSecrets(t *testing.T) {
+	client := newMockClient(t, "capture-secret", func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":{"upid":"UPID:node1:0001","ticket":"PVE:abc"}}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+	var log bytes.Buffer
+	WithDebugCaptureLog(&log)(client)
+	capture := NewCapture()
+
+	_, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionStorageEdit,
+		Target:      "storage/local",
+		Params:      map[string]any{"endpoint": "/api2/json/storage/local", "content": "iso", "password": "hunter2"},
+		Capture:     capture,
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+
+	exchanges := capture.Exchanges()
+	if len(exchanges) != 1 {
+		t.Fatalf("expected one exchange, got %d", len(exchanges))
+	}
+	ex := exchanges[0]
+	if ex.Method != http.MethodPut || ex.Endpoint != "/api2/json/storage/local" || ex.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected exchange: %+v", ex)
+	}
+	if got := ex.Headers["Authorization"]; got != "PVEAPIToken=root@pam!agent=[REDACTED]" {
+		t.Fatalf("expected redacted auth header, got %q", got)
+	}
+	if !strings.Contains(ex.RequestBody, "content=iso") || !strings.Contains(ex.RequestBody, "password=%5BREDACTED%5D") {
+		t.Fatalf("expected redacted request body, got %q", ex.RequestBody)
+	}
+	if strings.Contains(ex.ResponseBody, "PVE:abc") || !strings.Contains(ex.ResponseBody, "UPID:node1:0001") {
+		t.Fatalf("expected redacted response body, got %q", ex.ResponseBody)
+	}
+	for _, out := range []string{log.String(), ex.RequestBody, ex.ResponseBody} {
+		if strings.Contains(out, "capture-secret") || strings.Contains(out, "hunter2") {
+			t.Fatalf("capture leaked a secret: %q", out)
+		}
+	}
+	if !strings.Contains(log.String(), `"endpoint":"/api2/json/storage/local"`) {
+		t.Fatalf("expected capture log line, got %q", log.String())
+	}
+}
@@ -16,26 +16,85 @@ import (
 	"time"
 
 	"github.com/junlov/proxmox-ai/internal/config"
+	"github.com/junlov/proxmox-ai/internal/tracing"
 )
 
 type ActionType string
 
 const (
-	ActionReadVM         ActionType = "read_vm"
-	ActionReadInventory  ActionType = "read_inventory"
-	ActionReadNodes      ActionType = "read_nodes"
-	ActionReadTaskStatus ActionType = "read_task_status"
-	ActionReadTasks      ActionType = "read_tasks"
-	ActionStartVM        ActionType = "start_vm"
-	ActionStopVM         ActionType = "stop_vm"
-	ActionSnapshotVM     ActionType = "snapshot_vm"
-	ActionCloneVM        ActionType = "clone_vm"
-	ActionMigrateVM      ActionType = "migrate_vm"
-	ActionDeleteVM       ActionType = "delete_vm"
-	ActionStorageEdit    ActionType = "storage_edit"
-	ActionFirewallEdit   ActionType = "firewall_edit"
+	ActionReadVM                ActionType = "read_vm"
+	ActionReadInventory         ActionType = "read_inventory"
+	ActionReadNodes             ActionType = "read_nodes"
+	ActionReadTaskStatus        ActionType = "read_task_status"
+	ActionReadTasks             ActionType = "read_tasks"
+	ActionReadHAStatus          ActionType = "read_ha_status"
+	ActionReadCapacity          ActionType = "read_cluster_capacity"
+	ActionReadVersion           ActionType = "read_version"
+	ActionReadNodeVersion       ActionType = "read_node_version"
+	ActionReadNodeSubscription  ActionType = "read_node_subscription"
+	ActionReadVMPending         ActionType = "read_vm_pending"
+	ActionReadVMSnapshots       ActionType = "read_vm_snapshots"
+	ActionReadVMNetwork         ActionType = "read_vm_network"
+	ActionReadVMSnapshotConfig  ActionType = "read_vm_snapshot_config"
+	ActionReadClusterLog        ActionType = "read_cluster_log"
+	ActionReadVMRRDData         ActionType = "read_vm_rrddata"
+	ActionGuestOSInfo           ActionType = "read_guest_osinfo"
+	ActionReadVMConfig          ActionType = "read_vm_config"
+	ActionReadPoolMembers       ActionType = "read_pool_members"
+	ActionReadReplicationStatus ActionType = "read_replication_status"
+	ActionStartVM               ActionType = "start_vm"
+	ActionStopVM                ActionType = "stop_vm"
+	ActionSnapshotVM            ActionType = "snapshot_vm"
+	ActionDeleteSnapshot        ActionType = "delete_snapshot"
+	ActionCloneVM               ActionType = "clone_vm"
+	ActionMigrateVM             ActionType = "migrate_vm"
+	ActionSetVMConfig           ActionType = "set_vm_config"
+	ActionDeleteVM              ActionType = "delete_vm"
+	ActionStorageEdit           ActionType = "storage_edit"
+	ActionFirewallEdit          ActionType = "firewall_edit"
 )
 
+// actionTable lists every action the client implements; the value reports
+// whether the action addresses a single VM through a vm/<id> or node/<vmid>
+// target. knownAction and IsVMAction both read it.
+var actionTable = map[ActionType]bool{
+	ActionReadVM:                true,
+	ActionReadInventory:         false,
+	ActionReadNodes:             false,
+	ActionReadTaskStatus:        false,
+	ActionReadTasks:             false,
+	ActionReadHAStatus:          false,
+	ActionReadCapacity:          false,
+	ActionReadVersion:           false,
+	ActionReadNodeVersion:       false,
+	ActionReadNodeSubscription:  false,
+	ActionReadVMPending:         true,
+	ActionReadVMSnapshots:       true,
+	ActionReadVMNetwork:         true,
+	ActionReadVMSnapshotConfig:  true,
+	ActionReadClusterLog:        false,
+	ActionReadVMRRDData:         true,
+	ActionGuestOSInfo:           true,
+	ActionReadVMConfig:          true,
+	ActionReadPoolMembers:       false,
+	ActionReadReplicationStatus: false,
+	ActionStartVM:               true,
+	ActionStopVM:                true,
+	ActionSnapshotVM:            true,
+	ActionDeleteSnapshot:        true,
+	ActionCloneVM:               true,
+	ActionMigrateVM:             true,
+	ActionSetVMConfig:           true,
+	ActionDeleteVM:              true,
+	ActionStorageEdit:           false,
+	ActionFirewallEdit:          false,
+}
+
+// IsReadAction reports whether action only reads from Proxmox.
+func IsReadAction(action ActionType) bool {
+	return strings.HasPrefix(string(action), "read_")
+}
+
 type ActionRequest struct {
 	Environment    string         `json:"environment"`
 	Action         ActionType     `json:"action"`
@@ -46,29 +105,104 @@ type ActionRequest struct {
 	ApprovalTicket string         `json:"approval_ticket,omitempty"`
 	Reason         string         `json:"reason,omitempty"`
 	ExpiresAt      string         `json:"expires_at,omitempty"`
-	Actor          string         `json:"-"`
+	MinRisk        string         `json:"min_risk,omitempty"`
+	ExpectStatus   string         `json:"expect_status,omitempty"`
+	// Hold asks apply to park the request for out-of-band approval instead
+	// of denying it when approval is missing.
+	Hold       bool   `json:"hold,omitempty"`
+	Actor      string `json:"-"`
+	OnBehalfOf string `json:"-"`
+	ClientIP   string `json:"-"`
+	RequestID  string `json:"-"`
+	// TransactionID ties a plan to the apply that follows it; both audit
+	// records carry it.
+	TransactionID string `json:"-"`
+	// Capture, when set, records every upstream exchange for debugging.
+	Capture *Capture `json:"-"`
+	// Trace is the caller's span; the upstream call is recorded as its child.
+	Trace tracing.SpanContext `json:"-"`
+	// Raw asks Execute for the upstream response body untouched, in
+	// ActionResult.Raw, instead of the decoded Data. Reads only.
+	Raw bool `json:"-"`
+	// TokenIdentity selects one of the environment's token_identities to
+	// authenticate with instead of its default token.
+	TokenIdentity string `json:"-"`
+	// Context, when set, bounds the upstream calls and the waits between
+	// their retries; the server passes the HTTP request's context.
+	Context context.Context `json:"-"`
+}
+
+// requestContext returns req.Context, or the background context when the
+// caller set none.
+func requestContext(req ActionRequest) context.Context {
+	if req.Context == nil {
+		return context.Background()
+	}
+	return req.Context
 }
 
 type ActionResult struct {
 	Status  string `json:"status"`
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
+	// Node and TaskType are parsed from the UPID of an async action so the
+	// task can be polled without re-deriving where it runs.
+	Node     string `json:"node,omitempty"`
+	TaskType string `json:"task_type,omitempty"`
+	// Warnings are non-fatal notices Proxmox sent alongside the data.
+	Warnings []string `json:"warnings,omitempty"`
+	// Raw is the verbatim upstream body for requests that set Raw.
+	Raw json.RawMessage `json:"-"`
 }
 
 type Client interface {
 	Execute(req ActionRequest) (ActionResult, error)
 }
 
+// EndpointResolver is implemented by clients that can report the exact
+// upstream call a request maps to, including per-environment overrides.
+type EndpointResolver interface {
+	ResolveEndpoint(req ActionRequest) (method string, endpoint string, err error)
+}
+
 const (
 	defaultHTTPTimeout = 15 * time.Second
 	defaultReadRetries = 3
+	// Reads retried after a transient or busy response wait retryBaseDelay,
+	// doubling per attempt, unless Proxmox sends a Retry-After hint. Waits are
+	// capped at maxRetryDelay either way.
+	retryBaseDelay = 250 * time.Millisecond
+	maxRetryDelay  = 5 * time.Second
+	// defaultBusyRetryAfter is suggested to callers when a busy response
+	// carries no Retry-After of its own.
+	defaultBusyRetryAfter   = 5 * time.Second
+	defaultMaxResponseBytes = 4 << 20
+	// largeResponseFactor widens the cap for actions whose payloads are
+	// expected to be big, such as task lists and logs.
+	largeResponseFactor = 8
 )
 
+// DefaultBusyStatusCodes are the upstream statuses treated as "cluster busy":
+// 429 Too Many Requests and the 596 pveproxy returns when it cannot reach
+// the node behind it in time.
+var DefaultBusyStatusCodes = []int{http.StatusTooManyRequests, 596}
+
+var largeResponseActions = map[ActionType]bool{
+	ActionReadTasks: true,
+}
+
+// DefaultAPIBasePath is the path prefix of the Proxmox JSON API.
+const DefaultAPIBasePath = "/api2/json"
+
 type APIError struct {
 	StatusCode int
 	Method     string
 	Endpoint   string
 	Message    string
+	// Busy marks a rejection with one of the client's busy status codes.
+	// RetryAfter is how long the caller should wait before trying again.
+	Busy       bool
+	RetryAfter time.Duration
 }
 
 func (e *APIError) Error() string {
@@ -78,40 +212,244 @@ func (e *APIError) Error() string {
 	return fmt.Sprintf("proxmox API error (%s %s) status %d: %s", e.Method, e.Endpoint, e.StatusCode, e.Message)
 }
 
+// ErrDigestMismatch matches, via errors.Is, an upstream rejection of a
+// config edit whose digest no longer matches the current config.
+var ErrDigestMismatch = errors.New("config digest mismatch")
+
+// ErrClusterBusy matches, via errors.Is, an upstream rejection because the
+// cluster is overloaded. Reads have already been retried with backoff by the
+// time it surfaces; writes fail fast so the caller decides when to retry.
+var ErrClusterBusy = errors.New("cluster busy, retry later")
+
+// ErrNotFound matches, via errors.Is, an upstream answer that the addressed
+// VM, snapshot or other object does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrUnknownTokenIdentity is returned when a request selects a token
+// identity its environment does not configure.
+var ErrUnknownTokenIdentity = errors.New("unknown token identity")
+
+// Is reports busy rejections as ErrClusterBusy, missing objects as
+// ErrNotFound, and digest mismatches as ErrDigestMismatch. Proxmox answers
+// 500 with "does not exist" for unknown VMIDs and snapshots on most
+// endpoints, and 404 behind some proxies. Proxmox answers 400 for
+// an explicit digest check and 500 "detected modified configuration" when
+// the file changed underneath a locked edit.
+func (e *APIError) Is(target error) bool {
+	if target == ErrClusterBusy {
+		return e.Busy
+	}
+	if target == ErrNotFound {
+		return e.StatusCode == http.StatusNotFound ||
+			e.StatusCode == http.StatusInternalServerError && strings.Contains(e.Message, "does not exist")
+	}
+	if target != ErrDigestMismatch {
+		return false
+	}
+	msg := strings.ToLower(e.Message)
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return strings.Contains(msg, "digest")
+	case http.StatusInternalServerError:
+		return strings.Contains(msg, "detected modified configuration")
+	}
+	return false
+}
+
+// ExecuteError ties an execution failure to the request that caused it. The
+// cause, usually an *APIError, is available through errors.As.
+type ExecuteError struct {
+	Environment string
+	Action      ActionType
+	Target      string
+	Err         error
+}
+
+func (e *ExecuteError) Error() string {
+	return fmt.Sprintf("%s %s in environment %q failed: %v", e.Action, e.Target, e.Environment, e.Err)
+}
+
+func (e *ExecuteError) Unwrap() error {
+	return e.Err
+}
+
 type apiEnvironment struct {
 	baseURL     string
+	basePath    string
 	tokenID     string
 	tokenSecret string
+	// httpClient is set for environments with a pinned certificate and
+	// replaces the shared client for their requests.
+	httpClient        *http.Client
+	endpointOverrides map[ActionType]string
+	extraHeaders      http.Header
+	// identities holds the environment's token_identities as
+	// [token ID, secret] pairs.
+	identities map[string][2]string
+}
+
+// withIdentity returns e authenticating as the named token identity.
+func (e apiEnvironment) withIdentity(name string) (apiEnvironment, error) {
+	token, ok := e.identities[name]
+	if !ok {
+		return apiEnvironment{}, fmt.Errorf("%w %q", ErrUnknownTokenIdentity, name)
+	}
+	e.tokenID, e.tokenSecret = token[0], token[1]
+	return e, nil
+}
+
+func (e apiEnvironment) apiBasePath() string {
+	if e.basePath == "" {
+		return DefaultAPIBasePath
+	}
+	return e.basePath
 }
 
 type APIClient struct {
-	envs        map[string]apiEnvironment
-	httpClient  *http.Client
-	readRetries int
+	envs             map[string]apiEnvironment
+	httpClient       *http.Client
+	readRetries      int
+	maxResponseBytes int64
+	secrets          SecretProviders
+	busyStatusCodes  map[int]bool
+	sleep            func(time.Duration)
+	tracer           *tracing.Tracer
+	now              func() time.Time
+	captureLog       *captureLog
+	reads            flightGroup
+}
+
+// ClientOption configures optional APIClient behavior.
+type ClientOption func(*APIClient)
+
+// WithMaxResponseBytes caps how much of an upstream response body is read.
+// Zero or negative keeps the default.
+func WithMaxResponseBytes(n int64) ClientOption {
+	return func(c *APIClient) {
+		if n > 0 {
+			c.maxResponseBytes = n
+		}
+	}
+}
+
+// WithBusyStatusCodes replaces DefaultBusyStatusCodes. An empty list keeps
+// the default.
+func WithBusyStatusCodes(codes []int) ClientOption {
+	return func(c *APIClient) {
+		if len(codes) > 0 {
+			c.busyStatusCodes = statusCodeSet(codes)
+		}
+	}
+}
+
+// WithTracer records each upstream HTTP call as a span under the request's
+// Trace context.
+func WithTracer(t *tracing.Tracer) ClientOption {
+	return func(c *APIClient) {
+		c.tracer = t
+	}
 }
 
-func NewAPIClient(environments []config.Environment) (*APIClient, error) {
-	envs := make(map[string]apiEnvironment, len(environments))
+func statusCodeSet(codes []int) map[int]bool {
+	set := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		set[code] = true
+	}
+	return set
+}
+
+func NewAPIClient(environments []config.Environment, opts ...ClientOption) (*APIClient, error) {
+	httpClient, err := newHTTPClient(defaultHTTPTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &APIClient{
+		httpClient:       httpClient,
+		readRetries:      defaultReadRetries,
+		maxResponseBytes: defaultMaxResponseBytes,
+		secrets:          defaultSecretProviders(),
+		busyStatusCodes:  statusCodeSet(DefaultBusyStatusCodes),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.envs = make(map[string]apiEnvironment, len(environments))
 	for _, env := range environments {
-		tokenSecret := strings.TrimSpace(os.Getenv(env.TokenSecretEnv))
-		if tokenSecret == "" {
-			return nil, fmt.Errorf("missing token secret env var %q for environment %q", env.TokenSecretEnv, env.Name)
+		tokenSecret, err := c.resolveTokenSecret(env.Name, env.TokenSecretRef, env.TokenSecretEnv)
+		if err != nil {
+			return nil, err
 		}
-		envs[env.Name] = apiEnvironment{
+		apiEnv := apiEnvironment{
 			baseURL:     strings.TrimRight(env.BaseURL, "/"),
+			basePath:    strings.TrimRight(env.APIBasePath, "/"),
 			tokenID:     env.TokenID,
 			tokenSecret: tokenSecret,
 		}
+		apiEnv.endpointOverrides, err = parseEndpointOverrides(env.Name, env.EndpointOverrides)
+		if err != nil {
+			return nil, err
+		}
+		if err := config.ValidateExtraHeaders(env.ExtraHeaders); err != nil {
+			return nil, fmt.Errorf("environment %q: extra_headers: %w", env.Name, err)
+		}
+		for name, value := range env.ExtraHeaders {
+			if apiEnv.extraHeaders == nil {
+				apiEnv.extraHeaders = make(http.Header, len(env.ExtraHeaders))
+			}
+			apiEnv.extraHeaders.Set(name, value)
+		}
+		if pin := strings.TrimSpace(env.TLSFingerprint); pin != "" {
+			fingerprint, err := normalizeFingerprint(pin)
+			if err != nil {
+				return nil, fmt.Errorf("environment %q: %w", env.Name, err)
+			}
+			apiEnv.httpClient, err = newPinnedHTTPClient(defaultHTTPTimeout, fingerprint)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for name, identity := range env.TokenIdentities {
+			secret, err := c.resolveTokenSecret(fmt.Sprintf("%s (token identity %s)", env.Name, name), identity.TokenSecretRef, identity.TokenSecretEnv)
+			if err != nil {
+				return nil, err
+			}
+			if apiEnv.identities == nil {
+				apiEnv.identities = make(map[string][2]string, len(env.TokenIdentities))
+			}
+			apiEnv.identities[name] = [2]string{identity.TokenID, secret}
+		}
+		c.envs[env.Name] = apiEnv
 	}
-	httpClient, err := newHTTPClient(defaultHTTPTimeout)
-	if err != nil {
-		return nil, err
+	return c, nil
+}
+
+// resolveTokenSecret prefers token_secret_ref and falls back to the plain
+// token_secret_env variable.
+func (c *APIClient) resolveTokenSecret(name, secretRef, secretEnv string) (string, error) {
+	if ref := strings.TrimSpace(secretRef); ref != "" {
+		secret, err := c.secrets.Resolve(ref)
+		if err != nil {
+			return "", fmt.Errorf("resolve token secret for environment %q: %w", name, err)
+		}
+		return secret, nil
 	}
-	return &APIClient{
-		envs:        envs,
-		httpClient:  httpClient,
-		readRetries: defaultReadRetries,
-	}, nil
+	tokenSecret := strings.TrimSpace(os.Getenv(secretEnv))
+	if tokenSecret == "" {
+		return "", fmt.Errorf("missing token secret env var %q for environment %q", secretEnv, name)
+	}
+	return tokenSecret, nil
+}
+
+func (c *APIClient) responseLimit(action ActionType) int64 {
+	limit := c.maxResponseBytes
+	if limit <= 0 {
+		limit = defaultMaxResponseBytes
+	}
+	if largeResponseActions[action] {
+		limit *= largeResponseFactor
+	}
+	return limit
 }
 
 func newHTTPClient(timeout time.Duration) (*http.Client, error) {
@@ -167,70 +505,357 @@ func (c *APIClient) Execute(req ActionRequest) (ActionResult, error) {
 	if !ok {
 		return ActionResult{}, fmt.Errorf("unknown environment %q", req.Environment)
 	}
+	if req.TokenIdentity != "" {
+		var err error
+		if env, err = env.withIdentity(req.TokenIdentity); err != nil {
+			return ActionResult{}, err
+		}
+	}
 
-	method, endpoint, params, err := requestSpec(req)
+	method, endpoint, params, err := env.spec(req)
 	if err != nil {
 		return ActionResult{}, err
 	}
 
+	if IsSoftDelete(req) {
+		return c.softDeleteVM(env, req)
+	}
+
 	body := encodeParams(params)
-	respBody, err := c.performRequest(env, method, endpoint, body)
+	span := c.tracer.Start("proxmox.request", req.Trace)
+	span.SetAttribute("environment", req.Environment)
+	span.SetAttribute("http.method", method)
+	span.SetAttribute("http.route", endpoint)
+	var respBody []byte
+	if IsReadAction(req.Action) && req.Capture == nil {
+		// Identical concurrent reads share one upstream call. A captured
+		// read always goes upstream itself so its capture is complete.
+		var shared bool
+		ctx := requestContext(req)
+		respBody, err, shared = c.reads.do(req.Environment+"|"+req.TokenIdentity+"|"+string(req.Action)+"|"+endpoint, func() ([]byte, error) {
+			return c.performRequest(ctx, env, method, endpoint, body, c.responseLimit(req.Action), nil)
+		})
+		if shared && ctx.Err() == nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+			// The caller that led the shared read gave up; this one has not.
+			respBody, err = c.performRequest(ctx, env, method, endpoint, body, c.responseLimit(req.Action), nil)
+		}
+		span.SetAttribute("proxmox.coalesced", shared)
+	} else {
+		respBody, err = c.performRequest(requestContext(req), env, method, endpoint, body, c.responseLimit(req.Action), req.Capture)
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode != 0 {
+		span.SetAttribute("http.status_code", apiErr.StatusCode)
+	}
+	span.RecordError(err)
+	span.End()
+	if req.Action == ActionGuestOSInfo && isGuestAgentUnavailable(err) {
+		return ActionResult{Status: "unavailable", Message: "QEMU guest agent is not running", Data: GuestOSInfo{}}, nil
+	}
 	if err != nil {
 		return ActionResult{}, err
 	}
+	if req.Raw && IsReadAction(req.Action) {
+		return ActionResult{Status: "ok", Message: "raw Proxmox response", Raw: respBody}, nil
+	}
 
 	var envelope struct {
-		Data any `json:"data"`
+		Data     json.RawMessage `json:"data"`
+		Warnings json.RawMessage `json:"warnings"`
 	}
 	if len(respBody) > 0 {
 		if err := json.Unmarshal(respBody, &envelope); err != nil {
 			return ActionResult{}, fmt.Errorf("decode proxmox response: %w", err)
 		}
 	}
+	warnings := decodeWarnings(envelope.Warnings)
 
 	status := "accepted"
 	message := "request accepted by Proxmox API"
+	// Endpoints such as deletes answer {"data":null} on success; treat that
+	// as an empty accepted result rather than feeding nil to the decoders.
+	if isNullData(envelope.Data) {
+		return ActionResult{Status: status, Message: message, Warnings: warnings}, nil
+	}
+	var raw any
+	if err := json.Unmarshal(envelope.Data, &raw); err != nil {
+		return ActionResult{}, fmt.Errorf("decode proxmox response: %w", err)
+	}
 	var data any
-	if req.Action == ActionReadVM {
+	switch req.Action {
+	case ActionReadVM:
 		status = "ok"
 		message = "vm state retrieved from Proxmox API"
-	}
-	if req.Action == ActionReadInventory {
+		data = raw
+	case ActionReadInventory:
 		status = "ok"
 		message = "inventory retrieved from Proxmox API"
-		filtered, err := filterInventoryByTarget(req.Target, envelope.Data)
+		filtered, err := filterInventory(req, raw)
 		if err != nil {
 			return ActionResult{}, err
 		}
 		data = filtered
-	} else {
-		data = envelope.Data
+	case ActionReadTaskStatus:
+		taskStatus, err := decodeTaskStatus(envelope.Data)
+		if err != nil {
+			return ActionResult{}, err
+		}
+		data = taskStatus
+	case ActionReadHAStatus:
+		status = "ok"
+		message = "HA status retrieved from Proxmox API"
+		entries, err := decodeHAStatus(envelope.Data)
+		if err != nil {
+			return ActionResult{}, err
+		}
+		data = entries
+	case ActionReadCapacity:
+		status = "ok"
+		message = "cluster capacity computed from Proxmox resources"
+		capacity, err := decodeClusterCapacity(envelope.Data)
+		if err != nil {
+			return ActionResult{}, err
+		}
+		data = capacity
+	case ActionReadVersion:
+		status = "ok"
+		message = "version retrieved from Proxmox API"
+		info, err := decodeVersion(envelope.Data)
+		if err != nil {
+			return ActionResult{}, err
+		}
+		data = info
+	case ActionReadReplicationStatus:
+		replication, err := decodeReplicationStatus(envelope.Data)
+		if err != nil {
+			return ActionResult{}, err
+		}
+		if withLog, _, _ := optionalBoolParam(req.Params, "log"); withLog {
+			if replication.Log, err = c.replicationLog(env, req); err != nil {
+				return ActionResult{}, err
+			}
+		}
+		status = "ok"
+		message = "replication status retrieved from Proxmox API"
+		data = replication
+	case ActionReadPoolMembers:
+		status = "ok"
+		message = "pool members retrieved from Proxmox API"
+		members, err := decodePoolMembers(envelope.Data)
+		if err != nil {
+			return ActionResult{}, err
+		}
+		data = members
+	case ActionReadNodeVersion:
+		status = "ok"
+		message = "node version retrieved from Proxmox API"
+		info, err := decodeVersion(envelope.Data)
+		if err != nil {
+			return ActionResult{}, err
+		}
+		data = info
+	case ActionReadNodeSubscription:
+		status = "ok"
+		message = "node subscription retrieved from Proxmox API"
+		sub, err := decodeSubscription(envelope.Data)
+		if err != nil {
+			return ActionResult{}, err
+		}
+		data = sub
+	case ActionReadVMPending:
+		status = "ok"
+		message = "pending config retrieved from Proxmox API"
+		pending, err := decodePendingConfig(envelope.Data)
+		if err != nil {
+			return ActionResult{}, err
+		}
+		data = pending
+	case ActionReadVMNetwork:
+		status = "ok"
+		message = "network config retrieved from Proxmox API"
+		pending, err := decodePendingConfig(envelope.Data)
+		if err != nil {
+			return ActionResult{}, err
+		}
+		data = networkConfig(pending)
+	case ActionReadVMSnapshots:
+		status = "ok"
+		message = "snapshots retrieved from Proxmox API"
+		snapshots, err := decodeSnapshots(envelope.Data)
+		if err != nil {
+			return ActionResult{}, err
+		}
+		data = snapshots
+	case ActionReadClusterLog:
+		status = "ok"
+		message = "cluster log retrieved from Proxmox API"
+		severity, _ := optionalStringParam(req.Params, "severity")
+		entries, err := decodeClusterLog(envelope.Data, severity)
+		if err != nil {
+			return ActionResult{}, err
+		}
+		data = entries
+	case ActionReadVMRRDData:
+		status = "ok"
+		message = "rrd data retrieved from Proxmox API"
+		points, err := decodeRRDData(envelope.Data)
+		if err != nil {
+			return ActionResult{}, err
+		}
+		data = points
+	case ActionGuestOSInfo:
+		status = "ok"
+		message = "guest OS info retrieved from the QEMU guest agent"
+		info, err := decodeGuestOSInfo(envelope.Data)
+		if err != nil {
+			return ActionResult{}, err
+		}
+		data = info
+	case ActionReadVMConfig:
+		status = "ok"
+		message = "vm config retrieved from Proxmox API"
+		data = raw
+	case ActionReadVMSnapshotConfig:
+		status = "ok"
+		message = "snapshot config retrieved from Proxmox API"
+		data = raw
+	default:
+		data = raw
+	}
+	result := ActionResult{Status: status, Message: message, Data: data, Warnings: warnings}
+	if taskID, ok := raw.(string); ok && taskID != "" {
+		result.Message = taskID
+		if node, taskType, _, err := ParseUPID(taskID); err == nil {
+			result.Node = node
+			result.TaskType = taskType
+		}
+	}
+	switch req.Action {
+	case ActionCloneVM:
+		return c.cloneResult(env, req, result)
+	case ActionDeleteSnapshot:
+		return c.deleteSnapshotResult(env, req, result)
+	}
+	return result, nil
+}
+
+// decodeWarnings accepts the envelope's warnings as a list or a single
+// string. Anything else is ignored: warnings must never fail a request.
+func decodeWarnings(raw json.RawMessage) []string {
+	if isNullData(raw) {
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		out := list[:0]
+		for _, w := range list {
+			if w = strings.TrimSpace(w); w != "" {
+				out = append(out, w)
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		return out
 	}
-	if taskID, ok := envelope.Data.(string); ok && taskID != "" {
-		message = taskID
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil && strings.TrimSpace(single) != "" {
+		return []string{strings.TrimSpace(single)}
 	}
+	return nil
+}
 
-	return ActionResult{Status: status, Message: message, Data: data}, nil
+func isNullData(data json.RawMessage) bool {
+	trimmed := strings.TrimSpace(string(data))
+	return trimmed == "" || trimmed == "null"
+}
+
+// ResolveEndpoint returns the upstream HTTP method and API path that Execute
+// would call for req, without contacting Proxmox.
+func ResolveEndpoint(req ActionRequest) (method string, endpoint string, err error) {
+	method, endpoint, _, err = requestSpec(req, DefaultAPIBasePath)
+	return method, endpoint, err
+}
+
+// ResolveEndpoint is like the package-level ResolveEndpoint but honors the
+// environment's configured API base path.
+func (c *APIClient) ResolveEndpoint(req ActionRequest) (method string, endpoint string, err error) {
+	env, ok := c.envs[req.Environment]
+	if !ok {
+		return "", "", fmt.Errorf("unknown environment %q", req.Environment)
+	}
+	method, endpoint, _, err = env.spec(req)
+	return method, endpoint, err
 }
 
-func requestSpec(req ActionRequest) (method string, endpoint string, params map[string]any, err error) {
+func requestSpec(req ActionRequest, basePath string) (method string, endpoint string, params map[string]any, err error) {
+	if err := ValidateActionParams(req); err != nil {
+		return "", "", nil, err
+	}
 	switch req.Action {
 	case ActionReadVM:
 		node, vmid, err := parseVMTarget(req.Target, req.Params)
 		if err != nil {
 			return "", "", nil, err
 		}
-		return http.MethodGet, fmt.Sprintf("/api2/json/nodes/%s/qemu/%s/status/current", node, vmid), nil, nil
+		return http.MethodGet, fmt.Sprintf("%s/nodes/%s/qemu/%s/status/current", basePath, node, vmid), nil, nil
+	case ActionReadVMPending, ActionReadVMNetwork:
+		node, vmid, err := parseVMTarget(req.Target, req.Params)
+		if err != nil {
+			return "", "", nil, err
+		}
+		return http.MethodGet, fmt.Sprintf("%s/nodes/%s/qemu/%s/pending", basePath, node, vmid), nil, nil
+	case ActionReadVMSnapshotConfig:
+		node, vmid, err := parseVMTarget(req.Target, req.Params)
+		if err != nil {
+			return "", "", nil, err
+		}
+		snapname, err := requiredStringParam(req.Params, "snapname")
+		if err != nil {
+			return "", "", nil, err
+		}
+		return http.MethodGet, fmt.Sprintf("%s/nodes/%s/qemu/%s/snapshot/%s/config", basePath, node, vmid, url.PathEscape(snapname)), nil, nil
+	case ActionReadVMSnapshots:
+		node, vmid, err := parseVMTarget(req.Target, req.Params)
+		if err != nil {
+			return "", "", nil, err
+		}
+		return http.MethodGet, fmt.Sprintf("%s/nodes/%s/qemu/%s/snapshot", basePath, node, vmid), nil, nil
+	case ActionGuestOSInfo:
+		node, vmid, err := parseVMTarget(req.Target, req.Params)
+		if err != nil {
+			return "", "", nil, err
+		}
+		return http.MethodGet, fmt.Sprintf("%s/nodes/%s/qemu/%s/agent/get-osinfo", basePath, node, vmid), nil, nil
+	case ActionReadVMConfig:
+		node, vmid, err := parseVMTarget(req.Target, req.Params)
+		if err != nil {
+			return "", "", nil, err
+		}
+		return http.MethodGet, fmt.Sprintf("%s/nodes/%s/qemu/%s/config", basePath, node, vmid), nil, nil
+	case ActionReadVMRRDData:
+		node, vmid, err := parseVMTarget(req.Target, req.Params)
+		if err != nil {
+			return "", "", nil, err
+		}
+		timeframe, err := optionalStringParam(req.Params, "timeframe")
+		if err != nil {
+			return "", "", nil, err
+		}
+		if timeframe == "" {
+			timeframe = "hour"
+		}
+		return http.MethodGet, fmt.Sprintf("%s/nodes/%s/qemu/%s/rrddata?cf=AVERAGE&timeframe=%s", basePath, node, vmid, url.QueryEscape(timeframe)), nil, nil
 	case ActionReadInventory:
 		if err := validateInventoryTarget(req.Target); err != nil {
 			return "", "", nil, err
 		}
-		return http.MethodGet, "/api2/json/cluster/resources?type=vm", nil, nil
+		return http.MethodGet, basePath + "/cluster/resources?type=vm", nil, nil
 	case ActionReadNodes:
 		if strings.TrimSpace(req.Target) != "nodes/all" {
 			return "", "", nil, fmt.Errorf(`invalid nodes target %q; expected "nodes/all"`, req.Target)
 		}
-		return http.MethodGet, "/api2/json/cluster/resources?type=node", nil, nil
+		return http.MethodGet, basePath + "/cluster/resources?type=node", nil, nil
 	case ActionReadTaskStatus:
 		node, err := requiredStringParam(req.Params, "node")
 		if err != nil {
@@ -240,60 +865,134 @@ func requestSpec(req ActionRequest) (method string, endpoint string, params map[
 		if err != nil {
 			return "", "", nil, err
 		}
-		return http.MethodGet, fmt.Sprintf("/api2/json/nodes/%s/tasks/%s/status", node, url.PathEscape(upid)), nil, nil
+		return http.MethodGet, fmt.Sprintf("%s/nodes/%s/tasks/%s/status", basePath, node, url.PathEscape(upid)), nil, nil
 	case ActionReadTasks:
 		node, err := requiredStringParam(req.Params, "node")
 		if err != nil {
 			return "", "", nil, err
 		}
+		values := url.Values{}
+		if limit, ok := req.Params["limit"]; ok {
+			values.Set("limit", fmt.Sprint(limit))
+		}
+		if typeFilter, _ := optionalStringParam(req.Params, "typefilter"); typeFilter != "" {
+			values.Set("typefilter", typeFilter)
+		}
+		if statusFilter, _ := optionalStringParam(req.Params, "statusfilter"); statusFilter != "" {
+			values.Set("statusfilter", normalizeStatusFilter(statusFilter))
+		}
 		query := ""
-		if req.Params != nil {
-			if limit, ok := req.Params["limit"]; ok {
-				query = "?limit=" + url.QueryEscape(fmt.Sprint(limit))
-			}
+		if len(values) > 0 {
+			query = "?" + values.Encode()
+		}
+		return http.MethodGet, fmt.Sprintf("%s/nodes/%s/tasks%s", basePath, node, query), nil, nil
+	case ActionReadHAStatus:
+		return http.MethodGet, basePath + "/cluster/ha/status/current", nil, nil
+	case ActionReadCapacity:
+		return http.MethodGet, basePath + "/cluster/resources", nil, nil
+	case ActionReadClusterLog:
+		if maxEntries, set, _ := optionalIntParam(req.Params, "max"); set {
+			return http.MethodGet, fmt.Sprintf("%s/cluster/log?max=%d", basePath, maxEntries), nil, nil
+		}
+		return http.MethodGet, basePath + "/cluster/log", nil, nil
+	case ActionReadVersion:
+		return http.MethodGet, basePath + "/version", nil, nil
+	case ActionReadReplicationStatus:
+		node, err := requiredStringParam(req.Params, "node")
+		if err != nil {
+			return "", "", nil, err
 		}
-		return http.MethodGet, fmt.Sprintf("/api2/json/nodes/%s/tasks%s", node, query), nil, nil
+		id, err := parseReplicationTarget(req.Target)
+		if err != nil {
+			return "", "", nil, err
+		}
+		return http.MethodGet, fmt.Sprintf("%s/nodes/%s/replication/%s/status", basePath, node, url.PathEscape(id)), nil, nil
+	case ActionReadPoolMembers:
+		poolID, err := parsePoolTarget(req.Target)
+		if err != nil {
+			return "", "", nil, err
+		}
+		return http.MethodGet, fmt.Sprintf("%s/pools/%s", basePath, url.PathEscape(poolID)), nil, nil
+	case ActionReadNodeVersion, ActionReadNodeSubscription:
+		node, err := parseNodeTarget(req.Target)
+		if err != nil {
+			return "", "", nil, err
+		}
+		if req.Action == ActionReadNodeSubscription {
+			return http.MethodGet, fmt.Sprintf("%s/nodes/%s/subscription", basePath, node), nil, nil
+		}
+		return http.MethodGet, fmt.Sprintf("%s/nodes/%s/version", basePath, node), nil, nil
 	case ActionStartVM:
 		node, vmid, err := parseVMTarget(req.Target, req.Params)
 		if err != nil {
 			return "", "", nil, err
 		}
-		return http.MethodPost, fmt.Sprintf("/api2/json/nodes/%s/qemu/%s/status/start", node, vmid), req.Params, nil
+		return http.MethodPost, fmt.Sprintf("%s/nodes/%s/qemu/%s/status/start", basePath, node, vmid), req.Params, nil
 	case ActionStopVM:
 		node, vmid, err := parseVMTarget(req.Target, req.Params)
 		if err != nil {
 			return "", "", nil, err
 		}
-		return http.MethodPost, fmt.Sprintf("/api2/json/nodes/%s/qemu/%s/status/stop", node, vmid), req.Params, nil
+		return http.MethodPost, fmt.Sprintf("%s/nodes/%s/qemu/%s/status/stop", basePath, node, vmid), normalizeStopParams(req.Params), nil
 	case ActionSnapshotVM:
 		node, vmid, err := parseVMTarget(req.Target, req.Params)
 		if err != nil {
 			return "", "", nil, err
 		}
-		return http.MethodPost, fmt.Sprintf("/api2/json/nodes/%s/qemu/%s/snapshot", node, vmid), req.Params, nil
+		return http.MethodPost, fmt.Sprintf("%s/nodes/%s/qemu/%s/snapshot", basePath, node, vmid), req.Params, nil
+	case ActionDeleteSnapshot:
+		node, vmid, err := parseVMTarget(req.Target, req.Params)
+		if err != nil {
+			return "", "", nil, err
+		}
+		snapname, err := requiredStringParam(req.Params, "snapname")
+		if err != nil {
+			return "", "", nil, err
+		}
+		return http.MethodDelete, fmt.Sprintf("%s/nodes/%s/qemu/%s/snapshot/%s", basePath, node, vmid, url.PathEscape(snapname)), nil, nil
 	case ActionCloneVM:
 		node, vmid, err := parseVMTarget(req.Target, req.Params)
 		if err != nil {
 			return "", "", nil, err
 		}
-		return http.MethodPost, fmt.Sprintf("/api2/json/nodes/%s/qemu/%s/clone", node, vmid), normalizeCloneParams(req.Params), nil
+		return http.MethodPost, fmt.Sprintf("%s/nodes/%s/qemu/%s/clone", basePath, node, vmid), normalizeCloneParams(req.Params), nil
 	case ActionMigrateVM:
 		node, vmid, err := parseVMTarget(req.Target, req.Params)
 		if err != nil {
 			return "", "", nil, err
 		}
-		return http.MethodPost, fmt.Sprintf("/api2/json/nodes/%s/qemu/%s/migrate", node, vmid), req.Params, nil
+		return http.MethodPost, fmt.Sprintf("%s/nodes/%s/qemu/%s/migrate", basePath, node, vmid), normalizeMigrateParams(req.Params), nil
+	case ActionSetVMConfig:
+		node, vmid, err := parseVMTarget(req.Target, req.Params)
+		if err != nil {
+			return "", "", nil, err
+		}
+		return http.MethodPut, fmt.Sprintf("%s/nodes/%s/qemu/%s/config", basePath, node, vmid), normalizeVMConfigParams(req.Params), nil
 	case ActionDeleteVM:
 		node, vmid, err := parseVMTarget(req.Target, req.Params)
 		if err != nil {
 			return "", "", nil, err
 		}
-		return http.MethodDelete, fmt.Sprintf("/api2/json/nodes/%s/qemu/%s", node, vmid), req.Params, nil
+		if IsSoftDelete(req) {
+			// The soft phase ends by tagging the VM; Execute issues the
+			// config read and stop that precede it.
+			return http.MethodPut, fmt.Sprintf("%s/nodes/%s/qemu/%s/config", basePath, node, vmid), nil, nil
+		}
+		params := req.Params
+		if _, ok := params["soft"]; ok {
+			params = make(map[string]any, len(req.Params))
+			for k, v := range req.Params {
+				if k != "soft" {
+					params[k] = v
+				}
+			}
+		}
+		return http.MethodDelete, fmt.Sprintf("%s/nodes/%s/qemu/%s", basePath, node, vmid), params, nil
 	case ActionStorageEdit:
-		endpoint, method, params, err := customEndpointSpec(req.Params, http.MethodPut)
+		endpoint, method, params, err := customEndpointSpec(req.Params, http.MethodPut, basePath)
 		return method, endpoint, params, err
 	case ActionFirewallEdit:
-		endpoint, method, params, err := customEndpointSpec(req.Params, http.MethodPost)
+		endpoint, method, params, err := customEndpointSpec(req.Params, http.MethodPost, basePath)
 		return method, endpoint, params, err
 	default:
 		return "", "", nil, fmt.Errorf("unsupported action %q", req.Action)
@@ -309,8 +1008,17 @@ func validateInventoryTarget(target string) error {
 	}
 }
 
-func filterInventoryByTarget(target string, data any) (any, error) {
-	if strings.TrimSpace(target) != "inventory/running" {
+func filterInventory(req ActionRequest, data any) (any, error) {
+	running := strings.TrimSpace(req.Target) == "inventory/running"
+	minUptime, uptimeFilter, err := optionalIntParam(req.Params, "min_uptime_seconds")
+	if err != nil {
+		return nil, err
+	}
+	pendingDelete, _, err := optionalBoolParam(req.Params, "pending_delete")
+	if err != nil {
+		return nil, err
+	}
+	if !running && !uptimeFilter && !pendingDelete {
 		return data, nil
 	}
 	items, ok := data.([]any)
@@ -323,10 +1031,29 @@ func filterInventoryByTarget(target string, data any) (any, error) {
 		if !ok {
 			continue
 		}
-		status, _ := resource["status"].(string)
-		if strings.EqualFold(status, "running") {
-			filtered = append(filtered, resource)
+		if running {
+			status, _ := resource["status"].(string)
+			if !strings.EqualFold(status, "running") {
+				continue
+			}
+		}
+		if uptimeFilter {
+			// Resources that do not report uptime are excluded rather than
+			// treated as zero so the filter never matches unknown state.
+			uptime, ok := resource["uptime"].(float64)
+			if !ok || int64(uptime) < minUptime {
+				continue
+			}
+		}
+		if pendingDelete {
+			tags, _ := resource["tags"].(string)
+			since, ok := PendingDeleteSince(tags)
+			if !ok {
+				continue
+			}
+			resource["pending_delete_at"] = since.Format(time.RFC3339)
 		}
+		filtered = append(filtered, resource)
 	}
 	return filtered, nil
 }
@@ -340,11 +1067,56 @@ func normalizeCloneParams(params map[string]any) map[string]any {
 		out[k] = v
 	}
 	// Proxmox clone API expects full as 0/1 form value.
-	if rawFull, ok := out["full"].(bool); ok {
-		if rawFull {
-			out["full"] = 1
-		} else {
-			out["full"] = 0
+	if full, set, err := optionalBoolParam(out, "full"); err == nil && set {
+		out["full"] = formBool(full)
+	}
+	// wait is handled by the agent, not Proxmox.
+	delete(out, "wait")
+	return out
+}
+
+func normalizeMigrateParams(params map[string]any) map[string]any {
+	if len(params) == 0 {
+		return params
+	}
+	out := make(map[string]any, len(params))
+	for k, v := range params {
+		out[k] = v
+	}
+	// Proxmox migrate API expects with-local-disks and online as 0/1 form values.
+	if withLocalDisks, set, err := optionalBoolParam(out, "with-local-disks"); err == nil && set {
+		out["with-local-disks"] = formBool(withLocalDisks)
+	}
+	if online, set, err := optionalBoolParam(out, "online"); err == nil && set {
+		out["online"] = formBool(online)
+	}
+	return out
+}
+
+func normalizeStopParams(params map[string]any) map[string]any {
+	if len(params) == 0 {
+		return params
+	}
+	out := make(map[string]any, len(params))
+	for k, v := range params {
+		out[k] = v
+	}
+	// Proxmox expects keepActive as a 0/1 form value.
+	if keepActive, set, err := optionalBoolParam(out, "keepActive"); err == nil && set {
+		out["keepActive"] = formBool(keepActive)
+	}
+	return out
+}
+
+func normalizeVMConfigParams(params map[string]any) map[string]any {
+	out := make(map[string]any, len(params))
+	for k, v := range params {
+		out[k] = v
+	}
+	// Proxmox expects boolean config options as 0/1 form values.
+	for _, key := range []string{"protection", "onboot"} {
+		if value, set, err := optionalBoolParam(out, key); err == nil && set {
+			out[key] = formBool(value)
 		}
 	}
 	return out
@@ -361,7 +1133,7 @@ func requiredStringParam(params map[string]any, key string) (string, error) {
 	return strings.TrimSpace(v), nil
 }
 
-func customEndpointSpec(params map[string]any, defaultMethod string) (endpoint string, method string, body map[string]any, err error) {
+func customEndpointSpec(params map[string]any, defaultMethod string, basePath string) (endpoint string, method string, body map[string]any, err error) {
 	if params == nil {
 		return "", "", nil, errors.New("params are required for this action")
 	}
@@ -373,7 +1145,7 @@ func customEndpointSpec(params map[string]any, defaultMethod string) (endpoint s
 	if rawMethod, ok := params["method"].(string); ok && strings.TrimSpace(rawMethod) != "" {
 		method = strings.ToUpper(strings.TrimSpace(rawMethod))
 	}
-	if !strings.HasPrefix(rawEndpoint, "/api2/json/") {
+	if !strings.HasPrefix(rawEndpoint, basePath+"/") {
 		return "", "", nil, fmt.Errorf("invalid endpoint %q", rawEndpoint)
 	}
 
@@ -405,6 +1177,22 @@ func parseVMTarget(target string, params map[string]any) (node string, vmid stri
 	return "", "", fmt.Errorf("invalid VM target %q; expected vm/<id> with params.node or node/vmid", target)
 }
 
+func parseNodeTarget(target string) (string, error) {
+	node, ok := strings.CutPrefix(strings.TrimSpace(target), "node/")
+	if !ok || node == "" || strings.Contains(node, "/") {
+		return "", fmt.Errorf("invalid node target %q; expected node/<name>", target)
+	}
+	return node, nil
+}
+
+func parsePoolTarget(target string) (string, error) {
+	poolID, ok := strings.CutPrefix(strings.TrimSpace(target), "pool/")
+	if !ok || !poolIDPattern.MatchString(poolID) {
+		return "", fmt.Errorf("invalid pool target %q; expected pool/<poolid>", target)
+	}
+	return poolID, nil
+}
+
 func encodeParams(params map[string]any) io.Reader {
 	if len(params) == 0 {
 		return nil
@@ -432,7 +1220,7 @@ func encodeParams(params map[string]any) io.Reader {
 	return strings.NewReader(values.Encode())
 }
 
-func (c *APIClient) performRequest(env apiEnvironment, method, endpoint string, body io.Reader) ([]byte, error) {
+func (c *APIClient) performRequest(ctx context.Context, env apiEnvironment, method, endpoint string, body io.Reader, maxBytes int64, capture *Capture) ([]byte, error) {
 	attempts := 1
 	if method == http.MethodGet {
 		attempts = c.readRetries
@@ -441,21 +1229,53 @@ func (c *APIClient) performRequest(env apiEnvironment, method, endpoint string,
 		attempts = 1
 	}
 
+	var capturedBody string
+	if body != nil && c.capturing(capture) {
+		raw, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		capturedBody = string(raw)
+		body = strings.NewReader(capturedBody)
+	}
+
 	fullURL := env.baseURL + endpoint
 	for attempt := 1; attempt <= attempts; attempt++ {
-		req, err := http.NewRequestWithContext(context.Background(), method, fullURL, body)
+		req, err := http.NewRequestWithContext(ctx, method, fullURL, body)
 		if err != nil {
 			return nil, err
 		}
+		for name, values := range env.extraHeaders {
+			req.Header[name] = values
+		}
+		// Set after the extra headers so nothing can replace the token.
 		req.Header.Set("Authorization", BuildTokenAuthHeader(env.tokenID, env.tokenSecret))
 		req.Header.Set("Accept", "application/json")
 		if body != nil {
 			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		}
 
-		resp, err := c.httpClient.Do(req)
+		httpClient := c.httpClient
+		if env.httpClient != nil {
+			httpClient = env.httpClient
+		}
+		var exchange Exchange
+		if c.capturing(capture) {
+			exchange = newExchange(req, endpoint, capturedBody)
+		}
+		resp, err := httpClient.Do(req)
 		if err != nil {
-			if attempt < attempts {
+			if c.capturing(capture) {
+				exchange.Error = err.Error()
+				c.recordExchange(capture, exchange)
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if attempt < attempts && isRetryable(method, 0, err) {
+				if err := c.wait(ctx, retryBackoff(attempt, 0)); err != nil {
+					return nil, err
+				}
 				continue
 			}
 			return nil, &APIError{
@@ -465,24 +1285,51 @@ func (c *APIClient) performRequest(env apiEnvironment, method, endpoint string,
 			}
 		}
 
-		respBody, readErr := io.ReadAll(resp.Body)
+		respBody, readErr := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
 		_ = resp.Body.Close()
+		if c.capturing(capture) {
+			exchange.StatusCode = resp.StatusCode
+			exchange.ResponseBody = redactResponseBody(respBody)
+			c.recordExchange(capture, exchange)
+		}
 		if readErr != nil {
 			return nil, readErr
 		}
+		if int64(len(respBody)) > maxBytes {
+			return nil, &APIError{
+				StatusCode: resp.StatusCode,
+				Method:     method,
+				Endpoint:   endpoint,
+				Message:    fmt.Sprintf("response too large: exceeds %d bytes", maxBytes),
+			}
+		}
 		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 			return respBody, nil
 		}
-		if method == http.MethodGet && attempt < attempts && (resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout) {
+		busy := c.isBusy(resp.StatusCode)
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if attempt < attempts && (isRetryable(method, resp.StatusCode, nil) || (busy && method == http.MethodGet)) {
+			if err := c.wait(ctx, retryBackoff(attempt, retryAfter)); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
-		return nil, &APIError{
+		apiErr := &APIError{
 			StatusCode: resp.StatusCode,
 			Method:     method,
 			Endpoint:   endpoint,
-			Message:    extractErrorMessage(respBody),
+			Message:    extractErrorMessage(respBody, resp.StatusCode, resp.Status),
+		}
+		if busy {
+			apiErr.Busy = true
+			apiErr.Message = ErrClusterBusy.Error() + ": " + apiErr.Message
+			apiErr.RetryAfter = retryAfter
+			if apiErr.RetryAfter <= 0 {
+				apiErr.RetryAfter = defaultBusyRetryAfter
+			}
 		}
+		return nil, apiErr
 	}
 	return nil, &APIError{
 		Method:   method,
@@ -491,8 +1338,90 @@ func (c *APIClient) performRequest(env apiEnvironment, method, endpoint string,
 	}
 }
 
-func extractErrorMessage(respBody []byte) string {
+// isRetryable decides whether a failed attempt may be repeated. Only reads are
+// ever retried, and only for transport errors or gateway-style 5xx responses;
+// client errors (4xx) and a certificate that fails its pin are never retried.
+func isRetryable(method string, statusCode int, err error) bool {
+	if method != http.MethodGet {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, ErrFingerprintMismatch)
+	}
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *APIClient) isBusy(statusCode int) bool {
+	return c.busyStatusCodes[statusCode]
+}
+
+// wait pauses for d between retries and returns early with ctx's error
+// when ctx ends first. Tests replace the pause through c.sleep.
+func (c *APIClient) wait(ctx context.Context, d time.Duration) error {
+	if c.sleep != nil {
+		c.sleep(d)
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryBackoff is the wait before retry number attempt+1. An upstream
+// Retry-After hint wins over exponential backoff but is still capped.
+func retryBackoff(attempt int, retryAfter time.Duration) time.Duration {
+	d := retryAfter
+	if d <= 0 {
+		d = retryBaseDelay << (attempt - 1)
+	}
+	if d <= 0 || d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	return d
+}
+
+// parseRetryAfter accepts both Retry-After forms: delay-seconds and an
+// HTTP date. Malformed or past values yield zero.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// extractErrorMessage prefers the body's error fields. pveproxy often sends
+// only {"data":null} and puts the reason in the status line instead (e.g.
+// "500 QEMU guest agent is not running"), so a non-standard reason phrase is
+// used when the body has nothing better.
+func extractErrorMessage(respBody []byte, statusCode int, status string) string {
+	reason := strings.TrimSpace(strings.TrimPrefix(status, strconv.Itoa(statusCode)))
+	if reason == http.StatusText(statusCode) {
+		reason = ""
+	}
 	if len(respBody) == 0 {
+		if reason != "" {
+			return reason
+		}
 		return "empty error response"
 	}
 	var envelope struct {
@@ -510,6 +1439,8 @@ func extractErrorMessage(respBody []byte) string {
 		return fmt.Sprint(envelope.Errors)
 	case envelope.Data != nil:
 		return fmt.Sprint(envelope.Data)
+	case reason != "":
+		return reason
 	default:
 		return strings.TrimSpace(string(respBody))
 	}
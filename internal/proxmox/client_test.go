@@ -1,8 +1,12 @@
 package proxmox
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -31,7 +35,9 @@ func newMockClient(t *testing.T, tokenSecret string, fn roundTripFunc) *APIClien
 			Transport: fn,
 			Timeout:   3 * time.Second,
 		},
-		readRetries: 3,
+		readRetries:     3,
+		busyStatusCodes: statusCodeSet(DefaultBusyStatusCodes),
+		sleep:           func(time.Duration) {},
 	}
 }
 
@@ -398,3 +404,1229 @@ func TestNewAPIClientFailsWhenTokenSecretMissing(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestExecuteMigrateVMSendsLocalDiskFlags(t *testing.T) {
+	var gotPath, gotBody string
+	client := newMockClient(t, "migrate-secret", func(r *http.Request) (*http.Response, error) {
+		gotPath = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":"UPID:node1:0002"}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	_, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionMigrateVM,
+		Target:      "vm/103",
+		Params: map[string]any{
+			"node":             "node1",
+			"target":           "node2",
+			"online":           true,
+			"with-local-disks": true,
+			"targetstorage":    "local-zfs",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if gotPath != "/api2/json/nodes/node1/qemu/103/migrate" {
+		t.Fatalf("unexpected path: %q", gotPath)
+	}
+	if !strings.Contains(gotBody, "with-local-disks=1") {
+		t.Fatalf("expected body to include with-local-disks=1, got %q", gotBody)
+	}
+	if !strings.Contains(gotBody, "online=1") {
+		t.Fatalf("expected body to include online=1, got %q", gotBody)
+	}
+	if !strings.Contains(gotBody, "targetstorage=local-zfs") {
+		t.Fatalf("expected body to include targetstorage, got %q", gotBody)
+	}
+}
+
+func TestExecuteStopVMSendsTimeoutAndKeepActive(t *testing.T) {
+	var gotPath, gotBody string
+	client := newMockClient(t, "stop-secret", func(r *http.Request) (*http.Response, error) {
+		gotPath = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":"UPID:node1:0003"}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	_, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionStopVM,
+		Target:      "vm/103",
+		Params:      map[string]any{"node": "node1", "timeout": float64(30), "keepActive": true},
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if gotPath != "/api2/json/nodes/node1/qemu/103/status/stop" {
+		t.Fatalf("unexpected path: %q", gotPath)
+	}
+	if !strings.Contains(gotBody, "timeout=30") || !strings.Contains(gotBody, "keepActive=1") {
+		t.Fatalf("expected body to include timeout=30 and keepActive=1, got %q", gotBody)
+	}
+}
+
+func TestExecuteSetVMConfigSendsProtectionFlag(t *testing.T) {
+	var gotMethod, gotPath, gotBody string
+	client := newMockClient(t, "config-secret", func(r *http.Request) (*http.Response, error) {
+		gotMethod = r.Method
+		gotPath = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":null}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	for _, tc := range []struct {
+		protection bool
+		want       string
+	}{{true, "protection=1"}, {false, "protection=0"}} {
+		_, err := client.Execute(ActionRequest{
+			Environment: "home",
+			Action:      ActionSetVMConfig,
+			Target:      "vm/103",
+			Params:      map[string]any{"node": "node1", "protection": tc.protection},
+		})
+		if err != nil {
+			t.Fatalf("Execute returned error: %v", err)
+		}
+		if gotMethod != http.MethodPut || gotPath != "/api2/json/nodes/node1/qemu/103/config" {
+			t.Fatalf("unexpected request: %s %s", gotMethod, gotPath)
+		}
+		if !strings.Contains(gotBody, tc.want) {
+			t.Fatalf("expected body to include %s, got %q", tc.want, gotBody)
+		}
+	}
+
+	digest := strings.Repeat("ab", 20)
+	if _, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionSetVMConfig,
+		Target:      "vm/103",
+		Params:      map[string]any{"node": "node1", "onboot": true, "digest": digest},
+	}); err != nil {
+		t.Fatalf("Execute with digest returned error: %v", err)
+	}
+	if !strings.Contains(gotBody, "digest="+digest) {
+		t.Fatalf("expected digest in body, got %q", gotBody)
+	}
+
+	_, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionSetVMConfig,
+		Target:      "vm/103",
+		Params:      map[string]any{"node": "node1", "memory": float64(4096)},
+	})
+	if err == nil || !strings.Contains(err.Error(), "not an editable VM config option") {
+		t.Fatalf("expected non-allowlisted key to be rejected, got %v", err)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		statusCode int
+		err        error
+		want       bool
+	}{
+		{name: "400 get", method: http.MethodGet, statusCode: http.StatusBadRequest, want: false},
+		{name: "401 get", method: http.MethodGet, statusCode: http.StatusUnauthorized, want: false},
+		{name: "403 get", method: http.MethodGet, statusCode: http.StatusForbidden, want: false},
+		{name: "503 get", method: http.MethodGet, statusCode: http.StatusServiceUnavailable, want: true},
+		{name: "503 post", method: http.MethodPost, statusCode: http.StatusServiceUnavailable, want: false},
+		{name: "500 get", method: http.MethodGet, statusCode: http.StatusInternalServerError, want: false},
+		{name: "network error get", method: http.MethodGet, err: errors.New("connection reset"), want: true},
+		{name: "network error post", method: http.MethodPost, err: errors.New("connection reset"), want: false},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.method, tt.statusCode, tt.err); got != tt.want {
+				t.Fatalf("isRetryable(%s, %d, %v) = %v, want %v", tt.method, tt.statusCode, tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteReadVMDoesNotRetryClientErrors(t *testing.T) {
+	var calls int32
+	client := newMockClient(t, "retry-secret", func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Body:       io.NopCloser(strings.NewReader(`{"errors":{"vmid":"invalid"}}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	_, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionReadVM,
+		Target:      "node1/200",
+	})
+	if err == nil {
+		t.Fatal("expected error for 400 response")
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected a single call for 400 response, got %d", got)
+	}
+}
+
+func TestExecuteNullDataYieldsAcceptedResult(t *testing.T) {
+	client := newMockClient(t, "null-secret", func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":null}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	for _, req := range []ActionRequest{
+		{Environment: "home", Action: ActionDeleteVM, Target: "node1/101"},
+		{Environment: "home", Action: ActionReadInventory, Target: "inventory/running"},
+	} {
+		result, err := client.Execute(req)
+		if err != nil {
+			t.Fatalf("%s: Execute returned error: %v", req.Action, err)
+		}
+		if result.Status != "accepted" {
+			t.Fatalf("%s: unexpected status: %q", req.Action, result.Status)
+		}
+		if result.Data != nil {
+			t.Fatalf("%s: expected no data, got %#v", req.Action, result.Data)
+		}
+	}
+}
+
+func TestExecuteUsesEnvironmentAPIBasePath(t *testing.T) {
+	var gotPaths []string
+	client := newMockClient(t, "gateway-secret", func(r *http.Request) (*http.Response, error) {
+		gotPaths = append(gotPaths, r.URL.Path)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":"UPID:node1:0003"}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+	env := client.envs["home"]
+	env.basePath = "/pve/api2/json"
+	client.envs["home"] = env
+
+	if _, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionStartVM,
+		Target:      "node1/101",
+	}); err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if _, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionFirewallEdit,
+		Target:      "firewall/cluster",
+		Params: map[string]any{
+			"endpoint": "/pve/api2/json/cluster/firewall/rules",
+		},
+	}); err != nil {
+		t.Fatalf("Execute returned error for custom endpoint: %v", err)
+	}
+	_, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionFirewallEdit,
+		Target:      "firewall/cluster",
+		Params: map[string]any{
+			"endpoint": "/api2/json/cluster/firewall/rules",
+		},
+	})
+	if err == nil {
+		t.Fatal("expected custom endpoint outside the base path to be rejected")
+	}
+
+	want := []string{"/pve/api2/json/nodes/node1/qemu/101/status/start", "/pve/api2/json/cluster/firewall/rules"}
+	if len(gotPaths) != len(want) {
+		t.Fatalf("unexpected request paths: %v", gotPaths)
+	}
+	for i := range want {
+		if gotPaths[i] != want[i] {
+			t.Fatalf("unexpected path at %d: got %q want %q", i, gotPaths[i], want[i])
+		}
+	}
+}
+
+func TestExecuteReadHAStatusUsesClusterHAEndpoint(t *testing.T) {
+	var gotPath, gotMethod string
+	client := newMockClient(t, "ha-secret", func(r *http.Request) (*http.Response, error) {
+		gotPath = r.URL.Path
+		gotMethod = r.Method
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":[{"id":"service:vm:100","type":"service","sid":"vm:100","node":"pve1","state":"started","crm_state":"started"}]}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	result, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionReadHAStatus,
+		Target:      "ha/status",
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if gotMethod != http.MethodGet || gotPath != "/api2/json/cluster/ha/status/current" {
+		t.Fatalf("unexpected request: %s %s", gotMethod, gotPath)
+	}
+	entries, ok := result.Data.([]HAStatusEntry)
+	if !ok {
+		t.Fatalf("expected []HAStatusEntry data, got %T", result.Data)
+	}
+	if len(entries) != 1 || entries[0].SID != "vm:100" {
+		t.Fatalf("unexpected HA entries: %+v", entries)
+	}
+}
+
+func TestExecuteReadTaskStatusDerivesCompletionFlags(t *testing.T) {
+	tests := []struct {
+		name          string
+		payload       string
+		wantRunning   bool
+		wantSucceeded bool
+	}{
+		{
+			name:        "running",
+			payload:     `{"data":{"status":"running","type":"qmstart","node":"pve"}}`,
+			wantRunning: true,
+		},
+		{
+			name:    "failed",
+			payload: `{"data":{"status":"stopped","exitstatus":"some error","type":"qmstart","node":"pve"}}`,
+		},
+		{
+			name:          "succeeded",
+			payload:       `{"data":{"status":"stopped","exitstatus":"OK","type":"qmstart","node":"pve"}}`,
+			wantSucceeded: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			client := newMockClient(t, "task-secret", func(r *http.Request) (*http.Response, error) {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(tt.payload)),
+					Header:     make(http.Header),
+				}, nil
+			})
+			result, err := client.Execute(ActionRequest{
+				Environment: "home",
+				Action:      ActionReadTaskStatus,
+				Target:      "task/status",
+				Params:      map[string]any{"node": "pve", "upid": "UPID:pve:1"},
+			})
+			if err != nil {
+				t.Fatalf("Execute returned error: %v", err)
+			}
+			status, ok := result.Data.(TaskStatus)
+			if !ok {
+				t.Fatalf("expected TaskStatus data, got %T", result.Data)
+			}
+			if status.Running != tt.wantRunning || status.Succeeded != tt.wantSucceeded {
+				t.Fatalf("unexpected flags: running=%v succeeded=%v", status.Running, status.Succeeded)
+			}
+		})
+	}
+}
+
+func TestExecuteRejectsOversizedResponse(t *testing.T) {
+	client := newMockClient(t, "large-secret", func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":"` + strings.Repeat("x", 256) + `"}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+	client.maxResponseBytes = 64
+
+	_, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionReadVM,
+		Target:      "node1/101",
+	})
+	if err == nil {
+		t.Fatal("expected error for oversized response")
+	}
+	if !strings.Contains(err.Error(), "response too large") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestResponseLimitIsHigherForLargeReads(t *testing.T) {
+	client := newMockClient(t, "limit-secret", nil)
+	client.maxResponseBytes = 1024
+	if got := client.responseLimit(ActionReadVM); got != 1024 {
+		t.Fatalf("unexpected read_vm limit: %d", got)
+	}
+	if got := client.responseLimit(ActionReadTasks); got <= 1024 {
+		t.Fatalf("expected larger limit for read_tasks, got %d", got)
+	}
+}
+
+func TestExecuteReadNodeVersion(t *testing.T) {
+	var gotPath string
+	client := newMockClient(t, "version-secret", func(r *http.Request) (*http.Response, error) {
+		gotPath = r.URL.Path
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":{"version":"8.2.4","release":"8.2","repoid":"faa83925c9641325"}}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	result, err := client.Execute(ActionRequest{Environment: "home", Action: ActionReadNodeVersion, Target: "node/pve1"})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if gotPath != "/api2/json/nodes/pve1/version" {
+		t.Fatalf("unexpected path: %s", gotPath)
+	}
+	info, ok := result.Data.(VersionInfo)
+	if !ok || info.Version != "8.2.4" {
+		t.Fatalf("unexpected version data: %#v", result.Data)
+	}
+}
+
+func TestExecuteReadNodeSubscription(t *testing.T) {
+	tests := []struct {
+		name       string
+		payload    string
+		wantStatus string
+		wantActive bool
+	}{
+		{
+			name:       "active",
+			payload:    `{"data":{"status":"active","level":"c","productname":"Proxmox VE Community Subscription 1 CPU/year","nextduedate":"2027-01-01","key":"pve1c-0123456789"}}`,
+			wantStatus: "active",
+			wantActive: true,
+		},
+		{
+			name:       "no subscription",
+			payload:    `{"data":{"status":"notfound","message":"There is no subscription key"}}`,
+			wantStatus: "notfound",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath string
+			client := newMockClient(t, "sub-secret", func(r *http.Request) (*http.Response, error) {
+				gotPath = r.URL.Path
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader(tt.payload)),
+					Header:     make(http.Header),
+				}, nil
+			})
+
+			result, err := client.Execute(ActionRequest{Environment: "home", Action: ActionReadNodeSubscription, Target: "node/pve1"})
+			if err != nil {
+				t.Fatalf("Execute returned error: %v", err)
+			}
+			if gotPath != "/api2/json/nodes/pve1/subscription" {
+				t.Fatalf("unexpected path: %s", gotPath)
+			}
+			sub, ok := result.Data.(NodeSubscription)
+			if !ok {
+				t.Fatalf("expected NodeSubscription data, got %T", result.Data)
+			}
+			if sub.Status != tt.wantStatus || sub.Active != tt.wantActive {
+				t.Fatalf("unexpected subscription: %+v", sub)
+			}
+			if raw, _ := json.Marshal(sub); strings.Contains(string(raw), "pve1c-") {
+				t.Fatalf("subscription key must not be surfaced: %s", raw)
+			}
+		})
+	}
+}
+
+func TestExecuteReadVMPendingSurfacesStagedChanges(t *testing.T) {
+	var gotPath string
+	client := newMockClient(t, "pending-secret", func(r *http.Request) (*http.Response, error) {
+		gotPath = r.URL.Path
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body: io.NopCloser(strings.NewReader(`{"data":[
+				{"key":"memory","value":2048,"pending":4096},
+				{"key":"cores","value":2},
+				{"key":"net1","pending":"virtio=BC:24:11:00:00:01,bridge=vmbr1"},
+				{"key":"serial0","value":"socket","delete":1}
+			]}`)),
+			Header: make(http.Header),
+		}, nil
+	})
+
+	result, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionReadVMPending,
+		Target:      "vm/101",
+		Params:      map[string]any{"node": "pve1"},
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if gotPath != "/api2/json/nodes/pve1/qemu/101/pending" {
+		t.Fatalf("unexpected path: %s", gotPath)
+	}
+	pending, ok := result.Data.(PendingConfig)
+	if !ok {
+		t.Fatalf("expected PendingConfig data, got %T", result.Data)
+	}
+	if !pending.HasPending {
+		t.Fatal("expected has_pending to be true")
+	}
+	if pending.Current["memory"] != float64(2048) || pending.Pending["memory"] != float64(4096) {
+		t.Fatalf("expected memory diff, got current=%v pending=%v", pending.Current["memory"], pending.Pending["memory"])
+	}
+	if _, ok := pending.Pending["cores"]; ok {
+		t.Fatal("unchanged key must not appear as pending")
+	}
+	if _, ok := pending.Pending["net1"]; !ok {
+		t.Fatal("expected new net1 to be pending")
+	}
+	if len(pending.Deleted) != 1 || pending.Deleted[0] != "serial0" {
+		t.Fatalf("expected serial0 pending deletion, got %v", pending.Deleted)
+	}
+}
+
+func TestExecuteStorageEditPassesDigestThrough(t *testing.T) {
+	var gotMethod, gotPath, gotBody string
+	client := newMockClient(t, "digest-secret", func(r *http.Request) (*http.Response, error) {
+		gotMethod = r.Method
+		gotPath = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":null}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	digest := "3f2a6a7b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f"
+	_, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionStorageEdit,
+		Target:      "storage/local",
+		Params: map[string]any{
+			"endpoint": "/api2/json/storage/local",
+			"content":  "iso,vztmpl",
+			"digest":   digest,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if gotMethod != http.MethodPut || gotPath != "/api2/json/storage/local" {
+		t.Fatalf("unexpected request: %s %s", gotMethod, gotPath)
+	}
+	if !strings.Contains(gotBody, "digest="+digest) {
+		t.Fatalf("expected digest in form body, got %q", gotBody)
+	}
+}
+
+func TestValidateActionParamsRejectsMalformedDigest(t *testing.T) {
+	err := ValidateActionParams(ActionRequest{
+		Action: ActionStorageEdit,
+		Target: "storage/local",
+		Params: map[string]any{"endpoint": "/api2/json/storage/local", "digest": "not-a-digest"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "params.digest") {
+		t.Fatalf("expected digest validation error, got %v", err)
+	}
+}
+
+func TestAPIErrorDigestMismatch(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *APIError
+		want bool
+	}{
+		{name: "explicit digest check", err: &APIError{StatusCode: http.StatusBadRequest, Message: "map[digest:digest mismatch]"}, want: true},
+		{name: "modified under lock", err: &APIError{StatusCode: http.StatusInternalServerError, Message: "detected modified configuration - file changed by other user? Try again."}, want: true},
+		{name: "other bad request", err: &APIError{StatusCode: http.StatusBadRequest, Message: "map[content:invalid format]"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrapped := &ExecuteError{Action: ActionStorageEdit, Err: tt.err}
+			if got := errors.Is(wrapped, ErrDigestMismatch); got != tt.want {
+				t.Fatalf("errors.Is(ErrDigestMismatch) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteCloneVMSendsTargetNodeAndStorage(t *testing.T) {
+	var gotPath, gotBody string
+	client := newMockClient(t, "clone-secret", func(r *http.Request) (*http.Response, error) {
+		gotPath = r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":"UPID:node1:9999"}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	_, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionCloneVM,
+		Target:      "vm/103",
+		Params: map[string]any{
+			"node":    "node1",
+			"newid":   104,
+			"target":  "node2",
+			"full":    true,
+			"storage": "local-lvm",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if gotPath != "/api2/json/nodes/node1/qemu/103/clone" {
+		t.Fatalf("expected clone to be issued on the source node, got %q", gotPath)
+	}
+	for _, want := range []string{"target=node2", "storage=local-lvm", "full=1"} {
+		if !strings.Contains(gotBody, want) {
+			t.Fatalf("expected body to include %q, got %q", want, gotBody)
+		}
+	}
+}
+
+func TestExecuteCloneVMReturnsNewIDAndTargetNode(t *testing.T) {
+	const upid = "UPID:node1:0000A1B2:00C0FFEE:65F0A000:qmclone:103:root@pam!agent:"
+	client := newMockClient(t, "clone-secret", func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":"` + upid + `"}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	result, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionCloneVM,
+		Target:      "vm/103",
+		Params:      map[string]any{"node": "node1", "newid": 104, "target": "node2"},
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	clone, ok := result.Data.(CloneResult)
+	if !ok {
+		t.Fatalf("expected CloneResult data, got %T", result.Data)
+	}
+	want := CloneResult{UPID: upid, NewID: 104, Node: "node2", SourceNode: "node1"}
+	if clone != want {
+		t.Fatalf("unexpected clone result: %+v", clone)
+	}
+	if result.Message != upid || result.Status != "accepted" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
+
+func TestExecuteCloneVMWaitPollsTaskUntilDone(t *testing.T) {
+	const upid = "UPID:node1:0000A1B2:00C0FFEE:65F0A000:qmclone:103:root@pam!agent:"
+	var polls int
+	var gotBody string
+	client := newMockClient(t, "clone-secret", func(r *http.Request) (*http.Response, error) {
+		payload := `{"data":"` + upid + `"}`
+		if r.Method == http.MethodGet {
+			if r.URL.Path != "/api2/json/nodes/node1/tasks/"+upid+"/status" {
+				t.Errorf("unexpected poll path: %q", r.URL.Path)
+			}
+			polls++
+			payload = `{"data":{"status":"running"}}`
+			if polls == 2 {
+				payload = `{"data":{"status":"stopped","exitstatus":"OK"}}`
+			}
+		} else {
+			body, _ := io.ReadAll(r.Body)
+			gotBody = string(body)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(payload)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	result, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionCloneVM,
+		Target:      "node1/103",
+		Params:      map[string]any{"newid": 104, "wait": true},
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if strings.Contains(gotBody, "wait") {
+		t.Fatalf("expected wait to stay out of the clone request, got %q", gotBody)
+	}
+	if polls != 2 {
+		t.Fatalf("expected 2 status polls, got %d", polls)
+	}
+	clone := result.Data.(CloneResult)
+	if result.Status != "ok" || clone.Task == nil || !clone.Task.Succeeded || clone.NewID != 104 {
+		t.Fatalf("unexpected result: %+v (task %+v)", result, clone.Task)
+	}
+}
+
+func TestExecuteCloneVMWaitReportsFailedTask(t *testing.T) {
+	const upid = "UPID:node1:0000A1B2:00C0FFEE:65F0A000:qmclone:103:root@pam!agent:"
+	client := newMockClient(t, "clone-secret", func(r *http.Request) (*http.Response, error) {
+		payload := `{"data":"` + upid + `"}`
+		if r.Method == http.MethodGet {
+			payload = `{"data":{"status":"stopped","exitstatus":"storage full"}}`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(payload)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	_, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionCloneVM,
+		Target:      "node1/103",
+		Params:      map[string]any{"newid": 104, "wait": true},
+	})
+	if err == nil || !strings.Contains(err.Error(), "storage full") {
+		t.Fatalf("expected failed task error, got %v", err)
+	}
+	var cloneErr *CloneWaitError
+	if !errors.As(err, &cloneErr) || cloneErr.Clone.UPID != upid || cloneErr.Clone.NewID != 104 || cloneErr.Clone.Task == nil {
+		t.Fatalf("expected the clone to be reported with the error, got %+v", cloneErr)
+	}
+}
+
+func TestExecuteCloneVMWaitReturnsRunningTaskAtCap(t *testing.T) {
+	const upid = "UPID:node1:0000A1B2:00C0FFEE:65F0A000:qmclone:103:root@pam!agent:"
+	var polls int
+	client := newMockClient(t, "clone-secret", func(r *http.Request) (*http.Response, error) {
+		payload := `{"data":"` + upid + `"}`
+		if r.Method == http.MethodGet {
+			polls++
+			payload = `{"data":{"status":"running"}}`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(payload)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	result, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionCloneVM,
+		Target:      "node1/103",
+		Params:      map[string]any{"newid": 104, "wait": true},
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if want := int(cloneWaitTimeout / taskPollInterval); polls != want {
+		t.Fatalf("expected %d polls, got %d", want, polls)
+	}
+	clone := result.Data.(CloneResult)
+	if result.Status == "ok" || clone.Task == nil || !clone.Task.Running || clone.UPID != upid {
+		t.Fatalf("expected the running task back for polling, got %+v (task %+v)", result, clone.Task)
+	}
+}
+
+func TestExecuteReadRetriesBusyResponsesWithBackoff(t *testing.T) {
+	var calls int32
+	client := newMockClient(t, "busy-secret", func(r *http.Request) (*http.Response, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			header := make(http.Header)
+			header.Set("Retry-After", "2")
+			return &http.Response{
+				StatusCode: 596,
+				Body:       io.NopCloser(strings.NewReader(`{"data":null}`)),
+				Header:     header,
+			}, nil
+		}
+		if atomic.LoadInt32(&calls) == 2 {
+			return &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Body:       io.NopCloser(strings.NewReader(`{"data":null}`)),
+				Header:     make(http.Header),
+			}, nil
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":{"status":"running"}}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+	var waits []time.Duration
+	client.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	if _, err := client.Execute(ActionRequest{Environment: "home", Action: ActionReadVM, Target: "node1/200"}); err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Fatalf("expected 3 calls, got %d", got)
+	}
+	want := []time.Duration{2 * time.Second, 2 * retryBaseDelay}
+	if len(waits) != len(want) || waits[0] != want[0] || waits[1] != want[1] {
+		t.Fatalf("expected waits %v, got %v", want, waits)
+	}
+}
+
+func TestExecuteReadRetryWaitStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int32
+	client := newMockClient(t, "busy-secret", func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		cancel()
+		header := make(http.Header)
+		header.Set("Retry-After", "30")
+		return &http.Response{
+			StatusCode: 596,
+			Body:       io.NopCloser(strings.NewReader(`{"data":null}`)),
+			Header:     header,
+		}, nil
+	})
+	client.sleep = nil
+
+	start := time.Now()
+	_, err := client.Execute(ActionRequest{Environment: "home", Action: ActionReadVM, Target: "node1/200", Context: ctx})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the retry wait to end with the context, took %s", elapsed)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected a single call, got %d", got)
+	}
+}
+
+func TestExecuteWriteFailsFastWhenClusterBusy(t *testing.T) {
+	var calls int32
+	client := newMockClient(t, "busy-secret", func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		header := make(http.Header)
+		header.Set("Retry-After", "30")
+		return &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Body:       io.NopCloser(strings.NewReader(`{"errors":"too many requests"}`)),
+			Header:     header,
+		}, nil
+	})
+	client.sleep = func(time.Duration) { t.Fatal("writes must not back off") }
+
+	_, err := client.Execute(ActionRequest{Environment: "home", Action: ActionStartVM, Target: "node1/200"})
+	if !errors.Is(err, ErrClusterBusy) {
+		t.Fatalf("expected ErrClusterBusy, got %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter != 30*time.Second {
+		t.Fatalf("expected 30s retry-after, got %+v", apiErr)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected a single call, got %d", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{
+		"":                              0,
+		"5":                             5 * time.Second,
+		"-1":                            0,
+		"soon":                          0,
+		"Sun, 01 Mar 2026 12:00:10 GMT": 10 * time.Second,
+		"Sun, 01 Mar 2026 11:59:00 GMT": 0,
+	}
+	for value, want := range tests {
+		if got := parseRetryAfter(value, now); got != want {
+			t.Fatalf("parseRetryAfter(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestExtraHeadersAreSentButCannotReplaceAuthorization(t *testing.T) {
+	t.Setenv("PVE_TEST_SECRET", "header-secret")
+	envs := []config.Environment{{
+		Name:           "home",
+		BaseURL:        "https://proxmox.example.com",
+		TokenID:        "root@pam!agent",
+		TokenSecretEnv: "PVE_TEST_SECRET",
+		ExtraHeaders:   map[string]string{"x-gateway-key": "gw-123"},
+	}}
+	client, err := NewAPIClient(envs)
+	if err != nil {
+		t.Fatalf("NewAPIClient returned error: %v", err)
+	}
+	var got http.Header
+	client.httpClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		got = r.Header.Clone()
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":{"version":"8.2.4","release":"8.2"}}`)),
+			Header:     make(http.Header),
+		}, nil
+	})}
+	// Even a header table that slipped past validation must not win.
+	env := client.envs["home"]
+	env.extraHeaders.Set("Authorization", "Bearer hijack")
+	client.envs["home"] = env
+
+	if _, err := client.Execute(ActionRequest{Environment: "home", Action: ActionReadVersion, Target: "version"}); err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if got.Get("X-Gateway-Key") != "gw-123" {
+		t.Fatalf("expected X-Gateway-Key on the request, got %v", got)
+	}
+	if want := BuildTokenAuthHeader("root@pam!agent", "header-secret"); got.Get("Authorization") != want {
+		t.Fatalf("Authorization was overridden: %q", got.Get("Authorization"))
+	}
+
+	envs[0].ExtraHeaders = map[string]string{"authorization": "Bearer other"}
+	if _, err := NewAPIClient(envs); err == nil || !strings.Contains(err.Error(), "cannot be overridden") {
+		t.Fatalf("expected Authorization in extra_headers to be rejected, got %v", err)
+	}
+	envs[0].ExtraHeaders = map[string]string{"Bad Header": "x"}
+	if _, err := NewAPIClient(envs); err == nil || !strings.Contains(err.Error(), "invalid header name") {
+		t.Fatalf("expected invalid header name to be rejected, got %v", err)
+	}
+}
+
+func TestExecuteDecodesWarningsInEitherShape(t *testing.T) {
+	for _, payload := range []string{
+		`{"data":null,"warnings":["storage 'local' is nearly full"]}`,
+		`{"data":null,"warnings":"storage 'local' is nearly full"}`,
+	} {
+		client := newMockClient(t, "warn-secret", func(r *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(payload)),
+				Header:     make(http.Header),
+			}, nil
+		})
+		result, err := client.Execute(ActionRequest{Environment: "home", Action: ActionDeleteVM, Target: "node1/101"})
+		if err != nil {
+			t.Fatalf("Execute returned error: %v", err)
+		}
+		if len(result.Warnings) != 1 || result.Warnings[0] != "storage 'local' is nearly full" {
+			t.Fatalf("%s: unexpected warnings %q", payload, result.Warnings)
+		}
+	}
+}
+
+func TestSnapshotDescriptionIsSentAndListed(t *testing.T) {
+	var createBody string
+	client := newMockClient(t, "snap-secret", func(r *http.Request) (*http.Response, error) {
+		payload := `{"data":"UPID:node1:000A1B2C:0123ABCD:65F0AB12:qmsnapshot:101:root@pam:"}`
+		if r.Method == http.MethodPost {
+			b, _ := io.ReadAll(r.Body)
+			createBody = string(b)
+		} else {
+			payload = `{"data":[
+				{"name":"pre-upgrade","description":"before kernel 6.8\n","snaptime":1767225600,"vmstate":1},
+				{"name":"current","description":"You are here!","parent":"pre-upgrade"}
+			]}`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(payload)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	_, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionSnapshotVM,
+		Target:      "node1/101",
+		Params:      map[string]any{"snapname": "pre-upgrade", "description": "before kernel 6.8"},
+	})
+	if err != nil {
+		t.Fatalf("snapshot Execute returned error: %v", err)
+	}
+	form, err := url.ParseQuery(createBody)
+	if err != nil || form.Get("description") != "before kernel 6.8" || form.Get("snapname") != "pre-upgrade" {
+		t.Fatalf("expected description in create body, got %q", createBody)
+	}
+
+	result, err := client.Execute(ActionRequest{Environment: "home", Action: ActionReadVMSnapshots, Target: "node1/101"})
+	if err != nil {
+		t.Fatalf("list Execute returned error: %v", err)
+	}
+	snapshots, ok := result.Data.([]Snapshot)
+	if !ok || len(snapshots) != 1 {
+		t.Fatalf("expected one snapshot without the current marker, got %#v", result.Data)
+	}
+	if got := snapshots[0]; got.Name != "pre-upgrade" || got.Description != "before kernel 6.8" || !got.VMState {
+		t.Fatalf("unexpected snapshot: %+v", got)
+	}
+
+	_, err = client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionSnapshotVM,
+		Target:      "node1/101",
+		Params:      map[string]any{"snapname": "big", "description": strings.Repeat("x", maxSnapshotDescription+1)},
+	})
+	if err == nil || !strings.Contains(err.Error(), "params.description") {
+		t.Fatalf("expected oversized description to be rejected, got %v", err)
+	}
+}
+
+func TestExecuteReadClusterLogPassesMaxAndFiltersSeverity(t *testing.T) {
+	var gotPath, gotQuery string
+	client := newMockClient(t, "log-secret", func(r *http.Request) (*http.Response, error) {
+		gotPath = r.URL.Path
+		gotQuery = r.URL.RawQuery
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body: io.NopCloser(strings.NewReader(`{"data":[
+				{"uid":3,"time":1772366400,"node":"pve1","pri":3,"tag":"pvedaemon","user":"root@pam","msg":"VM 101 qmp command failed"},
+				{"uid":2,"time":1772366300,"node":"pve2","pri":6,"tag":"pvedaemon","user":"root@pam","msg":"starting task UPID:pve2:..."},
+				{"uid":1,"time":1772366200,"node":"pve1","pri":4,"tag":"corosync","msg":"link 0 down"}
+			]}`)),
+			Header: make(http.Header),
+		}, nil
+	})
+
+	result, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionReadClusterLog,
+		Target:      "cluster/log",
+		Params:      map[string]any{"max": "50", "severity": "warning"},
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if gotPath != "/api2/json/cluster/log" || gotQuery != "max=50" {
+		t.Fatalf("unexpected request %q ? %q", gotPath, gotQuery)
+	}
+	entries, ok := result.Data.([]ClusterLogEntry)
+	if !ok {
+		t.Fatalf("expected []ClusterLogEntry, got %T", result.Data)
+	}
+	if len(entries) != 2 || entries[0].Severity != "err" || entries[1].Severity != "warning" || entries[1].Node != "pve1" {
+		t.Fatalf("expected err and warning entries only, got %+v", entries)
+	}
+	if entries[0].Message != "VM 101 qmp command failed" || entries[0].Time != 1772366400 {
+		t.Fatalf("unexpected entry: %+v", entries[0])
+	}
+}
+
+func TestExecuteReadPoolMembersUsesPoolEndpoint(t *testing.T) {
+	var gotMethod, gotPath string
+	client := newMockClient(t, "pool-secret", func(r *http.Request) (*http.Response, error) {
+		gotMethod = r.Method
+		gotPath = r.URL.Path
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":{"members":[{"id":"qemu/100","type":"qemu","node":"pve1","vmid":100,"status":"running"}]}}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	result, err := client.Execute(ActionRequest{Environment: "home", Action: ActionReadPoolMembers, Target: "pool/web-tier"})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if gotMethod != http.MethodGet || gotPath != "/api2/json/pools/web-tier" {
+		t.Fatalf("unexpected request: %s %s", gotMethod, gotPath)
+	}
+	members, ok := result.Data.([]PoolMember)
+	if !ok || len(members) != 1 || members[0].VMID != 100 || members[0].Status != "running" {
+		t.Fatalf("unexpected members: %#v", result.Data)
+	}
+
+	if _, err := client.Execute(ActionRequest{Environment: "home", Action: ActionReadPoolMembers, Target: "pool/../nodes"}); err == nil {
+		t.Fatal("expected an invalid pool ID to be rejected")
+	}
+}
+
+func TestExecuteReadReplicationStatusReportsFailure(t *testing.T) {
+	var paths []string
+	client := newMockClient(t, "replication-secret", func(r *http.Request) (*http.Response, error) {
+		paths = append(paths, r.URL.Path)
+		body := `{"data":{"id":"100-0","guest":100,"target":"pve2","type":"local","last_sync":1760600000,"last_try":1760603600,` +
+			`"next_sync":1760604500,"duration":2.5,"fail_count":3,"error":"command 'zfs snapshot' failed: out of space"}}`
+		if strings.HasSuffix(r.URL.Path, "/log") {
+			body = `{"data":[{"n":1,"t":"start replication job"},{"n":2,"t":"end replication job with error: out of space"}]}`
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	result, err := client.Execute(ActionRequest{Environment: "home", Action: ActionReadReplicationStatus, Target: "replication/100-0", Params: map[string]any{"node": "pve1", "log": true}})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if len(paths) != 2 || paths[0] != "/api2/json/nodes/pve1/replication/100-0/status" || paths[1] != "/api2/json/nodes/pve1/replication/100-0/log" {
+		t.Fatalf("unexpected request paths: %v", paths)
+	}
+	status, ok := result.Data.(ReplicationStatus)
+	if !ok {
+		t.Fatalf("unexpected result data: %#v", result.Data)
+	}
+	if !status.Failed || status.FailCount != 3 || !strings.Contains(status.Error, "out of space") {
+		t.Fatalf("expected a failed replication, got %+v", status)
+	}
+	if status.LastSync != 1760600000 || status.Target != "pve2" || status.Guest != 100 {
+		t.Fatalf("unexpected replication fields: %+v", status)
+	}
+	if len(status.Log) != 2 || status.Log[1] != "end replication job with error: out of space" {
+		t.Fatalf("unexpected replication log: %v", status.Log)
+	}
+}
+
+func TestExecuteGuestOSInfoDecodesAgentResult(t *testing.T) {
+	var gotPath string
+	client := newMockClient(t, "osinfo-secret", func(r *http.Request) (*http.Response, error) {
+		gotPath = r.URL.Path
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body: io.NopCloser(strings.NewReader(`{"data":{"result":{"id":"ubuntu","name":"Ubuntu","pretty-name":"Ubuntu 24.04.1 LTS",` +
+				`"version":"24.04.1 LTS (Noble Numbat)","version-id":"24.04","kernel-release":"6.8.0-45-generic","machine":"x86_64"}}}`)),
+			Header: make(http.Header),
+		}, nil
+	})
+
+	result, err := client.Execute(ActionRequest{Environment: "home", Action: ActionGuestOSInfo, Target: "vm/101", Params: map[string]any{"node": "pve1"}})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if gotPath != "/api2/json/nodes/pve1/qemu/101/agent/get-osinfo" {
+		t.Fatalf("unexpected path %q", gotPath)
+	}
+	info, ok := result.Data.(GuestOSInfo)
+	if !ok || !info.AgentRunning || info.Name != "Ubuntu" || info.VersionID != "24.04" || info.KernelRelease != "6.8.0-45-generic" {
+		t.Fatalf("unexpected os info: %#v", result.Data)
+	}
+}
+
+func TestExecuteGuestOSInfoWithoutAgentIsNotAnError(t *testing.T) {
+	client := newMockClient(t, "osinfo-secret", func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusInternalServerError,
+			Status:     "500 QEMU guest agent is not running",
+			Body:       io.NopCloser(strings.NewReader(`{"data":null}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	result, err := client.Execute(ActionRequest{Environment: "home", Action: ActionGuestOSInfo, Target: "vm/101", Params: map[string]any{"node": "pve1"}})
+	if err != nil {
+		t.Fatalf("expected graceful fallback, got error: %v", err)
+	}
+	info, ok := result.Data.(GuestOSInfo)
+	if result.Status != "unavailable" || !ok || info.AgentRunning {
+		t.Fatalf("expected unavailable result without agent, got %+v", result)
+	}
+}
+
+func TestTokenIdentitySelectsAuthorizationHeader(t *testing.T) {
+	t.Setenv("PVE_TEST_SECRET", "default-secret")
+	t.Setenv("PVE_TENANT_A_SECRET", "tenant-a-secret")
+	envs := []config.Environment{{
+		Name:           "home",
+		BaseURL:        "https://proxmox.example.com",
+		TokenID:        "root@pam!agent",
+		TokenSecretEnv: "PVE_TEST_SECRET",
+		TokenIdentities: map[string]config.TokenIdentity{
+			"tenant-a": {TokenID: "tenant-a@pve!gateway", TokenSecretEnv: "PVE_TENANT_A_SECRET"},
+		},
+	}}
+	client, err := NewAPIClient(envs)
+	if err != nil {
+		t.Fatalf("NewAPIClient returned error: %v", err)
+	}
+	var auths []string
+	client.httpClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":{"version":"8.2.4","release":"8.2"}}`)),
+			Header:     make(http.Header),
+		}, nil
+	})}
+
+	if _, err := client.Execute(ActionRequest{Environment: "home", Action: ActionReadVersion, Target: "version", TokenIdentity: "tenant-a"}); err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if _, err := client.Execute(ActionRequest{Environment: "home", Action: ActionReadVersion, Target: "version"}); err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	want := []string{
+		BuildTokenAuthHeader("tenant-a@pve!gateway", "tenant-a-secret"),
+		BuildTokenAuthHeader("root@pam!agent", "default-secret"),
+	}
+	if len(auths) != 2 || auths[0] != want[0] || auths[1] != want[1] {
+		t.Fatalf("unexpected Authorization headers: %v", auths)
+	}
+
+	_, err = client.Execute(ActionRequest{Environment: "home", Action: ActionReadVersion, Target: "version", TokenIdentity: "tenant-b"})
+	if !errors.Is(err, ErrUnknownTokenIdentity) {
+		t.Fatalf("expected ErrUnknownTokenIdentity, got %v", err)
+	}
+	if len(auths) != 2 {
+		t.Fatalf("expected an unknown identity not to reach upstream, got %d calls", len(auths))
+	}
+}
+
+func TestExecuteDeleteSnapshotWaitsForTask(t *testing.T) {
+	const upid = "UPID:node1:0000A1B2:00C0FFEE:65F0A000:qmdelsnapshot:103:root@pam!agent:"
+	var polls int
+	client := newMockClient(t, "snap-secret", func(r *http.Request) (*http.Response, error) {
+		payload := `{"data":"` + upid + `"}`
+		if r.Method == http.MethodGet {
+			polls++
+			payload = `{"data":{"status":"running"}}`
+			if polls == 2 {
+				payload = `{"data":{"status":"stopped","exitstatus":"OK"}}`
+			}
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(payload)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	result, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionDeleteSnapshot,
+		Target:      "node1/103",
+		Params:      map[string]any{"snapname": "auto-1", "wait": true},
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if polls != 2 || result.Status != "ok" {
+		t.Fatalf("expected the delete to be waited for, got %d polls and %+v", polls, result)
+	}
+}
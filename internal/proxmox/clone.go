@@ -0,0 +1,153 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// A clone_vm or delete_snapshot request with params.wait polls its task at
+// taskPollInterval for at most cloneWaitTimeout. The cap stays below the server's default
+// 60s write timeout so the answer still reaches the caller; a clone that is
+// still running then is returned with its running task to poll.
+const (
+	taskPollInterval = 2 * time.Second
+	cloneWaitTimeout = 45 * time.Second
+)
+
+// errTaskStillRunning is returned by waitForTask, with the last status, when
+// the task outlasts cloneWaitTimeout.
+var errTaskStillRunning = errors.New("task still running")
+
+// CloneWaitError reports a clone whose task was started but did not finish
+// successfully while the caller waited. Clone identifies the task and the
+// new VM so the caller can follow up.
+type CloneWaitError struct {
+	Clone CloneResult
+	Err   error
+}
+
+func (e *CloneWaitError) Error() string {
+	return fmt.Sprintf("clone task %s: %v", e.Clone.UPID, e.Err)
+}
+
+func (e *CloneWaitError) Unwrap() error {
+	return e.Err
+}
+
+// CloneResult makes a clone response self-describing: the task performing
+// it, the VMID and node of the copy, and, when the caller waited, how the
+// task ended.
+type CloneResult struct {
+	UPID       string      `json:"upid"`
+	NewID      int64       `json:"newid,omitempty"`
+	Node       string      `json:"node,omitempty"`
+	SourceNode string      `json:"source_node,omitempty"`
+	Task       *TaskStatus `json:"task,omitempty"`
+}
+
+func newCloneResult(req ActionRequest, upid string) CloneResult {
+	sourceNode, _, _ := parseVMTarget(req.Target, req.Params)
+	targetNode, _ := optionalStringParam(req.Params, "target")
+	if targetNode == "" {
+		targetNode = sourceNode
+	}
+	newID, _, _ := optionalIntParam(req.Params, "newid")
+	return CloneResult{UPID: upid, NewID: newID, Node: targetNode, SourceNode: sourceNode}
+}
+
+// waitForTask polls a task's status until it stops running, ctx ends, or
+// cloneWaitTimeout passes. A task that ends without exit status OK is an
+// error.
+func (c *APIClient) waitForTask(ctx context.Context, env apiEnvironment, upid string, capture *Capture) (TaskStatus, error) {
+	node, _, _, err := ParseUPID(upid)
+	if err != nil {
+		return TaskStatus{}, err
+	}
+	endpoint := fmt.Sprintf("%s/nodes/%s/tasks/%s/status", env.apiBasePath(), node, url.PathEscape(upid))
+	var status TaskStatus
+	for polls := int(cloneWaitTimeout / taskPollInterval); polls > 0; polls-- {
+		body, err := c.performRequest(ctx, env, http.MethodGet, endpoint, nil, c.responseLimit(ActionReadTaskStatus), capture)
+		if err != nil {
+			return TaskStatus{}, err
+		}
+		var envelope struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			return TaskStatus{}, fmt.Errorf("decode proxmox response: %w", err)
+		}
+		status, err = decodeTaskStatus(envelope.Data)
+		if err != nil {
+			return TaskStatus{}, err
+		}
+		if !status.Running {
+			if !status.Succeeded {
+				return status, fmt.Errorf("task %s failed: %s", upid, status.ExitStatus)
+			}
+			return status, nil
+		}
+		if err := c.wait(ctx, taskPollInterval); err != nil {
+			return TaskStatus{}, err
+		}
+	}
+	return status, errTaskStillRunning
+}
+
+// cloneResult replaces the bare UPID in a clone_vm result with a
+// CloneResult and, for params.wait, blocks until the clone completes. A
+// failed wait returns a *CloneWaitError alongside the result.
+func (c *APIClient) cloneResult(env apiEnvironment, req ActionRequest, result ActionResult) (ActionResult, error) {
+	upid, _ := result.Data.(string)
+	clone := newCloneResult(req, upid)
+	result.Data = clone
+	if wait, _, _ := optionalBoolParam(req.Params, "wait"); !wait || upid == "" {
+		return result, nil
+	}
+	task, err := c.waitForTask(requestContext(req), env, upid, req.Capture)
+	if errors.Is(err, errTaskStillRunning) {
+		clone.Task = &task
+		result.Message = fmt.Sprintf("clone still running after %s; poll task %s", cloneWaitTimeout, upid)
+		result.Data = clone
+		return result, nil
+	}
+	if err != nil {
+		if task.Status != "" {
+			clone.Task = &task
+		}
+		result.Data = clone
+		return result, &CloneWaitError{Clone: clone, Err: err}
+	}
+	clone.Task = &task
+	result.Status = "ok"
+	result.Message = "clone completed"
+	result.Data = clone
+	return result, nil
+}
+
+// deleteSnapshotResult blocks on a delete_snapshot task for params.wait, so
+// the caller's next change to the VM does not run into its snapshot lock.
+// A delete still running at the cap is returned as submitted, with the task
+// to poll in the message.
+func (c *APIClient) deleteSnapshotResult(env apiEnvironment, req ActionRequest, result ActionResult) (ActionResult, error) {
+	upid, _ := result.Data.(string)
+	if wait, _, _ := optionalBoolParam(req.Params, "wait"); !wait || upid == "" {
+		return result, nil
+	}
+	task, err := c.waitForTask(requestContext(req), env, upid, req.Capture)
+	if errors.Is(err, errTaskStillRunning) {
+		result.Message = fmt.Sprintf("snapshot delete still running after %s; poll task %s", cloneWaitTimeout, upid)
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	result.Status = "ok"
+	result.Message = "snapshot deleted"
+	result.Data = task
+	return result, nil
+}
@@ -0,0 +1,533 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var netKeyPattern = regexp.MustCompile(`^net[0-9]+$`)
+
+// HAStatusEntry is one row of /cluster/ha/status/current. Quorum, master and
+// lrm rows only populate a subset of the fields; service rows carry the sid
+// and CRM/LRM view of the managed resource.
+type HAStatusEntry struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	SID          string `json:"sid,omitempty"`
+	Node         string `json:"node,omitempty"`
+	State        string `json:"state,omitempty"`
+	Status       string `json:"status,omitempty"`
+	CRMState     string `json:"crm_state,omitempty"`
+	RequestState string `json:"request_state,omitempty"`
+}
+
+func decodeHAStatus(data json.RawMessage) ([]HAStatusEntry, error) {
+	var entries []HAStatusEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("decode HA status: %w", err)
+	}
+	return entries, nil
+}
+
+// VersionInfo is the decoded form of /version.
+type VersionInfo struct {
+	Version string `json:"version"`
+	Release string `json:"release,omitempty"`
+	RepoID  string `json:"repoid,omitempty"`
+}
+
+func decodeVersion(data json.RawMessage) (VersionInfo, error) {
+	var info VersionInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return VersionInfo{}, fmt.Errorf("decode version: %w", err)
+	}
+	return info, nil
+}
+
+// NodeSubscription is the decoded form of /nodes/{node}/subscription. Nodes
+// without a key report status "notfound" rather than an error. The key itself
+// is deliberately not decoded.
+type NodeSubscription struct {
+	Status      string `json:"status"`
+	Active      bool   `json:"active"`
+	Level       string `json:"level,omitempty"`
+	ProductName string `json:"productname,omitempty"`
+	NextDueDate string `json:"nextduedate,omitempty"`
+	RegDate     string `json:"regdate,omitempty"`
+	Message     string `json:"message,omitempty"`
+}
+
+func decodeSubscription(data json.RawMessage) (NodeSubscription, error) {
+	var sub NodeSubscription
+	if err := json.Unmarshal(data, &sub); err != nil {
+		return NodeSubscription{}, fmt.Errorf("decode subscription: %w", err)
+	}
+	sub.Active = strings.EqualFold(sub.Status, "active")
+	return sub, nil
+}
+
+// Snapshot is one entry of /nodes/{node}/qemu/{vmid}/snapshot.
+type Snapshot struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parent      string `json:"parent,omitempty"`
+	SnapTime    int64  `json:"snaptime,omitempty"`
+	VMState     bool   `json:"vmstate"`
+}
+
+// decodeSnapshots drops the "current" pseudo-snapshot Proxmox appends to mark
+// the running state.
+func decodeSnapshots(data json.RawMessage) ([]Snapshot, error) {
+	var entries []struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Parent      string `json:"parent"`
+		SnapTime    int64  `json:"snaptime"`
+		VMState     int    `json:"vmstate"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("decode snapshots: %w", err)
+	}
+	out := make([]Snapshot, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Name == "current" {
+			continue
+		}
+		out = append(out, Snapshot{
+			Name:        entry.Name,
+			Description: strings.TrimRight(entry.Description, "\n"),
+			Parent:      entry.Parent,
+			SnapTime:    entry.SnapTime,
+			VMState:     entry.VMState != 0,
+		})
+	}
+	return out, nil
+}
+
+// PendingConfig splits /nodes/{node}/qemu/{vmid}/pending into the running
+// config and the staged changes that apply on next boot. Deleted lists keys
+// that are pending removal.
+type PendingConfig struct {
+	Current    map[string]any `json:"current"`
+	Pending    map[string]any `json:"pending"`
+	Deleted    []string       `json:"deleted,omitempty"`
+	HasPending bool           `json:"has_pending"`
+}
+
+func decodePendingConfig(data json.RawMessage) (PendingConfig, error) {
+	var entries []struct {
+		Key     string `json:"key"`
+		Value   any    `json:"value"`
+		Pending any    `json:"pending"`
+		Delete  int    `json:"delete"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return PendingConfig{}, fmt.Errorf("decode pending config: %w", err)
+	}
+	out := PendingConfig{Current: map[string]any{}, Pending: map[string]any{}}
+	for _, entry := range entries {
+		if entry.Value != nil {
+			out.Current[entry.Key] = entry.Value
+		}
+		if entry.Pending != nil {
+			out.Pending[entry.Key] = entry.Pending
+		}
+		if entry.Delete != 0 {
+			out.Deleted = append(out.Deleted, entry.Key)
+		}
+	}
+	out.HasPending = len(out.Pending) > 0 || len(out.Deleted) > 0
+	return out, nil
+}
+
+// TaskStatus is the decoded form of /nodes/{node}/tasks/{upid}/status with
+// the completion state derived so callers need not interpret exitstatus.
+type TaskStatus struct {
+	UPID       string `json:"upid,omitempty"`
+	Node       string `json:"node,omitempty"`
+	Type       string `json:"type,omitempty"`
+	ID         string `json:"id,omitempty"`
+	User       string `json:"user,omitempty"`
+	Status     string `json:"status"`
+	ExitStatus string `json:"exitstatus,omitempty"`
+	StartTime  int64  `json:"starttime,omitempty"`
+	Running    bool   `json:"running"`
+	Succeeded  bool   `json:"succeeded"`
+}
+
+func decodeTaskStatus(data json.RawMessage) (TaskStatus, error) {
+	var status TaskStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return TaskStatus{}, fmt.Errorf("decode task status: %w", err)
+	}
+	status.Running = status.Status == "running"
+	status.Succeeded = !status.Running && status.ExitStatus == "OK"
+	return status, nil
+}
+
+// NetworkInterface is one parsed netN entry, e.g.
+// "virtio=BC:24:11:00:00:01,bridge=vmbr0,firewall=1,tag=10". Options keeps
+// the remaining keys (rate, mtu, queues, ...) as Proxmox wrote them.
+type NetworkInterface struct {
+	Model      string            `json:"model"`
+	MACAddress string            `json:"macaddr,omitempty"`
+	Bridge     string            `json:"bridge,omitempty"`
+	Tag        int               `json:"tag,omitempty"`
+	Firewall   bool              `json:"firewall"`
+	LinkDown   bool              `json:"link_down,omitempty"`
+	Options    map[string]string `json:"options,omitempty"`
+	Raw        string            `json:"raw"`
+}
+
+// VMNetworkConfig holds the network interfaces of a VM as running and the
+// changes waiting for its next boot. Pending holds only the interfaces with
+// a pending change, not the full post-boot set; Deleted names interfaces
+// pending removal. Drift lists interfaces whose pending definition differs
+// from the current one, including additions and removals.
+type VMNetworkConfig struct {
+	Current map[string]NetworkInterface `json:"current"`
+	// Pending maps each changed interface to its pending definition.
+	Pending map[string]NetworkInterface `json:"pending"`
+	Deleted []string                    `json:"deleted,omitempty"`
+	Drift   []string                    `json:"drift,omitempty"`
+}
+
+func networkConfig(config PendingConfig) VMNetworkConfig {
+	out := VMNetworkConfig{Current: map[string]NetworkInterface{}, Pending: map[string]NetworkInterface{}}
+	for key, value := range config.Current {
+		if raw, ok := value.(string); ok && netKeyPattern.MatchString(key) {
+			out.Current[key] = parseNetworkInterface(raw)
+		}
+	}
+	for key, value := range config.Pending {
+		if raw, ok := value.(string); ok && netKeyPattern.MatchString(key) {
+			out.Pending[key] = parseNetworkInterface(raw)
+		}
+	}
+	for _, key := range config.Deleted {
+		if netKeyPattern.MatchString(key) {
+			out.Deleted = append(out.Deleted, key)
+		}
+	}
+	drift := map[string]bool{}
+	for key, pending := range out.Pending {
+		if current, ok := out.Current[key]; !ok || current.Raw != pending.Raw {
+			drift[key] = true
+		}
+	}
+	for _, key := range out.Deleted {
+		drift[key] = true
+	}
+	for key := range drift {
+		out.Drift = append(out.Drift, key)
+	}
+	sort.Strings(out.Drift)
+	return out
+}
+
+// parseNetworkInterface parses a netN value. The model comes first, either
+// bare or carrying the MAC address as its value.
+func parseNetworkInterface(raw string) NetworkInterface {
+	iface := NetworkInterface{Raw: raw}
+	for i, part := range strings.Split(raw, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if i == 0 && key != "model" {
+			iface.Model = key
+			iface.MACAddress = value
+			continue
+		}
+		switch key {
+		case "model":
+			iface.Model = value
+		case "macaddr":
+			iface.MACAddress = value
+		case "bridge":
+			iface.Bridge = value
+		case "tag":
+			iface.Tag, _ = strconv.Atoi(value)
+		case "firewall":
+			iface.Firewall = value == "1"
+		case "link_down":
+			iface.LinkDown = value == "1"
+		default:
+			if key == "" {
+				continue
+			}
+			if iface.Options == nil {
+				iface.Options = map[string]string{}
+			}
+			iface.Options[key] = value
+		}
+	}
+	return iface
+}
+
+// ConfigChange is one key that differs between two VM configs. From is
+// absent for added keys and To for removed ones.
+type ConfigChange struct {
+	Key    string `json:"key"`
+	Change string `json:"change"`
+	From   any    `json:"from,omitempty"`
+	To     any    `json:"to,omitempty"`
+}
+
+// snapshotMetaKeys describe the snapshot itself rather than the VM, so they
+// differ between any two snapshots and are left out of a diff.
+var snapshotMetaKeys = map[string]bool{
+	"digest":    true,
+	"parent":    true,
+	"snaptime":  true,
+	"snapstate": true,
+	"vmstate":   true,
+}
+
+// DiffConfigs compares two VM configs key by key, ordered by key.
+func DiffConfigs(from, to map[string]any) []ConfigChange {
+	keys := map[string]bool{}
+	for key := range from {
+		keys[key] = true
+	}
+	for key := range to {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		if !snapshotMetaKeys[key] {
+			sorted = append(sorted, key)
+		}
+	}
+	sort.Strings(sorted)
+	changes := []ConfigChange{}
+	for _, key := range sorted {
+		before, inFrom := from[key]
+		after, inTo := to[key]
+		switch {
+		case !inFrom:
+			changes = append(changes, ConfigChange{Key: key, Change: "added", To: after})
+		case !inTo:
+			changes = append(changes, ConfigChange{Key: key, Change: "removed", From: before})
+		case fmt.Sprint(before) != fmt.Sprint(after):
+			changes = append(changes, ConfigChange{Key: key, Change: "changed", From: before, To: after})
+		}
+	}
+	return changes
+}
+
+// syslogSeverities are the syslog severity names indexed by priority.
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// severityRank returns the syslog priority for a severity name; lower is
+// more severe.
+func severityRank(name string) (int, bool) {
+	for rank, severity := range syslogSeverities {
+		if strings.EqualFold(name, severity) {
+			return rank, true
+		}
+	}
+	return 0, false
+}
+
+// ClusterLogEntry is one row of /cluster/log.
+type ClusterLogEntry struct {
+	Time     int64  `json:"time"`
+	Node     string `json:"node"`
+	Severity string `json:"severity"`
+	Priority int    `json:"priority"`
+	Tag      string `json:"tag,omitempty"`
+	User     string `json:"user,omitempty"`
+	Message  string `json:"message"`
+}
+
+// decodeClusterLog decodes the cluster log, keeping only entries at least as
+// severe as minSeverity when it is set.
+func decodeClusterLog(data json.RawMessage, minSeverity string) ([]ClusterLogEntry, error) {
+	var rows []struct {
+		Time int64  `json:"time"`
+		Node string `json:"node"`
+		Pri  int    `json:"pri"`
+		Tag  string `json:"tag"`
+		User string `json:"user"`
+		Msg  string `json:"msg"`
+	}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("decode cluster log: %w", err)
+	}
+	limit, filtered := severityRank(minSeverity)
+	entries := make([]ClusterLogEntry, 0, len(rows))
+	for _, row := range rows {
+		if filtered && row.Pri > limit {
+			continue
+		}
+		severity := ""
+		if row.Pri >= 0 && row.Pri < len(syslogSeverities) {
+			severity = syslogSeverities[row.Pri]
+		}
+		entries = append(entries, ClusterLogEntry{
+			Time:     row.Time,
+			Node:     row.Node,
+			Severity: severity,
+			Priority: row.Pri,
+			Tag:      row.Tag,
+			User:     row.User,
+			Message:  row.Msg,
+		})
+	}
+	return entries, nil
+}
+
+// RRDPoint is one sample of a VM's rrddata. Fields are nil where the
+// sample has a gap, e.g. while the VM was stopped.
+type RRDPoint struct {
+	Time   int64    `json:"time"`
+	CPU    *float64 `json:"cpu,omitempty"`
+	MaxCPU *float64 `json:"maxcpu,omitempty"`
+	Mem    *float64 `json:"mem,omitempty"`
+	MaxMem *float64 `json:"maxmem,omitempty"`
+}
+
+func decodeRRDData(data json.RawMessage) ([]RRDPoint, error) {
+	var points []RRDPoint
+	if err := json.Unmarshal(data, &points); err != nil {
+		return nil, fmt.Errorf("decode rrddata: %w", err)
+	}
+	return points, nil
+}
+
+// RRDSummary aggregates rrddata over its window. CPU is the fraction of the
+// VM's allotted cores in use; memory is in bytes. MemMaxPercent relates the
+// peak to the largest maxmem seen.
+type RRDSummary struct {
+	Samples       int     `json:"samples"`
+	From          int64   `json:"from,omitempty"`
+	To            int64   `json:"to,omitempty"`
+	CPUAvg        float64 `json:"cpu_avg"`
+	CPUMax        float64 `json:"cpu_max"`
+	MemAvg        float64 `json:"mem_avg"`
+	MemMax        float64 `json:"mem_max"`
+	MemMaxPercent float64 `json:"mem_max_percent,omitempty"`
+}
+
+// SummarizeRRD averages and peaks CPU and memory across points, skipping
+// gaps. Samples counts the points that carried a CPU value.
+func SummarizeRRD(points []RRDPoint) RRDSummary {
+	var summary RRDSummary
+	var cpuTotal, memTotal, maxMem float64
+	memSamples := 0
+	for _, point := range points {
+		if summary.From == 0 || point.Time < summary.From {
+			summary.From = point.Time
+		}
+		if point.Time > summary.To {
+			summary.To = point.Time
+		}
+		if point.CPU != nil {
+			summary.Samples++
+			cpuTotal += *point.CPU
+			summary.CPUMax = max(summary.CPUMax, *point.CPU)
+		}
+		if point.Mem != nil {
+			memSamples++
+			memTotal += *point.Mem
+			summary.MemMax = max(summary.MemMax, *point.Mem)
+		}
+		if point.MaxMem != nil {
+			maxMem = max(maxMem, *point.MaxMem)
+		}
+	}
+	if summary.Samples > 0 {
+		summary.CPUAvg = cpuTotal / float64(summary.Samples)
+	}
+	if memSamples > 0 {
+		summary.MemAvg = memTotal / float64(memSamples)
+	}
+	if maxMem > 0 {
+		summary.MemMaxPercent = summary.MemMax / maxMem * 100
+	}
+	return summary
+}
+
+// GuestOSInfo is the guest agent's get-osinfo result. AgentRunning is false,
+// and the rest empty, when the VM has no running agent to ask.
+type GuestOSInfo struct {
+	AgentRunning  bool   `json:"agent_running"`
+	ID            string `json:"id,omitempty"`
+	Name          string `json:"name,omitempty"`
+	PrettyName    string `json:"pretty_name,omitempty"`
+	Version       string `json:"version,omitempty"`
+	VersionID     string `json:"version_id,omitempty"`
+	KernelRelease string `json:"kernel_release,omitempty"`
+	KernelVersion string `json:"kernel_version,omitempty"`
+	Machine       string `json:"machine,omitempty"`
+}
+
+func decodeGuestOSInfo(data json.RawMessage) (GuestOSInfo, error) {
+	var wrapper struct {
+		Result struct {
+			ID            string `json:"id"`
+			Name          string `json:"name"`
+			PrettyName    string `json:"pretty-name"`
+			Version       string `json:"version"`
+			VersionID     string `json:"version-id"`
+			KernelRelease string `json:"kernel-release"`
+			KernelVersion string `json:"kernel-version"`
+			Machine       string `json:"machine"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return GuestOSInfo{}, fmt.Errorf("decode guest os info: %w", err)
+	}
+	result := wrapper.Result
+	return GuestOSInfo{
+		AgentRunning:  true,
+		ID:            result.ID,
+		Name:          result.Name,
+		PrettyName:    result.PrettyName,
+		Version:       result.Version,
+		VersionID:     result.VersionID,
+		KernelRelease: result.KernelRelease,
+		KernelVersion: result.KernelVersion,
+		Machine:       result.Machine,
+	}, nil
+}
+
+// isGuestAgentUnavailable recognizes Proxmox's answer for a VM whose guest
+// agent is disabled, not installed, or not responding.
+func isGuestAgentUnavailable(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		return false
+	}
+	msg := strings.ToLower(apiErr.Message)
+	return strings.Contains(msg, "guest agent is not running") || strings.Contains(msg, "no qemu guest agent configured")
+}
+
+// PoolMember is one guest or storage in a resource pool, with its current
+// status as /pools/{poolid} reports it.
+type PoolMember struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Node    string `json:"node,omitempty"`
+	VMID    int    `json:"vmid,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Storage string `json:"storage,omitempty"`
+}
+
+func decodePoolMembers(data json.RawMessage) ([]PoolMember, error) {
+	var pool struct {
+		Members []PoolMember `json:"members"`
+	}
+	if err := json.Unmarshal(data, &pool); err != nil {
+		return nil, fmt.Errorf("decode pool members: %w", err)
+	}
+	if pool.Members == nil {
+		pool.Members = []PoolMember{}
+	}
+	return pool.Members, nil
+}
@@ -0,0 +1,115 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecodeHAStatus(t *testing.T) {
+	payload := json.RawMessage(`[
+		{"id":"quorum","type":"quorum","node":"pve1","status":"OK","quorate":1},
+		{"id":"lrm:pve1","type":"lrm","node":"pve1","status":"pve1 (active, Mon Feb 16 12:00:00 2026)","state":"active"},
+		{"id":"service:vm:100","type":"service","sid":"vm:100","node":"pve1","state":"started","crm_state":"started","request_state":"started"}
+	]`)
+
+	entries, err := decodeHAStatus(payload)
+	if err != nil {
+		t.Fatalf("decodeHAStatus returned error: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	svc := entries[2]
+	if svc.SID != "vm:100" || svc.Node != "pve1" || svc.State != "started" || svc.CRMState != "started" {
+		t.Fatalf("unexpected service entry: %+v", svc)
+	}
+	if entries[1].Type != "lrm" || entries[1].State != "active" {
+		t.Fatalf("unexpected lrm entry: %+v", entries[1])
+	}
+}
+
+func TestDecodeVersion(t *testing.T) {
+	info, err := decodeVersion(json.RawMessage(`{"version":"8.2.4","release":"8.2","repoid":"faa83925c9641325"}`))
+	if err != nil {
+		t.Fatalf("decodeVersion returned error: %v", err)
+	}
+	if info.Version != "8.2.4" || info.Release != "8.2" || info.RepoID != "faa83925c9641325" {
+		t.Fatalf("unexpected version info: %+v", info)
+	}
+}
+
+func TestDecodePoolMembers(t *testing.T) {
+	members, err := decodePoolMembers(json.RawMessage(`{"comment":"web tier","members":[
+		{"id":"qemu/100","type":"qemu","node":"pve1","vmid":100,"name":"web1","status":"running","maxmem":4294967296},
+		{"id":"lxc/200","type":"lxc","node":"pve2","vmid":200,"name":"cache","status":"stopped"},
+		{"id":"storage/pve1/local-zfs","type":"storage","node":"pve1","storage":"local-zfs","status":"available"}
+	]}`))
+	if err != nil {
+		t.Fatalf("decodePoolMembers returned error: %v", err)
+	}
+	if len(members) != 3 {
+		t.Fatalf("expected 3 members, got %d", len(members))
+	}
+	if m := members[0]; m.ID != "qemu/100" || m.VMID != 100 || m.Name != "web1" || m.Status != "running" || m.Node != "pve1" {
+		t.Fatalf("unexpected qemu member: %+v", m)
+	}
+	if m := members[1]; m.Type != "lxc" || m.Status != "stopped" {
+		t.Fatalf("unexpected lxc member: %+v", m)
+	}
+	if m := members[2]; m.Storage != "local-zfs" || m.VMID != 0 {
+		t.Fatalf("unexpected storage member: %+v", m)
+	}
+
+	empty, err := decodePoolMembers(json.RawMessage(`{"members":[]}`))
+	if err != nil || empty == nil || len(empty) != 0 {
+		t.Fatalf("expected an empty, non-nil member list, got %v, %v", empty, err)
+	}
+}
+
+func TestDecodeReplicationStatusHealthy(t *testing.T) {
+	status, err := decodeReplicationStatus(json.RawMessage(`{"id":"100-0","guest":100,"target":"pve2","last_sync":1760600000,"fail_count":0}`))
+	if err != nil {
+		t.Fatalf("decodeReplicationStatus returned error: %v", err)
+	}
+	if status.Failed || status.Error != "" || status.LastSync != 1760600000 {
+		t.Fatalf("expected a healthy replication, got %+v", status)
+	}
+}
+
+func TestParseNetworkInterface(t *testing.T) {
+	iface := parseNetworkInterface("virtio,bridge=vmbr0,tag=10")
+	if iface.Model != "virtio" || iface.Bridge != "vmbr0" || iface.Tag != 10 || iface.Firewall || iface.MACAddress != "" {
+		t.Fatalf("unexpected interface: %+v", iface)
+	}
+
+	iface = parseNetworkInterface("virtio=BC:24:11:2A:3B:4C,bridge=vmbr1,firewall=1,mtu=9000")
+	if iface.Model != "virtio" || iface.MACAddress != "BC:24:11:2A:3B:4C" || iface.Bridge != "vmbr1" || !iface.Firewall {
+		t.Fatalf("unexpected interface: %+v", iface)
+	}
+	if iface.Options["mtu"] != "9000" {
+		t.Fatalf("expected mtu option, got %v", iface.Options)
+	}
+}
+
+func TestNetworkConfigReportsDrift(t *testing.T) {
+	pending, err := decodePendingConfig(json.RawMessage(`[
+		{"key":"net0","value":"virtio=BC:24:11:2A:3B:4C,bridge=vmbr0,tag=10","pending":"virtio=BC:24:11:2A:3B:4C,bridge=vmbr0,tag=20"},
+		{"key":"net1","value":"e1000,bridge=vmbr1"},
+		{"key":"net2","value":"virtio,bridge=vmbr2","delete":1},
+		{"key":"memory","value":2048,"pending":4096}
+	]`))
+	if err != nil {
+		t.Fatalf("decodePendingConfig returned error: %v", err)
+	}
+	network := networkConfig(pending)
+	if len(network.Current) != 3 || len(network.Pending) != 1 {
+		t.Fatalf("expected only netN keys, got %+v", network)
+	}
+	if network.Pending["net0"].Tag != 20 || network.Current["net0"].Tag != 10 {
+		t.Fatalf("unexpected net0: %+v / %+v", network.Current["net0"], network.Pending["net0"])
+	}
+	if strings.Join(network.Drift, ",") != "net0,net2" {
+		t.Fatalf("unexpected drift: %v", network.Drift)
+	}
+}
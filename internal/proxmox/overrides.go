@@ -0,0 +1,82 @@
+package proxmox
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseEndpointOverrides checks an environment's endpoint_overrides table
+// against the known actions. Placeholder syntax is validated by config.Load;
+// here a template may only use {vmid} for actions that address a VM.
+func parseEndpointOverrides(envName string, raw map[string]string) (map[ActionType]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	out := make(map[ActionType]string, len(raw))
+	for name, tmpl := range raw {
+		action := ActionType(name)
+		if !knownAction(action) {
+			return nil, fmt.Errorf("environment %q: endpoint_overrides names unknown action %q", envName, name)
+		}
+		if usesCustomEndpoint(action) {
+			return nil, fmt.Errorf("environment %q: %q takes its endpoint from params and cannot be overridden", envName, name)
+		}
+		if strings.Contains(tmpl, "{vmid}") && !IsVMAction(action) {
+			return nil, fmt.Errorf("environment %q: endpoint override for %q uses {vmid} but the action has no VM target", envName, name)
+		}
+		out[action] = tmpl
+	}
+	return out, nil
+}
+
+// spec is requestSpec with the environment's base path and endpoint
+// overrides applied. An override replaces the whole path, query included.
+func (e apiEnvironment) spec(req ActionRequest) (method string, endpoint string, params map[string]any, err error) {
+	method, endpoint, params, err = requestSpec(req, e.apiBasePath())
+	if err != nil {
+		return "", "", nil, err
+	}
+	tmpl, ok := e.endpointOverrides[req.Action]
+	if !ok {
+		return method, endpoint, params, nil
+	}
+	endpoint, err = expandEndpoint(tmpl, req)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return method, e.apiBasePath() + endpoint, params, nil
+}
+
+func expandEndpoint(tmpl string, req ActionRequest) (string, error) {
+	var node, vmid string
+	switch {
+	case IsVMAction(req.Action):
+		var err error
+		node, vmid, err = parseVMTarget(req.Target, req.Params)
+		if err != nil {
+			return "", err
+		}
+	case strings.HasPrefix(strings.TrimSpace(req.Target), "node/"):
+		var err error
+		node, err = parseNodeTarget(req.Target)
+		if err != nil {
+			return "", err
+		}
+	default:
+		node, _ = optionalStringParam(req.Params, "node")
+	}
+	if strings.Contains(tmpl, "{node}") && node == "" {
+		return "", fmt.Errorf("endpoint override for %q needs a node; set params.node", req.Action)
+	}
+	return strings.NewReplacer("{node}", node, "{vmid}", vmid).Replace(tmpl), nil
+}
+
+// IsKnownAction reports whether name is an action this client implements.
+func IsKnownAction(name string) bool {
+	return knownAction(ActionType(name))
+}
+
+func knownAction(action ActionType) bool {
+	_, ok := actionTable[action]
+	return ok
+}
@@ -0,0 +1,73 @@
+package proxmox
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+)
+
+func TestEndpointOverrideReplacesStartPath(t *testing.T) {
+	var gotPath string
+	client := newMockClient(t, "override-secret", func(r *http.Request) (*http.Response, error) {
+		gotPath = r.URL.Path
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":"UPID:node1:0001"}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+	overrides, err := parseEndpointOverrides("home", map[string]string{
+		"start_vm": "/custom/{node}/vm/{vmid}/power-on",
+	})
+	if err != nil {
+		t.Fatalf("parseEndpointOverrides returned error: %v", err)
+	}
+	env := client.envs["home"]
+	env.endpointOverrides = overrides
+	client.envs["home"] = env
+
+	req := ActionRequest{Environment: "home", Action: ActionStartVM, Target: "node1/101"}
+	if _, err := client.Execute(req); err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if gotPath != "/api2/json/custom/node1/vm/101/power-on" {
+		t.Fatalf("expected overridden path, got %q", gotPath)
+	}
+	if _, endpoint, _ := client.ResolveEndpoint(req); endpoint != gotPath {
+		t.Fatalf("expected ResolveEndpoint to report the override, got %q", endpoint)
+	}
+
+	// Actions without an override keep the built-in path.
+	if _, err := client.Execute(ActionRequest{Environment: "home", Action: ActionStopVM, Target: "node1/101"}); err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if gotPath != "/api2/json/nodes/node1/qemu/101/status/stop" {
+		t.Fatalf("expected default stop path, got %q", gotPath)
+	}
+}
+
+func TestNewAPIClientRejectsInvalidEndpointOverrides(t *testing.T) {
+	t.Setenv("PVE_TEST_SECRET", "override-secret")
+	tests := map[string]map[string]string{
+		"unknown action":  {"reboot_vm": "/nodes/{node}/qemu/{vmid}/status/reboot"},
+		"vmid without vm": {"read_ha_status": "/cluster/ha/{vmid}"},
+		"params endpoint": {"storage_edit": "/storage/local"},
+	}
+	for name, overrides := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewAPIClient([]config.Environment{{
+				Name:              "home",
+				BaseURL:           "https://pve.example.com",
+				TokenID:           "root@pam!agent",
+				TokenSecretEnv:    "PVE_TEST_SECRET",
+				EndpointOverrides: overrides,
+			}})
+			if err == nil {
+				t.Fatal("expected NewAPIClient to reject the override")
+			}
+		})
+	}
+}
@@ -0,0 +1,517 @@
+package proxmox
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// taskTypePattern matches Proxmox task types such as "vzdump" or "qmstart".
+var taskTypePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{0,31}$`)
+
+// rrdTimeframes are the windows Proxmox keeps RRD data for.
+var rrdTimeframes = map[string]bool{"hour": true, "day": true, "week": true, "month": true, "year": true}
+
+// maxClusterLogEntries bounds params.max for read_cluster_log.
+const maxClusterLogEntries = 5000
+
+// poolIDPattern matches Proxmox resource pool IDs.
+var poolIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// snapnamePattern matches Proxmox snapshot names.
+var snapnamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{1,39}$`)
+
+// taskStatusFilters are the values Proxmox accepts in a tasks statusfilter;
+// "errors" is accepted as an alias for "error".
+var taskStatusFilters = map[string]string{
+	"ok":      "ok",
+	"error":   "error",
+	"errors":  "error",
+	"warning": "warning",
+	"unknown": "unknown",
+}
+
+// maxSnapshotDescription keeps snapshot notes to a readable size; Proxmox
+// stores them in the VM config file.
+const maxSnapshotDescription = 1024
+
+// vmConfigKeys are the VM config options set_vm_config may change.
+var vmConfigKeys = map[string]bool{
+	"protection": true,
+	"onboot":     true,
+	"startup":    true,
+}
+
+// digestPattern matches the SHA-1 or SHA-256 hex digests Proxmox returns
+// with config reads.
+var digestPattern = regexp.MustCompile(`^[0-9a-fA-F]{40}([0-9a-fA-F]{24})?$`)
+
+// intParams are the params Proxmox (or the agent) expects as integers. JSON
+// decodes every number as float64, so CoerceParams turns integral ones into
+// int64 and ValidateActionParams rejects any that are not integers.
+var intParams = map[string]bool{
+	"newid":              true,
+	"vmid":               true,
+	"cores":              true,
+	"sockets":            true,
+	"memory":             true,
+	"limit":              true,
+	"max":                true,
+	"timeout":            true,
+	"min_uptime_seconds": true,
+}
+
+// CoerceParams returns a copy of params, as decoded from JSON, in which
+// integral float64 values of integer params are int64. Other values,
+// including fractional ones, are copied unchanged for validation to judge.
+// The server calls it once when it decodes a request.
+func CoerceParams(params map[string]any) map[string]any {
+	if params == nil {
+		return nil
+	}
+	out := make(map[string]any, len(params))
+	for key, raw := range params {
+		if value, ok := raw.(float64); ok && intParams[key] && value == math.Trunc(value) && !math.IsInf(value, 0) {
+			raw = int64(value)
+		}
+		out[key] = raw
+	}
+	return out
+}
+
+// ValidateActionParams checks action-specific params before a request is
+// planned or dispatched. It is shared by the HTTP validator and requestSpec so
+// both layers reject the same inputs. It does not modify req.
+func ValidateActionParams(req ActionRequest) error {
+	for key := range req.Params {
+		if !intParams[key] {
+			continue
+		}
+		if _, _, err := optionalIntParam(req.Params, key); err != nil {
+			return err
+		}
+	}
+	if !usesCustomEndpoint(req.Action) {
+		for _, key := range []string{"endpoint", "method"} {
+			if _, ok := req.Params[key]; ok {
+				return fmt.Errorf("params.%s is only supported for %q and %q actions", key, ActionStorageEdit, ActionFirewallEdit)
+			}
+		}
+	}
+	if _, ok := req.Params["digest"]; ok {
+		if !usesCustomEndpoint(req.Action) && req.Action != ActionSetVMConfig {
+			return fmt.Errorf("params.digest is only supported for %q, %q and %q actions", ActionStorageEdit, ActionFirewallEdit, ActionSetVMConfig)
+		}
+		digest, err := optionalStringParam(req.Params, "digest")
+		if err != nil {
+			return err
+		}
+		if !digestPattern.MatchString(digest) {
+			return fmt.Errorf("params.digest must be a hex config digest")
+		}
+	}
+	if IsVMAction(req.Action) {
+		if err := checkTargetNode(req); err != nil {
+			return err
+		}
+	}
+	switch req.Action {
+	case ActionReadInventory:
+		minUptime, _, err := optionalIntParam(req.Params, "min_uptime_seconds")
+		if err != nil {
+			return err
+		}
+		if minUptime < 0 {
+			return fmt.Errorf("params.min_uptime_seconds must not be negative")
+		}
+		if _, _, err := optionalBoolParam(req.Params, "pending_delete"); err != nil {
+			return err
+		}
+	case ActionDeleteVM:
+		if _, _, err := optionalBoolParam(req.Params, "soft"); err != nil {
+			return err
+		}
+	case ActionReadReplicationStatus:
+		if _, err := requiredStringParam(req.Params, "node"); err != nil {
+			return err
+		}
+		if _, _, err := optionalBoolParam(req.Params, "log"); err != nil {
+			return err
+		}
+	case ActionStopVM:
+		timeout, _, err := optionalIntParam(req.Params, "timeout")
+		if err != nil {
+			return err
+		}
+		if timeout < 0 {
+			return fmt.Errorf("params.timeout must not be negative")
+		}
+		if _, _, err := optionalBoolParam(req.Params, "keepActive"); err != nil {
+			return err
+		}
+	case ActionSetVMConfig:
+		changes := 0
+		for key := range req.Params {
+			if key == "node" || key == "digest" {
+				continue
+			}
+			if !vmConfigKeys[key] {
+				return fmt.Errorf("params.%s is not an editable VM config option", key)
+			}
+			changes++
+		}
+		if changes == 0 {
+			return fmt.Errorf("set_vm_config requires at least one config option")
+		}
+		for _, key := range []string{"protection", "onboot"} {
+			if _, _, err := optionalBoolParam(req.Params, key); err != nil {
+				return err
+			}
+		}
+		startup, err := optionalStringParam(req.Params, "startup")
+		if err != nil {
+			return err
+		}
+		if _, set := req.Params["startup"]; set {
+			if err := validateStartup(startup); err != nil {
+				return err
+			}
+		}
+	case ActionSnapshotVM:
+		description, err := optionalStringParam(req.Params, "description")
+		if err != nil {
+			return err
+		}
+		if len(description) > maxSnapshotDescription {
+			return fmt.Errorf("params.description must be at most %d bytes", maxSnapshotDescription)
+		}
+	case ActionReadClusterLog:
+		maxEntries, set, err := optionalIntParam(req.Params, "max")
+		if err != nil {
+			return err
+		}
+		if set && (maxEntries < 1 || maxEntries > maxClusterLogEntries) {
+			return fmt.Errorf("params.max must be between 1 and %d", maxClusterLogEntries)
+		}
+		severity, err := optionalStringParam(req.Params, "severity")
+		if err != nil {
+			return err
+		}
+		if _, ok := severityRank(severity); severity != "" && !ok {
+			return fmt.Errorf("params.severity %q must be a syslog severity such as \"err\" or \"warning\"", severity)
+		}
+	case ActionReadVMRRDData:
+		timeframe, err := optionalStringParam(req.Params, "timeframe")
+		if err != nil {
+			return err
+		}
+		if timeframe != "" && !rrdTimeframes[timeframe] {
+			return fmt.Errorf("params.timeframe %q must be one of hour, day, week, month, year", timeframe)
+		}
+	case ActionReadVMSnapshotConfig, ActionDeleteSnapshot:
+		snapname, err := requiredStringParam(req.Params, "snapname")
+		if err != nil {
+			return err
+		}
+		if !snapnamePattern.MatchString(snapname) {
+			return fmt.Errorf("params.snapname %q is not a valid snapshot name", snapname)
+		}
+		if req.Action == ActionDeleteSnapshot {
+			if _, _, err := optionalBoolParam(req.Params, "wait"); err != nil {
+				return err
+			}
+		}
+	case ActionReadTasks:
+		typeFilter, err := optionalStringParam(req.Params, "typefilter")
+		if err != nil {
+			return err
+		}
+		if typeFilter != "" && !taskTypePattern.MatchString(typeFilter) {
+			return fmt.Errorf("params.typefilter must be a task type such as \"vzdump\"")
+		}
+		statusFilter, err := optionalStringParam(req.Params, "statusfilter")
+		if err != nil {
+			return err
+		}
+		if statusFilter != "" {
+			for _, status := range strings.Split(statusFilter, ",") {
+				if _, ok := taskStatusFilters[strings.ToLower(strings.TrimSpace(status))]; !ok {
+					return fmt.Errorf("params.statusfilter %q must be a comma-separated list of ok, error, warning, or unknown", statusFilter)
+				}
+			}
+		}
+	case ActionCloneVM:
+		full, _, err := optionalBoolParam(req.Params, "full")
+		if err != nil {
+			return err
+		}
+		newID, set, err := optionalIntParam(req.Params, "newid")
+		if err != nil {
+			return err
+		}
+		if set && newID <= 0 {
+			return fmt.Errorf("params.newid must be a positive VMID")
+		}
+		if _, _, err := optionalBoolParam(req.Params, "wait"); err != nil {
+			return err
+		}
+		if _, err := optionalStringParam(req.Params, "target"); err != nil {
+			return err
+		}
+		storage, err := optionalStringParam(req.Params, "storage")
+		if err != nil {
+			return err
+		}
+		if full && storage == "" {
+			return fmt.Errorf("params.storage is required when params.full is set")
+		}
+	case ActionMigrateVM:
+		withLocalDisks, _, err := optionalBoolParam(req.Params, "with-local-disks")
+		if err != nil {
+			return err
+		}
+		if _, _, err := optionalBoolParam(req.Params, "online"); err != nil {
+			return err
+		}
+		if _, err := optionalStringParam(req.Params, "targetstorage"); err != nil {
+			return err
+		}
+		if withLocalDisks {
+			if _, err := requiredStringParam(req.Params, "targetstorage"); err != nil {
+				return fmt.Errorf("params.targetstorage is required when params.with-local-disks is set")
+			}
+		}
+	}
+	return nil
+}
+
+// validateStartup checks a Proxmox startup spec such as "order=1,up=30":
+// comma-separated order, up and down settings, each a non-negative integer
+// and given at most once. A bare leading number is the order.
+func validateStartup(spec string) error {
+	if spec == "" {
+		return fmt.Errorf("params.startup must not be empty")
+	}
+	seen := map[string]bool{}
+	for i, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok && i == 0 {
+			key, value = "order", key
+		}
+		switch key {
+		case "order", "up", "down":
+		default:
+			return fmt.Errorf("params.startup %q: expected order=N, up=N, or down=N, got %q", spec, part)
+		}
+		if seen[key] {
+			return fmt.Errorf("params.startup %q sets %s more than once", spec, key)
+		}
+		seen[key] = true
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return fmt.Errorf("params.startup %q: %s must be a non-negative integer", spec, key)
+		}
+	}
+	return nil
+}
+
+// IsVMAction reports whether action addresses a single VM through a
+// vm/<id> or node/<vmid> target.
+func IsVMAction(action ActionType) bool {
+	return actionTable[action]
+}
+
+// RequestNodes returns the node names req refers to: the node hosting the
+// target, and for clone and migrate the destination node. Actions that do not
+// name a node return nil.
+func RequestNodes(req ActionRequest) []string {
+	var nodes []string
+	switch {
+	case IsVMAction(req.Action):
+		node, _, err := parseVMTarget(req.Target, req.Params)
+		if err != nil {
+			return nil
+		}
+		nodes = append(nodes, node)
+		if req.Action == ActionCloneVM || req.Action == ActionMigrateVM {
+			if target, err := optionalStringParam(req.Params, "target"); err == nil && target != "" && target != node {
+				nodes = append(nodes, target)
+			}
+		}
+	case req.Action == ActionReadNodeVersion || req.Action == ActionReadNodeSubscription:
+		if node, err := parseNodeTarget(req.Target); err == nil {
+			nodes = append(nodes, node)
+		}
+	case req.Action == ActionReadTasks || req.Action == ActionReadTaskStatus || req.Action == ActionReadReplicationStatus:
+		if node, err := optionalStringParam(req.Params, "node"); err == nil && node != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// checkTargetNode rejects a params.node that disagrees with the node encoded
+// in a node/<vmid> target, since the target would silently win.
+func checkTargetNode(req ActionRequest) error {
+	targetNode, _, ok := strings.Cut(strings.TrimSpace(req.Target), "/")
+	if !ok || targetNode == "vm" {
+		return nil
+	}
+	node, err := optionalStringParam(req.Params, "node")
+	if err != nil {
+		return err
+	}
+	if node != "" && node != targetNode {
+		return fmt.Errorf("params.node %q conflicts with node %q in target %q", node, targetNode, req.Target)
+	}
+	return nil
+}
+
+func usesCustomEndpoint(action ActionType) bool {
+	return action == ActionStorageEdit || action == ActionFirewallEdit
+}
+
+// DescribeRequest returns the effective action-specific settings surfaced in a
+// plan so operators can see what apply would send. It returns nil when there
+// is nothing beyond the raw request worth reporting.
+func DescribeRequest(req ActionRequest) map[string]any {
+	switch req.Action {
+	case ActionCloneVM:
+		details := map[string]any{}
+		sourceNode, _ := optionalStringParam(req.Params, "node")
+		if node, _, ok := strings.Cut(req.Target, "/"); ok && node != "vm" {
+			sourceNode = node
+		}
+		targetNode, _ := optionalStringParam(req.Params, "target")
+		if targetNode == "" {
+			targetNode = sourceNode
+		}
+		if targetNode != "" {
+			details["target_node"] = targetNode
+		}
+		full, _, _ := optionalBoolParam(req.Params, "full")
+		details["full"] = full
+		if storage, err := optionalStringParam(req.Params, "storage"); err == nil && storage != "" {
+			details["storage"] = storage
+		}
+		if newID, set, err := optionalIntParam(req.Params, "newid"); err == nil && set {
+			details["newid"] = newID
+		}
+		return details
+	case ActionDeleteVM:
+		if IsSoftDelete(req) {
+			return map[string]any{"soft": true, "tag": PendingDeleteTag}
+		}
+		return nil
+	case ActionStopVM:
+		details := map[string]any{}
+		if timeout, set, err := optionalIntParam(req.Params, "timeout"); err == nil && set {
+			details["timeout_seconds"] = timeout
+		}
+		if keepActive, set, err := optionalBoolParam(req.Params, "keepActive"); err == nil && set {
+			details["keep_active"] = keepActive
+		}
+		if len(details) == 0 {
+			return nil
+		}
+		return details
+	case ActionMigrateVM:
+		details := map[string]any{}
+		if target, err := optionalStringParam(req.Params, "target"); err == nil && target != "" {
+			details["target_node"] = target
+		}
+		withLocalDisks, _, _ := optionalBoolParam(req.Params, "with-local-disks")
+		details["with_local_disks"] = withLocalDisks
+		if online, set, _ := optionalBoolParam(req.Params, "online"); set {
+			details["online"] = online
+		}
+		if storage, err := optionalStringParam(req.Params, "targetstorage"); err == nil && storage != "" {
+			details["targetstorage"] = storage
+		}
+		return details
+	}
+	return nil
+}
+
+func optionalStringParam(params map[string]any, key string) (string, error) {
+	raw, ok := params[key]
+	if !ok || raw == nil {
+		return "", nil
+	}
+	v, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("params.%s must be a string", key)
+	}
+	return strings.TrimSpace(v), nil
+}
+
+// optionalBoolParam accepts JSON booleans as well as the 0/1 form values the
+// Proxmox API itself uses.
+func optionalBoolParam(params map[string]any, key string) (value bool, set bool, err error) {
+	raw, ok := params[key]
+	if !ok || raw == nil {
+		return false, false, nil
+	}
+	switch typed := raw.(type) {
+	case bool:
+		return typed, true, nil
+	case int:
+		if typed == 0 || typed == 1 {
+			return typed == 1, true, nil
+		}
+	case float64:
+		if typed == 0 || typed == 1 {
+			return typed == 1, true, nil
+		}
+	case string:
+		switch strings.ToLower(strings.TrimSpace(typed)) {
+		case "1", "true":
+			return true, true, nil
+		case "0", "false":
+			return false, true, nil
+		}
+	}
+	return false, false, fmt.Errorf("params.%s must be a boolean", key)
+}
+
+// optionalIntParam accepts JSON numbers without a fractional part as well as
+// decimal strings, which is how query parameters arrive.
+func optionalIntParam(params map[string]any, key string) (value int64, set bool, err error) {
+	raw, ok := params[key]
+	if !ok || raw == nil {
+		return 0, false, nil
+	}
+	switch typed := raw.(type) {
+	case int:
+		return int64(typed), true, nil
+	case int64:
+		return typed, true, nil
+	case float64:
+		if typed == math.Trunc(typed) {
+			return int64(typed), true, nil
+		}
+	case string:
+		if n, err := strconv.ParseInt(strings.TrimSpace(typed), 10, 64); err == nil {
+			return n, true, nil
+		}
+	}
+	return 0, false, fmt.Errorf("params.%s must be an integer", key)
+}
+
+// normalizeStatusFilter maps a validated statusfilter to the values Proxmox
+// expects.
+func normalizeStatusFilter(raw string) string {
+	parts := strings.Split(raw, ",")
+	for i, status := range parts {
+		parts[i] = taskStatusFilters[strings.ToLower(strings.TrimSpace(status))]
+	}
+	return strings.Join(parts, ",")
+}
+
+func formBool(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package proxmox
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateActionParamsFullCloneRequiresStorage(t *testing.T) {
+	err := ValidateActionParams(ActionRequest{
+		Action: ActionCloneVM,
+		Target: "vm/103",
+		Params: map[string]any{"node": "node1", "newid": 104, "target": "node2", "full": true},
+	})
+	if err == nil || !strings.Contains(err.Error(), "params.storage is required") {
+		t.Fatalf("expected missing storage error, got %v", err)
+	}
+
+	err = ValidateActionParams(ActionRequest{
+		Action: ActionCloneVM,
+		Target: "vm/103",
+		Params: map[string]any{"node": "node1", "newid": 104, "target": "node2", "full": 1, "storage": "local-lvm"},
+	})
+	if err != nil {
+		t.Fatalf("expected full clone with storage to validate, got %v", err)
+	}
+}
+
+func TestDescribeRequestCloneReportsResolvedTarget(t *testing.T) {
+	details := DescribeRequest(ActionRequest{
+		Action: ActionCloneVM,
+		Target: "vm/103",
+		Params: map[string]any{"node": "node1", "newid": 104},
+	})
+	if details["target_node"] != "node1" || details["full"] != false {
+		t.Fatalf("expected same-node linked clone, got %v", details)
+	}
+
+	details = DescribeRequest(ActionRequest{
+		Action: ActionCloneVM,
+		Target: "vm/103",
+		Params: map[string]any{"node": "node1", "newid": 104, "target": "node2", "full": true, "storage": "ceph"},
+	})
+	if details["target_node"] != "node2" || details["storage"] != "ceph" || details["full"] != true {
+		t.Fatalf("expected cross-node full clone details, got %v", details)
+	}
+}
+
+func TestCoerceParamsConvertsIntegralNumbersInACopy(t *testing.T) {
+	params := map[string]any{"node": "pve1", "newid": 104.0, "name": "web-104", "cores": 2.5}
+	coerced := CoerceParams(params)
+	if got, ok := coerced["newid"].(int64); !ok || got != 104 {
+		t.Fatalf("expected newid coerced to int64 104, got %#v", coerced["newid"])
+	}
+	if coerced["cores"] != 2.5 || coerced["name"] != "web-104" {
+		t.Fatalf("expected other values to be copied unchanged, got %v", coerced)
+	}
+	if params["newid"] != 104.0 {
+		t.Fatalf("expected the decoded params to be left alone, got %#v", params["newid"])
+	}
+}
+
+func TestValidateActionParamsRejectsFractionalIntegers(t *testing.T) {
+	params := map[string]any{"node": "pve1", "newid": 104.0, "name": "web-104"}
+	if err := ValidateActionParams(ActionRequest{Action: ActionCloneVM, Target: "vm/103", Params: params}); err != nil {
+		t.Fatalf("expected newid 104.0 to be accepted, got %v", err)
+	}
+	if params["newid"] != 104.0 {
+		t.Fatalf("expected validation not to modify params, got %#v", params["newid"])
+	}
+
+	err := ValidateActionParams(ActionRequest{Action: ActionCloneVM, Target: "vm/103", Params: map[string]any{"node": "pve1", "newid": 104.5}})
+	if err == nil || !strings.Contains(err.Error(), "params.newid must be an integer") {
+		t.Fatalf("expected fractional newid to be rejected, got %v", err)
+	}
+}
+
+func TestDescribeRequestStopReportsTimeout(t *testing.T) {
+	details := DescribeRequest(ActionRequest{
+		Action: ActionStopVM,
+		Target: "vm/101",
+		Params: map[string]any{"node": "pve1", "timeout": int64(30)},
+	})
+	if details["timeout_seconds"] != int64(30) {
+		t.Fatalf("expected timeout_seconds 30 in plan details, got %v", details)
+	}
+	if DescribeRequest(ActionRequest{Action: ActionStopVM, Target: "vm/101", Params: map[string]any{"node": "pve1"}}) != nil {
+		t.Fatal("expected no details for a stop without options")
+	}
+}
+
+func TestValidateStartup(t *testing.T) {
+	for _, spec := range []string{"order=1,up=30", "order=2", "3,up=10", "up=5,down=120"} {
+		if err := validateStartup(spec); err != nil {
+			t.Fatalf("expected %q to be valid: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "order=1,,up=30", "order=-1", "up=30,up=60", "order=1;up=30", "wait=5"} {
+		if err := validateStartup(spec); err == nil {
+			t.Fatalf("expected %q to be rejected", spec)
+		}
+	}
+}
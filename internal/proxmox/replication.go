@@ -0,0 +1,78 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// replicationJobPattern matches Proxmox replication job IDs, "<vmid>-<jobnum>".
+var replicationJobPattern = regexp.MustCompile(`^[0-9]+-[0-9]+$`)
+
+// ReplicationStatus is the last-run state of one storage replication job.
+// Failed is set when the last attempt ended with an error.
+type ReplicationStatus struct {
+	ID        string   `json:"id"`
+	Guest     int      `json:"guest,omitempty"`
+	Target    string   `json:"target,omitempty"`
+	LastSync  int64    `json:"last_sync,omitempty"`
+	LastTry   int64    `json:"last_try,omitempty"`
+	NextSync  int64    `json:"next_sync,omitempty"`
+	Duration  float64  `json:"duration,omitempty"`
+	FailCount int      `json:"fail_count"`
+	Error     string   `json:"error,omitempty"`
+	Failed    bool     `json:"failed"`
+	Log       []string `json:"log,omitempty"`
+}
+
+func decodeReplicationStatus(data json.RawMessage) (ReplicationStatus, error) {
+	var status ReplicationStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return ReplicationStatus{}, fmt.Errorf("decode replication status: %w", err)
+	}
+	status.Error = strings.TrimSpace(status.Error)
+	status.Failed = status.Error != "" || status.FailCount > 0
+	return status, nil
+}
+
+func parseReplicationTarget(target string) (string, error) {
+	id, ok := strings.CutPrefix(strings.TrimSpace(target), "replication/")
+	if !ok || !replicationJobPattern.MatchString(id) {
+		return "", fmt.Errorf("invalid replication target %q; expected replication/<vmid>-<jobnum>", target)
+	}
+	return id, nil
+}
+
+// replicationLog reads the job's log from /nodes/{node}/replication/{id}/log.
+func (c *APIClient) replicationLog(env apiEnvironment, req ActionRequest) ([]string, error) {
+	node, err := requiredStringParam(req.Params, "node")
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseReplicationTarget(req.Target)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := fmt.Sprintf("%s/nodes/%s/replication/%s/log", env.apiBasePath(), node, url.PathEscape(id))
+	body, err := c.performRequest(requestContext(req), env, http.MethodGet, endpoint, nil, c.responseLimit(req.Action), req.Capture)
+	if err != nil {
+		return nil, err
+	}
+	var envelope struct {
+		Data []struct {
+			N int    `json:"n"`
+			T string `json:"t"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, fmt.Errorf("decode replication log: %w", err)
+	}
+	lines := make([]string, 0, len(envelope.Data))
+	for _, line := range envelope.Data {
+		lines = append(lines, line.T)
+	}
+	return lines, nil
+}
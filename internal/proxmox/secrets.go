@@ -0,0 +1,80 @@
+package proxmox
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SecretProvider resolves a secret reference to its value. The reference
+// passed to Resolve has its "scheme:" prefix already stripped.
+type SecretProvider interface {
+	Resolve(ref string) (string, error)
+}
+
+// EnvSecretProvider reads secrets from environment variables.
+type EnvSecretProvider struct{}
+
+func (EnvSecretProvider) Resolve(name string) (string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return "", fmt.Errorf("env var %q is empty or unset", name)
+	}
+	return value, nil
+}
+
+// FileSecretProvider reads secrets from files, such as mounted Kubernetes or
+// Docker secrets. Surrounding whitespace is trimmed.
+type FileSecretProvider struct{}
+
+func (FileSecretProvider) Resolve(path string) (string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read secret file: %w", err)
+	}
+	value := strings.TrimSpace(string(raw))
+	if value == "" {
+		return "", fmt.Errorf("secret file %q is empty", path)
+	}
+	return value, nil
+}
+
+// SecretProviders dispatches "scheme:ref" references to the provider
+// registered for the scheme.
+type SecretProviders map[string]SecretProvider
+
+func defaultSecretProviders() SecretProviders {
+	return SecretProviders{
+		"env":  EnvSecretProvider{},
+		"file": FileSecretProvider{},
+	}
+}
+
+func (p SecretProviders) Resolve(ref string) (string, error) {
+	scheme, rest, ok := strings.Cut(strings.TrimSpace(ref), ":")
+	if !ok || scheme == "" || rest == "" {
+		return "", fmt.Errorf("invalid secret reference %q: expected <scheme>:<ref>", ref)
+	}
+	provider, ok := p[scheme]
+	if !ok {
+		return "", fmt.Errorf("unknown secret provider scheme %q", scheme)
+	}
+	return provider.Resolve(rest)
+}
+
+// ResolveSecretRef resolves an "env:" or "file:" reference with the
+// built-in providers.
+func ResolveSecretRef(ref string) (string, error) {
+	return defaultSecretProviders().Resolve(ref)
+}
+
+// WithSecretProvider registers provider for token_secret_ref values that use
+// scheme, e.g. "vault". Registering "env" or "file" replaces the built-in.
+func WithSecretProvider(scheme string, provider SecretProvider) ClientOption {
+	return func(c *APIClient) {
+		if c.secrets == nil {
+			c.secrets = defaultSecretProviders()
+		}
+		c.secrets[scheme] = provider
+	}
+}
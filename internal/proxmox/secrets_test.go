@@ -0,0 +1,63 @@
+package proxmox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+)
+
+func TestSecretProvidersResolveEnv(t *testing.T) {
+	t.Setenv("PVE_REF_SECRET", " env-secret \n")
+	got, err := defaultSecretProviders().Resolve("env:PVE_REF_SECRET")
+	if err != nil {
+		t.Fatalf("Resolve returned error: %v", err)
+	}
+	if got != "env-secret" {
+		t.Fatalf("expected trimmed env secret, got %q", got)
+	}
+}
+
+func TestSecretProvidersResolveFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pve-secret")
+	if err := os.WriteFile(path, []byte("file-secret\n"), 0o600); err != nil {
+		t.Fatalf("write secret file: %v", err)
+	}
+	got, err := defaultSecretProviders().Resolve("file:" + path)
+	if err != nil {
+		t.Fatalf("Resolve returned error: %v", err)
+	}
+	if got != "file-secret" {
+		t.Fatalf("expected file secret, got %q", got)
+	}
+}
+
+func TestSecretProvidersRejectUnknownScheme(t *testing.T) {
+	_, err := defaultSecretProviders().Resolve("vault:secret/pve")
+	if err == nil || !strings.Contains(err.Error(), `unknown secret provider scheme "vault"`) {
+		t.Fatalf("expected unknown scheme error, got %v", err)
+	}
+}
+
+type staticSecretProvider map[string]string
+
+func (p staticSecretProvider) Resolve(ref string) (string, error) {
+	return p[ref], nil
+}
+
+func TestNewAPIClientUsesRegisteredSecretProvider(t *testing.T) {
+	client, err := NewAPIClient([]config.Environment{{
+		Name:           "home",
+		BaseURL:        "https://proxmox.example.com",
+		TokenID:        "root@pam!agent",
+		TokenSecretRef: "vault:secret/pve",
+	}}, WithSecretProvider("vault", staticSecretProvider{"secret/pve": "vault-secret"}))
+	if err != nil {
+		t.Fatalf("NewAPIClient returned error: %v", err)
+	}
+	if got := client.envs["home"].tokenSecret; got != "vault-secret" {
+		t.Fatalf("expected secret from registered provider, got %q", got)
+	}
+}
@@ -0,0 +1,38 @@
+package proxmox
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+)
+
+// SelfTestResult is the outcome of probing one environment at startup.
+// AuthRejected marks a 401 or 403, i.e. a bad token rather than an
+// unreachable cluster.
+type SelfTestResult struct {
+	Environment  string
+	Err          error
+	AuthRejected bool
+}
+
+// SelfTest reads the API version from every environment so bad credentials
+// surface at startup instead of on the first real request. Results are
+// ordered by environment name.
+func (c *APIClient) SelfTest() []SelfTestResult {
+	names := make([]string, 0, len(c.envs))
+	for name := range c.envs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	results := make([]SelfTestResult, 0, len(names))
+	for _, name := range names {
+		_, err := c.Execute(ActionRequest{Environment: name, Action: ActionReadVersion, Target: "version"})
+		result := SelfTestResult{Environment: name, Err: err}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			result.AuthRejected = apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden
+		}
+		results = append(results, result)
+	}
+	return results
+}
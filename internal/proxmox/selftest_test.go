@@ -0,0 +1,41 @@
+package proxmox
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSelfTestReportsEnvironmentWithRejectedAuth(t *testing.T) {
+	client := newMockClient(t, "good-secret", func(r *http.Request) (*http.Response, error) {
+		if r.URL.Host == "lab.example.com" {
+			return &http.Response{
+				StatusCode: http.StatusUnauthorized,
+				Body:       io.NopCloser(strings.NewReader(`{"data":null}`)),
+				Header:     make(http.Header),
+			}, nil
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":{"version":"8.2.4","release":"8.2"}}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+	client.envs["lab"] = apiEnvironment{
+		baseURL:     "https://lab.example.com",
+		tokenID:     "root@pam!agent",
+		tokenSecret: "stale-secret",
+	}
+
+	results := client.SelfTest()
+	if len(results) != 2 {
+		t.Fatalf("expected one result per environment, got %+v", results)
+	}
+	if results[0].Environment != "home" || results[0].Err != nil {
+		t.Fatalf("expected home to pass, got %+v", results[0])
+	}
+	if results[1].Environment != "lab" || results[1].Err == nil || !results[1].AuthRejected {
+		t.Fatalf("expected lab to fail with rejected auth, got %+v", results[1])
+	}
+}
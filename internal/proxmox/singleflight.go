@@ -0,0 +1,56 @@
+package proxmox
+
+import (
+	"errors"
+	"sync"
+)
+
+var errFlightPanicked = errors.New("shared upstream read panicked")
+
+// flightGroup coalesces concurrent identical reads: while one upstream call
+// for a key is in flight, later callers wait for it and share its body
+// instead of issuing their own. Nothing is kept after the call returns, so
+// this only helps with stampedes, not repeated reads.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg   sync.WaitGroup
+	body []byte
+	err  error
+	// dups counts callers that joined an in-flight call.
+	dups int
+}
+
+// do runs fn once per key at a time and reports whether the result came from
+// another caller's call. The returned body is shared and must not be
+// modified.
+func (g *flightGroup) do(key string, fn func() ([]byte, error)) (body []byte, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		call.dups++
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.body, call.err, true
+	}
+	// err stays set only if fn panics, so joined callers see a failure
+	// rather than an empty body.
+	call := &flightCall{err: errFlightPanicked}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		call.wg.Done()
+	}()
+
+	call.body, call.err = fn()
+	return call.body, call.err, false
+}
@@ -0,0 +1,114 @@
+package proxmox
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrentIdenticalReadsShareOneUpstreamCall(t *testing.T) {
+	const callers = 8
+	var upstream int32
+	release := make(chan struct{})
+	client := newMockClient(t, "flight-secret", func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&upstream, 1)
+		<-release
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":{"status":"running","vmid":101}}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := client.Execute(ActionRequest{Environment: "home", Action: ActionReadVM, Target: "node1/101"})
+			if err == nil && result.Data.(map[string]any)["status"] != "running" {
+				err = io.ErrUnexpectedEOF
+			}
+			errs <- err
+		}()
+	}
+
+	// Hold the upstream call until every other caller has joined it.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client.reads.mu.Lock()
+		joined := 0
+		for _, call := range client.reads.calls {
+			joined = call.dups
+		}
+		client.reads.mu.Unlock()
+		if joined == callers-1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d callers joined the in-flight read", joined)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Execute returned error: %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&upstream); got != 1 {
+		t.Fatalf("expected a single upstream call, got %d", got)
+	}
+}
+
+func TestFlightGroupReleasesWaitersWhenCallPanics(t *testing.T) {
+	var g flightGroup
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		defer func() { _ = recover() }()
+		g.do("k", func() ([]byte, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+
+	joined := make(chan error, 1)
+	go func() {
+		_, err, _ := g.do("k", func() ([]byte, error) { return nil, nil })
+		joined <- err
+	}()
+	for {
+		g.mu.Lock()
+		dups := g.calls["k"].dups
+		g.mu.Unlock()
+		if dups == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	select {
+	case err := <-joined:
+		if !errors.Is(err, errFlightPanicked) {
+			t.Fatalf("expected the joined caller to see the panic, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("joined caller was never released")
+	}
+	if body, err, shared := g.do("k", func() ([]byte, error) { return []byte("ok"), nil }); err != nil || shared || string(body) != "ok" {
+		t.Fatalf("expected a fresh call after the panic, got %q, %v, shared=%v", body, err, shared)
+	}
+}
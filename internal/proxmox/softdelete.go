@@ -0,0 +1,118 @@
+package proxmox
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PendingDeleteTag marks a VM that was soft-deleted. A second tag,
+// PendingDeleteTag-<unix seconds>, records when, since Proxmox tags are the
+// only free-form metadata that survives without touching the description.
+const PendingDeleteTag = "pending-delete"
+
+// IsSoftDelete reports whether req asks for the first, reversible phase of a
+// delete, accepting every form of params.soft the client does.
+func IsSoftDelete(req ActionRequest) bool {
+	if req.Action != ActionDeleteVM {
+		return false
+	}
+	soft, _, _ := optionalBoolParam(req.Params, "soft")
+	return soft
+}
+
+// PendingDeleteSince returns when a VM was soft-deleted, given its tags as
+// Proxmox reports them (";", "," or space separated).
+func PendingDeleteSince(tags string) (time.Time, bool) {
+	marked := false
+	var since time.Time
+	for _, tag := range splitTags(tags) {
+		if tag == PendingDeleteTag {
+			marked = true
+			continue
+		}
+		if raw, ok := strings.CutPrefix(tag, PendingDeleteTag+"-"); ok {
+			if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+				since = time.Unix(secs, 0).UTC()
+			}
+		}
+	}
+	if !marked || since.IsZero() {
+		return time.Time{}, false
+	}
+	return since, true
+}
+
+func splitTags(tags string) []string {
+	return strings.FieldsFunc(tags, func(r rune) bool {
+		return r == ';' || r == ',' || r == ' '
+	})
+}
+
+// softDeleteVM stops the VM and tags it pending-delete instead of destroying
+// it. Existing tags are kept; a previous pending-delete timestamp is replaced
+// so the grace period restarts.
+func (c *APIClient) softDeleteVM(env apiEnvironment, req ActionRequest) (ActionResult, error) {
+	node, vmid, err := parseVMTarget(req.Target, req.Params)
+	if err != nil {
+		return ActionResult{}, err
+	}
+	vmPath := fmt.Sprintf("%s/nodes/%s/qemu/%s", env.apiBasePath(), node, vmid)
+	limit := c.responseLimit(req.Action)
+
+	configBody, err := c.performRequest(requestContext(req), env, http.MethodGet, vmPath+"/config", nil, limit, req.Capture)
+	if err != nil {
+		return ActionResult{}, err
+	}
+	var current struct {
+		Data struct {
+			Tags string `json:"tags"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(configBody, &current); err != nil {
+		return ActionResult{}, fmt.Errorf("decode proxmox response: %w", err)
+	}
+
+	stopBody, err := c.performRequest(requestContext(req), env, http.MethodPost, vmPath+"/status/stop", nil, limit, req.Capture)
+	if err != nil {
+		return ActionResult{}, err
+	}
+	var stop struct {
+		Data string `json:"data"`
+	}
+	_ = json.Unmarshal(stopBody, &stop)
+
+	now := c.clock().UTC()
+	tags := make([]string, 0, 4)
+	for _, tag := range splitTags(current.Data.Tags) {
+		if tag == PendingDeleteTag || strings.HasPrefix(tag, PendingDeleteTag+"-") {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	tags = append(tags, PendingDeleteTag, fmt.Sprintf("%s-%d", PendingDeleteTag, now.Unix()))
+	joined := strings.Join(tags, ";")
+	if _, err := c.performRequest(requestContext(req), env, http.MethodPut, vmPath+"/config", encodeParams(map[string]any{"tags": joined}), limit, req.Capture); err != nil {
+		return ActionResult{}, err
+	}
+
+	return ActionResult{
+		Status:  "accepted",
+		Message: "vm stopped and marked pending-delete; it will be destroyed after the grace period",
+		Data: map[string]any{
+			"stop_upid":         stop.Data,
+			"tags":              joined,
+			"pending_delete_at": now.Format(time.RFC3339),
+		},
+	}, nil
+}
+
+func (c *APIClient) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
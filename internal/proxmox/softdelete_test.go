@@ -0,0 +1,105 @@
+package proxmox
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecuteSoftDeleteStopsAndTagsWithoutDeleting(t *testing.T) {
+	var calls []string
+	var tagBody string
+	client := newMockClient(t, "soft-secret", func(r *http.Request) (*http.Response, error) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		body := `{"data":null}`
+		switch {
+		case r.Method == http.MethodGet:
+			body = `{"data":{"tags":"web;pending-delete-1;prod"}}`
+		case r.Method == http.MethodPost:
+			body = `{"data":"UPID:node1:stop"}`
+		case r.Method == http.MethodPut:
+			raw, _ := io.ReadAll(r.Body)
+			tagBody = string(raw)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Header:     make(http.Header),
+		}, nil
+	})
+	client.now = func() time.Time { return time.Unix(1760000000, 0) }
+
+	result, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionDeleteVM,
+		Target:      "node1/101",
+		Params:      map[string]any{"soft": true},
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+
+	want := []string{
+		"GET /api2/json/nodes/node1/qemu/101/config",
+		"POST /api2/json/nodes/node1/qemu/101/status/stop",
+		"PUT /api2/json/nodes/node1/qemu/101/config",
+	}
+	if strings.Join(calls, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected calls %v, got %v", want, calls)
+	}
+	if tagBody != "tags=web%3Bprod%3Bpending-delete%3Bpending-delete-1760000000" {
+		t.Fatalf("unexpected tag update body %q", tagBody)
+	}
+	data, _ := result.Data.(map[string]any)
+	if data["stop_upid"] != "UPID:node1:stop" {
+		t.Fatalf("expected stop UPID in result, got %v", result.Data)
+	}
+}
+
+func TestExecuteHardDeleteDropsSoftParam(t *testing.T) {
+	var gotMethod, gotBody string
+	client := newMockClient(t, "soft-secret", func(r *http.Request) (*http.Response, error) {
+		gotMethod = r.Method
+		if r.Body != nil {
+			raw, _ := io.ReadAll(r.Body)
+			gotBody = string(raw)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":"UPID:node1:destroy"}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	if _, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionDeleteVM,
+		Target:      "node1/101",
+		Params:      map[string]any{"soft": false},
+	}); err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if gotMethod != http.MethodDelete || strings.Contains(gotBody, "soft") {
+		t.Fatalf("expected plain DELETE without soft, got %s %q", gotMethod, gotBody)
+	}
+}
+
+func TestFilterInventoryPendingDelete(t *testing.T) {
+	data := []any{
+		map[string]any{"vmid": float64(100), "type": "qemu", "tags": "web"},
+		map[string]any{"vmid": float64(101), "type": "qemu", "tags": "pending-delete;pending-delete-1760000000"},
+	}
+	got, err := filterInventory(ActionRequest{Target: "inventory/all", Params: map[string]any{"pending_delete": "true"}}, data)
+	if err != nil {
+		t.Fatalf("filterInventory returned error: %v", err)
+	}
+	items := got.([]any)
+	if len(items) != 1 {
+		t.Fatalf("expected only the pending-delete VM, got %v", items)
+	}
+	if at := items[0].(map[string]any)["pending_delete_at"]; at != "2025-10-09T08:53:20Z" {
+		t.Fatalf("unexpected pending_delete_at %v", at)
+	}
+}
@@ -0,0 +1,79 @@
+package proxmox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+)
+
+func newPinnedTestClient(t *testing.T, baseURL, fingerprint string) *APIClient {
+	t.Helper()
+	t.Setenv("PVE_TEST_SECRET", "pin-secret")
+	client, err := NewAPIClient([]config.Environment{{
+		Name:           "home",
+		BaseURL:        baseURL,
+		TokenID:        "root@pam!agent",
+		TokenSecretEnv: "PVE_TEST_SECRET",
+		TLSFingerprint: fingerprint,
+	}})
+	if err != nil {
+		t.Fatalf("NewAPIClient returned error: %v", err)
+	}
+	return client
+}
+
+func TestPinnedFingerprintAcceptsMatchingCertificate(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[{"node":"node1","status":"online"}]}`))
+	}))
+	defer upstream.Close()
+	sum := sha256.Sum256(upstream.Certificate().Raw)
+	hexSum := strings.ToUpper(hex.EncodeToString(sum[:]))
+	var pairs []string
+	for i := 0; i < len(hexSum); i += 2 {
+		pairs = append(pairs, hexSum[i:i+2])
+	}
+
+	// Colon-separated uppercase, as openssl prints it.
+	client := newPinnedTestClient(t, upstream.URL, strings.Join(pairs, ":"))
+	if _, err := client.Execute(ActionRequest{Environment: "home", Action: ActionReadNodes, Target: "nodes/all"}); err != nil {
+		t.Fatalf("expected pinned self-signed server to be accepted, got %v", err)
+	}
+}
+
+func TestPinnedFingerprintRejectsMismatchedCertificate(t *testing.T) {
+	var calls int
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}))
+	defer upstream.Close()
+
+	client := newPinnedTestClient(t, upstream.URL, strings.Repeat("ab", sha256.Size))
+	client.sleep = func(time.Duration) { t.Fatal("a fingerprint mismatch must not be retried") }
+	_, err := client.Execute(ActionRequest{Environment: "home", Action: ActionReadNodes, Target: "nodes/all"})
+	if err == nil || !strings.Contains(err.Error(), ErrFingerprintMismatch.Error()) {
+		t.Fatalf("expected fingerprint mismatch, got %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected no request to reach the server, got %d", calls)
+	}
+}
+
+func TestNormalizeFingerprintRejectsMalformedInput(t *testing.T) {
+	for _, raw := range []string{"", "abc", strings.Repeat("zz", sha256.Size)} {
+		if _, err := normalizeFingerprint(raw); err == nil {
+			t.Fatalf("expected %q to be rejected", raw)
+		}
+	}
+	t.Setenv("PVE_TEST_SECRET", "pin-secret")
+	if _, err := NewAPIClient([]config.Environment{{Name: "home", BaseURL: "https://pve", TokenID: "id", TokenSecretEnv: "PVE_TEST_SECRET", TLSFingerprint: "abc"}}); err == nil {
+		t.Fatal("expected NewAPIClient to reject a malformed fingerprint")
+	}
+}
@@ -0,0 +1,28 @@
+package proxmox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseUPID splits a Proxmox task ID of the form
+// UPID:<node>:<pid>:<pstart>:<starttime>:<type>:<id>:<user>: and returns the
+// node that runs the task, the task type (e.g. "qmstart") and the hex PID.
+func ParseUPID(upid string) (node, taskType, pid string, err error) {
+	fields := strings.Split(strings.TrimSpace(upid), ":")
+	if len(fields) < 8 || fields[0] != "UPID" {
+		return "", "", "", fmt.Errorf("invalid UPID %q", upid)
+	}
+	node, pid, taskType = fields[1], fields[2], fields[5]
+	if node == "" {
+		return "", "", "", fmt.Errorf("invalid UPID %q: missing node", upid)
+	}
+	if _, err := strconv.ParseUint(pid, 16, 64); err != nil {
+		return "", "", "", fmt.Errorf("invalid UPID %q: bad pid", upid)
+	}
+	if taskType == "" {
+		return "", "", "", fmt.Errorf("invalid UPID %q: missing task type", upid)
+	}
+	return node, taskType, pid, nil
+}
@@ -0,0 +1,56 @@
+package proxmox
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseUPID(t *testing.T) {
+	node, taskType, pid, err := ParseUPID("UPID:pve-node1:000A1B2C:0123ABCD:65F0AB12:qmstart:101:root@pam!agent:")
+	if err != nil {
+		t.Fatalf("ParseUPID returned error: %v", err)
+	}
+	if node != "pve-node1" || taskType != "qmstart" || pid != "000A1B2C" {
+		t.Fatalf("unexpected parse: node=%q type=%q pid=%q", node, taskType, pid)
+	}
+}
+
+func TestParseUPIDRejectsMalformed(t *testing.T) {
+	for _, upid := range []string{
+		"",
+		"UPID:node1:0001",
+		"TASK:node1:000A1B2C:0123ABCD:65F0AB12:qmstart:101:root@pam:",
+		"UPID::000A1B2C:0123ABCD:65F0AB12:qmstart:101:root@pam:",
+		"UPID:node1:not-hex:0123ABCD:65F0AB12:qmstart:101:root@pam:",
+		"UPID:node1:000A1B2C:0123ABCD:65F0AB12::101:root@pam:",
+	} {
+		if _, _, _, err := ParseUPID(upid); err == nil {
+			t.Fatalf("expected error for %q", upid)
+		}
+	}
+}
+
+func TestExecuteAsyncActionReportsTaskNodeAndType(t *testing.T) {
+	const upid = "UPID:node2:000A1B2C:0123ABCD:65F0AB12:qmstop:101:root@pam:"
+	client := newMockClient(t, "upid-secret", func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":"` + upid + `"}`)),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	result, err := client.Execute(ActionRequest{
+		Environment: "home",
+		Action:      ActionStopVM,
+		Target:      "node2/101",
+	})
+	if err != nil {
+		t.Fatalf("Execute returned error: %v", err)
+	}
+	if result.Message != upid || result.Node != "node2" || result.TaskType != "qmstop" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
@@ -0,0 +1,64 @@
+// Package redact masks sensitive values before requests, upstream exchanges
+// or errors are written to audit records, logs or debug output.
+package redact
+
+import "strings"
+
+// Placeholder replaces every masked value.
+const Placeholder = "[REDACTED]"
+
+// SensitiveKeys are the key fragments treated as secrets unless a caller
+// supplies its own list. "ticket" covers Proxmox auth tickets, which are
+// bearer credentials.
+var SensitiveKeys = []string{"password", "secret", "token", "key", "ticket"}
+
+// Map returns a deep copy of m in which every value whose key contains one of
+// sensitiveKeys, compared case-insensitively, is replaced by Placeholder.
+// Nested maps and slices are copied and masked too; m itself is not modified.
+func Map(m map[string]any, sensitiveKeys []string) map[string]any {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		if IsSensitive(k, sensitiveKeys) {
+			out[k] = Placeholder
+			continue
+		}
+		out[k] = Value(v, sensitiveKeys)
+	}
+	return out
+}
+
+// Value is Map for an arbitrary decoded JSON value: maps are masked, slices
+// are copied element by element, and anything else is returned as-is.
+func Value(v any, sensitiveKeys []string) any {
+	switch typed := v.(type) {
+	case map[string]any:
+		return Map(typed, sensitiveKeys)
+	case []any:
+		out := make([]any, len(typed))
+		for i, item := range typed {
+			out[i] = Value(item, sensitiveKeys)
+		}
+		return out
+	case []map[string]any:
+		out := make([]map[string]any, len(typed))
+		for i, item := range typed {
+			out[i] = Map(item, sensitiveKeys)
+		}
+		return out
+	}
+	return v
+}
+
+// IsSensitive reports whether key contains one of sensitiveKeys, ignoring case.
+func IsSensitive(key string, sensitiveKeys []string) bool {
+	lower := strings.ToLower(key)
+	for _, fragment := range sensitiveKeys {
+		if strings.Contains(lower, strings.ToLower(fragment)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package redact
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMapMasksNestedValues(t *testing.T) {
+	in := map[string]any{
+		"node":       "pve1",
+		"CIPassword": "hunter2",
+		"config": map[string]any{
+			"name":      "web",
+			"API_TOKEN": "abc",
+		},
+		"disks": []any{
+			map[string]any{"storage": "local", "encryption-key": "k1"},
+			"scsi0",
+		},
+		"users": []map[string]any{{"name": "ops", "Secret": "s1"}},
+	}
+
+	got := Map(in, SensitiveKeys)
+
+	want := map[string]any{
+		"node":       "pve1",
+		"CIPassword": Placeholder,
+		"config": map[string]any{
+			"name":      "web",
+			"API_TOKEN": Placeholder,
+		},
+		"disks": []any{
+			map[string]any{"storage": "local", "encryption-key": Placeholder},
+			"scsi0",
+		},
+		"users": []map[string]any{{"name": "ops", "Secret": Placeholder}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected redaction:\n got  %#v\n want %#v", got, want)
+	}
+}
+
+func TestMapDoesNotModifyInput(t *testing.T) {
+	nested := map[string]any{"password": "hunter2"}
+	list := []any{map[string]any{"token": "abc"}}
+	in := map[string]any{"nested": nested, "list": list}
+
+	Map(in, SensitiveKeys)
+
+	if nested["password"] != "hunter2" {
+		t.Fatalf("expected nested input to be left alone, got %v", nested["password"])
+	}
+	if list[0].(map[string]any)["token"] != "abc" {
+		t.Fatalf("expected slice input to be left alone, got %v", list[0])
+	}
+}
+
+func TestIsSensitiveIgnoresCase(t *testing.T) {
+	keys := []string{"Password", "apikey"}
+	for _, key := range []string{"password", "ROOT_PASSWORD", "X-ApiKey"} {
+		if !IsSensitive(key, keys) {
+			t.Fatalf("expected %q to be sensitive", key)
+		}
+	}
+	if IsSensitive("storage", keys) {
+		t.Fatalf("expected storage not to be sensitive")
+	}
+	if Map(nil, keys) != nil {
+		t.Fatalf("expected nil map to stay nil")
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/junlov/proxmox-ai/internal/actions"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// listHeldRequests returns the applies waiting for out-of-band approval.
+func (s *Server) listHeldRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireAuth(w, r); !ok {
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{"held": s.runner.HeldRequests()})
+}
+
+// releaseHeldRequest applies a held request with the approval in the body:
+// POST /v1/approvals/{id} {"approved_by":...,"approval_ticket":...,"reason":...}.
+// Releasing is gated by the admin token: the API token is shared by the
+// agents whose requests are held, so it cannot also approve them.
+func (s *Server) releaseHeldRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	actor := strings.TrimSpace(r.Header.Get("X-Actor-ID"))
+	if actor == "" {
+		actor = "admin"
+	}
+	if !requireJSONContentType(w, r) {
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/v1/approvals/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "hold id is required", http.StatusBadRequest)
+		return
+	}
+	var approval actions.Approval
+	if err := decodeStrictReader(r.Body, &approval); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	approval.ApprovedBy = strings.TrimSpace(approval.ApprovedBy)
+	if approval.ApprovedBy == "" {
+		http.Error(w, "approved_by is required", http.StatusBadRequest)
+		return
+	}
+	metadata := proxmox.ActionRequest{
+		ApprovedBy:     approval.ApprovedBy,
+		ApprovalTicket: approval.ApprovalTicket,
+		Reason:         approval.Reason,
+	}
+	if err := validateApprovalMetadata(metadata, s.validator.now(), 0); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := s.runner.Release(id, approval, actor)
+	if err != nil {
+		if errors.Is(err, actions.ErrHoldNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), applyErrorStatus(err))
+		return
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
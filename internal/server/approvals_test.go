@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHeldDeleteIsReleasedWithApproval(t *testing.T) {
+	client := &testClient{}
+	s := newTestServer(client)
+	s.adminToken = "admin-token"
+	handler := s.Handler()
+	asAdmin := func(r *http.Request) *http.Request {
+		r.Header.Set("Authorization", "Bearer admin-token")
+		return r
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"delete_vm","target":"vm/101","params":{"node":"pve"},"hold":true}`))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for held request, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var held struct {
+		Status string `json:"status"`
+		HoldID string `json:"hold_id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &held); err != nil || held.Status != "held" || held.HoldID == "" {
+		t.Fatalf("unexpected held response %q (%v)", rr.Body.String(), err)
+	}
+	if client.calls != 0 {
+		t.Fatalf("expected no upstream call while held, got %d", client.calls)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/v1/approvals", ""))
+	if rr.Code != http.StatusOK || !json.Valid(rr.Body.Bytes()) {
+		t.Fatalf("expected held list, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	release := `{"approved_by":"ops-lead","approval_ticket":"CHG-1234","reason":"approved in change review"}`
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newAuthedRequest(http.MethodPost, "/v1/approvals/"+held.HoldID, release))
+	if rr.Code != http.StatusUnauthorized || client.calls != 0 {
+		t.Fatalf("expected the API token not to release its own hold, got %d with %d calls", rr.Code, client.calls)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, asAdmin(newAuthedRequest(http.MethodPost, "/v1/approvals/"+held.HoldID, release)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected release to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if client.calls != 1 {
+		t.Fatalf("expected release to execute once, got %d calls", client.calls)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, asAdmin(newAuthedRequest(http.MethodPost, "/v1/approvals/"+held.HoldID, `{"approved_by":"ops-lead"}`)))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected released hold to be gone, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/actions"
+)
+
+// auditCSVHeader names the columns of the CSV audit export.
+var auditCSVHeader = []string{"ts", "actor", "kind", "environment", "action", "target", "allowed", "risk", "reason"}
+
+// auditFilter selects audit records by exact field match and time window.
+// Empty fields match everything.
+type auditFilter struct {
+	environment string
+	actor       string
+	kind        string
+	action      string
+	since       time.Time
+	until       time.Time
+}
+
+func parseAuditFilter(r *http.Request) (auditFilter, error) {
+	q := r.URL.Query()
+	filter := auditFilter{
+		environment: strings.TrimSpace(q.Get("environment")),
+		actor:       strings.TrimSpace(q.Get("actor")),
+		kind:        strings.TrimSpace(q.Get("kind")),
+		action:      strings.TrimSpace(q.Get("action")),
+	}
+	for name, dst := range map[string]*time.Time{"since": &filter.since, "until": &filter.until} {
+		raw := strings.TrimSpace(q.Get(name))
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return auditFilter{}, errors.New(name + " must be RFC3339 format")
+		}
+		*dst = t
+	}
+	return filter, nil
+}
+
+func (f auditFilter) matches(record actions.AuditRecord) bool {
+	if f.environment != "" && record.Request.Environment != f.environment {
+		return false
+	}
+	if f.actor != "" && record.Actor != f.actor {
+		return false
+	}
+	if f.kind != "" && record.Kind != f.kind {
+		return false
+	}
+	if f.action != "" && record.Request.Action != f.action {
+		return false
+	}
+	if !f.since.IsZero() || !f.until.IsZero() {
+		ts, err := time.Parse(time.RFC3339, record.TS)
+		if err != nil {
+			return false
+		}
+		if !f.since.IsZero() && ts.Before(f.since) {
+			return false
+		}
+		if !f.until.IsZero() && ts.After(f.until) {
+			return false
+		}
+	}
+	return true
+}
+
+// auditExporter writes matching audit records in one export format.
+// begin runs once before the first record, or before finish when none match.
+type auditExporter interface {
+	begin() error
+	record(actions.AuditRecord) error
+	finish() error
+}
+
+// csvAuditExporter writes the auditCSVHeader columns, one row per record.
+type csvAuditExporter struct {
+	w *csv.Writer
+}
+
+func (e csvAuditExporter) begin() error {
+	return e.w.Write(auditCSVHeader)
+}
+
+func (e csvAuditExporter) record(record actions.AuditRecord) error {
+	return e.w.Write([]string{
+		csvCell(record.TS),
+		csvCell(record.Actor),
+		csvCell(record.Kind),
+		csvCell(record.Request.Environment),
+		csvCell(record.Request.Action),
+		csvCell(record.Request.Target),
+		strconv.FormatBool(record.Decision.Allowed),
+		csvCell(record.Decision.RiskLevel),
+		csvCell(record.Decision.Reason),
+	})
+}
+
+func (e csvAuditExporter) finish() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvCell defuses values a spreadsheet would run as a formula, such as an
+// actor or reason a caller chose, by prefixing them with a quote.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// jsonAuditExporter writes one JSON object per record (NDJSON).
+type jsonAuditExporter struct {
+	enc *json.Encoder
+}
+
+func (jsonAuditExporter) begin() error { return nil }
+
+func (e jsonAuditExporter) record(record actions.AuditRecord) error {
+	return e.enc.Encode(record)
+}
+
+func (jsonAuditExporter) finish() error { return nil }
+
+// auditExportCSV streams audit records as CSV for reviewers who work in
+// spreadsheets.
+func (s *Server) auditExportCSV(w http.ResponseWriter, r *http.Request) {
+	s.auditExport(w, r, "text/csv; charset=utf-8", "audit.csv", csvAuditExporter{w: csv.NewWriter(w)})
+}
+
+// auditExportJSON streams audit records as NDJSON, with the same fields and
+// filters as the CSV export, for tools rather than spreadsheets.
+func (s *Server) auditExportJSON(w http.ResponseWriter, r *http.Request) {
+	s.auditExport(w, r, "application/x-ndjson", "audit.ndjson", jsonAuditExporter{enc: json.NewEncoder(w)})
+}
+
+// auditExport streams the records matching the request's environment,
+// actor, kind, action, since and until filters through exp. It is gated by
+// the admin token.
+func (s *Server) auditExport(w http.ResponseWriter, r *http.Request, contentType, filename string, exp auditExporter) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	begun := false
+	err = s.runner.AuditRecords(func(record actions.AuditRecord) error {
+		if !begun {
+			begun = true
+			if err := exp.begin(); err != nil {
+				return err
+			}
+		}
+		if !filter.matches(record) {
+			return nil
+		}
+		return exp.record(record)
+	})
+	if err != nil && !begun {
+		status := http.StatusInternalServerError
+		if errors.Is(err, actions.ErrAuditDisabled) {
+			status = http.StatusNotFound
+		}
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "audit export: "+err.Error(), status)
+		return
+	}
+	if !begun {
+		err = exp.begin()
+	}
+	if finishErr := exp.finish(); err == nil {
+		err = finishErr
+	}
+	if err != nil {
+		// Records are already on the wire; a truncated export is all the
+		// caller can be told.
+		log.Printf("audit export: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/actions"
+	"github.com/junlov/proxmox-ai/internal/config"
+	"github.com/junlov/proxmox-ai/internal/policy"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+func TestAuditExportCSVEscapesFieldsAndFilters(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	runner := actions.NewRunner(policy.NewEngine(policy.WithDenyMessage("see runbook, section 4, before retrying")), &testClient{}, auditPath)
+	defer runner.Close()
+	s := New(config.Config{Environments: []config.Environment{{Name: "home"}, {Name: "lab"}}}, runner)
+	s.adminToken = "admin-token"
+
+	if _, err := runner.Apply(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionDeleteVM, Target: "vm/101", Actor: "ops-bot", Params: map[string]any{"node": "pve"}}); err == nil {
+		t.Fatal("expected unapproved delete to be denied")
+	}
+	if _, err := runner.Plan(proxmox.ActionRequest{Environment: "lab", Action: proxmox.ActionStartVM, Target: "vm/102", Actor: "ops-bot", Params: map[string]any{"node": "pve"}}); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/audit/export.csv?environment=home", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Fatalf("expected text/csv, got %q", ct)
+	}
+
+	body := rr.Body.String()
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if lines[0] != "ts,actor,kind,environment,action,target,allowed,risk,reason" {
+		t.Fatalf("unexpected CSV header: %q", lines[0])
+	}
+	if len(lines) != 2 {
+		t.Fatalf("expected the header and one filtered row, got %q", lines)
+	}
+	if !strings.HasSuffix(lines[1], `,false,high,"approval required before apply: see runbook, section 4, before retrying"`) {
+		t.Fatalf("expected the reason to be quoted, got %q", lines[1])
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatalf("parse CSV: %v", err)
+	}
+	row := rows[1]
+	if row[1] != "ops-bot" || row[2] != "apply_denied" || row[3] != "home" || row[4] != "delete_vm" || row[5] != "vm/101" {
+		t.Fatalf("unexpected row: %q", row)
+	}
+	if row[8] != "approval required before apply: see runbook, section 4, before retrying" {
+		t.Fatalf("expected reason to round-trip, got %q", row[8])
+	}
+}
+
+func TestAuditExportCSVDefusesFormulasAndJSONExportFilters(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	runner := actions.NewRunner(policy.NewEngine(), &testClient{}, auditPath)
+	defer runner.Close()
+	s := New(config.Config{Environments: []config.Environment{{Name: "home"}, {Name: "lab"}}}, runner)
+	s.adminToken = "admin-token"
+
+	for _, env := range []string{"home", "lab"} {
+		if _, err := runner.Plan(proxmox.ActionRequest{Environment: env, Action: proxmox.ActionStartVM, Target: "vm/102", Actor: `=HYPERLINK("http://evil")`, Params: map[string]any{"node": "pve"}}); err != nil {
+			t.Fatalf("Plan returned error: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/audit/export.csv?environment=home", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	rows, err := csv.NewReader(strings.NewReader(rr.Body.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("parse CSV: %v", err)
+	}
+	if len(rows) != 2 || rows[1][1] != `'=HYPERLINK("http://evil")` {
+		t.Fatalf("expected the formula actor to be prefixed with a quote, got %q", rows)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/v1/audit/export.json?environment=lab", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("expected an NDJSON export, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one filtered record, got %q", lines)
+	}
+	var record actions.AuditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("decode exported record: %v", err)
+	}
+	if record.Request.Environment != "lab" || record.Actor != `=HYPERLINK("http://evil")` {
+		t.Fatalf("expected the lab record unchanged, got %+v", record)
+	}
+}
+
+func TestAuditExportCSVRequiresAdminToken(t *testing.T) {
+	s := newTestServer(&testClient{})
+	s.adminToken = "admin-token"
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/v1/audit/export.csv", ""))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for the API token, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/actions"
+)
+
+// auditHealth reports the audit writer's backlog and failed writes so an
+// operator can see it falling behind before writers start to block.
+func (s *Server) auditHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireAuth(w, r); !ok {
+		return
+	}
+	health, err := s.runner.AuditHealth()
+	if errors.Is(err, actions.ErrAuditDisabled) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	body := map[string]any{
+		"queue_depth":    health.QueueDepth,
+		"queue_capacity": health.QueueCapacity,
+		"failed_writes":  health.FailedWrites,
+	}
+	if !health.LastWrite.IsZero() {
+		body["last_write"] = health.LastWrite.Format(time.RFC3339Nano)
+	}
+	s.writeJSON(w, http.StatusOK, body)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/actions"
+	"github.com/junlov/proxmox-ai/internal/policy"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+func TestAuditHealthReportsWriterState(t *testing.T) {
+	s := newTestServer(&testClient{})
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/v1/audit/health", ""))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 without an audit log, got %d", rr.Code)
+	}
+
+	s.runner = actions.NewRunner(policy.NewEngine(), &testClient{}, filepath.Join(t.TempDir(), "audit.log"))
+	defer s.runner.Close()
+	if _, err := s.runner.Plan(proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/101", Params: map[string]any{"node": "pve"}}); err != nil {
+		t.Fatalf("Plan returned error: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/v1/audit/health", ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		QueueDepth    int64  `json:"queue_depth"`
+		QueueCapacity int    `json:"queue_capacity"`
+		FailedWrites  int64  `json:"failed_writes"`
+		LastWrite     string `json:"last_write"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response JSON: %v", err)
+	}
+	if body.QueueDepth != 0 || body.QueueCapacity == 0 || body.FailedWrites != 0 || body.LastWrite == "" {
+		t.Fatalf("unexpected audit health: %+v", body)
+	}
+}
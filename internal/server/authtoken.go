@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+)
+
+// authTokens holds the API bearer token. After a rotation the previous
+// token keeps working until previousUntil so in-flight clients can switch
+// over without failed requests.
+type authTokens struct {
+	mu            sync.RWMutex
+	current       string
+	previous      string
+	previousUntil time.Time
+	overlap       time.Duration
+	now           func() time.Time
+}
+
+func newAuthTokens(token string, overlap time.Duration) *authTokens {
+	return &authTokens{current: token, overlap: overlap, now: time.Now}
+}
+
+func (t *authTokens) Set(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current = token
+	t.previous = ""
+	t.previousUntil = time.Time{}
+}
+
+func (t *authTokens) Configured() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.current != ""
+}
+
+// Rotate installs token as current and returns when the previous one stops
+// being accepted.
+func (t *authTokens) Rotate(token string) (time.Time, error) {
+	if token == "" {
+		return time.Time{}, errors.New("new token is empty")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if token == t.current {
+		return time.Time{}, errors.New("new token matches the current token")
+	}
+	t.previous = t.current
+	t.previousUntil = t.now().Add(t.overlap)
+	t.current = token
+	return t.previousUntil, nil
+}
+
+func (t *authTokens) Match(token string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.current != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t.current)) == 1 {
+		return true
+	}
+	return t.previous != "" && t.now().Before(t.previousUntil) &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(t.previous)) == 1
+}
+
+// loadAuthToken reads the API token from auth_token_file when configured and
+// from PROXMOX_AGENT_API_TOKEN otherwise.
+func loadAuthToken(cfg config.Config) (string, error) {
+	if cfg.AuthTokenFile == "" {
+		return strings.TrimSpace(os.Getenv("PROXMOX_AGENT_API_TOKEN")), nil
+	}
+	raw, err := os.ReadFile(cfg.AuthTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("read auth_token_file: %w", err)
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
+
+// reloadAuthToken re-reads the API token and rotates to it. It is gated by
+// PROXMOX_AGENT_ADMIN_TOKEN, which is separate from the API token and is
+// disabled when unset.
+func (s *Server) reloadAuthToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	next, err := loadAuthToken(s.cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	previousUntil, err := s.tokens.Rotate(next)
+	if err != nil {
+		http.Error(w, "token not rotated: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{
+		"rotated":              true,
+		"previous_valid_until": previousUntil.UTC().Format(time.RFC3339),
+	})
+}
+
+// requireAdmin checks the bearer token against PROXMOX_AGENT_ADMIN_TOKEN.
+// Admin endpoints are disabled when it is unset.
+func (s *Server) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.adminToken == "" {
+		http.Error(w, "admin token is not configured", http.StatusServiceUnavailable)
+		return false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(r.Header.Get("Authorization")), "Bearer "))
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		http.Error(w, "invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
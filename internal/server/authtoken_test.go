@@ -0,0 +1,94 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+)
+
+func TestReloadAuthTokenAcceptsOldTokenDuringOverlapOnly(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "api-token")
+	if err := os.WriteFile(tokenFile, []byte("new-token\n"), 0o600); err != nil {
+		t.Fatalf("write token file: %v", err)
+	}
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.AuthTokenFile = tokenFile
+		cfg.TokenRotationOverlapSeconds = 30
+	})
+	s.adminToken = "admin-token"
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.tokens.now = func() time.Time { return now }
+
+	nodesWith := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/nodes?environment=home", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	reload := httptest.NewRequest(http.MethodPost, "/v1/admin/token/reload", nil)
+	reload.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, reload)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected reload to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if code := nodesWith("new-token"); code != http.StatusOK {
+		t.Fatalf("expected new token accepted, got %d", code)
+	}
+	if code := nodesWith("test-token"); code != http.StatusOK {
+		t.Fatalf("expected old token accepted during overlap, got %d", code)
+	}
+
+	now = now.Add(31 * time.Second)
+	if code := nodesWith("test-token"); code != http.StatusUnauthorized {
+		t.Fatalf("expected old token rejected after overlap, got %d", code)
+	}
+	if code := nodesWith("new-token"); code != http.StatusOK {
+		t.Fatalf("expected new token still accepted, got %d", code)
+	}
+}
+
+func TestReloadAuthTokenRequiresAdminToken(t *testing.T) {
+	s := newTestServer(&testClient{})
+	s.adminToken = "admin-token"
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/admin/token/reload", nil)
+	req.Header.Set("Authorization", "Bearer test-token")
+	rr := httptest.NewRecorder()
+	s.reloadAuthToken(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected API token to be refused for reload, got %d", rr.Code)
+	}
+}
+
+func TestReloadAuthTokenRejectsEmptyToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "api-token")
+	if err := os.WriteFile(tokenFile, []byte("  \n"), 0o600); err != nil {
+		t.Fatalf("write token file: %v", err)
+	}
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.AuthTokenFile = tokenFile
+	})
+	s.adminToken = "admin-token"
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/admin/token/reload", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	s.reloadAuthToken(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for empty token, got %d", rr.Code)
+	}
+	if !s.tokens.Match("test-token") {
+		t.Fatal("expected current token to remain valid after rejected reload")
+	}
+}
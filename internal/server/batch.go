@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/junlov/proxmox-ai/internal/actions"
+	"github.com/junlov/proxmox-ai/internal/config"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// batchItemResult reports one item of a batch. Code is the HTTP status the
+// item would have received on its own.
+type batchItemResult struct {
+	Index    int                    `json:"index"`
+	Status   string                 `json:"status"`
+	Code     int                    `json:"code"`
+	Error    string                 `json:"error,omitempty"`
+	Response *actions.ApplyResponse `json:"response,omitempty"`
+}
+
+func (s *Server) maxBatchItems() int {
+	if s.cfg.MaxBatchItems > 0 {
+		return s.cfg.MaxBatchItems
+	}
+	return config.DefaultMaxBatchItems
+}
+
+func (s *Server) checkBatchSize(n int) error {
+	if n == 0 {
+		return fmt.Errorf("batch must contain at least one item")
+	}
+	if limit := s.maxBatchItems(); n > limit {
+		return fmt.Errorf("batch contains %d items; maximum is %d", n, limit)
+	}
+	return nil
+}
+
+// batch applies several action requests in order. Every item is validated
+// before any of them executes, so a malformed or oversized batch has no side
+// effects.
+func (s *Server) batch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor, ok := s.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	if !requireJSONContentType(w, r) {
+		return
+	}
+	if !s.requireIdempotencyKey(w, r) {
+		return
+	}
+	var body struct {
+		Requests []json.RawMessage `json:"requests"`
+	}
+	if err := decodeStrictJSON(r, &body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkBatchSize(len(body.Requests)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clientIP := s.clientIP.Resolve(r)
+	principal := onBehalfOf(r)
+	reqs := make([]proxmox.ActionRequest, 0, len(body.Requests))
+	for i, raw := range body.Requests {
+		req, dryRunSet, err := decodeActionRequestBytes(raw)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("requests[%d]: invalid JSON body", i), http.StatusBadRequest)
+			return
+		}
+		s.applyRequestDefaults(&req, dryRunSet)
+		if err := s.validator.ValidateActionRequest(req); err != nil {
+			http.Error(w, fmt.Sprintf("requests[%d]: %s", i, err.Error()), http.StatusBadRequest)
+			return
+		}
+		req.Actor = actor
+		req.OnBehalfOf = principal
+		req.ClientIP = clientIP
+		req.RequestID = requestID(r)
+		req.Context = r.Context()
+		identity, status, err := s.resolveTokenIdentity(r, req.Environment)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("requests[%d]: %s", i, err.Error()), status)
+			return
+		}
+		req.TokenIdentity = identity
+		reqs = append(reqs, req)
+	}
+
+	key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+	var hash string
+	if key != "" {
+		var err error
+		hash, err = s.idem.HashBatch(reqs)
+		if err != nil {
+			log.Printf("idempotency: hash batch for key %q: %v", key, err)
+			http.Error(w, "failed to hash request", http.StatusInternalServerError)
+			return
+		}
+		if rec, ok := s.idem.Get(r.URL.Path, key); ok {
+			if rec.payloadHash != hash {
+				http.Error(w, "idempotency key reused with different payload", http.StatusConflict)
+				return
+			}
+			s.writeRaw(w, rec.statusCode, rec.contentType, rec.body)
+			return
+		}
+	}
+
+	results := make([]batchItemResult, 0, len(reqs))
+	for i, req := range reqs {
+		_, resp, code, err := s.planThenApply(req)
+		if err != nil {
+			results = append(results, batchItemResult{Index: i, Status: "error", Code: code, Error: err.Error()})
+			continue
+		}
+		results = append(results, batchItemResult{Index: i, Status: "ok", Code: http.StatusOK, Response: &resp})
+	}
+	codes := make([]int, len(results))
+	for i, result := range results {
+		codes[i] = result.Code
+	}
+	status := aggregateStatus(codes)
+	respBody, contentType := marshalJSONBody(map[string]any{"results": results})
+	s.writeRaw(w, status, contentType, respBody)
+	if key != "" {
+		s.idem.Put(r.URL.Path, key, idempotencyRecord{
+			payloadHash: hash,
+			statusCode:  status,
+			contentType: contentType,
+			body:        respBody,
+		})
+	}
+}
+
+// bulkTaskConcurrency bounds how many task status reads one bulk call issues
+// to Proxmox at a time.
+const bulkTaskConcurrency = 8
+
+type bulkTaskResult struct {
+	Code   int                 `json:"code"`
+	Status *proxmox.TaskStatus `json:"status,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+// aggregateStatus is the status for a response covering items with the
+// given statuses: their shared status when they agree, so an all-successful
+// call is 200 and a uniformly failing one carries that error, and 207
+// Multi-Status when some succeeded and some did not. When none succeeded it
+// is the highest failing status, so a server error outranks a client one
+// and the call never looks partly successful.
+func aggregateStatus(codes []int) int {
+	mixed, succeeded, worst := false, false, 0
+	for _, code := range codes {
+		mixed = mixed || code != codes[0]
+		if code < http.StatusBadRequest {
+			succeeded = true
+		}
+		worst = max(worst, code)
+	}
+	switch {
+	case !mixed:
+		return codes[0]
+	case succeeded:
+		return http.StatusMultiStatus
+	default:
+		return worst
+	}
+}
+
+// bulkTaskStatus looks up several UPIDs on one node concurrently. A failed
+// lookup is reported against its UPID and does not fail the whole call.
+// Each lookup takes the shared read path, so the call is shed, captured and
+// replayed like any other read; a UPID may appear only once.
+func (s *Server) bulkTaskStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor, ok := s.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	if !requireJSONContentType(w, r) {
+		return
+	}
+	var body struct {
+		Environment string   `json:"environment"`
+		Node        string   `json:"node"`
+		UPIDs       []string `json:"upids"`
+	}
+	if err := decodeStrictJSON(r, &body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	body.Environment = strings.TrimSpace(body.Environment)
+	body.Node = strings.TrimSpace(body.Node)
+	if body.Environment == "" || body.Node == "" {
+		http.Error(w, "environment and node are required", http.StatusBadRequest)
+		return
+	}
+	if err := s.checkBatchSize(len(body.UPIDs)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clientIP := s.clientIP.Resolve(r)
+	reqs := make([]proxmox.ActionRequest, len(body.UPIDs))
+	seen := make(map[string]int, len(body.UPIDs))
+	for i, upid := range body.UPIDs {
+		upid = strings.TrimSpace(upid)
+		if upid == "" {
+			http.Error(w, fmt.Sprintf("upids[%d]: upid is required", i), http.StatusBadRequest)
+			return
+		}
+		// Results are keyed by UPID, so a repeat would silently collapse
+		// into one entry.
+		if first, dup := seen[upid]; dup {
+			http.Error(w, fmt.Sprintf("upids[%d]: duplicate of upids[%d]", i, first), http.StatusBadRequest)
+			return
+		}
+		seen[upid] = i
+		reqs[i] = proxmox.ActionRequest{
+			Environment: body.Environment,
+			Action:      proxmox.ActionReadTaskStatus,
+			Target:      "task/status",
+			Params:      map[string]any{"node": body.Node, "upid": upid},
+			Actor:       actor,
+			ClientIP:    clientIP,
+			RequestID:   requestID(r),
+		}
+	}
+	ptrs := make([]*proxmox.ActionRequest, len(reqs))
+	for i := range reqs {
+		ptrs[i] = &reqs[i]
+	}
+	if !s.prepareReads(w, r, ptrs...) {
+		return
+	}
+
+	calls := fanOut(r.Context(), reqs, bulkTaskConcurrency, func(_ context.Context, req proxmox.ActionRequest) (*dedupCall, error) {
+		return s.executeRead(r, req), nil
+	})
+	results := make(map[string]bulkTaskResult, len(reqs))
+	codes := make([]int, len(reqs))
+	for i, res := range calls {
+		upid := reqs[i].Params["upid"].(string)
+		call := res.Value
+		if res.Err != nil {
+			// Skipped because the caller went away before it started.
+			call = &dedupCall{status: applyErrorStatus(res.Err), err: res.Err}
+		}
+		if call.err != nil {
+			codes[i] = call.status
+			results[upid] = bulkTaskResult{Code: codes[i], Error: call.err.Error()}
+			continue
+		}
+		status, ok := call.apply.Result.Data.(proxmox.TaskStatus)
+		if !ok {
+			codes[i] = http.StatusBadGateway
+			results[upid] = bulkTaskResult{Code: codes[i], Error: "unexpected task status response"}
+			continue
+		}
+		codes[i] = http.StatusOK
+		results[upid] = bulkTaskResult{Code: http.StatusOK, Status: &status}
+	}
+	s.writeReadStatusJSON(w, r, reqs, aggregateStatus(codes), map[string]any{
+		"environment": body.Environment,
+		"node":        body.Node,
+		"tasks":       results,
+	})
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/actions"
+	"github.com/junlov/proxmox-ai/internal/config"
+	"github.com/junlov/proxmox-ai/internal/policy"
+)
+
+func batchBody(n int) string {
+	items := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		items = append(items, fmt.Sprintf(`{"environment":"home","action":"start_vm","target":"vm/%d","params":{"node":"pve"}}`, 100+i))
+	}
+	return `{"requests":[` + strings.Join(items, ",") + `]}`
+}
+
+func TestBatchRejectsRequestsOverCap(t *testing.T) {
+	client := &testClient{}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.MaxBatchItems = 2
+	})
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/batch", batchBody(3))
+	rr := httptest.NewRecorder()
+
+	s.batch(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "batch contains 3 items; maximum is 2") {
+		t.Fatalf("unexpected error message: %q", rr.Body.String())
+	}
+	if got := atomic.LoadInt32(&client.calls); got != 0 {
+		t.Fatalf("expected no execution for oversized batch, got %d", got)
+	}
+}
+
+func TestBatchAppliesEachRequest(t *testing.T) {
+	client := &testClient{}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.MaxBatchItems = 2
+	})
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/batch", batchBody(2))
+	rr := httptest.NewRecorder()
+
+	s.batch(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := atomic.LoadInt32(&client.calls); got != 2 {
+		t.Fatalf("expected 2 execution calls, got %d", got)
+	}
+}
+
+func TestBatchIdempotencyKeyIsRequiredAndReplays(t *testing.T) {
+	client := &testClient{}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.RequireIdempotencyKey = true
+	})
+
+	rr := httptest.NewRecorder()
+	s.batch(rr, newAuthedRequest(http.MethodPost, "/v1/actions/batch", batchBody(2)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without Idempotency-Key, got %d", rr.Code)
+	}
+
+	var bodies []string
+	for i := 0; i < 2; i++ {
+		req := newAuthedRequest(http.MethodPost, "/v1/actions/batch", batchBody(2))
+		req.Header.Set("Idempotency-Key", "batch-key")
+		rr := httptest.NewRecorder()
+		s.batch(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		bodies = append(bodies, rr.Body.String())
+	}
+	if bodies[0] != bodies[1] {
+		t.Fatalf("expected identical replay, got %q and %q", bodies[0], bodies[1])
+	}
+	if got := atomic.LoadInt32(&client.calls); got != 2 {
+		t.Fatalf("expected the batch to execute once (2 calls), got %d", got)
+	}
+
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/batch", batchBody(1))
+	req.Header.Set("Idempotency-Key", "batch-key")
+	rr = httptest.NewRecorder()
+	s.batch(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a reused key with a different batch, got %d", rr.Code)
+	}
+}
+
+func TestBulkTaskStatusReportsEachUPID(t *testing.T) {
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "UPID:pve1:0001"):
+			_, _ = w.Write([]byte(`{"data":{"upid":"UPID:pve1:0001","status":"stopped","exitstatus":"OK"}}`))
+		case strings.Contains(r.URL.Path, "UPID:pve1:0002"):
+			_, _ = w.Write([]byte(`{"data":{"upid":"UPID:pve1:0002","status":"running"}}`))
+		default:
+			http.Error(w, `{"errors":{"upid":"no such task"}}`, http.StatusInternalServerError)
+		}
+	})
+	s := newTestServer(client)
+	req := newAuthedRequest(http.MethodPost, "/v1/tasks/status/bulk", `{"environment":"home","node":"pve1","upids":["UPID:pve1:0001","UPID:pve1:0002","UPID:pve1:0003"]}`)
+	rr := httptest.NewRecorder()
+
+	s.bulkTaskStatus(rr, req)
+
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("expected 207 for mixed results, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Tasks map[string]bulkTaskResult `json:"tasks"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response JSON: %v", err)
+	}
+	if len(body.Tasks) != 3 {
+		t.Fatalf("expected 3 task results, got %d", len(body.Tasks))
+	}
+	if done := body.Tasks["UPID:pve1:0001"]; done.Status == nil || !done.Status.Succeeded {
+		t.Fatalf("expected succeeded task, got %+v", done)
+	}
+	if running := body.Tasks["UPID:pve1:0002"]; running.Status == nil || !running.Status.Running {
+		t.Fatalf("expected running task, got %+v", running)
+	}
+	if done := body.Tasks["UPID:pve1:0001"]; done.Code != http.StatusOK {
+		t.Fatalf("expected code 200 for a found task, got %d", done.Code)
+	}
+	if failed := body.Tasks["UPID:pve1:0003"]; failed.Status != nil || failed.Error == "" || failed.Code == http.StatusOK {
+		t.Fatalf("expected per-upid error, got %+v", failed)
+	}
+}
+
+func TestBulkTaskStatusRejectsRepeatedUPIDs(t *testing.T) {
+	client := &testClient{}
+	s := newTestServer(client)
+	rr := httptest.NewRecorder()
+
+	s.bulkTaskStatus(rr, newAuthedRequest(http.MethodPost, "/v1/tasks/status/bulk", `{"environment":"home","node":"pve1","upids":["UPID:pve1:0001","UPID:pve1:0002"," UPID:pve1:0001"]}`))
+
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "upids[2]: duplicate of upids[0]") {
+		t.Fatalf("expected 400 naming the repeated UPID, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if atomic.LoadInt32(&client.calls) != 0 {
+		t.Fatalf("expected no lookups for a rejected call, got %d", client.calls)
+	}
+}
+
+func TestBulkTaskStatusReplaysIdempotentRetries(t *testing.T) {
+	var lookups atomic.Int32
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		lookups.Add(1)
+		_, _ = w.Write([]byte(`{"data":{"status":"running"}}`))
+	})
+	s := newTestServer(client)
+	body := `{"environment":"home","node":"pve1","upids":["UPID:pve1:0001","UPID:pve1:0002"]}`
+
+	var first string
+	for i := 0; i < 2; i++ {
+		req := newAuthedRequest(http.MethodPost, "/v1/tasks/status/bulk", body)
+		req.Header.Set("Idempotency-Key", "bulk-key")
+		rr := httptest.NewRecorder()
+		s.bulkTaskStatus(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("attempt %d: expected 200, got %d: %s", i+1, rr.Code, rr.Body.String())
+		}
+		if i == 0 {
+			first = rr.Body.String()
+		} else if rr.Body.String() != first {
+			t.Fatalf("expected the retry to replay %q, got %q", first, rr.Body.String())
+		}
+	}
+	if got := lookups.Load(); got != 2 {
+		t.Fatalf("expected the lookups to run once (2 upstream calls), got %d", got)
+	}
+}
+
+func TestAggregateStatusFailsWhenNoItemSucceeded(t *testing.T) {
+	if got := aggregateStatus([]int{http.StatusOK, http.StatusForbidden}); got != http.StatusMultiStatus {
+		t.Fatalf("expected 207 for a partial success, got %d", got)
+	}
+	if got := aggregateStatus([]int{http.StatusForbidden, http.StatusBadGateway, http.StatusNotFound}); got != http.StatusBadGateway {
+		t.Fatalf("expected 502 when every item failed, got %d", got)
+	}
+	if got := aggregateStatus([]int{http.StatusForbidden, http.StatusNotFound}); got != http.StatusNotFound {
+		t.Fatalf("expected a 4xx when every item failed on the client side, got %d", got)
+	}
+}
+
+func TestBatchReportsMultiStatusForMixedResults(t *testing.T) {
+	s := newTestServer(&testClient{})
+	body := `{"requests":[` +
+		`{"environment":"home","action":"start_vm","target":"vm/100","params":{"node":"pve"}},` +
+		`{"environment":"home","action":"delete_vm","target":"vm/101","params":{"node":"pve"}}]}`
+
+	rr := httptest.NewRecorder()
+	s.batch(rr, newAuthedRequest(http.MethodPost, "/v1/actions/batch", body))
+	if rr.Code != http.StatusMultiStatus {
+		t.Fatalf("expected 207, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Results []batchItemResult `json:"results"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response JSON: %v", err)
+	}
+	if len(resp.Results) != 2 {
+		t.Fatalf("expected 2 results, got %+v", resp.Results)
+	}
+	if ok := resp.Results[0]; ok.Status != "ok" || ok.Code != http.StatusOK {
+		t.Fatalf("expected start_vm to succeed, got %+v", ok)
+	}
+	if denied := resp.Results[1]; denied.Status != "error" || denied.Code != http.StatusForbidden {
+		t.Fatalf("expected delete_vm to be denied with 403, got %+v", denied)
+	}
+}
+
+func TestBatchReportsSharedErrorWhenEveryItemFails(t *testing.T) {
+	s := newTestServer(&testClient{})
+	body := `{"requests":[` +
+		`{"environment":"home","action":"delete_vm","target":"vm/100","params":{"node":"pve"}},` +
+		`{"environment":"home","action":"delete_vm","target":"vm/101","params":{"node":"pve"}}]}`
+
+	rr := httptest.NewRecorder()
+	s.batch(rr, newAuthedRequest(http.MethodPost, "/v1/actions/batch", body))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 when every item is denied, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestBatchPlansEachItemAndHonorsTokenIdentity(t *testing.T) {
+	client := &testClient{}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.Environments[0].TokenIdentities = map[string]config.TokenIdentity{
+			"tenant-a": {TokenID: "tenant-a@pve!gateway", TokenSecretEnv: "PVE_TENANT_A_SECRET"},
+		}
+	})
+	s.adminToken = "admin-secret"
+	s.runner = actions.NewRunner(policy.NewEngine(), client, filepath.Join(t.TempDir(), "audit.log"))
+	defer s.runner.Close()
+
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/batch", batchBody(2))
+	req.Header.Set("X-Proxmox-Token-Identity", "tenant-a")
+	req.Header.Set("X-Admin-Token", "admin-secret")
+	rr := httptest.NewRecorder()
+	s.batch(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if client.lastReq.TokenIdentity != "tenant-a" {
+		t.Fatalf("expected batch items to carry the token identity, got %q", client.lastReq.TokenIdentity)
+	}
+	var kinds []string
+	if err := s.runner.AuditRecords(func(record actions.AuditRecord) error {
+		kinds = append(kinds, record.Kind)
+		return nil
+	}); err != nil {
+		t.Fatalf("AuditRecords returned error: %v", err)
+	}
+	if strings.Join(kinds, ",") != "plan,apply,plan,apply" {
+		t.Fatalf("expected each item to be planned then applied, got %v", kinds)
+	}
+
+	req = newAuthedRequest(http.MethodPost, "/v1/actions/batch", batchBody(1))
+	req.Header.Set("X-Proxmox-Token-Identity", "tenant-b")
+	req.Header.Set("X-Admin-Token", "admin-secret")
+	rr = httptest.NewRecorder()
+	s.batch(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an unknown token identity, got %d", rr.Code)
+	}
+}
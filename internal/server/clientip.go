@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// cidrSet is a set of networks from config. Entries that do not parse are
+// skipped; config.Load has already rejected them.
+type cidrSet []*net.IPNet
+
+func parseCIDRSet(cidrs []string) cidrSet {
+	set := make(cidrSet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			continue
+		}
+		set = append(set, network)
+	}
+	return set
+}
+
+// contains reports whether ip falls in one of the networks; an address that
+// does not parse is in none of them.
+func (s cidrSet) contains(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range s {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+type clientIPResolver struct {
+	trusted cidrSet
+}
+
+func newClientIPResolver(cidrs []string) *clientIPResolver {
+	return &clientIPResolver{trusted: parseCIDRSet(cidrs)}
+}
+
+// Resolve returns the client IP for r. X-Forwarded-For is only consulted when
+// the immediate peer is a trusted proxy; the chain is walked right to left and
+// the first address that is not itself a trusted proxy wins.
+func (c *clientIPResolver) Resolve(r *http.Request) string {
+	peer := remoteIP(r.RemoteAddr)
+	if !c.trusted.contains(peer) {
+		return peer
+	}
+	forwarded := r.Header.Values("X-Forwarded-For")
+	var hops []string
+	for _, value := range forwarded {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			break
+		}
+		if !c.trusted.contains(ip.String()) {
+			return ip.String()
+		}
+	}
+	return peer
+}
+
+// sourceAllowlist holds the CIDRs allowed to reach the API at all. An empty
+// list allows everyone.
+type sourceAllowlist struct {
+	allowed cidrSet
+}
+
+func newSourceAllowlist(cidrs []string) *sourceAllowlist {
+	return &sourceAllowlist{allowed: parseCIDRSet(cidrs)}
+}
+
+func (a *sourceAllowlist) permits(ip string) bool {
+	return len(a.allowed) == 0 || a.allowed.contains(ip)
+}
+
+// restrictSources refuses requests from outside allowed_source_cidrs before
+// any handler, and so any token check, runs. Health probes are exempt: they
+// come from the orchestrator, not from API clients, and reveal nothing.
+func (s *Server) restrictSources(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probe := r.URL.Path == "/healthz" || r.URL.Path == "/readyz"
+		if !probe && !s.sources.permits(s.clientIP.Resolve(r)) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+)
+
+func TestClientIPResolverHonorsForwardedForFromTrustedProxy(t *testing.T) {
+	resolver := newClientIPResolver([]string{"10.0.0.0/8"})
+	req := httptest.NewRequest(http.MethodGet, "/v1/nodes", nil)
+	req.RemoteAddr = "10.1.2.3:41000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7, 10.9.9.9")
+
+	if got := resolver.Resolve(req); got != "198.51.100.7" {
+		t.Fatalf("expected forwarded client IP, got %q", got)
+	}
+}
+
+func TestClientIPResolverIgnoresForwardedForFromUntrustedPeer(t *testing.T) {
+	resolver := newClientIPResolver([]string{"10.0.0.0/8"})
+	req := httptest.NewRequest(http.MethodGet, "/v1/nodes", nil)
+	req.RemoteAddr = "203.0.113.5:41000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+
+	if got := resolver.Resolve(req); got != "203.0.113.5" {
+		t.Fatalf("expected peer IP for untrusted source, got %q", got)
+	}
+}
+
+func TestSourceAllowlistAdmitsForwardedClientInRange(t *testing.T) {
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.TrustedProxies = []string{"10.0.0.0/8"}
+		cfg.AllowedSourceCIDRs = []string{"198.51.100.0/24"}
+	})
+	req := newAuthedRequest(http.MethodGet, "/v1/environments", "")
+	req.RemoteAddr = "10.1.2.3:41000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSourceAllowlistRejectsOutsideSourceDespiteValidToken(t *testing.T) {
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.AllowedSourceCIDRs = []string{"198.51.100.0/24"}
+	})
+	req := newAuthedRequest(http.MethodGet, "/v1/environments", "")
+	req.RemoteAddr = "203.0.113.5:41000"
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSourceAllowlistExemptsHealthProbes(t *testing.T) {
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.AllowedSourceCIDRs = []string{"198.51.100.0/24"}
+	})
+	for _, path := range []string{"/healthz", "/readyz"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "203.0.113.5:41000"
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, req)
+		if rr.Code == http.StatusForbidden {
+			t.Fatalf("expected %s to skip the source allowlist, got 403", path)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+	"github.com/junlov/proxmox-ai/internal/redact"
+)
+
+// secretEnvironmentFields name where an environment's token secret comes
+// from; they are left out of the config view altogether.
+var secretEnvironmentFields = []string{"token_secret_env", "token_secret_ref"}
+
+// publicConfigFields match redact.SensitiveKeys by name but hold no secret:
+// switches, durations, action lists, and the already masked token IDs and
+// token identities. Every other matching field is redacted, so a new one
+// stays hidden until it is listed here.
+var publicConfigFields = map[string]bool{
+	"token_id":                       true,
+	"token_identities":               true,
+	"token_rotation_overlap_seconds": true,
+	"require_ticket":                 true,
+	"require_idempotency_key":        true,
+	"approval_ticket_min_risk":       true,
+	"approval_ticket_actions":        true,
+}
+
+// effectiveConfig returns the configuration the agent is running with, as
+// loaded and validated, minus anything that locates a secret. It is gated by
+// the admin token.
+func (s *Server) effectiveConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	view, err := redactedConfig(s.cfg)
+	if err != nil {
+		http.Error(w, "render config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{"config": view})
+}
+
+func redactedConfig(cfg config.Config) (map[string]any, error) {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var view map[string]any
+	if err := json.Unmarshal(raw, &view); err != nil {
+		return nil, err
+	}
+	delete(view, "audit_signing_key_ref")
+	envs, _ := view["environments"].([]any)
+	for _, item := range envs {
+		env, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, field := range secretEnvironmentFields {
+			delete(env, field)
+		}
+		if tokenID, ok := env["token_id"].(string); ok {
+			env["token_id"] = maskTokenID(tokenID)
+		}
+		identities, _ := env["token_identities"].(map[string]any)
+		for _, item := range identities {
+			identity, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			for _, field := range secretEnvironmentFields {
+				delete(identity, field)
+			}
+			if tokenID, ok := identity["token_id"].(string); ok {
+				identity["token_id"] = maskTokenID(tokenID)
+			}
+		}
+		// Gateway headers usually carry keys; show which are set, not their values.
+		if headers, ok := env["extra_headers"].(map[string]any); ok {
+			for name := range headers {
+				headers[name] = redact.Placeholder
+			}
+		}
+	}
+	masked := redact.Map(view, redact.SensitiveKeys)
+	restorePublicFields(masked, view)
+	return masked, nil
+}
+
+// restorePublicFields copies the publicConfigFields that redact.Map hid back
+// from orig into masked, at any depth.
+func restorePublicFields(masked, orig any) {
+	switch typed := masked.(type) {
+	case map[string]any:
+		source, _ := orig.(map[string]any)
+		for key, value := range typed {
+			if publicConfigFields[key] {
+				typed[key] = source[key]
+				continue
+			}
+			restorePublicFields(value, source[key])
+		}
+	case []any:
+		source, _ := orig.([]any)
+		for i, value := range typed {
+			if i < len(source) {
+				restorePublicFields(value, source[i])
+			}
+		}
+	}
+}
+
+// maskTokenID keeps the user@realm part of a Proxmox token ID, which says
+// whose privileges the agent runs with, and hides most of the token name.
+func maskTokenID(tokenID string) string {
+	user, name, ok := strings.Cut(tokenID, "!")
+	if !ok {
+		return maskTail(tokenID)
+	}
+	return user + "!" + maskTail(name)
+}
+
+func maskTail(s string) string {
+	if len(s) <= 2 {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:2] + strings.Repeat("*", len(s)-2)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+)
+
+func TestEffectiveConfigOmitsSecretsAndMasksTokenIDs(t *testing.T) {
+	t.Setenv("PVE_TEST_SECRET", "very-secret-value")
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.MaxBatchItems = 25
+		cfg.AuthTokenFile = "/run/secrets/api-token"
+		cfg.TokenRotationOverlapSeconds = 90
+		cfg.Environments[0].TokenSecretRef = "file:/run/secrets/pve"
+		cfg.Environments[0].ExtraHeaders = map[string]string{"X-Gateway-Key": "gw-secret"}
+	})
+	s.adminToken = "admin-token"
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/config", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	body := rr.Body.String()
+	for _, leaked := range []string{"very-secret-value", "PVE_TEST_SECRET", "/run/secrets/pve", "root@pam!agent", "gw-secret", "/run/secrets/api-token"} {
+		if strings.Contains(body, leaked) {
+			t.Fatalf("config view leaked %q: %s", leaked, body)
+		}
+	}
+	var resp struct {
+		Config struct {
+			MaxBatchItems   int              `json:"max_batch_items"`
+			RotationOverlap int              `json:"token_rotation_overlap_seconds"`
+			Environments    []map[string]any `json:"environments"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Config.MaxBatchItems != 25 || resp.Config.RotationOverlap != 90 {
+		t.Fatalf("expected effective settings in view, got %+v", resp.Config)
+	}
+	env := resp.Config.Environments[0]
+	if env["name"] != "home" || env["token_id"] != "root@pam!ag***" {
+		t.Fatalf("unexpected environment view: %v", env)
+	}
+}
+
+func TestEffectiveConfigMasksTokenIdentities(t *testing.T) {
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.Environments[0].TokenIdentities = map[string]config.TokenIdentity{
+			"ops": {TokenID: "ops@pve!automation", TokenSecretEnv: "PVE_OPS_SECRET"},
+			"ci":  {TokenID: "ci@pve!deploy", TokenSecretRef: "file:/run/secrets/ci"},
+		}
+	})
+	s.adminToken = "admin-token"
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/config", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	body := rr.Body.String()
+	for _, leaked := range []string{"PVE_OPS_SECRET", "/run/secrets/ci", "ops@pve!automation", "ci@pve!deploy"} {
+		if strings.Contains(body, leaked) {
+			t.Fatalf("config view leaked %q: %s", leaked, body)
+		}
+	}
+	var resp struct {
+		Config struct {
+			Environments []struct {
+				TokenIdentities map[string]map[string]any `json:"token_identities"`
+			} `json:"environments"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	identities := resp.Config.Environments[0].TokenIdentities
+	if identities["ops"]["token_id"] != "ops@pve!au********" || identities["ci"]["token_id"] != "ci@pve!de****" {
+		t.Fatalf("expected identities listed with masked token IDs, got %v", identities)
+	}
+}
+
+func TestEffectiveConfigRequiresAdminToken(t *testing.T) {
+	s := newTestServer(&testClient{})
+	s.adminToken = "admin-token"
+
+	req := newAuthedRequest(http.MethodGet, "/v1/config", "")
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for the API token, got %d", rr.Code)
+	}
+}
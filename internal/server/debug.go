@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/junlov/proxmox-ai/internal/redact"
+)
+
+// debugAction shows how a request is decoded, defaulted and validated without
+// planning or executing it. Validation failures are reported in the body
+// rather than as an error status so clients can inspect the resolved form.
+func (s *Server) debugAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor, ok := s.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	if !requireJSONContentType(w, r) {
+		return
+	}
+	req, dryRunSet, err := decodeActionRequest(r)
+	if err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	s.applyRequestDefaults(&req, dryRunSet)
+	req.Actor = actor
+	req.OnBehalfOf = onBehalfOf(r)
+	req.ClientIP = s.clientIP.Resolve(r)
+	req.RequestID = requestID(r)
+
+	body := map[string]any{
+		"actor":     req.Actor,
+		"client_ip": req.ClientIP,
+		"valid":     true,
+	}
+	if req.OnBehalfOf != "" {
+		body["on_behalf_of"] = req.OnBehalfOf
+	}
+	if err := s.validator.ValidateActionRequest(req); err != nil {
+		body["valid"] = false
+		body["error"] = err.Error()
+	}
+	req.Params = redact.Map(req.Params, redact.SensitiveKeys)
+	body["request"] = req
+	s.writeJSON(w, http.StatusOK, body)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+)
+
+func TestDebugActionShowsResolvedRequestWithoutExecuting(t *testing.T) {
+	client := &testClient{}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.DefaultDryRunActions = []string{"delete_vm"}
+	})
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/debug", `{"environment":"home","action":"delete_vm","target":"vm/101","params":{"node":"pve","password":"hunter2"}}`)
+	rr := httptest.NewRecorder()
+
+	s.debugAction(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Actor   string `json:"actor"`
+		Valid   bool   `json:"valid"`
+		Request struct {
+			DryRun bool           `json:"dry_run"`
+			Params map[string]any `json:"params"`
+		} `json:"request"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response JSON: %v", err)
+	}
+	if !body.Valid {
+		t.Fatalf("expected request to be valid: %s", rr.Body.String())
+	}
+	if !body.Request.DryRun {
+		t.Fatal("expected default dry_run to be merged into the resolved request")
+	}
+	if body.Actor != "test-agent" {
+		t.Fatalf("unexpected actor: %q", body.Actor)
+	}
+	if body.Request.Params["password"] != "[REDACTED]" {
+		t.Fatalf("expected password param to be redacted, got %v", body.Request.Params["password"])
+	}
+	if got := atomic.LoadInt32(&client.calls); got != 0 {
+		t.Fatalf("expected no execution calls, got %d", got)
+	}
+}
+
+func TestDebugActionReportsValidationError(t *testing.T) {
+	s := newTestServer(&testClient{})
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/debug", `{"environment":"home","action":"read_vm","target":"101"}`)
+	rr := httptest.NewRecorder()
+
+	s.debugAction(rr, req)
+
+	var body struct {
+		Valid bool   `json:"valid"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response JSON: %v", err)
+	}
+	if body.Valid || body.Error == "" {
+		t.Fatalf("expected validation error in body, got %s", rr.Body.String())
+	}
+}
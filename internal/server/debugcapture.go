@@ -0,0 +1,44 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// debugCapture opts a request into upstream capture when its X-Debug-Capture
+// header carries the admin token. Without the header it returns nil, true;
+// with a wrong token it rejects the request, since silently ignoring it
+// would leave the caller debugging without a capture.
+func (s *Server) debugCapture(w http.ResponseWriter, r *http.Request) (*proxmox.Capture, bool) {
+	token := strings.TrimSpace(r.Header.Get("X-Debug-Capture"))
+	if token == "" {
+		return nil, true
+	}
+	if s.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		http.Error(w, "invalid debug capture token", http.StatusForbidden)
+		return nil, false
+	}
+	return proxmox.NewCapture(), true
+}
+
+// writeCaptureError reports a failed request together with the exchanges
+// that led to it.
+func (s *Server) writeCaptureError(w http.ResponseWriter, r *http.Request, hash func() (string, error), status int, message string, capture *proxmox.Capture) {
+	s.writeCaptured(w, r, hash, status, map[string]any{
+		"error":         message,
+		"debug_capture": capture.Exchanges(),
+	}, "text/plain; charset=utf-8", []byte(message+"\n"))
+}
+
+// writeCaptured answers with captured, the response including its debug
+// capture, but stores the plain response for idempotent replay: upstream
+// exchanges are for the admin who asked for them, not for whoever presents
+// the key next.
+func (s *Server) writeCaptured(w http.ResponseWriter, r *http.Request, hash func() (string, error), status int, captured any, plainType string, plain []byte) {
+	body, contentType := marshalJSONBody(captured)
+	s.writeRaw(w, status, contentType, body)
+	s.storeHashedResponse(r, hash, status, plainType, plain)
+}
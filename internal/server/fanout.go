@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// fanOutResult is the outcome of one fanOut item.
+type fanOutResult[R any] struct {
+	Value R
+	Err   error
+}
+
+// fanOut calls fn for every item with at most limit calls in flight and
+// returns one result per item, in input order. A failing item does not stop
+// the others. Once ctx is done, items that have not started are skipped and
+// report ctx.Err(); calls already running are left to finish.
+func fanOut[T, R any](ctx context.Context, items []T, limit int, fn func(context.Context, T) (R, error)) []fanOutResult[R] {
+	if limit < 1 {
+		limit = 1
+	}
+	results := make([]fanOutResult[R], len(items))
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		select {
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		case sem <- struct{}{}:
+		}
+		if err := ctx.Err(); err != nil {
+			<-sem
+			results[i].Err = err
+			continue
+		}
+		wg.Add(1)
+		go func(i int, item T) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			value, err := fn(ctx, item)
+			results[i] = fanOutResult[R]{Value: value, Err: err}
+		}(i, item)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFanOutPreservesOrderAndBoundsConcurrency(t *testing.T) {
+	var inFlight, peak int32
+	results := fanOut(context.Background(), []int{1, 2, 3, 4, 5, 6}, 2, func(_ context.Context, n int) (int, error) {
+		cur := atomic.AddInt32(&inFlight, 1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if cur <= old || atomic.CompareAndSwapInt32(&peak, old, cur) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		return n * 10, nil
+	})
+
+	for i, res := range results {
+		if res.Err != nil || res.Value != (i+1)*10 {
+			t.Fatalf("result %d = %+v, want %d", i, res, (i+1)*10)
+		}
+	}
+	if got := atomic.LoadInt32(&peak); got > 2 {
+		t.Fatalf("expected at most 2 calls in flight, saw %d", got)
+	}
+}
+
+func TestFanOutCollectsPartialFailures(t *testing.T) {
+	boom := errors.New("boom")
+	results := fanOut(context.Background(), []string{"ok", "bad", "ok"}, 3, func(_ context.Context, s string) (string, error) {
+		if s == "bad" {
+			return "", boom
+		}
+		return s, nil
+	})
+
+	if results[0].Value != "ok" || results[2].Value != "ok" {
+		t.Fatalf("expected successful items to keep their values, got %+v", results)
+	}
+	if !errors.Is(results[1].Err, boom) {
+		t.Fatalf("expected failure recorded at index 1, got %+v", results[1])
+	}
+}
+
+func TestFanOutSkipsItemsAfterCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int32
+	results := fanOut(ctx, []int{1, 2, 3, 4}, 1, func(_ context.Context, n int) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		if n == 2 {
+			cancel()
+		}
+		return n, nil
+	})
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("expected 2 calls before cancellation, got %d", got)
+	}
+	for _, i := range []int{2, 3} {
+		if !errors.Is(results[i].Err, context.Canceled) {
+			t.Fatalf("expected item %d skipped with context.Canceled, got %+v", i, results[i])
+		}
+	}
+}
@@ -2,15 +2,20 @@ package server
 
 import (
 	"bytes"
-	"crypto/subtle"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"mime"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/junlov/proxmox-ai/internal/actions"
 	"github.com/junlov/proxmox-ai/internal/config"
@@ -22,37 +27,178 @@ type Server struct {
 	runner    *actions.Runner
 	validator *requestValidator
 	idem      *idempotencyStore
-	authToken string
+	clientIP  *clientIPResolver
+	sources   *sourceAllowlist
+	tokens    *authTokens
+
+	adminToken string
+	metrics    *metrics
+	shedder    *loadShedder
+	dedup      *readDedup
+
+	defaultDryRun map[proxmox.ActionType]bool
+
+	mu      sync.Mutex
+	httpSrv *http.Server
 }
 
 func New(cfg config.Config, runner *actions.Runner) *Server {
+	defaultDryRun := make(map[proxmox.ActionType]bool, len(cfg.DefaultDryRunActions))
+	for _, action := range cfg.DefaultDryRunActions {
+		defaultDryRun[proxmox.ActionType(strings.TrimSpace(action))] = true
+	}
+	authToken, err := loadAuthToken(cfg)
+	if err != nil {
+		log.Printf("auth token: %v", err)
+	}
+	if authToken == "" {
+		log.Printf("WARNING: no API auth token is configured; every authenticated endpoint will answer 503 until one is loaded")
+	}
 	return &Server{
-		cfg:       cfg,
-		runner:    runner,
-		validator: newRequestValidator(cfg),
-		idem:      newIdempotencyStore(),
-		authToken: strings.TrimSpace(os.Getenv("PROXMOX_AGENT_API_TOKEN")),
+		cfg:           cfg,
+		runner:        runner,
+		validator:     newRequestValidator(cfg),
+		idem:          newIdempotencyStore(),
+		clientIP:      newClientIPResolver(cfg.TrustedProxies),
+		sources:       newSourceAllowlist(cfg.AllowedSourceCIDRs),
+		tokens:        newAuthTokens(authToken, cfg.TokenRotationOverlap()),
+		adminToken:    strings.TrimSpace(os.Getenv("PROXMOX_AGENT_ADMIN_TOKEN")),
+		metrics:       newMetrics(cfg),
+		shedder:       newLoadShedder(cfg),
+		dedup:         newReadDedup(cfg.ReadDedupWindowMillis),
+		defaultDryRun: defaultDryRun,
 	}
 }
 
+// Start listens on cfg.ListenAddr and serves until Shutdown is called. An
+// address of the form "unix:/path/to/sock" listens on a Unix domain socket.
 func (s *Server) Start() error {
+	if s.cfg.StrictAuth && !s.tokens.Configured() {
+		return fmt.Errorf("strict_auth is set but no API auth token is configured")
+	}
+	srv := s.httpServer()
+	s.mu.Lock()
+	s.httpSrv = srv
+	s.mu.Unlock()
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown gracefully stops a running server. Unix socket files are removed
+// when their listener closes.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	srv := s.httpSrv
+	s.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
+}
+
+func (s *Server) listen() (net.Listener, error) {
+	path, ok := strings.CutPrefix(s.cfg.ListenAddr, "unix:")
+	if !ok {
+		return net.Listen("tcp", s.cfg.ListenAddr)
+	}
+	// Only clear a stale socket; never remove some other file at the path.
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("listen path %q exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func (s *Server) httpServer() *http.Server {
+	readHeader, read, write, idle := s.cfg.ServerTimeouts()
+	return &http.Server{
+		Addr:              s.cfg.ListenAddr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: readHeader,
+		ReadTimeout:       read,
+		WriteTimeout:      write,
+		IdleTimeout:       idle,
+	}
+}
+
+// Handler returns the routed API handler wrapped in the server middleware.
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
 	mux.HandleFunc("/v1/environments", s.environments)
 	mux.HandleFunc("/v1/nodes", s.nodes)
 	mux.HandleFunc("/v1/inventory", s.inventory)
-	mux.HandleFunc("/v1/vm/status", s.vmStatus)
+	mux.HandleFunc("/v1/vm/status", s.vmRead(proxmox.ActionReadVM))
+	mux.HandleFunc("/v1/vm/pending", s.vmRead(proxmox.ActionReadVMPending))
+	mux.HandleFunc("/v1/vm/config", s.vmRead(proxmox.ActionReadVMConfig))
+	mux.HandleFunc("/v1/vm/snapshots", s.vmRead(proxmox.ActionReadVMSnapshots))
+	mux.HandleFunc("/v1/vm/snapshots/diff", s.vmSnapshotDiff)
+	mux.HandleFunc("/v1/vm/snapshots/prune", s.vmSnapshotPrune)
+	mux.HandleFunc("/v1/vm/network", s.vmRead(proxmox.ActionReadVMNetwork))
+	mux.HandleFunc("/v1/vm/guest/osinfo", s.vmRead(proxmox.ActionGuestOSInfo))
+	mux.HandleFunc("/v1/vm/metrics/summary", s.vmMetricsSummary)
 	mux.HandleFunc("/v1/tasks", s.tasks)
 	mux.HandleFunc("/v1/tasks/status", s.taskStatus)
+	mux.HandleFunc("/v1/tasks/status/bulk", s.bulkTaskStatus)
+	mux.HandleFunc("/v1/ha/status", s.haStatus)
+	mux.HandleFunc("/v1/cluster/capacity", s.clusterCapacity)
+	mux.HandleFunc("/v1/cluster/log", s.clusterLog)
+	mux.HandleFunc("/v1/node/version", s.nodeVersion)
+	mux.HandleFunc("/v1/pool/members", s.poolMembers)
+	mux.HandleFunc("/v1/replication/status", s.replicationStatus)
+	mux.HandleFunc("/v1/actions", s.listActions)
 	mux.HandleFunc("/v1/actions/plan", s.plan)
 	mux.HandleFunc("/v1/actions/apply", s.apply)
+	mux.HandleFunc("/v1/actions/batch", s.batch)
+	mux.HandleFunc("/v1/actions/debug", s.debugAction)
+	mux.HandleFunc("/v1/approvals", s.listHeldRequests)
+	mux.HandleFunc("/v1/approvals/", s.releaseHeldRequest)
+	mux.HandleFunc("/v1/admin/token/reload", s.reloadAuthToken)
+	mux.HandleFunc("/v1/config", s.effectiveConfig)
+	mux.HandleFunc("/v1/audit/export.csv", s.auditExportCSV)
+	mux.HandleFunc("/v1/audit/export.json", s.auditExportJSON)
+	mux.HandleFunc("/v1/audit/health", s.auditHealth)
+	if s.cfg.MetricsEnabled {
+		mux.HandleFunc("/metrics", s.serveMetrics)
+	}
+	if s.cfg.UIEnabled {
+		ui := uiHandler()
+		mux.Handle("/ui", ui)
+		mux.Handle("/ui/", ui)
+	}
 
-	return http.ListenAndServe(s.cfg.ListenAddr, s.logRequests(mux))
+	return withRequestID(s.logRequests(s.restrictSources(s.securityHeaders(s.trackInFlight(mux)))))
 }
 
-func (s *Server) logRequests(next http.Handler) http.Handler {
+func (s *Server) securityHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s", r.Method, r.URL.Path)
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		if strings.HasPrefix(r.URL.Path, "/v1/") {
+			h.Set("Cache-Control", "no-store")
+		}
+		if s.cfg.StrictTransportSecurity {
+			h.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -65,12 +211,31 @@ func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
 	s.writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
+// readyz reports whether the agent can serve API calls. Unlike healthz it
+// fails while no auth token is configured, since every API call would be
+// refused.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.tokens.Configured() {
+		s.writeJSON(w, http.StatusServiceUnavailable, map[string]any{
+			"ready":  false,
+			"reason": "auth not configured",
+		})
+		return
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{"ready": true})
+}
+
 func (s *Server) environments(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if _, ok := s.requireAuth(w, r); !ok {
+	actor, ok := s.requireAuth(w, r)
+	if !ok {
 		return
 	}
 	envs := make([]map[string]string, 0, len(s.cfg.Environments))
@@ -81,9 +246,52 @@ func (s *Server) environments(w http.ResponseWriter, r *http.Request) {
 			"token_id": env.TokenID,
 		})
 	}
+	if probe, _ := strconv.ParseBool(r.URL.Query().Get("probe")); probe {
+		s.probeVersions(r, envs, proxmox.ActionRequest{
+			Action:     proxmox.ActionReadVersion,
+			Target:     "version",
+			Actor:      actor,
+			OnBehalfOf: onBehalfOf(r),
+			ClientIP:   s.clientIP.Resolve(r),
+			RequestID:  requestID(r),
+		})
+	}
 	s.writeJSON(w, http.StatusOK, map[string]any{"environments": envs})
 }
 
+// probeVersions queries /version on every environment concurrently and
+// records the result, or the error, on each entry in place. Each probe runs
+// as the token identity r selects, which must exist in that environment.
+func (s *Server) probeVersions(r *http.Request, envs []map[string]string, probe proxmox.ActionRequest) {
+	results := fanOut(r.Context(), envs, len(envs), func(ctx context.Context, entry map[string]string) (proxmox.VersionInfo, error) {
+		identity, _, err := s.resolveTokenIdentity(r, entry["name"])
+		if err != nil {
+			return proxmox.VersionInfo{}, err
+		}
+		req := probe
+		req.Environment = entry["name"]
+		req.Context = ctx
+		req.TokenIdentity = identity
+		resp, err := s.runner.Apply(req)
+		if err != nil {
+			return proxmox.VersionInfo{}, err
+		}
+		info, ok := resp.Result.Data.(proxmox.VersionInfo)
+		if !ok {
+			return proxmox.VersionInfo{}, errors.New("unexpected version response")
+		}
+		return info, nil
+	})
+	for i, res := range results {
+		if res.Err != nil {
+			envs[i]["probe_error"] = res.Err.Error()
+			continue
+		}
+		envs[i]["version"] = res.Value.Version
+		envs[i]["release"] = res.Value.Release
+	}
+}
+
 func (s *Server) inventory(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -110,31 +318,20 @@ func (s *Server) inventory(w http.ResponseWriter, r *http.Request) {
 		Action:      proxmox.ActionReadInventory,
 		Target:      target,
 		Actor:       actor,
+		ClientIP:    s.clientIP.Resolve(r),
+		RequestID:   requestID(r),
 	}
-	if err := s.validator.ValidateActionRequest(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	params := map[string]any{}
+	if minUptime := strings.TrimSpace(r.URL.Query().Get("min_uptime_seconds")); minUptime != "" {
+		params["min_uptime_seconds"] = minUptime
 	}
-	if _, handled := s.tryReplayIdempotent(w, r, req); handled {
-		return
+	if pendingDelete := strings.TrimSpace(r.URL.Query().Get("pending_delete")); pendingDelete != "" {
+		params["pending_delete"] = pendingDelete
 	}
-
-	planResp, err := s.runner.Plan(req)
-	if err != nil {
-		s.writeAndStoreError(w, r, req, http.StatusBadRequest, err.Error())
-		return
-	}
-	applyResp, err := s.runner.Apply(req)
-	if err != nil {
-		s.writeAndStoreError(w, r, req, http.StatusForbidden, err.Error())
-		return
+	if len(params) > 0 {
+		req.Params = params
 	}
-
-	s.writeAndStoreJSON(w, r, req, http.StatusOK, map[string]any{
-		"request": req,
-		"plan":    planResp.Decision,
-		"result":  applyResp.Result,
-	})
+	s.runRead(w, r, req)
 }
 
 func (s *Server) taskStatus(w http.ResponseWriter, r *http.Request) {
@@ -162,32 +359,11 @@ func (s *Server) taskStatus(w http.ResponseWriter, r *http.Request) {
 			"node": node,
 			"upid": upid,
 		},
-		Actor: actor,
-	}
-	if err := s.validator.ValidateActionRequest(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	if _, handled := s.tryReplayIdempotent(w, r, req); handled {
-		return
-	}
-
-	planResp, err := s.runner.Plan(req)
-	if err != nil {
-		s.writeAndStoreError(w, r, req, http.StatusBadRequest, err.Error())
-		return
-	}
-	applyResp, err := s.runner.Apply(req)
-	if err != nil {
-		s.writeAndStoreError(w, r, req, http.StatusForbidden, err.Error())
-		return
+		Actor:     actor,
+		ClientIP:  s.clientIP.Resolve(r),
+		RequestID: requestID(r),
 	}
-
-	s.writeAndStoreJSON(w, r, req, http.StatusOK, map[string]any{
-		"request": req,
-		"plan":    planResp.Decision,
-		"result":  applyResp.Result,
-	})
+	s.runRead(w, r, req)
 }
 
 func (s *Server) tasks(w http.ResponseWriter, r *http.Request) {
@@ -212,38 +388,129 @@ func (s *Server) tasks(w http.ResponseWriter, r *http.Request) {
 		Params: map[string]any{
 			"node": node,
 		},
-		Actor: actor,
+		Actor:     actor,
+		ClientIP:  s.clientIP.Resolve(r),
+		RequestID: requestID(r),
 	}
-	if limit := strings.TrimSpace(r.URL.Query().Get("limit")); limit != "" {
-		req.Params["limit"] = limit
+	for _, key := range []string{"limit", "typefilter", "statusfilter"} {
+		if value := strings.TrimSpace(r.URL.Query().Get(key)); value != "" {
+			req.Params[key] = value
+		}
 	}
-	if err := s.validator.ValidateActionRequest(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	s.runRead(w, r, req)
+}
+
+// vmRead returns the handler for a GET endpoint that runs action against
+// one VM named by the environment, node and vmid query parameters.
+func (s *Server) vmRead(action proxmox.ActionType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		actor, ok := s.requireAuth(w, r)
+		if !ok {
+			return
+		}
+		environment := strings.TrimSpace(r.URL.Query().Get("environment"))
+		node := strings.TrimSpace(r.URL.Query().Get("node"))
+		vmid := strings.TrimSpace(r.URL.Query().Get("vmid"))
+		if environment == "" || node == "" || vmid == "" {
+			http.Error(w, "environment, node, and vmid query parameters are required", http.StatusBadRequest)
+			return
+		}
+		req := proxmox.ActionRequest{
+			Environment: environment,
+			Action:      action,
+			Target:      "vm/" + vmid,
+			Params: map[string]any{
+				"node": node,
+			},
+			Actor:     actor,
+			ClientIP:  s.clientIP.Resolve(r),
+			RequestID: requestID(r),
+		}
+		s.runRead(w, r, req)
+	}
+}
+
+func (s *Server) nodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if _, handled := s.tryReplayIdempotent(w, r, req); handled {
+	actor, ok := s.requireAuth(w, r)
+	if !ok {
 		return
 	}
+	environment := strings.TrimSpace(r.URL.Query().Get("environment"))
+	if environment == "" {
+		http.Error(w, "environment query parameter is required", http.StatusBadRequest)
+		return
+	}
+	req := proxmox.ActionRequest{
+		Environment: environment,
+		Action:      proxmox.ActionReadNodes,
+		Target:      "nodes/all",
+		Actor:       actor,
+		ClientIP:    s.clientIP.Resolve(r),
+		RequestID:   requestID(r),
+	}
+	s.runRead(w, r, req)
+}
 
-	planResp, err := s.runner.Plan(req)
-	if err != nil {
-		s.writeAndStoreError(w, r, req, http.StatusBadRequest, err.Error())
+func (s *Server) haStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	applyResp, err := s.runner.Apply(req)
-	if err != nil {
-		s.writeAndStoreError(w, r, req, http.StatusForbidden, err.Error())
+	actor, ok := s.requireAuth(w, r)
+	if !ok {
 		return
 	}
+	environment := strings.TrimSpace(r.URL.Query().Get("environment"))
+	if environment == "" {
+		http.Error(w, "environment query parameter is required", http.StatusBadRequest)
+		return
+	}
+	req := proxmox.ActionRequest{
+		Environment: environment,
+		Action:      proxmox.ActionReadHAStatus,
+		Target:      "ha/status",
+		Actor:       actor,
+		ClientIP:    s.clientIP.Resolve(r),
+		RequestID:   requestID(r),
+	}
+	s.runRead(w, r, req)
+}
 
-	s.writeAndStoreJSON(w, r, req, http.StatusOK, map[string]any{
-		"request": req,
-		"plan":    planResp.Decision,
-		"result":  applyResp.Result,
-	})
+func (s *Server) poolMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor, ok := s.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	environment := strings.TrimSpace(r.URL.Query().Get("environment"))
+	poolID := strings.TrimSpace(r.URL.Query().Get("poolid"))
+	if environment == "" || poolID == "" {
+		http.Error(w, "environment and poolid query parameters are required", http.StatusBadRequest)
+		return
+	}
+	req := proxmox.ActionRequest{
+		Environment: environment,
+		Action:      proxmox.ActionReadPoolMembers,
+		Target:      "pool/" + poolID,
+		Actor:       actor,
+		ClientIP:    s.clientIP.Resolve(r),
+		RequestID:   requestID(r),
+	}
+	s.runRead(w, r, req)
 }
 
-func (s *Server) vmStatus(w http.ResponseWriter, r *http.Request) {
+func (s *Server) replicationStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -254,45 +521,90 @@ func (s *Server) vmStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	environment := strings.TrimSpace(r.URL.Query().Get("environment"))
 	node := strings.TrimSpace(r.URL.Query().Get("node"))
-	vmid := strings.TrimSpace(r.URL.Query().Get("vmid"))
-	if environment == "" || node == "" || vmid == "" {
-		http.Error(w, "environment, node, and vmid query parameters are required", http.StatusBadRequest)
+	id := strings.TrimSpace(r.URL.Query().Get("id"))
+	if environment == "" || node == "" || id == "" {
+		http.Error(w, "environment, node, and id query parameters are required", http.StatusBadRequest)
 		return
 	}
+	params := map[string]any{"node": node}
+	if raw := r.URL.Query().Get("log"); raw != "" {
+		withLog, err := strconv.ParseBool(raw)
+		if err != nil {
+			http.Error(w, "log must be true or false", http.StatusBadRequest)
+			return
+		}
+		params["log"] = withLog
+	}
 	req := proxmox.ActionRequest{
 		Environment: environment,
-		Action:      proxmox.ActionReadVM,
-		Target:      "vm/" + vmid,
-		Params: map[string]any{
-			"node": node,
-		},
-		Actor: actor,
+		Action:      proxmox.ActionReadReplicationStatus,
+		Target:      "replication/" + id,
+		Params:      params,
+		Actor:       actor,
+		ClientIP:    s.clientIP.Resolve(r),
+		RequestID:   requestID(r),
 	}
-	if err := s.validator.ValidateActionRequest(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	s.runRead(w, r, req)
+}
+
+// nodeVersion reports a node's package version and, with subscription=true,
+// its subscription status. A failed subscription lookup is reported inline
+// so the version is still returned.
+func (s *Server) nodeVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if _, handled := s.tryReplayIdempotent(w, r, req); handled {
+	actor, ok := s.requireAuth(w, r)
+	if !ok {
 		return
 	}
-	planResp, err := s.runner.Plan(req)
-	if err != nil {
-		s.writeAndStoreError(w, r, req, http.StatusBadRequest, err.Error())
+	environment := strings.TrimSpace(r.URL.Query().Get("environment"))
+	node := strings.TrimSpace(r.URL.Query().Get("node"))
+	if environment == "" || node == "" {
+		http.Error(w, "environment and node query parameters are required", http.StatusBadRequest)
 		return
 	}
-	applyResp, err := s.runner.Apply(req)
-	if err != nil {
-		s.writeAndStoreError(w, r, req, http.StatusForbidden, err.Error())
+	withSubscription, _ := strconv.ParseBool(r.URL.Query().Get("subscription"))
+
+	req := proxmox.ActionRequest{
+		Environment: environment,
+		Action:      proxmox.ActionReadNodeVersion,
+		Target:      "node/" + node,
+		Actor:       actor,
+		OnBehalfOf:  onBehalfOf(r),
+		ClientIP:    s.clientIP.Resolve(r),
+		RequestID:   requestID(r),
+	}
+	if !withSubscription {
+		s.runRead(w, r, req)
 		return
 	}
-	s.writeAndStoreJSON(w, r, req, http.StatusOK, map[string]any{
+	subReq := req
+	subReq.Action = proxmox.ActionReadNodeSubscription
+	if !s.prepareReads(w, r, &req, &subReq) {
+		return
+	}
+	version := s.executeRead(r, req)
+	reqs := []proxmox.ActionRequest{req, subReq}
+	if version.err != nil {
+		s.writeReadError(w, r, reqs, version.status, version.err)
+		return
+	}
+	body := map[string]any{
 		"request": req,
-		"plan":    planResp.Decision,
-		"result":  applyResp.Result,
-	})
+		"plan":    version.plan.Decision,
+		"result":  version.apply.Result,
+	}
+	if sub := s.executeRead(r, subReq); sub.err != nil {
+		body["subscription_error"] = sub.err.Error()
+	} else {
+		body["subscription"] = sub.apply.Result.Data
+	}
+	s.writeReadJSON(w, r, reqs, body)
 }
 
-func (s *Server) nodes(w http.ResponseWriter, r *http.Request) {
+func (s *Server) clusterCapacity(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -308,32 +620,93 @@ func (s *Server) nodes(w http.ResponseWriter, r *http.Request) {
 	}
 	req := proxmox.ActionRequest{
 		Environment: environment,
-		Action:      proxmox.ActionReadNodes,
-		Target:      "nodes/all",
+		Action:      proxmox.ActionReadCapacity,
+		Target:      "cluster/capacity",
 		Actor:       actor,
+		ClientIP:    s.clientIP.Resolve(r),
+		RequestID:   requestID(r),
 	}
-	if err := s.validator.ValidateActionRequest(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	s.runRead(w, r, req)
+}
+
+func (s *Server) clusterLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if _, handled := s.tryReplayIdempotent(w, r, req); handled {
+	actor, ok := s.requireAuth(w, r)
+	if !ok {
 		return
 	}
-	planResp, err := s.runner.Plan(req)
-	if err != nil {
-		s.writeAndStoreError(w, r, req, http.StatusBadRequest, err.Error())
+	environment := strings.TrimSpace(r.URL.Query().Get("environment"))
+	if environment == "" {
+		http.Error(w, "environment query parameter is required", http.StatusBadRequest)
 		return
 	}
-	applyResp, err := s.runner.Apply(req)
-	if err != nil {
-		s.writeAndStoreError(w, r, req, http.StatusForbidden, err.Error())
+	req := proxmox.ActionRequest{
+		Environment: environment,
+		Action:      proxmox.ActionReadClusterLog,
+		Target:      "cluster/log",
+		Params:      map[string]any{},
+		Actor:       actor,
+		ClientIP:    s.clientIP.Resolve(r),
+		RequestID:   requestID(r),
+	}
+	for _, key := range []string{"max", "severity"} {
+		if value := strings.TrimSpace(r.URL.Query().Get(key)); value != "" {
+			req.Params[key] = value
+		}
+	}
+	s.runRead(w, r, req)
+}
+
+// runRead validates a read request built from query parameters and runs it
+// through the same plan/apply pipeline as POSTed actions.
+func (s *Server) runRead(w http.ResponseWriter, r *http.Request, req proxmox.ActionRequest) {
+	if !s.prepareReads(w, r, &req) {
+		return
+	}
+	req.Raw = wantsRaw(r)
+	call := s.executeRead(r, req)
+	if call.err != nil {
+		s.writeReadError(w, r, []proxmox.ActionRequest{req}, call.status, call.err)
 		return
 	}
-	s.writeAndStoreJSON(w, r, req, http.StatusOK, map[string]any{
+	if req.Raw {
+		w.Header().Set("X-Proxmox-Raw", "true")
+		s.storeIdempotencyResponse(r, req, http.StatusOK, "application/json", call.apply.Result.Raw)
+		s.writeRaw(w, http.StatusOK, "application/json", call.apply.Result.Raw)
+		return
+	}
+	body := map[string]any{
 		"request": req,
-		"plan":    planResp.Decision,
-		"result":  applyResp.Result,
-	})
+		"plan":    call.plan.Decision,
+		"result":  call.apply.Result,
+	}
+	s.writeReadJSON(w, r, []proxmox.ActionRequest{req}, body)
+}
+
+// planThenApply runs req through the same plan and apply steps as a
+// request planned and applied on its own, reporting the HTTP status a
+// failure maps to. The plan is inline, so it never satisfies an approval
+// binding for its own apply.
+func (s *Server) planThenApply(req proxmox.ActionRequest) (actions.PlanResponse, actions.ApplyResponse, int, error) {
+	planResp, err := s.runner.PlanInline(req)
+	if err != nil {
+		return actions.PlanResponse{}, actions.ApplyResponse{}, http.StatusBadRequest, err
+	}
+	applyResp, err := s.runner.Apply(req)
+	if err != nil {
+		return planResp, actions.ApplyResponse{}, applyErrorStatus(err), err
+	}
+	return planResp, applyResp, http.StatusOK, nil
+}
+
+// wantsRaw reports whether a read asked for the upstream body verbatim via
+// ?raw=true.
+func wantsRaw(r *http.Request) bool {
+	raw, _ := strconv.ParseBool(r.URL.Query().Get("raw"))
+	return raw
 }
 
 func (s *Server) plan(w http.ResponseWriter, r *http.Request) {
@@ -345,23 +718,42 @@ func (s *Server) plan(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
-	var req proxmox.ActionRequest
-	if err := decodeStrictJSON(r, &req); err != nil {
+	if !requireJSONContentType(w, r) {
+		return
+	}
+	req, dryRunSet, err := decodeActionRequest(r)
+	if err != nil {
 		http.Error(w, "invalid JSON body", http.StatusBadRequest)
 		return
 	}
+	if s.shedLoad(w, req.Action) {
+		return
+	}
+	s.applyRequestDefaults(&req, dryRunSet)
 	if err := s.validator.ValidateActionRequest(req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	req.Actor = actor
+	req.OnBehalfOf = onBehalfOf(r)
+	req.ClientIP = s.clientIP.Resolve(r)
+	req.RequestID = requestID(r)
+	req.Context = r.Context()
+	noteRequest(r, req)
+	if req.TransactionID, ok = transactionID(w, r); !ok {
+		return
+	}
 	if _, handled := s.tryReplayIdempotent(w, r, req); handled {
 		return
 	}
 
 	resp, err := s.runner.Plan(req)
 	if err != nil {
-		s.writeAndStoreError(w, r, req, http.StatusBadRequest, err.Error())
+		status := http.StatusBadRequest
+		if errors.Is(err, actions.ErrTooManyPlans) {
+			status = http.StatusServiceUnavailable
+		}
+		s.writeAndStoreError(w, r, req, status, err.Error())
 		return
 	}
 	s.writeAndStoreJSON(w, r, req, http.StatusOK, resp)
@@ -376,28 +768,121 @@ func (s *Server) apply(w http.ResponseWriter, r *http.Request) {
 	if !ok {
 		return
 	}
-	var req proxmox.ActionRequest
-	if err := decodeStrictJSON(r, &req); err != nil {
+	if !requireJSONContentType(w, r) {
+		return
+	}
+	if !s.requireIdempotencyKey(w, r) {
+		return
+	}
+	req, dryRunSet, err := decodeActionRequest(r)
+	if err != nil {
 		http.Error(w, "invalid JSON body", http.StatusBadRequest)
 		return
 	}
+	if s.shedLoad(w, req.Action) {
+		return
+	}
+	s.applyRequestDefaults(&req, dryRunSet)
 	if err := s.validator.ValidateActionRequest(req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	req.Actor = actor
+	req.OnBehalfOf = onBehalfOf(r)
+	req.ClientIP = s.clientIP.Resolve(r)
+	req.RequestID = requestID(r)
+	req.Context = r.Context()
+	noteRequest(r, req)
+	if req.TransactionID, ok = transactionID(w, r); !ok {
+		return
+	}
+	capture, ok := s.debugCapture(w, r)
+	if !ok {
+		return
+	}
+	if req.TokenIdentity, ok = s.tokenIdentity(w, r, req.Environment); !ok {
+		return
+	}
 	if _, handled := s.tryReplayIdempotent(w, r, req); handled {
 		return
 	}
 
+	req.Capture = capture
+	hash := func() (string, error) { return s.idem.Hash(req) }
 	resp, err := s.runner.Apply(req)
+	var held *actions.HeldError
+	if errors.As(err, &held) {
+		s.writeAndStoreJSON(w, r, req, http.StatusAccepted, map[string]any{
+			"status":  "held",
+			"hold_id": held.ID,
+			"reason":  held.Reason,
+		})
+		return
+	}
 	if err != nil {
-		s.writeAndStoreError(w, r, req, http.StatusForbidden, err.Error())
+		setRetryAfter(w, err)
+		if errors.Is(err, actions.ErrApprovalRequired) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(s.cfg.ApprovalRetryAfter().Seconds())))
+		}
+		var cloneErr *proxmox.CloneWaitError
+		if errors.As(err, &cloneErr) {
+			// The clone was started; tell the caller which task and VM.
+			body := map[string]any{"error": err.Error(), "clone": cloneErr.Clone}
+			plain, contentType := marshalJSONBody(body)
+			if capture != nil {
+				body["debug_capture"] = capture.Exchanges()
+				s.writeCaptured(w, r, hash, applyErrorStatus(err), body, contentType, plain)
+				return
+			}
+			s.writeRaw(w, applyErrorStatus(err), contentType, plain)
+			s.storeHashedResponse(r, hash, applyErrorStatus(err), contentType, plain)
+			return
+		}
+		if capture != nil {
+			s.writeCaptureError(w, r, hash, applyErrorStatus(err), err.Error(), capture)
+			return
+		}
+		s.writeAndStoreError(w, r, req, applyErrorStatus(err), err.Error())
+		return
+	}
+	if capture != nil {
+		plain, contentType := marshalJSONBody(resp)
+		s.writeCaptured(w, r, hash, http.StatusOK, struct {
+			actions.ApplyResponse
+			DebugCapture []proxmox.Exchange `json:"debug_capture"`
+		}{resp, capture.Exchanges()}, contentType, plain)
 		return
 	}
 	s.writeAndStoreJSON(w, r, req, http.StatusOK, resp)
 }
 
+func applyErrorStatus(err error) int {
+	if errors.Is(err, proxmox.ErrClusterBusy) || errors.Is(err, actions.ErrTooManyHolds) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, actions.ErrPreconditionFailed) {
+		return http.StatusPreconditionFailed
+	}
+	if errors.Is(err, proxmox.ErrDigestMismatch) || errors.Is(err, actions.ErrVMIDExists) || errors.Is(err, actions.ErrVMProtected) || errors.Is(err, actions.ErrTransactionMismatch) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, actions.ErrUnknownNode) || errors.Is(err, proxmox.ErrUnknownTokenIdentity) {
+		return http.StatusBadRequest
+	}
+	return http.StatusForbidden
+}
+
+// setRetryAfter advertises the upstream's suggested wait when err is a
+// cluster-busy rejection.
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var apiErr *proxmox.APIError
+	if !errors.As(err, &apiErr) || !apiErr.Busy || apiErr.RetryAfter <= 0 {
+		return
+	}
+	secs := int64(math.Ceil(apiErr.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+}
+
 func (s *Server) writeJSON(w http.ResponseWriter, status int, body any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -405,17 +890,26 @@ func (s *Server) writeJSON(w http.ResponseWriter, status int, body any) {
 }
 
 func (s *Server) tryReplayIdempotent(w http.ResponseWriter, r *http.Request, req proxmox.ActionRequest) (replayed bool, handled bool) {
+	return s.tryReplayHashed(w, r, func() (string, error) { return s.idem.Hash(req) })
+}
+
+// tryReplayHashed is tryReplayIdempotent for a payload fingerprinted by
+// hash, such as every request of a composite read.
+func (s *Server) tryReplayHashed(w http.ResponseWriter, r *http.Request, hash func() (string, error)) (replayed bool, handled bool) {
 	key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
 	if key == "" {
 		return false, false
 	}
-	hash, err := hashActionRequest(req)
+	payloadHash, err := hash()
 	if err != nil {
+		// Fail closed: without a hash a retry cannot be recognized, so
+		// executing could run the action twice.
+		log.Printf("idempotency: hash request for key %q on %s: %v", key, r.URL.Path, err)
 		http.Error(w, "failed to hash request", http.StatusInternalServerError)
 		return false, true
 	}
 	if rec, ok := s.idem.Get(r.URL.Path, key); ok {
-		if rec.payloadHash != hash {
+		if rec.payloadHash != payloadHash {
 			http.Error(w, "idempotency key reused with different payload", http.StatusConflict)
 			return false, true
 		}
@@ -439,16 +933,21 @@ func (s *Server) writeAndStoreError(w http.ResponseWriter, r *http.Request, req
 }
 
 func (s *Server) storeIdempotencyResponse(r *http.Request, req proxmox.ActionRequest, status int, contentType string, body []byte) {
+	s.storeHashedResponse(r, func() (string, error) { return s.idem.Hash(req) }, status, contentType, body)
+}
+
+func (s *Server) storeHashedResponse(r *http.Request, hash func() (string, error), status int, contentType string, body []byte) {
 	key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
 	if key == "" {
 		return
 	}
-	hash, err := hashActionRequest(req)
+	payloadHash, err := hash()
 	if err != nil {
+		log.Printf("idempotency: response for key %q on %s not stored; a retry will re-execute: %v", key, r.URL.Path, err)
 		return
 	}
 	s.idem.Put(r.URL.Path, key, idempotencyRecord{
-		payloadHash: hash,
+		payloadHash: payloadHash,
 		statusCode:  status,
 		contentType: contentType,
 		body:        body,
@@ -474,7 +973,7 @@ func (s *Server) writeRaw(w http.ResponseWriter, status int, contentType string,
 }
 
 func (s *Server) requireAuth(w http.ResponseWriter, r *http.Request) (string, bool) {
-	if s.authToken == "" {
+	if !s.tokens.Configured() {
 		http.Error(w, "server auth token is not configured", http.StatusServiceUnavailable)
 		return "", false
 	}
@@ -484,7 +983,7 @@ func (s *Server) requireAuth(w http.ResponseWriter, r *http.Request) (string, bo
 		return "", false
 	}
 	token := strings.TrimSpace(strings.TrimPrefix(rawAuth, "Bearer "))
-	if subtle.ConstantTimeCompare([]byte(token), []byte(s.authToken)) != 1 {
+	if !s.tokens.Match(token) {
 		http.Error(w, "invalid bearer token", http.StatusUnauthorized)
 		return "", false
 	}
@@ -493,11 +992,78 @@ func (s *Server) requireAuth(w http.ResponseWriter, r *http.Request) (string, bo
 	if actor == "" {
 		actor = "authenticated"
 	}
+	if principal := onBehalfOf(r); principal != "" && !approvedByPattern.MatchString(principal) {
+		http.Error(w, "invalid X-On-Behalf-Of format", http.StatusBadRequest)
+		return "", false
+	}
 	return actor, true
 }
 
+// onBehalfOf returns the delegating principal named by X-On-Behalf-Of. It is
+// recorded alongside the authenticated actor and never replaces it.
+func onBehalfOf(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get("X-On-Behalf-Of"))
+}
+
+// decodeActionRequest strictly decodes an action request body, coercing
+// integer params once, and reports whether dry_run was present, so
+// per-action defaults only apply when the caller did not choose explicitly.
+func decodeActionRequest(r *http.Request) (proxmox.ActionRequest, bool, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return proxmox.ActionRequest{}, false, err
+	}
+	return decodeActionRequestBytes(body)
+}
+
+func decodeActionRequestBytes(body []byte) (proxmox.ActionRequest, bool, error) {
+	var req proxmox.ActionRequest
+	if err := decodeStrictReader(bytes.NewReader(body), &req); err != nil {
+		return req, false, err
+	}
+	req.Params = proxmox.CoerceParams(req.Params)
+	var presence struct {
+		DryRun *bool `json:"dry_run"`
+	}
+	if err := json.Unmarshal(body, &presence); err != nil {
+		return req, false, err
+	}
+	return req, presence.DryRun != nil, nil
+}
+
+func (s *Server) applyRequestDefaults(req *proxmox.ActionRequest, dryRunSet bool) {
+	if !dryRunSet && s.defaultDryRun[req.Action] {
+		req.DryRun = true
+	}
+}
+
+// requireIdempotencyKey enforces require_idempotency_key on endpoints that
+// execute actions, so a retrying client cannot run an action twice.
+func (s *Server) requireIdempotencyKey(w http.ResponseWriter, r *http.Request) bool {
+	if !s.cfg.RequireIdempotencyKey || strings.TrimSpace(r.Header.Get("Idempotency-Key")) != "" {
+		return true
+	}
+	http.Error(w, "Idempotency-Key header is required", http.StatusBadRequest)
+	return false
+}
+
+// requireJSONContentType rejects request bodies that are not declared as
+// JSON, so a form-encoded body gets a 415 instead of a puzzling decode error.
+func requireJSONContentType(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+	return true
+}
+
 func decodeStrictJSON(r *http.Request, dst any) error {
-	dec := json.NewDecoder(r.Body)
+	return decodeStrictReader(r.Body, dst)
+}
+
+func decodeStrictReader(body io.Reader, dst any) error {
+	dec := json.NewDecoder(body)
 	dec.DisallowUnknownFields()
 	if err := dec.Decode(dst); err != nil {
 		return err
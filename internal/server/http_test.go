@@ -1,12 +1,19 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/junlov/proxmox-ai/internal/actions"
 	"github.com/junlov/proxmox-ai/internal/config"
@@ -32,6 +39,10 @@ func (c *testClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, e
 }
 
 func newTestServer(client proxmox.Client) *Server {
+	return newTestServerWithConfig(client, nil)
+}
+
+func newTestServerWithConfig(client proxmox.Client, configure func(*config.Config)) *Server {
 	cfg := config.Config{
 		ListenAddr: ":0",
 		Environments: []config.Environment{
@@ -43,9 +54,12 @@ func newTestServer(client proxmox.Client) *Server {
 			},
 		},
 	}
+	if configure != nil {
+		configure(&cfg)
+	}
 	runner := actions.NewRunner(policy.NewEngine(), client, "")
 	srv := New(cfg, runner)
-	srv.authToken = "test-token"
+	srv.tokens.Set("test-token")
 	return srv
 }
 
@@ -53,6 +67,9 @@ func newAuthedRequest(method, path, body string) *http.Request {
 	req := httptest.NewRequest(method, path, strings.NewReader(body))
 	req.Header.Set("Authorization", "Bearer test-token")
 	req.Header.Set("X-Actor-ID", "test-agent")
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
 	return req
 }
 
@@ -130,6 +147,103 @@ func TestApplyIdempotencyReplaysAndPreventsDuplicateExecution(t *testing.T) {
 	}
 }
 
+func TestApplyRequiresIdempotencyKeyWhenConfigured(t *testing.T) {
+	body := `{"environment":"home","action":"start_vm","target":"vm/101","params":{"node":"pve"}}`
+	for _, tc := range []struct {
+		name     string
+		required bool
+		want     int
+	}{
+		{name: "required", required: true, want: http.StatusBadRequest},
+		{name: "optional", required: false, want: http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &testClient{}
+			s := newTestServerWithConfig(client, func(cfg *config.Config) {
+				cfg.RequireIdempotencyKey = tc.required
+			})
+			rr := httptest.NewRecorder()
+			s.apply(rr, newAuthedRequest(http.MethodPost, "/v1/actions/apply", body))
+			if rr.Code != tc.want {
+				t.Fatalf("expected %d, got %d: %s", tc.want, rr.Code, rr.Body.String())
+			}
+			if tc.required && atomic.LoadInt32(&client.calls) != 0 {
+				t.Fatal("expected no execution without an Idempotency-Key")
+			}
+		})
+	}
+
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.RequireIdempotencyKey = true
+	})
+	plan := httptest.NewRecorder()
+	s.plan(plan, newAuthedRequest(http.MethodPost, "/v1/actions/plan", body))
+	if plan.Code != http.StatusOK {
+		t.Fatalf("expected plan to stay exempt, got %d: %s", plan.Code, plan.Body.String())
+	}
+}
+
+func TestApplyIdempotencyFailsClosedWhenHashFails(t *testing.T) {
+	client := &testClient{}
+	s := newTestServer(client)
+	s.idem.hash = func(proxmox.ActionRequest) (string, error) {
+		return "", errors.New("injected hash failure")
+	}
+
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"start_vm","target":"vm/101"}`)
+	req.Header.Set("Idempotency-Key", "apply-key-hash")
+	rr := httptest.NewRecorder()
+	s.apply(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rr.Code)
+	}
+	if got := atomic.LoadInt32(&client.calls); got != 0 {
+		t.Fatalf("expected no execution when hash fails, got %d", got)
+	}
+	if got := s.idem.hashFailures.Load(); got != 1 {
+		t.Fatalf("expected hash failure to be counted, got %d", got)
+	}
+}
+
+func TestStoreIdempotencyResponseSkipsAndCountsHashFailure(t *testing.T) {
+	s := newTestServer(&testClient{})
+	s.idem.hash = func(proxmox.ActionRequest) (string, error) {
+		return "", errors.New("injected hash failure")
+	}
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/apply", "")
+	req.Header.Set("Idempotency-Key", "store-key")
+
+	s.storeIdempotencyResponse(req, proxmox.ActionRequest{}, http.StatusOK, "application/json", []byte("{}"))
+
+	if _, ok := s.idem.Get("/v1/actions/apply", "store-key"); ok {
+		t.Fatal("expected no record stored when hash fails")
+	}
+	if got := s.idem.hashFailures.Load(); got != 1 {
+		t.Fatalf("expected hash failure to be counted, got %d", got)
+	}
+}
+
+func TestIdempotencyHashCoversRawAndHold(t *testing.T) {
+	req := proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionDeleteVM, Target: "vm/101", Params: map[string]any{"node": "pve"}}
+	plain, err := hashActionRequest(req)
+	if err != nil {
+		t.Fatalf("hashActionRequest returned error: %v", err)
+	}
+	raw, held := req, req
+	raw.Raw = true
+	held.Hold = true
+	for name, variant := range map[string]proxmox.ActionRequest{"raw": raw, "hold": held} {
+		hash, err := hashActionRequest(variant)
+		if err != nil {
+			t.Fatalf("hashActionRequest returned error: %v", err)
+		}
+		if hash == plain {
+			t.Fatalf("expected %s to change the request hash", name)
+		}
+	}
+}
+
 func TestApplyIdempotencyRejectsDifferentPayloadForSameKey(t *testing.T) {
 	client := &testClient{}
 	s := newTestServer(client)
@@ -309,11 +423,38 @@ func TestTasksExecutesReadTasks(t *testing.T) {
 	}
 }
 
+func TestTasksForwardsTypeAndStatusFilters(t *testing.T) {
+	var gotQuery url.Values
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.Query()
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	})
+	s := newTestServer(client)
+	req := newAuthedRequest(http.MethodGet, "/v1/tasks?environment=home&node=pve&limit=20&typefilter=vzdump&statusfilter=errors", "")
+	rr := httptest.NewRecorder()
+	s.tasks(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if gotQuery.Get("typefilter") != "vzdump" || gotQuery.Get("statusfilter") != "error" || gotQuery.Get("limit") != "20" {
+		t.Fatalf("expected filters forwarded upstream, got %v", gotQuery)
+	}
+
+	for _, query := range []string{"typefilter=vz%20dump", "statusfilter=failed"} {
+		req := newAuthedRequest(http.MethodGet, "/v1/tasks?environment=home&node=pve&"+query, "")
+		rr := httptest.NewRecorder()
+		s.tasks(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", query, rr.Code)
+		}
+	}
+}
+
 func TestVMStatusValidatesQueryParams(t *testing.T) {
 	s := newTestServer(&testClient{})
 	req := newAuthedRequest(http.MethodGet, "/v1/vm/status?environment=home&node=pve", "")
 	rr := httptest.NewRecorder()
-	s.vmStatus(rr, req)
+	s.vmRead(proxmox.ActionReadVM)(rr, req)
 	if rr.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400 for missing vmid, got %d", rr.Code)
 	}
@@ -324,7 +465,7 @@ func TestVMStatusExecutesReadVM(t *testing.T) {
 	s := newTestServer(client)
 	req := newAuthedRequest(http.MethodGet, "/v1/vm/status?environment=home&node=pve&vmid=101", "")
 	rr := httptest.NewRecorder()
-	s.vmStatus(rr, req)
+	s.vmRead(proxmox.ActionReadVM)(rr, req)
 	if rr.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
 	}
@@ -335,3 +476,845 @@ func TestVMStatusExecutesReadVM(t *testing.T) {
 		t.Fatalf("unexpected target: %q", client.lastReq.Target)
 	}
 }
+
+func newUpstreamClient(t *testing.T, handler http.HandlerFunc) *proxmox.APIClient {
+	t.Helper()
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+	t.Setenv("PVE_TEST_SECRET", "test-secret")
+	client, err := proxmox.NewAPIClient([]config.Environment{{
+		Name:           "home",
+		BaseURL:        upstream.URL,
+		TokenID:        "root@pam!agent",
+		TokenSecretEnv: "PVE_TEST_SECRET",
+	}})
+	if err != nil {
+		t.Fatalf("NewAPIClient returned error: %v", err)
+	}
+	return client
+}
+
+func TestApplyCloneWaitFailureReportsClone(t *testing.T) {
+	const upid = "UPID:node1:0000A1B2:00C0FFEE:65F0A000:qmclone:103:root@pam!agent:"
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(`{"data":{"status":"stopped","exitstatus":"storage full"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":"` + upid + `"}`))
+	})
+	s := newTestServer(client)
+
+	rr := httptest.NewRecorder()
+	s.apply(rr, newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"clone_vm","target":"vm/103","params":{"node":"node1","newid":104,"wait":true}}`))
+	if rr.Code < 400 {
+		t.Fatalf("expected the failed clone to be an error, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Error string              `json:"error"`
+		Clone proxmox.CloneResult `json:"clone"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v: %s", err, rr.Body.String())
+	}
+	if !strings.Contains(body.Error, "storage full") || body.Clone.UPID != upid || body.Clone.NewID != 104 {
+		t.Fatalf("expected the error with the clone's task and VMID, got %+v", body)
+	}
+}
+
+func TestInventoryMinUptimeExcludesShortUptimeVMs(t *testing.T) {
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[{"vmid":100,"status":"running","uptime":90000},{"vmid":101,"status":"running","uptime":120},{"vmid":102,"status":"stopped"}]}`))
+	})
+	s := newTestServer(client)
+
+	req := newAuthedRequest(http.MethodGet, "/v1/inventory?environment=home&min_uptime_seconds=3600", "")
+	rr := httptest.NewRecorder()
+	s.inventory(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Result struct {
+			Data []struct {
+				VMID int `json:"vmid"`
+			} `json:"data"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response JSON: %v", err)
+	}
+	if len(body.Result.Data) != 1 || body.Result.Data[0].VMID != 100 {
+		t.Fatalf("expected only vmid 100, got %+v", body.Result.Data)
+	}
+}
+
+func TestInventoryRejectsInvalidMinUptime(t *testing.T) {
+	s := newTestServer(&testClient{})
+	req := newAuthedRequest(http.MethodGet, "/v1/inventory?environment=home&min_uptime_seconds=soon", "")
+	rr := httptest.NewRecorder()
+	s.inventory(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid min_uptime_seconds, got %d", rr.Code)
+	}
+}
+
+func TestApplyDefaultsListedActionToDryRun(t *testing.T) {
+	client := &testClient{}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.DefaultDryRunActions = []string{"delete_vm"}
+	})
+
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"delete_vm","target":"vm/101","params":{"node":"pve"},"approved_by":"ops-user"}`)
+	rr := httptest.NewRecorder()
+	s.apply(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !client.lastReq.DryRun {
+		t.Fatal("expected listed action to default to dry-run")
+	}
+
+	req = newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"delete_vm","target":"vm/101","params":{"node":"pve"},"approved_by":"ops-user","dry_run":false}`)
+	rr = httptest.NewRecorder()
+	s.apply(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if client.lastReq.DryRun {
+		t.Fatal("expected explicit dry_run=false to execute")
+	}
+}
+
+func TestApplyDoesNotDefaultUnlistedActionToDryRun(t *testing.T) {
+	client := &testClient{}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.DefaultDryRunActions = []string{"delete_vm"}
+	})
+
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"start_vm","target":"vm/101","params":{"node":"pve"}}`)
+	rr := httptest.NewRecorder()
+	s.apply(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if client.lastReq.DryRun {
+		t.Fatal("expected unlisted action to execute without dry-run")
+	}
+}
+
+func TestHAStatusExecutesReadHAStatus(t *testing.T) {
+	client := &testClient{}
+	s := newTestServer(client)
+	req := newAuthedRequest(http.MethodGet, "/v1/ha/status?environment=home", "")
+	rr := httptest.NewRecorder()
+	s.haStatus(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if client.lastReq.Action != proxmox.ActionReadHAStatus {
+		t.Fatalf("expected read_ha_status action, got %q", client.lastReq.Action)
+	}
+}
+
+func TestHandlerSetsSecurityHeadersOnPlanResponse(t *testing.T) {
+	s := newTestServer(&testClient{})
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/plan", `{"environment":"home","action":"read_vm","target":"vm/101"}`)
+	rr := httptest.NewRecorder()
+
+	s.Handler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Fatalf("unexpected X-Content-Type-Options: %q", got)
+	}
+	if got := rr.Header().Get("Cache-Control"); got != "no-store" {
+		t.Fatalf("unexpected Cache-Control: %q", got)
+	}
+	if got := rr.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Fatalf("expected no HSTS header by default, got %q", got)
+	}
+}
+
+func TestHandlerSetsHSTSWhenEnabled(t *testing.T) {
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.StrictTransportSecurity = true
+	})
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rr := httptest.NewRecorder()
+
+	s.Handler().ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("Strict-Transport-Security"); got == "" {
+		t.Fatal("expected HSTS header when enabled")
+	}
+}
+
+func TestReadyzReportsAuthNotConfiguredUnderStrictMode(t *testing.T) {
+	t.Setenv("PROXMOX_AGENT_API_TOKEN", "")
+	s := New(config.Config{ListenAddr: "127.0.0.1:0", StrictAuth: true}, nil)
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), "auth not configured") {
+		t.Fatalf("expected not-ready for missing auth, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if err := s.Start(); err == nil || !strings.Contains(err.Error(), "strict_auth") {
+		t.Fatalf("expected Start to refuse without a token, got %v", err)
+	}
+
+	s.tokens.Set("now-configured")
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected ready once a token is set, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHTTPServerUsesConfiguredTimeouts(t *testing.T) {
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.ReadHeaderTimeoutSeconds = 2
+		cfg.WriteTimeoutSeconds = 45
+	})
+
+	srv := s.httpServer()
+
+	if srv.ReadHeaderTimeout != 2*time.Second {
+		t.Fatalf("unexpected ReadHeaderTimeout: %s", srv.ReadHeaderTimeout)
+	}
+	if srv.ReadTimeout != config.DefaultReadTimeout {
+		t.Fatalf("unexpected ReadTimeout: %s", srv.ReadTimeout)
+	}
+	if srv.WriteTimeout != 45*time.Second {
+		t.Fatalf("unexpected WriteTimeout: %s", srv.WriteTimeout)
+	}
+	if srv.IdleTimeout != config.DefaultIdleTimeout {
+		t.Fatalf("unexpected IdleTimeout: %s", srv.IdleTimeout)
+	}
+}
+
+func TestStartServesOverUnixSocket(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "agent.sock")
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.ListenAddr = "unix:" + sockPath
+	})
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Start() }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(sockPath); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("socket file was not created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	info, err := os.Stat(sockPath)
+	if err != nil {
+		t.Fatalf("stat socket: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("unexpected socket permissions: %o", perm)
+	}
+
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sockPath)
+		},
+	}}
+	resp, err := httpClient.Get("http://agent/healthz")
+	if err != nil {
+		t.Fatalf("request over unix socket: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned error: %v", err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Start returned error: %v", err)
+	}
+	if _, err := os.Stat(sockPath); !os.IsNotExist(err) {
+		t.Fatalf("expected socket file to be removed, got %v", err)
+	}
+}
+
+func TestClusterCapacitySumsResourcesAcrossNodes(t *testing.T) {
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api2/json/cluster/resources" || r.URL.RawQuery != "" {
+			t.Errorf("unexpected upstream request: %s", r.URL.RequestURI())
+		}
+		_, _ = w.Write([]byte(`{"data":[
+			{"type":"node","node":"pve1","status":"online","maxcpu":16,"cpu":0.25,"maxmem":68719476736,"mem":17179869184},
+			{"type":"node","node":"pve2","status":"online","maxcpu":8,"cpu":0.5,"maxmem":34359738368,"mem":8589934592},
+			{"type":"qemu","node":"pve1","status":"running","maxcpu":4,"maxmem":8589934592},
+			{"type":"qemu","node":"pve2","status":"stopped","maxcpu":2,"maxmem":4294967296},
+			{"type":"lxc","node":"pve2","status":"running","maxcpu":1,"maxmem":1073741824},
+			{"type":"storage","node":"pve1","storage":"local","shared":0,"maxdisk":1000,"disk":400},
+			{"type":"storage","node":"pve1","storage":"ceph","shared":1,"maxdisk":5000,"disk":1000},
+			{"type":"storage","node":"pve2","storage":"ceph","shared":1,"maxdisk":5000,"disk":1000}
+		]}`))
+	})
+	s := newTestServer(client)
+
+	req := newAuthedRequest(http.MethodGet, "/v1/cluster/capacity?environment=home", "")
+	rr := httptest.NewRecorder()
+	s.clusterCapacity(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Result struct {
+			Data proxmox.ClusterCapacity `json:"data"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response JSON: %v", err)
+	}
+	totals := body.Result.Data.Totals
+	if totals.CPUTotal != 24 || totals.CPUAllocated != 7 {
+		t.Fatalf("unexpected CPU totals: %+v", totals)
+	}
+	if totals.MemTotalBytes != 103079215104 || totals.MemAllocated != 13958643712 {
+		t.Fatalf("unexpected memory totals: %+v", totals)
+	}
+	if totals.StorageTotal != 6000 || totals.StorageUsed != 1400 {
+		t.Fatalf("expected shared storage counted once, got %+v", totals)
+	}
+	if totals.Guests != 3 || totals.RunningGuests != 2 {
+		t.Fatalf("unexpected guest counts: %+v", totals)
+	}
+	if len(body.Result.Data.Nodes) != 2 || body.Result.Data.Nodes[1].Node != "pve2" || body.Result.Data.Nodes[1].CPUAllocated != 3 {
+		t.Fatalf("unexpected per-node breakdown: %+v", body.Result.Data.Nodes)
+	}
+}
+
+type versionProbeClient struct{}
+
+func (versionProbeClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	if req.Environment == "cloud" {
+		return proxmox.ActionResult{}, &proxmox.APIError{Method: http.MethodGet, Endpoint: "/api2/json/version", Message: "connection refused"}
+	}
+	return proxmox.ActionResult{Status: "ok", Data: proxmox.VersionInfo{Version: "8.2.4", Release: "8.2"}}, nil
+}
+
+func TestEnvironmentsProbeReportsVersionAndErrors(t *testing.T) {
+	s := newTestServerWithConfig(versionProbeClient{}, func(cfg *config.Config) {
+		cfg.Environments = append(cfg.Environments, config.Environment{
+			Name:           "cloud",
+			BaseURL:        "https://cloud.example.com",
+			TokenID:        "root@pam!agent",
+			TokenSecretEnv: "PVE_TEST_SECRET",
+		})
+	})
+
+	req := newAuthedRequest(http.MethodGet, "/v1/environments?probe=true", "")
+	rr := httptest.NewRecorder()
+	s.environments(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Environments []map[string]string `json:"environments"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response JSON: %v", err)
+	}
+	if len(body.Environments) != 2 {
+		t.Fatalf("expected 2 environments, got %d", len(body.Environments))
+	}
+	home, cloud := body.Environments[0], body.Environments[1]
+	if home["version"] != "8.2.4" || home["release"] != "8.2" || home["probe_error"] != "" {
+		t.Fatalf("unexpected home entry: %v", home)
+	}
+	if cloud["version"] != "" || !strings.Contains(cloud["probe_error"], "connection refused") {
+		t.Fatalf("expected probe error for cloud, got %v", cloud)
+	}
+}
+
+func TestEnvironmentsWithoutProbeSkipsUpstream(t *testing.T) {
+	client := &testClient{}
+	s := newTestServer(client)
+
+	rr := httptest.NewRecorder()
+	s.environments(rr, newAuthedRequest(http.MethodGet, "/v1/environments", ""))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if atomic.LoadInt32(&client.calls) != 0 {
+		t.Fatalf("expected no upstream calls without probe")
+	}
+	if strings.Contains(rr.Body.String(), "version") {
+		t.Fatalf("expected no version fields without probe: %s", rr.Body.String())
+	}
+}
+
+type runningVMClient struct {
+	testClient
+}
+
+func (c *runningVMClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	if req.Action == proxmox.ActionReadVM {
+		return proxmox.ActionResult{Status: "ok", Data: map[string]any{"status": "running"}}, nil
+	}
+	return c.testClient.Execute(req)
+}
+
+func TestApplyReturnsPreconditionFailedOnStatusMismatch(t *testing.T) {
+	client := &runningVMClient{}
+	s := newTestServer(client)
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"start_vm","target":"vm/101","params":{"node":"pve1"},"expect_status":"stopped"}`)
+	rr := httptest.NewRecorder()
+
+	s.apply(rr, req)
+
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if atomic.LoadInt32(&client.calls) != 0 {
+		t.Fatalf("expected start_vm not to execute")
+	}
+}
+
+func TestValidateRejectsExpectStatusOnNonVMAction(t *testing.T) {
+	s := newTestServer(&testClient{})
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"read_nodes","target":"nodes/all","expect_status":"running"}`)
+	rr := httptest.NewRecorder()
+
+	s.apply(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestApplyAuditRecordsActorAndOnBehalfOf(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	s := New(config.Config{
+		ListenAddr:   ":0",
+		Environments: []config.Environment{{Name: "home", BaseURL: "https://proxmox.example.com", TokenID: "root@pam!agent", TokenSecretEnv: "PVE_TEST_SECRET"}},
+	}, actions.NewRunner(policy.NewEngine(), &testClient{}, auditPath))
+	s.tokens.Set("test-token")
+	defer s.runner.Close()
+
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"start_vm","target":"vm/101","params":{"node":"pve"}}`)
+	req.Header.Set("X-On-Behalf-Of", "alice@example.com")
+	rr := httptest.NewRecorder()
+	s.apply(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit file: %v", err)
+	}
+	var record struct {
+		Actor      string `json:"actor"`
+		OnBehalfOf string `json:"on_behalf_of"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(string(b))), &record); err != nil {
+		t.Fatalf("decode audit record: %v", err)
+	}
+	if record.Actor != "test-agent" || record.OnBehalfOf != "alice@example.com" {
+		t.Fatalf("expected actor and on_behalf_of recorded separately, got %+v", record)
+	}
+}
+
+func TestTransactionIDLinksPlanAndApplyAuditRecords(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	s := New(config.Config{
+		ListenAddr:   ":0",
+		Environments: []config.Environment{{Name: "home", BaseURL: "https://proxmox.example.com", TokenID: "root@pam!agent", TokenSecretEnv: "PVE_TEST_SECRET"}},
+	}, actions.NewRunner(policy.NewEngine(), &testClient{}, auditPath))
+	s.tokens.Set("test-token")
+	defer s.runner.Close()
+	body := `{"environment":"home","action":"start_vm","target":"vm/101","params":{"node":"pve"}}`
+
+	planRR := httptest.NewRecorder()
+	s.Handler().ServeHTTP(planRR, newAuthedRequest(http.MethodPost, "/v1/actions/plan", body))
+	if planRR.Code != http.StatusOK {
+		t.Fatalf("plan: expected 200, got %d: %s", planRR.Code, planRR.Body.String())
+	}
+	txID := planRR.Header().Get("X-Transaction-ID")
+	if txID == "" {
+		t.Fatal("expected plan to generate an X-Transaction-ID")
+	}
+
+	applyReq := newAuthedRequest(http.MethodPost, "/v1/actions/apply", body)
+	applyReq.Header.Set("X-Transaction-ID", txID)
+	applyRR := httptest.NewRecorder()
+	s.Handler().ServeHTTP(applyRR, applyReq)
+	if applyRR.Code != http.StatusOK {
+		t.Fatalf("apply: expected 200, got %d: %s", applyRR.Code, applyRR.Body.String())
+	}
+	var applyResp actions.ApplyResponse
+	if err := json.Unmarshal(applyRR.Body.Bytes(), &applyResp); err != nil {
+		t.Fatalf("decode apply response: %v", err)
+	}
+	if applyResp.TransactionID != txID || applyRR.Header().Get("X-Transaction-ID") != txID {
+		t.Fatalf("expected apply to echo transaction %q, got body %q header %q", txID, applyResp.TransactionID, applyRR.Header().Get("X-Transaction-ID"))
+	}
+
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit file: %v", err)
+	}
+	kinds := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var record struct {
+			Kind          string `json:"kind"`
+			TransactionID string `json:"transaction_id"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("decode audit record: %v", err)
+		}
+		kinds[record.Kind] = record.TransactionID
+	}
+	if kinds["plan"] != txID || kinds["apply"] != txID {
+		t.Fatalf("expected plan and apply audit records to share %q, got %v", txID, kinds)
+	}
+}
+
+func TestApplyRejectsTransactionPlannedForAnotherRequest(t *testing.T) {
+	client := &testClient{}
+	s := newTestServer(client)
+	planRR := httptest.NewRecorder()
+	s.Handler().ServeHTTP(planRR, newAuthedRequest(http.MethodPost, "/v1/actions/plan", `{"environment":"home","action":"start_vm","target":"vm/101","params":{"node":"pve"}}`))
+	if planRR.Code != http.StatusOK {
+		t.Fatalf("plan: expected 200, got %d: %s", planRR.Code, planRR.Body.String())
+	}
+
+	applyReq := newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"start_vm","target":"vm/102","params":{"node":"pve"}}`)
+	applyReq.Header.Set("X-Transaction-ID", planRR.Header().Get("X-Transaction-ID"))
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, applyReq)
+	if rr.Code != http.StatusConflict || client.calls != 0 {
+		t.Fatalf("expected 409 without an upstream call, got %d (calls %d): %s", rr.Code, client.calls, rr.Body.String())
+	}
+}
+
+func TestApplyRejectsMalformedTransactionID(t *testing.T) {
+	client := &testClient{}
+	s := newTestServer(client)
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"start_vm","target":"vm/101","params":{"node":"pve"}}`)
+	req.Header.Set("X-Transaction-ID", "not a valid id")
+	rr := httptest.NewRecorder()
+	s.apply(rr, req)
+
+	if rr.Code != http.StatusBadRequest || client.calls != 0 {
+		t.Fatalf("expected 400 without an upstream call, got %d (calls %d)", rr.Code, client.calls)
+	}
+}
+
+func TestRequireAuthRejectsMalformedOnBehalfOf(t *testing.T) {
+	s := newTestServer(&testClient{})
+	req := newAuthedRequest(http.MethodGet, "/v1/nodes?environment=home", "")
+	req.Header.Set("X-On-Behalf-Of", "bad value with spaces")
+	rr := httptest.NewRecorder()
+
+	s.nodes(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestApplyMapsDigestMismatchToConflict(t *testing.T) {
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"errors":{"digest":"digest mismatch"},"data":null}`))
+	})
+	s := newTestServer(client)
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"storage_edit","target":"storage/local","params":{"endpoint":"/api2/json/storage/local","content":"iso","digest":"3f2a6a7b9c0d1e2f3a4b5c6d7e8f9a0b1c2d3e4f"},"approved_by":"ops-lead"}`)
+	rr := httptest.NewRecorder()
+
+	s.apply(rr, req)
+
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestApplyMapsClusterBusyToServiceUnavailable(t *testing.T) {
+	var calls int32
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(596)
+		_, _ = w.Write([]byte(`{"data":null}`))
+	})
+	s := newTestServer(client)
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"start_vm","target":"vm/101","params":{"node":"node1"},"approved_by":"ops-lead"}`)
+	rr := httptest.NewRecorder()
+
+	s.apply(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Retry-After"); got != "7" {
+		t.Fatalf("expected Retry-After 7, got %q", got)
+	}
+	if !strings.Contains(rr.Body.String(), "cluster busy, retry later") {
+		t.Fatalf("expected busy message, got %q", rr.Body.String())
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected write to fail fast without retries, got %d calls", got)
+	}
+}
+
+func TestApplyDebugCaptureRequiresAdminToken(t *testing.T) {
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":"UPID:node1:0001"}`))
+	})
+	s := newTestServer(client)
+	s.adminToken = "admin-secret"
+	body := `{"environment":"home","action":"start_vm","target":"vm/101","params":{"node":"node1"}}`
+
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/apply", body)
+	req.Header.Set("X-Debug-Capture", "wrong")
+	rr := httptest.NewRecorder()
+	s.apply(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for bad capture token, got %d", rr.Code)
+	}
+
+	req = newAuthedRequest(http.MethodPost, "/v1/actions/apply", body)
+	req.Header.Set("X-Debug-Capture", "admin-secret")
+	rr = httptest.NewRecorder()
+	s.apply(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Result       proxmox.ActionResult `json:"result"`
+		DebugCapture []proxmox.Exchange   `json:"debug_capture"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(resp.DebugCapture) != 1 || resp.DebugCapture[0].Endpoint != "/api2/json/nodes/node1/qemu/101/status/start" {
+		t.Fatalf("expected captured start call, got %+v", resp.DebugCapture)
+	}
+	if strings.Contains(rr.Body.String(), "test-secret") {
+		t.Fatal("debug capture leaked the token secret")
+	}
+}
+
+func TestApplyDebugCaptureIsNotStoredForReplay(t *testing.T) {
+	var calls int32
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte(`{"data":"UPID:node1:0001"}`))
+	})
+	s := newTestServer(client)
+	s.adminToken = "admin-secret"
+	body := `{"environment":"home","action":"start_vm","target":"vm/101","params":{"node":"node1"}}`
+
+	for i, capture := range []string{"admin-secret", ""} {
+		req := newAuthedRequest(http.MethodPost, "/v1/actions/apply", body)
+		req.Header.Set("Idempotency-Key", "capture-key")
+		if capture != "" {
+			req.Header.Set("X-Debug-Capture", capture)
+		}
+		rr := httptest.NewRecorder()
+		s.apply(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d: %s", i, rr.Code, rr.Body.String())
+		}
+		if got := strings.Contains(rr.Body.String(), "debug_capture"); got != (i == 0) {
+			t.Fatalf("request %d: debug_capture present = %v: %s", i, got, rr.Body.String())
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected the replay to skip upstream, got %d calls", got)
+	}
+}
+
+func TestPlanRequiresJSONContentType(t *testing.T) {
+	s := newTestServer(&testClient{})
+	body := `{"environment":"home","action":"read_vm","target":"vm/101"}`
+
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/plan", body)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	rr := httptest.NewRecorder()
+	s.plan(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 with charset suffix, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req = newAuthedRequest(http.MethodPost, "/v1/actions/plan", body)
+	req.Header.Set("Content-Type", "text/plain")
+	rr = httptest.NewRecorder()
+	s.plan(rr, req)
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415 for text/plain, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "application/json") {
+		t.Fatalf("expected message naming the required type, got %q", rr.Body.String())
+	}
+}
+
+func TestUIServesDashboardWhenEnabled(t *testing.T) {
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.UIEnabled = true
+	})
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ui", nil))
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "/ui/" {
+		t.Fatalf("expected redirect to /ui/, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ui/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("expected HTML content type, got %q", ct)
+	}
+	if !strings.Contains(rr.Body.String(), "<title>proxmox-agent</title>") {
+		t.Fatalf("unexpected body: %s", rr.Body.String())
+	}
+	if rr.Header().Get("Content-Security-Policy") == "" {
+		t.Fatal("expected a Content-Security-Policy header")
+	}
+
+	rr = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ui/app.js", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected app.js to be served, got %d", rr.Code)
+	}
+}
+
+func TestUIDisabledByDefault(t *testing.T) {
+	s := newTestServer(&testClient{})
+	for _, path := range []string{"/ui", "/ui/", "/ui/app.js"} {
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("%s: expected 404 with ui disabled, got %d", path, rr.Code)
+		}
+	}
+}
+
+func TestApplySurfacesProxmoxWarnings(t *testing.T) {
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":"UPID:pve:000A1B2C:0123ABCD:65F0AB12:qmstart:101:root@pam:","warnings":["machine type 'pc-i440fx-5.1' is deprecated"]}`))
+	})
+	s := newTestServer(client)
+
+	req := newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"start_vm","target":"vm/101","params":{"node":"pve"}}`)
+	rr := httptest.NewRecorder()
+	s.apply(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Result proxmox.ActionResult `json:"result"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(body.Result.Warnings) != 1 || !strings.Contains(body.Result.Warnings[0], "deprecated") {
+		t.Fatalf("expected the upstream warning in the result, got %+v", body.Result)
+	}
+}
+
+func TestReadRawReturnsUpstreamBodyVerbatim(t *testing.T) {
+	const upstream = `{"data":{"status":"running","vmid":101,"cpus":2,"ha":{"managed":0}}}`
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(upstream))
+	})
+	s := newTestServer(client)
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/v1/vm/status?environment=home&node=pve&vmid=101&raw=true", ""))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Body.String() != upstream {
+		t.Fatalf("expected upstream body verbatim, got %q", rr.Body.String())
+	}
+	if rr.Header().Get("X-Proxmox-Raw") != "true" {
+		t.Fatalf("expected X-Proxmox-Raw header, got %v", rr.Header())
+	}
+}
+
+func TestApplySuggestsRetryAfterOnlyForApprovalDenials(t *testing.T) {
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.ApprovalRetryAfterSeconds = 120
+	})
+	rr := httptest.NewRecorder()
+	s.apply(rr, newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"delete_vm","target":"vm/101","params":{"node":"pve"}}`))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Retry-After"); got != "120" {
+		t.Fatalf("expected Retry-After 120 on an approval-required denial, got %q", got)
+	}
+
+	cfg := s.cfg
+	blocked := New(cfg, actions.NewRunner(policy.NewEngine(policy.WithReadOnlyEnvironments([]string{"home"})), &testClient{}, ""))
+	blocked.tokens.Set("test-token")
+	rr = httptest.NewRecorder()
+	blocked.apply(rr, newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"delete_vm","target":"vm/101","params":{"node":"pve"}}`))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Retry-After"); got != "" {
+		t.Fatalf("expected no Retry-After on a read-only block, got %q", got)
+	}
+}
+
+func TestNodeVersionWithSubscriptionUsesReadPipeline(t *testing.T) {
+	client := &testClient{}
+	s := newTestServer(client)
+	handler := s.Handler()
+	send := func() *httptest.ResponseRecorder {
+		req := newAuthedRequest(http.MethodGet, "/v1/node/version?environment=home&node=pve&subscription=true", "")
+		req.Header.Set("Idempotency-Key", "version-1")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response JSON: %v", err)
+	}
+	for _, field := range []string{"plan", "result", "subscription"} {
+		if _, ok := body[field]; !ok {
+			t.Fatalf("expected %q in response, got %v", field, body)
+		}
+	}
+	if got := atomic.LoadInt32(&client.calls); got != 2 {
+		t.Fatalf("expected version and subscription reads, got %d calls", got)
+	}
+
+	if replay := send(); replay.Code != http.StatusOK || replay.Body.String() != rr.Body.String() {
+		t.Fatalf("expected the idempotent replay, got %d: %s", replay.Code, replay.Body.String())
+	}
+	if got := atomic.LoadInt32(&client.calls); got != 2 {
+		t.Fatalf("expected the replay not to reach upstream, got %d calls", got)
+	}
+}
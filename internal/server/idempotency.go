@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"sync"
+	"sync/atomic"
 
 	"github.com/junlov/proxmox-ai/internal/proxmox"
 )
@@ -19,12 +20,39 @@ type idempotencyRecord struct {
 type idempotencyStore struct {
 	mu      sync.Mutex
 	records map[string]idempotencyRecord
+
+	hash         func(proxmox.ActionRequest) (string, error)
+	hashFailures atomic.Int64
 }
 
 func newIdempotencyStore() *idempotencyStore {
 	return &idempotencyStore{
 		records: make(map[string]idempotencyRecord),
+		hash:    hashActionRequest,
+	}
+}
+
+// Hash fingerprints req for replay matching and counts failures so they are
+// visible even though the input is always marshalable in practice.
+func (s *idempotencyStore) Hash(req proxmox.ActionRequest) (string, error) {
+	hash, err := s.hash(req)
+	if err != nil {
+		s.hashFailures.Add(1)
+	}
+	return hash, err
+}
+
+// HashBatch fingerprints a batch as the ordered hashes of its items.
+func (s *idempotencyStore) HashBatch(reqs []proxmox.ActionRequest) (string, error) {
+	sum := sha256.New()
+	for _, req := range reqs {
+		hash, err := s.Hash(req)
+		if err != nil {
+			return "", err
+		}
+		sum.Write([]byte(hash))
 	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
 }
 
 func (s *idempotencyStore) Get(scope, key string) (idempotencyRecord, bool) {
@@ -56,6 +84,11 @@ func hashActionRequest(req proxmox.ActionRequest) (string, error) {
 		ApprovalTicket string             `json:"approval_ticket,omitempty"`
 		Reason         string             `json:"reason,omitempty"`
 		ExpiresAt      string             `json:"expires_at,omitempty"`
+		MinRisk        string             `json:"min_risk,omitempty"`
+		ExpectStatus   string             `json:"expect_status,omitempty"`
+		TokenIdentity  string             `json:"token_identity,omitempty"`
+		Raw            bool               `json:"raw,omitempty"`
+		Hold           bool               `json:"hold,omitempty"`
 	}{
 		Environment:    req.Environment,
 		Action:         req.Action,
@@ -66,6 +99,11 @@ func hashActionRequest(req proxmox.ActionRequest) (string, error) {
 		ApprovalTicket: req.ApprovalTicket,
 		Reason:         req.Reason,
 		ExpiresAt:      req.ExpiresAt,
+		MinRisk:        req.MinRisk,
+		ExpectStatus:   req.ExpectStatus,
+		TokenIdentity:  req.TokenIdentity,
+		Raw:            req.Raw,
+		Hold:           req.Hold,
 	})
 	if err != nil {
 		return "", err
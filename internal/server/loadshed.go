@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// loadShedder turns away low-priority requests while more than limit
+// requests are in flight, so writes keep flowing during an incident
+// instead of queueing behind reads. A zero limit disables it.
+type loadShedder struct {
+	limit      int64
+	inFlight   atomic.Int64
+	priorities map[proxmox.ActionType]string
+}
+
+func newLoadShedder(cfg config.Config) *loadShedder {
+	priorities := make(map[proxmox.ActionType]string, len(cfg.LoadShedPriorities))
+	for action, priority := range cfg.LoadShedPriorities {
+		priorities[proxmox.ActionType(strings.TrimSpace(action))] = priority
+	}
+	return &loadShedder{limit: int64(cfg.LoadShedMaxInFlight), priorities: priorities}
+}
+
+// priority returns the configured priority for action; reads default to
+// "low" and everything else to "high".
+func (l *loadShedder) priority(action proxmox.ActionType) string {
+	if priority, ok := l.priorities[action]; ok {
+		return priority
+	}
+	if proxmox.IsReadAction(action) {
+		return config.PriorityLow
+	}
+	return config.PriorityHigh
+}
+
+func (s *Server) trackInFlight(next http.Handler) http.Handler {
+	if s.shedder.limit <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.shedder.inFlight.Add(1)
+		defer s.shedder.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shedLoad answers 503 and reports true when action is low priority and
+// the server is over its in-flight limit.
+func (s *Server) shedLoad(w http.ResponseWriter, action proxmox.ActionType) bool {
+	l := s.shedder
+	if l.limit <= 0 || l.inFlight.Load() <= l.limit || l.priority(action) != config.PriorityLow {
+		return false
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "server overloaded; low-priority request shed", http.StatusServiceUnavailable)
+	return true
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+)
+
+func TestLoadSheddingRejectsReadsButAcceptsApply(t *testing.T) {
+	client := &testClient{}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.LoadShedMaxInFlight = 2
+	})
+	handler := s.Handler()
+	// Simulate two requests already being served.
+	s.shedder.inFlight.Add(2)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/v1/vm/status?environment=home&node=pve&vmid=101", ""))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected read to be shed with 503, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After on shed response")
+	}
+	if client.calls != 0 {
+		t.Fatalf("expected shed read not to reach upstream, got %d calls", client.calls)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newAuthedRequest(http.MethodPost, "/v1/actions/apply", `{"environment":"home","action":"start_vm","target":"vm/101","params":{"node":"pve"}}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected apply to proceed during overload, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	s.shedder.inFlight.Add(-2)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/v1/vm/status?environment=home&node=pve&vmid=101", ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected read to succeed once load drops, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestLoadSheddingHonorsPriorityOverrides(t *testing.T) {
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.LoadShedMaxInFlight = 1
+		cfg.LoadShedPriorities = map[string]string{"read_vm": "high"}
+	})
+	handler := s.Handler()
+	s.shedder.inFlight.Add(1)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/v1/vm/status?environment=home&node=pve&vmid=101", ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected high-priority read to proceed, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestLoadSheddingRejectsBulkTaskStatus(t *testing.T) {
+	client := &testClient{}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.LoadShedMaxInFlight = 1
+	})
+	s.shedder.inFlight.Add(1)
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodPost, "/v1/tasks/status/bulk", `{"environment":"home","node":"pve","upids":["UPID:pve:0001","UPID:pve:0002"]}`))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected bulk task status to be shed with 503, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if client.calls != 0 {
+		t.Fatalf("expected shed lookups not to reach upstream, got %d calls", client.calls)
+	}
+}
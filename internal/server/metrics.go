@@ -0,0 +1,72 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/junlov/proxmox-ai/internal/actions"
+	"github.com/junlov/proxmox-ai/internal/config"
+)
+
+// metrics holds the /metrics counters and who may scrape them.
+type metrics struct {
+	requests atomic.Int64
+
+	token   string
+	allowed cidrSet
+	open    bool
+}
+
+func newMetrics(cfg config.Config) *metrics {
+	return &metrics{
+		token:   strings.TrimSpace(os.Getenv("PROXMOX_AGENT_METRICS_TOKEN")),
+		allowed: parseCIDRSet(cfg.MetricsAllowedCIDRs),
+		open:    cfg.MetricsOpen,
+	}
+}
+
+// permits reports whether a scrape from clientIP carrying bearer may read
+// the metrics. With neither a token nor CIDRs configured, only metrics_open
+// lets anyone in.
+func (m *metrics) permits(clientIP, bearer string) bool {
+	if m.open {
+		return true
+	}
+	if m.token != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(m.token)) == 1 {
+		return true
+	}
+	return m.allowed.contains(clientIP)
+}
+
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	bearer := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(r.Header.Get("Authorization")), "Bearer "))
+	if !s.metrics.permits(s.clientIP.Resolve(r), bearer) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	authConfigured := 0
+	if s.tokens.Configured() {
+		authConfigured = 1
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# TYPE proxmox_agent_http_requests_total counter\nproxmox_agent_http_requests_total %d\n", s.metrics.requests.Load())
+	fmt.Fprintf(w, "# TYPE proxmox_agent_idempotency_hash_failures_total counter\nproxmox_agent_idempotency_hash_failures_total %d\n", s.idem.hashFailures.Load())
+	fmt.Fprintf(w, "# TYPE proxmox_agent_auth_configured gauge\nproxmox_agent_auth_configured %d\n", authConfigured)
+	plan, apply := s.runner.Timings()
+	fmt.Fprintf(w, "# TYPE proxmox_agent_phase_duration_seconds summary\n")
+	for _, phase := range []struct {
+		name   string
+		timing actions.PhaseTiming
+	}{{"plan", plan}, {"apply", apply}} {
+		fmt.Fprintf(w, "proxmox_agent_phase_duration_seconds_sum{phase=%q} %g\n", phase.name, phase.timing.Total.Seconds())
+		fmt.Fprintf(w, "proxmox_agent_phase_duration_seconds_count{phase=%q} %d\n", phase.name, phase.timing.Count)
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+)
+
+func TestMetricsAllowsScrapeFromAllowedCIDR(t *testing.T) {
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.MetricsEnabled = true
+		cfg.MetricsAllowedCIDRs = []string{"10.0.0.0/8"}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "10.1.2.3:40000"
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "proxmox_agent_http_requests_total 1") {
+		t.Fatalf("expected request counter, got %q", rr.Body.String())
+	}
+}
+
+func TestMetricsRejectsScrapeFromOtherAddress(t *testing.T) {
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.MetricsEnabled = true
+		cfg.MetricsAllowedCIDRs = []string{"10.0.0.0/8"}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "192.0.2.7:40000"
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rr.Code)
+	}
+}
+
+func TestMetricsAcceptsMetricsToken(t *testing.T) {
+	t.Setenv("PROXMOX_AGENT_METRICS_TOKEN", "scrape-token")
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.MetricsEnabled = true
+	})
+
+	for token, want := range map[string]int{"scrape-token": http.StatusOK, "test-token": http.StatusForbidden} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Fatalf("token %q: expected %d, got %d", token, want, rr.Code)
+		}
+	}
+}
+
+func TestMetricsExportPhaseTimings(t *testing.T) {
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.MetricsEnabled = true
+		cfg.MetricsOpen = true
+	})
+	handler := s.Handler()
+	handler.ServeHTTP(httptest.NewRecorder(), newAuthedRequest(http.MethodGet, "/v1/vm/status?environment=home&node=pve&vmid=101", ""))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`proxmox_agent_phase_duration_seconds_count{phase="plan"} 1`,
+		`proxmox_agent_phase_duration_seconds_count{phase="apply"} 1`,
+		`proxmox_agent_phase_duration_seconds_sum{phase="apply"} `,
+	} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Fatalf("expected %q in metrics, got %q", want, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/actions"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// readDedup collapses identical reads that arrive within window of each
+// other onto one Plan/Apply, so client retries on a flaky network do not
+// multiply upstream load. A duplicate either waits for the in-flight call
+// or reuses a result that completed less than window ago. A zero window
+// disables it.
+type readDedup struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu    sync.Mutex
+	calls map[string]*dedupCall
+}
+
+type dedupCall struct {
+	done     chan struct{}
+	finished time.Time
+
+	// requestID is the request that ran the shared call.
+	requestID string
+	plan      actions.PlanResponse
+	apply     actions.ApplyResponse
+	// status is the HTTP status err maps to, so duplicates answer with
+	// the same code as the original.
+	status int
+	err    error
+}
+
+func newReadDedup(windowMillis int) *readDedup {
+	return &readDedup{
+		window: time.Duration(windowMillis) * time.Millisecond,
+		now:    time.Now,
+		calls:  make(map[string]*dedupCall),
+	}
+}
+
+// Do runs fn for key unless an identical call is in flight or finished
+// within the window, in which case that call's result is returned and
+// shared is true. Each call removes itself from the map a window after it
+// finishes.
+func (d *readDedup) Do(key string, fn func(*dedupCall)) (_ *dedupCall, shared bool) {
+	d.mu.Lock()
+	if call, ok := d.calls[key]; ok && (call.finished.IsZero() || d.now().Sub(call.finished) < d.window) {
+		d.mu.Unlock()
+		<-call.done
+		return call, true
+	}
+	call := &dedupCall{done: make(chan struct{})}
+	d.calls[key] = call
+	d.mu.Unlock()
+
+	defer close(call.done)
+	defer d.finish(key, call)
+	fn(call)
+	return call, false
+}
+
+// finish marks call finished and schedules its removal once the window
+// has passed, unless a newer call for key has replaced it by then.
+func (d *readDedup) finish(key string, call *dedupCall) {
+	d.mu.Lock()
+	call.finished = d.now()
+	d.mu.Unlock()
+	time.AfterFunc(d.window, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.calls[key] == call {
+			delete(d.calls, key)
+		}
+	})
+}
+
+// readDedupKey scopes a read to its actor and everything that shapes the
+// upstream call; ok is false when the read must not be shared.
+func (s *Server) readDedupKey(req proxmox.ActionRequest, idempotencyKey string) (string, bool) {
+	if s.dedup.window <= 0 || idempotencyKey != "" || req.Capture != nil || !proxmox.IsReadAction(req.Action) {
+		return "", false
+	}
+	hash, err := s.idem.Hash(req)
+	if err != nil {
+		return "", false
+	}
+	raw := "json"
+	if req.Raw {
+		raw = "raw"
+	}
+	return req.Actor + "|" + req.OnBehalfOf + "|" + req.TokenIdentity + "|" + raw + "|" + hash, true
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/actions"
+	"github.com/junlov/proxmox-ai/internal/config"
+	"github.com/junlov/proxmox-ai/internal/policy"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// gatedClient holds every Execute until release is closed.
+type gatedClient struct {
+	testClient
+	release chan struct{}
+}
+
+func (c *gatedClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	<-c.release
+	return c.testClient.Execute(req)
+}
+
+func TestReadDedupSharesOneUpstreamCallAcrossRapidRetries(t *testing.T) {
+	client := &gatedClient{release: make(chan struct{})}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.ReadDedupWindowMillis = 500
+	})
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	s.dedup.now = func() time.Time { return now }
+	handler := s.Handler()
+	const path = "/v1/vm/status?environment=home&node=pve&vmid=101"
+
+	var wg sync.WaitGroup
+	codes := make([]int, 5)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, newAuthedRequest(http.MethodGet, path, ""))
+			codes[i] = rr.Code
+		}()
+	}
+	close(client.release)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, code)
+		}
+	}
+	if got := atomic.LoadInt32(&client.calls); got != 1 {
+		t.Fatalf("expected one upstream call for rapid duplicates, got %d", got)
+	}
+
+	keyed := newAuthedRequest(http.MethodGet, path, "")
+	keyed.Header.Set("Idempotency-Key", "status-1")
+	handler.ServeHTTP(httptest.NewRecorder(), keyed)
+	if got := atomic.LoadInt32(&client.calls); got != 2 {
+		t.Fatalf("expected a keyed read to bypass dedup, got %d calls", got)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newAuthedRequest(http.MethodGet, path, ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 after the window, got %d", rr.Code)
+	}
+	if got := atomic.LoadInt32(&client.calls); got != 3 {
+		t.Fatalf("expected a fresh upstream call once the window passed, got %d", got)
+	}
+}
+
+func TestReadDedupAuditsEverySharedCaller(t *testing.T) {
+	client := &testClient{}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.ReadDedupWindowMillis = 500
+	})
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	s.runner = actions.NewRunner(policy.NewEngine(), client, auditPath)
+	defer s.runner.Close()
+	handler := s.Handler()
+
+	for _, id := range []string{"req-1", "req-2"} {
+		req := newAuthedRequest(http.MethodGet, "/v1/vm/status?environment=home&node=pve&vmid=101", "")
+		req.Header.Set("X-Request-ID", id)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", id, rr.Code)
+		}
+	}
+	if got := atomic.LoadInt32(&client.calls); got != 1 {
+		t.Fatalf("expected the second read to be shared, got %d upstream calls", got)
+	}
+
+	b, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("read audit log: %v", err)
+	}
+	var shared []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("decode audit record: %v", err)
+		}
+		if record["kind"] == "read_shared" {
+			shared = append(shared, record)
+		}
+	}
+	if len(shared) != 1 || shared[0]["request_id"] != "req-2" || shared[0]["shared_with"] != "req-1" {
+		t.Fatalf("expected one read_shared record for req-2, got %v", shared)
+	}
+}
+
+func TestReadDedupForgetsFinishedCallsAfterTheWindow(t *testing.T) {
+	d := newReadDedup(10)
+	d.Do("key", func(*dedupCall) {})
+	deadline := time.Now().Add(time.Second)
+	for {
+		d.mu.Lock()
+		n := len(d.calls)
+		d.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the finished call to be removed after the window")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
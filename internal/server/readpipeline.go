@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// prepareReads runs the steps every read takes before anything executes:
+// load shedding, validation, debug capture, token identity and idempotent
+// replay. It fills in the caller fields of reqs and reports false when it
+// has already answered. Composite reads pass every request they will make,
+// so a bad parameter fails them all before the first one runs.
+func (s *Server) prepareReads(w http.ResponseWriter, r *http.Request, reqs ...*proxmox.ActionRequest) bool {
+	noteRequest(r, *reqs[0])
+	if s.shedLoad(w, reqs[0].Action) {
+		return false
+	}
+	for _, req := range reqs {
+		req.OnBehalfOf = onBehalfOf(r)
+		req.Context = r.Context()
+		if err := s.validator.ValidateActionRequest(*req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return false
+		}
+	}
+	capture, ok := s.debugCapture(w, r)
+	if !ok {
+		return false
+	}
+	identity, ok := s.tokenIdentity(w, r, reqs[0].Environment)
+	if !ok {
+		return false
+	}
+	values := make([]proxmox.ActionRequest, len(reqs))
+	for i, req := range reqs {
+		req.TokenIdentity = identity
+		values[i] = *req
+	}
+	if _, handled := s.tryReplayHashed(w, r, s.readsHash(values)); handled {
+		return false
+	}
+	for _, req := range reqs {
+		req.Capture = capture
+	}
+	return true
+}
+
+// readsHash fingerprints the requests of one read for idempotent replay; a
+// single read hashes like any other request.
+func (s *Server) readsHash(reqs []proxmox.ActionRequest) func() (string, error) {
+	return func() (string, error) {
+		if len(reqs) == 1 {
+			return s.idem.Hash(reqs[0])
+		}
+		return s.idem.HashBatch(reqs)
+	}
+}
+
+// executeRead plans and applies one prepared read, sharing the outcome
+// with identical reads inside the dedup window. A caller served by another
+// request's read is still audited, under its own request ID.
+func (s *Server) executeRead(r *http.Request, req proxmox.ActionRequest) *dedupCall {
+	key, ok := s.readDedupKey(req, strings.TrimSpace(r.Header.Get("Idempotency-Key")))
+	if !ok {
+		call := &dedupCall{}
+		call.plan, call.apply, call.status, call.err = s.planThenApply(req)
+		return call
+	}
+	// The shared read serves every caller in the window, so one of them
+	// disconnecting must not cancel it.
+	shared := req
+	shared.Context = context.WithoutCancel(r.Context())
+	call, reused := s.dedup.Do(key, func(call *dedupCall) {
+		call.requestID = req.RequestID
+		call.plan, call.apply, call.status, call.err = s.planThenApply(shared)
+	})
+	if !reused {
+		return call
+	}
+	decision := call.apply.Decision
+	if call.err != nil {
+		decision = call.plan.Decision
+	}
+	if err := s.runner.AuditSharedRead(req, decision, call.requestID, call.err); err != nil {
+		return &dedupCall{status: applyErrorStatus(err), err: err}
+	}
+	return call
+}
+
+// writeReadJSON answers a read with body, adding the debug capture when
+// one was requested, and stores it, without the capture, for idempotent
+// replay.
+func (s *Server) writeReadJSON(w http.ResponseWriter, r *http.Request, reqs []proxmox.ActionRequest, body map[string]any) {
+	s.writeReadStatusJSON(w, r, reqs, http.StatusOK, body)
+}
+
+// writeReadStatusJSON is writeReadJSON for a composite read whose parts can
+// fail on their own, such as a 207 Multi-Status answer.
+func (s *Server) writeReadStatusJSON(w http.ResponseWriter, r *http.Request, reqs []proxmox.ActionRequest, status int, body map[string]any) {
+	respBody, contentType := marshalJSONBody(body)
+	if capture := reqs[0].Capture; capture != nil {
+		body["debug_capture"] = capture.Exchanges()
+		s.writeCaptured(w, r, s.readsHash(reqs), status, body, contentType, respBody)
+		return
+	}
+	s.writeRaw(w, status, contentType, respBody)
+	s.storeHashedResponse(r, s.readsHash(reqs), status, contentType, respBody)
+}
+
+// writeReadError reports a failed read with status, including the debug
+// capture when one was requested.
+func (s *Server) writeReadError(w http.ResponseWriter, r *http.Request, reqs []proxmox.ActionRequest, status int, err error) {
+	setRetryAfter(w, err)
+	if capture := reqs[0].Capture; capture != nil {
+		s.writeCaptureError(w, r, s.readsHash(reqs), status, err.Error(), capture)
+		return
+	}
+	contentType := "text/plain; charset=utf-8"
+	respBody := []byte(err.Error() + "\n")
+	s.writeRaw(w, status, contentType, respBody)
+	s.storeHashedResponse(r, s.readsHash(reqs), status, contentType, respBody)
+}
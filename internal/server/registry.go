@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// actionSpec describes an action the API accepts. The registry is the single
+// source for validation and for GET /v1/actions.
+type actionSpec struct {
+	Action      proxmox.ActionType
+	Target      string
+	Description string
+	pattern     *regexp.Regexp
+}
+
+var actionRegistry = []actionSpec{
+	{proxmox.ActionReadVM, "vm/<id>", "Read a VM's current status.", vmTargetPattern},
+	{proxmox.ActionReadVMPending, "vm/<id>", "Read a VM's config with changes pending until next boot.", vmTargetPattern},
+	{proxmox.ActionReadVMNetwork, "vm/<id>", "Read a VM's current and pending network interfaces, parsed.", vmTargetPattern},
+	{proxmox.ActionReadVMSnapshotConfig, "vm/<id>", "Read the VM config captured in one snapshot (params.snapname).", vmTargetPattern},
+	{proxmox.ActionReadVMRRDData, "vm/<id>", "Read the VM's CPU and memory rrddata over params.timeframe (hour by default).", vmTargetPattern},
+	{proxmox.ActionGuestOSInfo, "vm/<id>", "Read the guest OS name, version, and kernel from the QEMU guest agent.", vmTargetPattern},
+	{proxmox.ActionReadVMConfig, "vm/<id>", "Read a VM's current config, including protection, onboot, and startup.", vmTargetPattern},
+	{proxmox.ActionReadVMSnapshots, "vm/<id>", "List a VM's snapshots with their descriptions.", vmTargetPattern},
+	{proxmox.ActionReadInventory, "inventory/all or inventory/running", "List VM and LXC resources.", inventoryTargetPattern},
+	{proxmox.ActionReadNodes, "nodes/all", "List cluster nodes.", nodesTargetPattern},
+	{proxmox.ActionReadTaskStatus, "task/status", "Read the status of one task by UPID.", taskStatusTargetPattern},
+	{proxmox.ActionReadTasks, "task/list", "List recent tasks on a node.", taskListTargetPattern},
+	{proxmox.ActionReadHAStatus, "ha/status", "Read cluster HA manager status.", haStatusTargetPattern},
+	{proxmox.ActionReadCapacity, "cluster/capacity", "Aggregate cluster CPU, memory, and storage usage.", capacityTargetPattern},
+	{proxmox.ActionReadClusterLog, "cluster/log", "Read recent cluster log entries, optionally filtered by severity.", clusterLogTargetPattern},
+	{proxmox.ActionReadPoolMembers, "pool/<poolid>", "List a resource pool's members with their current status.", poolTargetPattern},
+	{proxmox.ActionReadReplicationStatus, "replication/<vmid>-<jobnum>", "Read a replication job's last sync and error on params.node; params.log adds its log.", replicationTargetPattern},
+	{proxmox.ActionReadVersion, "version", "Read the Proxmox VE API version.", versionTargetPattern},
+	{proxmox.ActionReadNodeVersion, "node/<name>", "Read a node's package version.", nodeTargetPattern},
+	{proxmox.ActionReadNodeSubscription, "node/<name>", "Read a node's subscription status.", nodeTargetPattern},
+	{proxmox.ActionStartVM, "vm/<id>", "Start a VM.", vmTargetPattern},
+	{proxmox.ActionStopVM, "vm/<id>", "Stop a VM.", vmTargetPattern},
+	{proxmox.ActionSnapshotVM, "vm/<id>", "Create a VM snapshot (params.snapname, optional params.description).", vmTargetPattern},
+	{proxmox.ActionCloneVM, "vm/<id>", "Clone a VM.", vmTargetPattern},
+	{proxmox.ActionMigrateVM, "vm/<id>", "Migrate a VM to another node.", vmTargetPattern},
+	{proxmox.ActionSetVMConfig, "vm/<id>", "Change allowlisted VM config options (params.protection, onboot, startup).", vmTargetPattern},
+	{proxmox.ActionDeleteSnapshot, "vm/<id>", "Delete one VM snapshot (params.snapname; params.wait blocks until its task ends). Requires approval.", vmTargetPattern},
+	{proxmox.ActionDeleteVM, "vm/<id>", "Delete a VM.", vmTargetPattern},
+	{proxmox.ActionStorageEdit, "storage/<name>", "Edit storage configuration via a Proxmox API endpoint.", storageTargetPattern},
+	{proxmox.ActionFirewallEdit, "firewall/cluster, firewall/node/<name>, or firewall/vm/<id>", "Edit firewall rules via a Proxmox API endpoint.", firewallTargetPattern},
+}
+
+func lookupAction(action proxmox.ActionType) (actionSpec, bool) {
+	for _, spec := range actionRegistry {
+		if spec.Action == action {
+			return spec, true
+		}
+	}
+	return actionSpec{}, false
+}
+
+func (s *Server) listActions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireAuth(w, r); !ok {
+		return
+	}
+	out := make([]map[string]any, 0, len(actionRegistry))
+	for _, spec := range actionRegistry {
+		risk, requiresApproval := s.runner.DefaultRisk(spec.Action)
+		out = append(out, map[string]any{
+			"action":            spec.Action,
+			"risk_level":        risk,
+			"requires_approval": requiresApproval,
+			"target":            spec.Target,
+			"description":       spec.Description,
+		})
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{"actions": out})
+}
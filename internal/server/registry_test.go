@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+func TestListActionsReportsRiskAndApproval(t *testing.T) {
+	s := newTestServer(&testClient{})
+	rr := httptest.NewRecorder()
+
+	s.listActions(rr, newAuthedRequest(http.MethodGet, "/v1/actions", ""))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var body struct {
+		Actions []struct {
+			Action           string `json:"action"`
+			RiskLevel        string `json:"risk_level"`
+			RequiresApproval bool   `json:"requires_approval"`
+			Target           string `json:"target"`
+		} `json:"actions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response JSON: %v", err)
+	}
+	if len(body.Actions) != len(actionRegistry) {
+		t.Fatalf("expected %d actions, got %d", len(actionRegistry), len(body.Actions))
+	}
+	for _, a := range body.Actions {
+		if a.Action != "delete_vm" {
+			continue
+		}
+		if a.RiskLevel != "high" || !a.RequiresApproval || a.Target != "vm/<id>" {
+			t.Fatalf("unexpected delete_vm entry: %+v", a)
+		}
+		return
+	}
+	t.Fatal("delete_vm missing from action list")
+}
+
+func TestListActionsRequiresAuth(t *testing.T) {
+	s := newTestServer(&testClient{})
+	rr := httptest.NewRecorder()
+
+	s.listActions(rr, httptest.NewRequest(http.MethodGet, "/v1/actions", nil))
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestActionRegistryMatchesClientActions(t *testing.T) {
+	for _, spec := range actionRegistry {
+		if !proxmox.IsKnownAction(string(spec.Action)) {
+			t.Errorf("registry lists %q, which the client does not implement", spec.Action)
+		}
+		if got, want := proxmox.IsVMAction(spec.Action), spec.pattern == vmTargetPattern; got != want {
+			t.Errorf("%q: IsVMAction = %v, but registry target is %q", spec.Action, got, spec.Target)
+		}
+	}
+}
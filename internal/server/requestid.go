@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type requestIDKey struct{}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// withRequestID tags every request with an ID, taken from a well-formed
+// X-Request-ID header or generated, and echoes it in the response. Handlers
+// read it back with requestID.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(r.Header.Get("X-Request-ID"))
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID returns the ID assigned by withRequestID, or "" for requests
+// that did not pass through it.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// transactionID returns the plan/apply transaction ID from X-Transaction-ID,
+// generating one when the header is absent, and echoes it in the response.
+// A malformed header is rejected with 400.
+func transactionID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := strings.TrimSpace(r.Header.Get("X-Transaction-ID"))
+	if id == "" {
+		id = newRequestID()
+	} else if !requestIDPattern.MatchString(id) {
+		http.Error(w, "X-Transaction-ID must be 1-128 letters, digits, or ._:-", http.StatusBadRequest)
+		return "", false
+	}
+	w.Header().Set("X-Transaction-ID", id)
+	return id, true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+type requestLogKey struct{}
+
+// requestLogFields collects what handlers learn about a request (its action
+// and environment) for the line logRequests writes when it completes.
+type requestLogFields struct {
+	action      string
+	environment string
+}
+
+// noteRequest records req's action and environment for the request log.
+func noteRequest(r *http.Request, req proxmox.ActionRequest) {
+	if fields, ok := r.Context().Value(requestLogKey{}).(*requestLogFields); ok {
+		fields.action = string(req.Action)
+		fields.environment = req.Environment
+	}
+}
+
+// logRequests writes one slog text line per request once it completes, at
+// WARN when it took longer than slow_request_threshold_ms. Lines go to the
+// standard logger's output.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	slow := time.Duration(s.cfg.SlowRequestThresholdMs) * time.Millisecond
+	logger := slog.New(slog.NewTextHandler(log.Writer(), nil))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.metrics.requests.Add(1)
+		fields := &requestLogFields{}
+		start := time.Now()
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, fields)))
+		elapsed := time.Since(start)
+
+		if fields.environment == "" {
+			fields.environment = strings.TrimSpace(r.URL.Query().Get("environment"))
+		}
+		level, msg := slog.LevelInfo, "request"
+		if slow > 0 && elapsed > slow {
+			level, msg = slog.LevelWarn, "slow request"
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int64("duration_ms", elapsed.Milliseconds()),
+		}
+		if fields.action != "" {
+			attrs = append(attrs, slog.String("action", fields.action))
+		}
+		if fields.environment != "" {
+			attrs = append(attrs, slog.String("environment", fields.environment))
+		}
+		if id := requestID(r); id != "" {
+			attrs = append(attrs, slog.String("request_id", id))
+		}
+		logger.LogAttrs(r.Context(), level, msg, attrs...)
+	})
+}
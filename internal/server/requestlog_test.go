@@ -0,0 +1,62 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+)
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+func TestLogRequestsWarnsAboutSlowRequest(t *testing.T) {
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	})
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.SlowRequestThresholdMs = 10
+	})
+	logs := captureLog(t)
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/v1/nodes?environment=home", ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	line := logs.String()
+	for _, want := range []string{`level=WARN msg="slow request"`, "path=/v1/nodes", "action=read_nodes", "environment=home", "duration_ms="} {
+		if !strings.Contains(line, want) {
+			t.Fatalf("expected %q in slow request log, got %q", want, line)
+		}
+	}
+}
+
+func TestLogRequestsLogsFastRequestAtInfo(t *testing.T) {
+	s := newTestServerWithConfig(&testClient{}, func(cfg *config.Config) {
+		cfg.SlowRequestThresholdMs = 10000
+	})
+	logs := captureLog(t)
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/healthz", ""))
+	if line := logs.String(); !strings.Contains(line, "level=INFO msg=request method=GET path=/healthz") {
+		t.Fatalf("expected info-level request log, got %q", line)
+	}
+}
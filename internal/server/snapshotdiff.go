@@ -0,0 +1,74 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// vmSnapshotDiff compares the VM config captured in two snapshots:
+// GET /v1/vm/snapshots/diff?environment=&node=&vmid=&from=&to=.
+func (s *Server) vmSnapshotDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor, ok := s.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	environment := strings.TrimSpace(query.Get("environment"))
+	node := strings.TrimSpace(query.Get("node"))
+	vmid := strings.TrimSpace(query.Get("vmid"))
+	from := strings.TrimSpace(query.Get("from"))
+	to := strings.TrimSpace(query.Get("to"))
+	if environment == "" || node == "" || vmid == "" || from == "" || to == "" {
+		http.Error(w, "environment, node, vmid, from, and to query parameters are required", http.StatusBadRequest)
+		return
+	}
+	reqs := make([]proxmox.ActionRequest, 2)
+	for i, snapname := range []string{from, to} {
+		reqs[i] = proxmox.ActionRequest{
+			Environment: environment,
+			Action:      proxmox.ActionReadVMSnapshotConfig,
+			Target:      "vm/" + vmid,
+			Params:      map[string]any{"node": node, "snapname": snapname},
+			Actor:       actor,
+			ClientIP:    s.clientIP.Resolve(r),
+			RequestID:   requestID(r),
+		}
+	}
+	if !s.prepareReads(w, r, &reqs[0], &reqs[1]) {
+		return
+	}
+	configs := make([]map[string]any, 0, 2)
+	for i, snapname := range []string{from, to} {
+		call := s.executeRead(r, reqs[i])
+		if errors.Is(call.err, proxmox.ErrNotFound) {
+			s.writeReadError(w, r, reqs, http.StatusNotFound, fmt.Errorf("snapshot %q not found", snapname))
+			return
+		}
+		if call.err != nil {
+			s.writeReadError(w, r, reqs, call.status, call.err)
+			return
+		}
+		config, ok := call.apply.Result.Data.(map[string]any)
+		if !ok {
+			s.writeReadError(w, r, reqs, http.StatusBadGateway, fmt.Errorf("unexpected config for snapshot %q", snapname))
+			return
+		}
+		configs = append(configs, config)
+	}
+	s.writeReadJSON(w, r, reqs, map[string]any{
+		"environment": environment,
+		"node":        node,
+		"vmid":        vmid,
+		"from":        from,
+		"to":          to,
+		"changes":     proxmox.DiffConfigs(configs[0], configs[1]),
+	})
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+type snapshotConfigClient struct {
+	configs map[string]map[string]any
+	calls   int
+}
+
+func (c *snapshotConfigClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	c.calls++
+	snapname, _ := req.Params["snapname"].(string)
+	config, ok := c.configs[snapname]
+	if !ok {
+		return proxmox.ActionResult{}, &proxmox.APIError{StatusCode: http.StatusInternalServerError, Message: "snapshot '" + snapname + "' does not exist"}
+	}
+	return proxmox.ActionResult{Status: "ok", Data: config}, nil
+}
+
+func TestSnapshotDiffListsChangedKeys(t *testing.T) {
+	client := &snapshotConfigClient{configs: map[string]map[string]any{
+		"before": {"cores": 2, "memory": "4096", "net0": "virtio=BC:24:11:00:00:01,bridge=vmbr0", "snaptime": 1700000000},
+		"after":  {"cores": 4, "memory": "4096", "scsi1": "local-lvm:vm-101-disk-1,size=32G", "snaptime": 1700003600, "parent": "before"},
+	}}
+	s := newTestServer(client)
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/v1/vm/snapshots/diff?environment=home&node=pve&vmid=101&from=before&to=after", ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Changes []proxmox.ConfigChange `json:"changes"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	want := map[string]string{"cores": "changed", "net0": "removed", "scsi1": "added"}
+	if len(body.Changes) != len(want) {
+		t.Fatalf("expected %d changes, got %+v", len(want), body.Changes)
+	}
+	for _, change := range body.Changes {
+		if want[change.Key] != change.Change {
+			t.Fatalf("unexpected change %+v; want %v", change, want)
+		}
+	}
+}
+
+func TestSnapshotDiffReturnsNotFoundForMissingSnapshot(t *testing.T) {
+	client := &snapshotConfigClient{configs: map[string]map[string]any{"before": {"cores": 2}}}
+	s := newTestServer(client)
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/v1/vm/snapshots/diff?environment=home&node=pve&vmid=101&from=before&to=gone", ""))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSnapshotDiffValidatesBothSnapshotsBeforeReading(t *testing.T) {
+	client := &snapshotConfigClient{configs: map[string]map[string]any{"before": {"cores": 2}}}
+	s := newTestServer(client)
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/v1/vm/snapshots/diff?environment=home&node=pve&vmid=101&from=before&to=9bad", ""))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if client.calls != 0 {
+		t.Fatalf("expected no upstream read before validation passed, got %d", client.calls)
+	}
+}
+
+func TestSnapshotDiffReplaysIdempotentRequest(t *testing.T) {
+	client := &snapshotConfigClient{configs: map[string]map[string]any{"before": {"cores": 2}, "after": {"cores": 4}}}
+	s := newTestServer(client)
+
+	var bodies []string
+	for i := 0; i < 2; i++ {
+		req := newAuthedRequest(http.MethodGet, "/v1/vm/snapshots/diff?environment=home&node=pve&vmid=101&from=before&to=after", "")
+		req.Header.Set("Idempotency-Key", "diff-1")
+		rr := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		bodies = append(bodies, rr.Body.String())
+	}
+	if client.calls != 2 || bodies[0] != bodies[1] {
+		t.Fatalf("expected the second diff to replay without reading, got %d calls", client.calls)
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+type snapshotPruneResult struct {
+	Name    string `json:"name"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// vmSnapshotPrune keeps the newest keep snapshots named with
+// snapshot_prune_prefix and deletes the older ones, each planned and applied
+// so it is audited and subject to policy, including delete_snapshot's
+// approval. Each delete waits for its task, since Proxmox locks the VM while
+// a snapshot is removed. A failed delete is reported against its snapshot
+// and does not stop the rest; a delete still running does, as the rest
+// would only hit the lock.
+func (s *Server) vmSnapshotPrune(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor, ok := s.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	if !requireJSONContentType(w, r) {
+		return
+	}
+	var body struct {
+		Environment    string `json:"environment"`
+		Node           string `json:"node"`
+		VMID           string `json:"vmid"`
+		Keep           int    `json:"keep"`
+		DryRun         bool   `json:"dry_run"`
+		Reason         string `json:"reason"`
+		ApprovedBy     string `json:"approved_by"`
+		ApprovalTicket string `json:"approval_ticket"`
+	}
+	if err := decodeStrictJSON(r, &body); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	body.Environment = strings.TrimSpace(body.Environment)
+	body.Node = strings.TrimSpace(body.Node)
+	body.VMID = strings.TrimSpace(body.VMID)
+	if body.Environment == "" || body.Node == "" || body.VMID == "" {
+		http.Error(w, "environment, node, and vmid are required", http.StatusBadRequest)
+		return
+	}
+	if body.Keep < 1 {
+		http.Error(w, "keep must be at least 1", http.StatusBadRequest)
+		return
+	}
+	prefix := strings.TrimSpace(s.cfg.SnapshotPrunePrefix)
+	if prefix == "" {
+		http.Error(w, "snapshot pruning is disabled; set snapshot_prune_prefix", http.StatusBadRequest)
+		return
+	}
+	if s.shedLoad(w, proxmox.ActionDeleteSnapshot) {
+		return
+	}
+	identity, ok := s.tokenIdentity(w, r, body.Environment)
+	if !ok {
+		return
+	}
+
+	base := proxmox.ActionRequest{
+		Environment:   body.Environment,
+		Target:        "vm/" + body.VMID,
+		Actor:         actor,
+		OnBehalfOf:    onBehalfOf(r),
+		ClientIP:      s.clientIP.Resolve(r),
+		RequestID:     requestID(r),
+		Context:       r.Context(),
+		TokenIdentity: identity,
+	}
+	list := base
+	list.Action = proxmox.ActionReadVMSnapshots
+	list.Params = map[string]any{"node": body.Node}
+	if err := s.validator.ValidateActionRequest(list); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, resp, status, err := s.planThenApply(list)
+	if err != nil {
+		setRetryAfter(w, err)
+		http.Error(w, err.Error(), status)
+		return
+	}
+	snapshots, ok := resp.Result.Data.([]proxmox.Snapshot)
+	if !ok {
+		http.Error(w, "unexpected snapshot list from Proxmox", http.StatusBadGateway)
+		return
+	}
+
+	kept, expired := snapshotsToPrune(snapshots, prefix, body.Keep)
+	results := make([]snapshotPruneResult, 0, len(expired))
+	stalled := ""
+	for _, snapshot := range expired {
+		result := snapshotPruneResult{Name: snapshot.Name}
+		if stalled != "" {
+			result.Error = "skipped: delete of " + stalled + " is still running"
+			results = append(results, result)
+			continue
+		}
+		del := base
+		del.Action = proxmox.ActionDeleteSnapshot
+		del.Params = map[string]any{"node": body.Node, "snapname": snapshot.Name, "wait": true}
+		del.DryRun = body.DryRun
+		del.Reason = body.Reason
+		del.ApprovedBy = strings.TrimSpace(body.ApprovedBy)
+		del.ApprovalTicket = strings.TrimSpace(body.ApprovalTicket)
+		if err := s.validator.ValidateActionRequest(del); err != nil {
+			result.Error = err.Error()
+		} else if _, applied, _, err := s.planThenApply(del); err != nil {
+			result.Error = err.Error()
+		} else if !body.DryRun && applied.Result.Status != "ok" {
+			result.Error = applied.Result.Message
+			stalled = snapshot.Name
+		} else {
+			result.Deleted = !body.DryRun
+		}
+		results = append(results, result)
+	}
+	keptNames := make([]string, 0, len(kept))
+	for _, snapshot := range kept {
+		keptNames = append(keptNames, snapshot.Name)
+	}
+	s.writeJSON(w, http.StatusOK, map[string]any{
+		"environment": body.Environment,
+		"node":        body.Node,
+		"vmid":        body.VMID,
+		"dry_run":     body.DryRun,
+		"kept":        keptNames,
+		"pruned":      results,
+	})
+}
+
+// snapshotsToPrune splits the snapshots named with prefix into the newest
+// keep, by snaptime, and the older ones to delete, oldest first.
+func snapshotsToPrune(snapshots []proxmox.Snapshot, prefix string, keep int) (kept, expired []proxmox.Snapshot) {
+	var eligible []proxmox.Snapshot
+	for _, snapshot := range snapshots {
+		if strings.HasPrefix(snapshot.Name, prefix) {
+			eligible = append(eligible, snapshot)
+		}
+	}
+	sort.SliceStable(eligible, func(i, j int) bool { return eligible[i].SnapTime > eligible[j].SnapTime })
+	if len(eligible) <= keep {
+		return eligible, nil
+	}
+	expired = eligible[keep:]
+	sort.SliceStable(expired, func(i, j int) bool { return expired[i].SnapTime < expired[j].SnapTime })
+	return eligible[:keep], expired
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+type snapshotPruneClient struct {
+	snapshots []proxmox.Snapshot
+	deleted   []string
+	// running names a snapshot whose delete is still running at the wait cap.
+	running string
+}
+
+func (c *snapshotPruneClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	if req.Action == proxmox.ActionReadVMSnapshots {
+		return proxmox.ActionResult{Status: "ok", Data: c.snapshots}, nil
+	}
+	snapname := req.Params["snapname"].(string)
+	if wait, _ := req.Params["wait"].(bool); !wait {
+		return proxmox.ActionResult{}, fmt.Errorf("expected delete of %s to wait for its task", snapname)
+	}
+	c.deleted = append(c.deleted, snapname)
+	if snapname == c.running {
+		return proxmox.ActionResult{Status: "submitted", Message: "snapshot delete still running"}, nil
+	}
+	return proxmox.ActionResult{Status: "ok", Message: "snapshot deleted"}, nil
+}
+
+func TestSnapshotPruneDeletesOldestEligibleBeyondKeep(t *testing.T) {
+	client := &snapshotPruneClient{snapshots: []proxmox.Snapshot{
+		{Name: "auto-3", SnapTime: 1700000300},
+		{Name: "auto-1", SnapTime: 1700000100},
+		{Name: "manual-before-upgrade", SnapTime: 1700000050},
+		{Name: "auto-5", SnapTime: 1700000500},
+		{Name: "auto-2", SnapTime: 1700000200},
+		{Name: "auto-4", SnapTime: 1700000400},
+	}}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.SnapshotPrunePrefix = "auto-"
+	})
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodPost, "/v1/vm/snapshots/prune", `{"environment":"home","node":"pve","vmid":"101","keep":2,"approved_by":"ops-lead"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if want := []string{"auto-1", "auto-2", "auto-3"}; !reflect.DeepEqual(client.deleted, want) {
+		t.Fatalf("expected %v deleted, got %v", want, client.deleted)
+	}
+}
+
+func TestSnapshotPruneRefusedWithoutPrefix(t *testing.T) {
+	client := &snapshotPruneClient{}
+	s := newTestServer(client)
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodPost, "/v1/vm/snapshots/prune", `{"environment":"home","node":"pve","vmid":"101","keep":2}`))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestSnapshotPruneRequiresApproval(t *testing.T) {
+	client := &snapshotPruneClient{snapshots: []proxmox.Snapshot{
+		{Name: "auto-1", SnapTime: 1700000100},
+		{Name: "auto-2", SnapTime: 1700000200},
+	}}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.SnapshotPrunePrefix = "auto-"
+	})
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodPost, "/v1/vm/snapshots/prune", `{"environment":"home","node":"pve","vmid":"101","keep":1}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(client.deleted) != 0 || !strings.Contains(rr.Body.String(), "approval required") {
+		t.Fatalf("expected unapproved deletes to be denied, deleted %v: %s", client.deleted, rr.Body.String())
+	}
+}
+
+func TestSnapshotPruneStopsWhileADeleteIsStillRunning(t *testing.T) {
+	client := &snapshotPruneClient{running: "auto-1", snapshots: []proxmox.Snapshot{
+		{Name: "auto-1", SnapTime: 1700000100},
+		{Name: "auto-2", SnapTime: 1700000200},
+		{Name: "auto-3", SnapTime: 1700000300},
+	}}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.SnapshotPrunePrefix = "auto-"
+	})
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodPost, "/v1/vm/snapshots/prune", `{"environment":"home","node":"pve","vmid":"101","keep":1,"approved_by":"ops-lead"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if want := []string{"auto-1"}; !reflect.DeepEqual(client.deleted, want) {
+		t.Fatalf("expected pruning to stop after %v, got %v", want, client.deleted)
+	}
+	if !strings.Contains(rr.Body.String(), "skipped: delete of auto-1 is still running") {
+		t.Fatalf("expected the rest to be reported as skipped: %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// tokenIdentity returns the token identity named by X-Proxmox-Token-Identity,
+// or "" when the header is absent. Choosing which Proxmox token acts is an
+// admin capability, so the request must also carry the admin token in
+// X-Admin-Token; secrets themselves never travel over the wire.
+func (s *Server) tokenIdentity(w http.ResponseWriter, r *http.Request, environment string) (string, bool) {
+	name, status, err := s.resolveTokenIdentity(r, environment)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return "", false
+	}
+	return name, true
+}
+
+// resolveTokenIdentity is tokenIdentity for callers that report errors
+// themselves, such as batch items; status is the HTTP status for err.
+func (s *Server) resolveTokenIdentity(r *http.Request, environment string) (name string, status int, err error) {
+	name = strings.TrimSpace(r.Header.Get("X-Proxmox-Token-Identity"))
+	if name == "" {
+		return "", http.StatusOK, nil
+	}
+	token := strings.TrimSpace(r.Header.Get("X-Admin-Token"))
+	if s.adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		return "", http.StatusForbidden, errors.New("selecting a token identity requires a valid X-Admin-Token")
+	}
+	for _, env := range s.cfg.Environments {
+		if env.Name != environment {
+			continue
+		}
+		if _, ok := env.TokenIdentities[name]; ok {
+			return name, http.StatusOK, nil
+		}
+	}
+	return "", http.StatusBadRequest, fmt.Errorf("unknown token identity %q for environment %q", name, environment)
+}
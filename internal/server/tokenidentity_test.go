@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/config"
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+func TestTokenIdentityRequiresAdminTokenAndKnownName(t *testing.T) {
+	client := &testClient{}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.Environments[0].TokenIdentities = map[string]config.TokenIdentity{
+			"tenant-a": {TokenID: "tenant-a@pve!gateway", TokenSecretEnv: "PVE_TENANT_A_SECRET"},
+		}
+	})
+	s.adminToken = "admin-secret"
+	handler := s.Handler()
+	const path = "/v1/vm/status?environment=home&node=pve&vmid=101"
+
+	send := func(identity, admin string) *httptest.ResponseRecorder {
+		req := newAuthedRequest(http.MethodGet, path, "")
+		req.Header.Set("X-Proxmox-Token-Identity", identity)
+		if admin != "" {
+			req.Header.Set("X-Admin-Token", admin)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := send("tenant-a", ""); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without admin token, got %d", rr.Code)
+	}
+	if rr := send("tenant-b", "admin-secret"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown identity, got %d", rr.Code)
+	}
+	if client.calls != 0 {
+		t.Fatalf("expected rejected selections not to reach upstream, got %d calls", client.calls)
+	}
+	if rr := send("tenant-a", "admin-secret"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if client.lastReq.TokenIdentity != "tenant-a" {
+		t.Fatalf("expected token identity to reach the client, got %q", client.lastReq.TokenIdentity)
+	}
+}
+
+// identityClient records the token identity of every request it executes.
+type identityClient struct {
+	snapshotPruneClient
+	identities []string
+}
+
+func (c *identityClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	c.identities = append(c.identities, req.TokenIdentity)
+	return c.snapshotPruneClient.Execute(req)
+}
+
+func TestTokenIdentityAppliesToSnapshotPrune(t *testing.T) {
+	client := &identityClient{snapshotPruneClient: snapshotPruneClient{snapshots: []proxmox.Snapshot{
+		{Name: "auto-1", SnapTime: 1700000100},
+		{Name: "auto-2", SnapTime: 1700000200},
+	}}}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.SnapshotPrunePrefix = "auto-"
+		cfg.Environments[0].TokenIdentities = map[string]config.TokenIdentity{
+			"tenant-a": {TokenID: "tenant-a@pve!gateway", TokenSecretEnv: "PVE_TENANT_A_SECRET"},
+		}
+	})
+	s.adminToken = "admin-secret"
+
+	req := newAuthedRequest(http.MethodPost, "/v1/vm/snapshots/prune", `{"environment":"home","node":"pve","vmid":"101","keep":1,"approved_by":"ops-lead"}`)
+	req.Header.Set("X-Proxmox-Token-Identity", "tenant-a")
+	req.Header.Set("X-Admin-Token", "admin-secret")
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if want := []string{"tenant-a", "tenant-a"}; !reflect.DeepEqual(client.identities, want) {
+		t.Fatalf("expected the list and the delete to act as tenant-a, got %q", client.identities)
+	}
+}
+
+// probeIdentityClient answers version probes, failing any probe that is
+// not bound to a request context, and records the identity each ran as.
+type probeIdentityClient struct {
+	mu         sync.Mutex
+	identities map[string]string
+}
+
+func (c *probeIdentityClient) Execute(req proxmox.ActionRequest) (proxmox.ActionResult, error) {
+	if req.Context == nil {
+		return proxmox.ActionResult{}, errors.New("probe has no context")
+	}
+	c.mu.Lock()
+	c.identities[req.Environment] = req.TokenIdentity
+	c.mu.Unlock()
+	return proxmox.ActionResult{Status: "ok", Data: proxmox.VersionInfo{Version: "8.2.4", Release: "8.2"}}, nil
+}
+
+func TestTokenIdentityAppliesToVersionProbe(t *testing.T) {
+	client := &probeIdentityClient{identities: map[string]string{}}
+	s := newTestServerWithConfig(client, func(cfg *config.Config) {
+		cfg.Environments[0].TokenIdentities = map[string]config.TokenIdentity{
+			"tenant-a": {TokenID: "tenant-a@pve!gateway", TokenSecretEnv: "PVE_TENANT_A_SECRET"},
+		}
+		cfg.Environments = append(cfg.Environments, config.Environment{
+			Name:           "cloud",
+			BaseURL:        "https://cloud.example.com",
+			TokenID:        "root@pam!agent",
+			TokenSecretEnv: "PVE_TEST_SECRET",
+		})
+	})
+	s.adminToken = "admin-secret"
+
+	req := newAuthedRequest(http.MethodGet, "/v1/environments?probe=true", "")
+	req.Header.Set("X-Proxmox-Token-Identity", "tenant-a")
+	req.Header.Set("X-Admin-Token", "admin-secret")
+	rr := httptest.NewRecorder()
+	s.environments(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		Environments []map[string]string `json:"environments"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response JSON: %v", err)
+	}
+	if home := body.Environments[0]; home["version"] != "8.2.4" || home["probe_error"] != "" {
+		t.Fatalf("expected home to be probed, got %v", home)
+	}
+	if cloud := body.Environments[1]; !strings.Contains(cloud["probe_error"], "unknown token identity") {
+		t.Fatalf("expected cloud to report the identity it lacks, got %v", cloud)
+	}
+	if want := map[string]string{"home": "tenant-a"}; !reflect.DeepEqual(client.identities, want) {
+		t.Fatalf("expected only home to be probed, as tenant-a, got %v", client.identities)
+	}
+}
+
+func TestIdempotencyHashCoversTokenIdentity(t *testing.T) {
+	req := proxmox.ActionRequest{Environment: "home", Action: proxmox.ActionStartVM, Target: "vm/101", Params: map[string]any{"node": "pve"}}
+	as := req
+	as.TokenIdentity = "tenant-a"
+	plain, err := hashActionRequest(req)
+	if err != nil {
+		t.Fatalf("hashActionRequest returned error: %v", err)
+	}
+	selected, err := hashActionRequest(as)
+	if err != nil {
+		t.Fatalf("hashActionRequest returned error: %v", err)
+	}
+	if plain == selected {
+		t.Fatalf("expected a different token identity to change the request hash")
+	}
+}
@@ -0,0 +1,125 @@
+"use strict";
+
+// The token lives in sessionStorage only, so it is dropped when the tab closes.
+const state = {
+  token: sessionStorage.getItem("proxmox-agent-token") || "",
+  actor: sessionStorage.getItem("proxmox-agent-actor") || "ui-operator",
+};
+
+async function api(path, options = {}, extraHeaders = {}) {
+  const headers = {
+    Authorization: "Bearer " + state.token,
+    "X-Actor-ID": state.actor,
+    ...extraHeaders,
+  };
+  if (options.body) {
+    headers["Content-Type"] = "application/json";
+  }
+  const resp = await fetch(path, { ...options, headers });
+  const text = await resp.text();
+  let body;
+  try {
+    body = JSON.parse(text);
+  } catch {
+    body = text;
+  }
+  if (!resp.ok) {
+    throw new Error(resp.status + ": " + (typeof body === "string" ? body : JSON.stringify(body)));
+  }
+  return body;
+}
+
+function show(value) {
+  document.getElementById("output").textContent =
+    typeof value === "string" ? value : JSON.stringify(value, null, 2);
+}
+
+async function loadEnvironments() {
+  const list = document.getElementById("environments");
+  list.replaceChildren();
+  const body = await api("/v1/environments");
+  for (const env of body.environments || []) {
+    const item = document.createElement("li");
+    const button = document.createElement("button");
+    button.textContent = env.name;
+    button.addEventListener("click", () => loadInventory(env.name).catch((err) => show(err.message)));
+    item.append(button);
+    list.append(item);
+  }
+}
+
+async function loadInventory(environment) {
+  document.getElementById("inventory-env").textContent = "(" + environment + ")";
+  document.querySelector("#action [name=environment]").value = environment;
+  const body = await api("/v1/inventory?environment=" + encodeURIComponent(environment));
+  const rows = document.querySelector("#inventory tbody");
+  rows.replaceChildren();
+  for (const vm of (body.result && body.result.data) || []) {
+    if (vm.type !== "qemu" && vm.type !== "lxc") {
+      continue;
+    }
+    const row = document.createElement("tr");
+    for (const value of [vm.vmid, vm.name, vm.type, vm.node, vm.status]) {
+      const cell = document.createElement("td");
+      cell.textContent = value === undefined ? "" : String(value);
+      row.append(cell);
+    }
+    rows.append(row);
+  }
+}
+
+async function loadActions() {
+  const select = document.getElementById("action-list");
+  select.replaceChildren();
+  const body = await api("/v1/actions");
+  for (const action of body.actions || []) {
+    const option = document.createElement("option");
+    option.value = action.action;
+    option.textContent = action.action + " (" + action.risk_level + ")";
+    select.append(option);
+  }
+}
+
+document.getElementById("token").value = state.token;
+document.getElementById("actor").value = state.actor;
+
+document.getElementById("auth").addEventListener("submit", (event) => {
+  event.preventDefault();
+  state.token = document.getElementById("token").value.trim();
+  state.actor = document.getElementById("actor").value.trim();
+  sessionStorage.setItem("proxmox-agent-token", state.token);
+  sessionStorage.setItem("proxmox-agent-actor", state.actor);
+  Promise.all([loadEnvironments(), loadActions()]).catch((err) => show(err.message));
+});
+
+document.getElementById("action").addEventListener("submit", (event) => {
+  event.preventDefault();
+  const mode = event.submitter ? event.submitter.dataset.mode : "plan";
+  const form = new FormData(event.target);
+  let params;
+  try {
+    params = JSON.parse(form.get("params") || "{}");
+  } catch (err) {
+    show("params is not valid JSON: " + err.message);
+    return;
+  }
+  const request = {
+    environment: form.get("environment"),
+    action: form.get("action"),
+    target: form.get("target"),
+    params,
+  };
+  for (const key of ["approved_by", "approval_ticket", "reason"]) {
+    if (form.get(key)) {
+      request[key] = form.get(key);
+    }
+  }
+  const extra = mode === "apply" ? { "Idempotency-Key": crypto.randomUUID() } : {};
+  api("/v1/actions/" + mode, { method: "POST", body: JSON.stringify(request) }, extra)
+    .then(show)
+    .catch((err) => show(err.message));
+});
+
+if (state.token) {
+  Promise.all([loadEnvironments(), loadActions()]).catch((err) => show(err.message));
+}
@@ -11,20 +11,32 @@ import (
 )
 
 var (
-	vmTargetPattern         = regexp.MustCompile(`^vm/[0-9]+$`)
-	inventoryTargetPattern  = regexp.MustCompile(`^inventory/(all|running)$`)
-	nodesTargetPattern      = regexp.MustCompile(`^nodes/all$`)
-	taskStatusTargetPattern = regexp.MustCompile(`^task/status$`)
-	taskListTargetPattern   = regexp.MustCompile(`^task/list$`)
-	storageTargetPattern    = regexp.MustCompile(`^storage/[A-Za-z0-9._:-]+$`)
-	firewallTargetPattern   = regexp.MustCompile(`^firewall/(cluster|node/[A-Za-z0-9._-]+|vm/[0-9]+)$`)
-	approvedByPattern       = regexp.MustCompile(`^[A-Za-z0-9._:@/\-]{3,128}$`)
-	approvalTicketPattern   = regexp.MustCompile(`^[A-Za-z0-9._:\-]{3,128}$`)
+	vmTargetPattern          = regexp.MustCompile(`^vm/[0-9]+$`)
+	inventoryTargetPattern   = regexp.MustCompile(`^inventory/(all|running)$`)
+	nodesTargetPattern       = regexp.MustCompile(`^nodes/all$`)
+	taskStatusTargetPattern  = regexp.MustCompile(`^task/status$`)
+	taskListTargetPattern    = regexp.MustCompile(`^task/list$`)
+	haStatusTargetPattern    = regexp.MustCompile(`^ha/status$`)
+	capacityTargetPattern    = regexp.MustCompile(`^cluster/capacity$`)
+	clusterLogTargetPattern  = regexp.MustCompile(`^cluster/log$`)
+	versionTargetPattern     = regexp.MustCompile(`^version$`)
+	nodeTargetPattern        = regexp.MustCompile(`^node/[A-Za-z0-9._-]+$`)
+	poolTargetPattern        = regexp.MustCompile(`^pool/[A-Za-z0-9_-]{1,64}$`)
+	replicationTargetPattern = regexp.MustCompile(`^replication/[0-9]+-[0-9]+$`)
+	storageTargetPattern     = regexp.MustCompile(`^storage/[A-Za-z0-9._:-]+$`)
+	firewallTargetPattern    = regexp.MustCompile(`^firewall/(cluster|node/[A-Za-z0-9._-]+|vm/[0-9]+)$`)
+	approvedByPattern        = regexp.MustCompile(`^[A-Za-z0-9._:@/\-]{3,128}$`)
+	approvalTicketPattern    = regexp.MustCompile(`^[A-Za-z0-9._:\-]{3,128}$`)
 )
 
 type requestValidator struct {
 	environments map[string]struct{}
 	actions      map[proxmox.ActionType]struct{}
+	// maxApprovalValidity bounds expires_at relative to now.
+	maxApprovalValidity time.Duration
+	// requireMigrateOnline rejects migrate_vm without an explicit online.
+	requireMigrateOnline bool
+	now                  func() time.Time
 }
 
 func newRequestValidator(cfg config.Config) *requestValidator {
@@ -32,23 +44,16 @@ func newRequestValidator(cfg config.Config) *requestValidator {
 	for _, env := range cfg.Environments {
 		envs[env.Name] = struct{}{}
 	}
+	actions := make(map[proxmox.ActionType]struct{}, len(actionRegistry))
+	for _, spec := range actionRegistry {
+		actions[spec.Action] = struct{}{}
+	}
 	return &requestValidator{
-		environments: envs,
-		actions: map[proxmox.ActionType]struct{}{
-			proxmox.ActionReadVM:         {},
-			proxmox.ActionReadInventory:  {},
-			proxmox.ActionReadNodes:      {},
-			proxmox.ActionReadTaskStatus: {},
-			proxmox.ActionReadTasks:      {},
-			proxmox.ActionStartVM:        {},
-			proxmox.ActionStopVM:         {},
-			proxmox.ActionSnapshotVM:     {},
-			proxmox.ActionCloneVM:        {},
-			proxmox.ActionMigrateVM:      {},
-			proxmox.ActionDeleteVM:       {},
-			proxmox.ActionStorageEdit:    {},
-			proxmox.ActionFirewallEdit:   {},
-		},
+		environments:         envs,
+		actions:              actions,
+		maxApprovalValidity:  cfg.MaxApprovalValidity(),
+		requireMigrateOnline: cfg.RequireMigrateOnline,
+		now:                  time.Now,
 	}
 }
 
@@ -71,53 +76,40 @@ func (v *requestValidator) ValidateActionRequest(req proxmox.ActionRequest) erro
 	if err := validateTargetByAction(req.Action, req.Target); err != nil {
 		return err
 	}
-	if err := validateApprovalMetadata(req); err != nil {
+	if err := proxmox.ValidateActionParams(req); err != nil {
+		return err
+	}
+	if v.requireMigrateOnline && req.Action == proxmox.ActionMigrateVM {
+		if req.Params["online"] == nil {
+			return fmt.Errorf("params.online is required for migrate_vm: set true for live or false for offline migration")
+		}
+	}
+	switch req.MinRisk {
+	case "", "low", "medium", "high":
+	default:
+		return fmt.Errorf("min_risk must be one of low, medium, or high")
+	}
+	if req.ExpectStatus != "" && !proxmox.IsVMAction(req.Action) {
+		return fmt.Errorf("expect_status is only supported for VM actions")
+	}
+	if err := validateApprovalMetadata(req, v.now(), v.maxApprovalValidity); err != nil {
 		return err
 	}
 	return nil
 }
 
 func validateTargetByAction(action proxmox.ActionType, target string) error {
-	switch action {
-	case proxmox.ActionReadNodes:
-		if !nodesTargetPattern.MatchString(target) {
-			return fmt.Errorf("invalid target for %q: expected nodes/all", action)
-		}
-	case proxmox.ActionReadTasks:
-		if !taskListTargetPattern.MatchString(target) {
-			return fmt.Errorf("invalid target for %q: expected task/list", action)
-		}
-	case proxmox.ActionReadTaskStatus:
-		if !taskStatusTargetPattern.MatchString(target) {
-			return fmt.Errorf("invalid target for %q: expected task/status", action)
-		}
-	case proxmox.ActionReadInventory:
-		if !inventoryTargetPattern.MatchString(target) {
-			return fmt.Errorf("invalid target for %q: expected inventory/all or inventory/running", action)
-		}
-	case proxmox.ActionReadVM,
-		proxmox.ActionStartVM,
-		proxmox.ActionStopVM,
-		proxmox.ActionSnapshotVM,
-		proxmox.ActionCloneVM,
-		proxmox.ActionMigrateVM,
-		proxmox.ActionDeleteVM:
-		if !vmTargetPattern.MatchString(target) {
-			return fmt.Errorf("invalid target for %q: expected vm/<id>", action)
-		}
-	case proxmox.ActionStorageEdit:
-		if !storageTargetPattern.MatchString(target) {
-			return fmt.Errorf("invalid target for %q: expected storage/<name>", action)
-		}
-	case proxmox.ActionFirewallEdit:
-		if !firewallTargetPattern.MatchString(target) {
-			return fmt.Errorf("invalid target for %q: expected firewall/cluster, firewall/node/<name>, or firewall/vm/<id>", action)
-		}
+	spec, ok := lookupAction(action)
+	if !ok {
+		return nil
+	}
+	if !spec.pattern.MatchString(target) {
+		return fmt.Errorf("invalid target for %q: expected %s", action, spec.Target)
 	}
 	return nil
 }
 
-func validateApprovalMetadata(req proxmox.ActionRequest) error {
+func validateApprovalMetadata(req proxmox.ActionRequest, now time.Time, maxValidity time.Duration) error {
 	approvedBy := strings.TrimSpace(req.ApprovedBy)
 	approvalTicket := strings.TrimSpace(req.ApprovalTicket)
 	reason := strings.TrimSpace(req.Reason)
@@ -133,9 +125,13 @@ func validateApprovalMetadata(req proxmox.ActionRequest) error {
 		return fmt.Errorf("reason must be at least 8 characters when provided")
 	}
 	if expiresAt != "" {
-		if _, err := time.Parse(time.RFC3339, expiresAt); err != nil {
+		expires, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
 			return fmt.Errorf("expires_at must be RFC3339 format")
 		}
+		if maxValidity > 0 && expires.Sub(now) > maxValidity {
+			return fmt.Errorf("expires_at must be within %s of now", maxValidity)
+		}
 	}
 	if approvedBy == "" && (approvalTicket != "" || reason != "" || expiresAt != "") {
 		return fmt.Errorf("approved_by is required when approval metadata is provided")
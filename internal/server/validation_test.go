@@ -1,7 +1,9 @@
 package server
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/junlov/proxmox-ai/internal/config"
 	"github.com/junlov/proxmox-ai/internal/proxmox"
@@ -96,6 +98,20 @@ func TestValidateActionRequest(t *testing.T) {
 				ExpiresAt:      "2026-02-16T12:00:00Z",
 			},
 		},
+		{
+			name: "valid migrate with local disks",
+			req: proxmox.ActionRequest{
+				Environment: "home",
+				Action:      proxmox.ActionMigrateVM,
+				Target:      "vm/100",
+				Params: map[string]any{
+					"node":             "pve1",
+					"target":           "pve2",
+					"with-local-disks": true,
+					"targetstorage":    "local-lvm",
+				},
+			},
+		},
 		{
 			name: "missing environment",
 			req: proxmox.ActionRequest{
@@ -245,6 +261,101 @@ func TestValidateActionRequest(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "migrate with local disks requires targetstorage",
+			req: proxmox.ActionRequest{
+				Environment: "home",
+				Action:      proxmox.ActionMigrateVM,
+				Target:      "vm/100",
+				Params: map[string]any{
+					"node":             "pve1",
+					"target":           "pve2",
+					"with-local-disks": true,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid stop with timeout",
+			req: proxmox.ActionRequest{
+				Environment: "home",
+				Action:      proxmox.ActionStopVM,
+				Target:      "vm/100",
+				Params:      map[string]any{"node": "pve1", "timeout": float64(30), "keepActive": true},
+			},
+		},
+		{
+			name: "stop with non-numeric timeout",
+			req: proxmox.ActionRequest{
+				Environment: "home",
+				Action:      proxmox.ActionStopVM,
+				Target:      "vm/100",
+				Params:      map[string]any{"node": "pve1", "timeout": "soon"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "stop with negative timeout",
+			req: proxmox.ActionRequest{
+				Environment: "home",
+				Action:      proxmox.ActionStopVM,
+				Target:      "vm/100",
+				Params:      map[string]any{"node": "pve1", "timeout": float64(-5)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid set_vm_config startup order",
+			req: proxmox.ActionRequest{
+				Environment: "home",
+				Action:      proxmox.ActionSetVMConfig,
+				Target:      "vm/100",
+				Params:      map[string]any{"node": "pve1", "onboot": true, "startup": "order=1,up=30,down=60"},
+			},
+		},
+		{
+			name: "set_vm_config with malformed startup",
+			req: proxmox.ActionRequest{
+				Environment: "home",
+				Action:      proxmox.ActionSetVMConfig,
+				Target:      "vm/100",
+				Params:      map[string]any{"node": "pve1", "startup": "order=first,up=30"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "set_vm_config with unknown startup setting",
+			req: proxmox.ActionRequest{
+				Environment: "home",
+				Action:      proxmox.ActionSetVMConfig,
+				Target:      "vm/100",
+				Params:      map[string]any{"node": "pve1", "startup": "order=1,delay=30"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "endpoint param rejected for start_vm",
+			req: proxmox.ActionRequest{
+				Environment: "home",
+				Action:      proxmox.ActionStartVM,
+				Target:      "vm/100",
+				Params: map[string]any{
+					"node":     "pve1",
+					"endpoint": "/api2/json/nodes/pve1/qemu/100/status/stop",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid min_risk",
+			req: proxmox.ActionRequest{
+				Environment: "home",
+				Action:      proxmox.ActionStartVM,
+				Target:      "vm/100",
+				MinRisk:     "critical",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -260,3 +371,99 @@ func TestValidateActionRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateApprovalExpiryWindow(t *testing.T) {
+	v := newRequestValidator(config.Config{
+		Environments:               []config.Environment{{Name: "home"}},
+		MaxApprovalValiditySeconds: 3600,
+	})
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	v.now = func() time.Time { return now }
+
+	req := proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionDeleteVM,
+		Target:      "vm/100",
+		ApprovedBy:  "ops-user",
+	}
+	req.ExpiresAt = now.Add(30 * time.Minute).Format(time.RFC3339)
+	if err := v.ValidateActionRequest(req); err != nil {
+		t.Fatalf("expected in-window expiry to be accepted: %v", err)
+	}
+	req.ExpiresAt = now.Add(365 * 24 * time.Hour).Format(time.RFC3339)
+	if err := v.ValidateActionRequest(req); err == nil || !strings.Contains(err.Error(), "within 1h0m0s") {
+		t.Fatalf("expected far-future expiry to be rejected, got %v", err)
+	}
+}
+
+func TestValidateRequireMigrateOnline(t *testing.T) {
+	v := newRequestValidator(config.Config{
+		Environments:         []config.Environment{{Name: "home"}},
+		RequireMigrateOnline: true,
+	})
+	req := proxmox.ActionRequest{
+		Environment: "home",
+		Action:      proxmox.ActionMigrateVM,
+		Target:      "vm/100",
+		Params:      map[string]any{"node": "pve1", "target": "pve2"},
+	}
+	if err := v.ValidateActionRequest(req); err == nil || !strings.Contains(err.Error(), "params.online is required") {
+		t.Fatalf("expected migrate without online to be rejected, got %v", err)
+	}
+	for _, online := range []any{true, false} {
+		req.Params = map[string]any{"node": "pve1", "target": "pve2", "online": online}
+		if err := v.ValidateActionRequest(req); err != nil {
+			t.Fatalf("expected explicit online=%v to be accepted: %v", online, err)
+		}
+		if got := proxmox.DescribeRequest(req)["online"]; got != online {
+			t.Fatalf("expected plan details to report online=%v, got %v", online, got)
+		}
+	}
+	req.Params = map[string]any{"node": "pve1", "target": "pve2", "online": "maybe"}
+	if err := v.ValidateActionRequest(req); err == nil {
+		t.Fatalf("expected non-boolean online to be rejected")
+	}
+}
+
+func TestValidateActionParamsTargetNodeConsistency(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		params  map[string]any
+		wantErr string
+	}{
+		{name: "vm target with node param", target: "vm/101", params: map[string]any{"node": "pve1"}},
+		{name: "node target without node param", target: "pve1/101"},
+		{name: "node target with matching node param", target: "pve1/101", params: map[string]any{"node": "pve1"}},
+		{name: "node target with conflicting node param", target: "pve1/101", params: map[string]any{"node": "pve2"}, wantErr: `params.node "pve2" conflicts with node "pve1"`},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			err := proxmox.ValidateActionParams(proxmox.ActionRequest{
+				Action: proxmox.ActionStopVM,
+				Target: tt.target,
+				Params: tt.params,
+			})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDecodeActionRequestCoercesIntegerParams(t *testing.T) {
+	req, _, err := decodeActionRequestBytes([]byte(`{"environment":"home","action":"clone_vm","target":"vm/103","params":{"node":"pve1","newid":104}}`))
+	if err != nil {
+		t.Fatalf("decode returned error: %v", err)
+	}
+	if got, ok := req.Params["newid"].(int64); !ok || got != 104 {
+		t.Fatalf("expected newid decoded as int64 104, got %#v", req.Params["newid"])
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+// vmMetricsSummary reads a VM's rrddata and returns CPU and memory
+// aggregates over the window:
+// GET /v1/vm/metrics/summary?environment=&node=&vmid=[&timeframe=].
+func (s *Server) vmMetricsSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actor, ok := s.requireAuth(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	environment := strings.TrimSpace(query.Get("environment"))
+	node := strings.TrimSpace(query.Get("node"))
+	vmid := strings.TrimSpace(query.Get("vmid"))
+	if environment == "" || node == "" || vmid == "" {
+		http.Error(w, "environment, node, and vmid query parameters are required", http.StatusBadRequest)
+		return
+	}
+	timeframe := strings.TrimSpace(query.Get("timeframe"))
+	if timeframe == "" {
+		timeframe = "hour"
+	}
+	req := proxmox.ActionRequest{
+		Environment: environment,
+		Action:      proxmox.ActionReadVMRRDData,
+		Target:      "vm/" + vmid,
+		Params:      map[string]any{"node": node, "timeframe": timeframe},
+		Actor:       actor,
+		ClientIP:    s.clientIP.Resolve(r),
+		RequestID:   requestID(r),
+	}
+	if !s.prepareReads(w, r, &req) {
+		return
+	}
+	reqs := []proxmox.ActionRequest{req}
+	call := s.executeRead(r, req)
+	if call.err != nil {
+		s.writeReadError(w, r, reqs, call.status, call.err)
+		return
+	}
+	points, ok := call.apply.Result.Data.([]proxmox.RRDPoint)
+	if !ok {
+		s.writeReadError(w, r, reqs, http.StatusBadGateway, errors.New("unexpected rrddata from Proxmox"))
+		return
+	}
+	s.writeReadJSON(w, r, reqs, map[string]any{
+		"environment": environment,
+		"node":        node,
+		"vmid":        vmid,
+		"timeframe":   timeframe,
+		"summary":     proxmox.SummarizeRRD(points),
+	})
+}
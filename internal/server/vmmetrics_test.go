@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/junlov/proxmox-ai/internal/proxmox"
+)
+
+func TestVMMetricsSummaryAveragesRRDData(t *testing.T) {
+	var gotPath, gotTimeframe string
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotTimeframe = r.URL.Query().Get("timeframe")
+		_, _ = w.Write([]byte(`{"data":[
+			{"time":1772366400,"cpu":0.10,"maxcpu":2,"mem":1073741824,"maxmem":4294967296},
+			{"time":1772366460,"cpu":0.30,"maxcpu":2,"mem":2147483648,"maxmem":4294967296},
+			{"time":1772366520},
+			{"time":1772366580,"cpu":0.50,"maxcpu":2,"mem":3221225472,"maxmem":4294967296}
+		]}`))
+	})
+	s := newTestServer(client)
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/v1/vm/metrics/summary?environment=home&node=pve&vmid=101&timeframe=day", ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if gotPath != "/api2/json/nodes/pve/qemu/101/rrddata" || gotTimeframe != "day" {
+		t.Fatalf("unexpected upstream request %q timeframe=%q", gotPath, gotTimeframe)
+	}
+	var body struct {
+		Summary proxmox.RRDSummary `json:"summary"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	summary := body.Summary
+	if summary.Samples != 3 || summary.From != 1772366400 || summary.To != 1772366580 {
+		t.Fatalf("unexpected window: %+v", summary)
+	}
+	if math.Abs(summary.CPUAvg-0.30) > 1e-9 || summary.CPUMax != 0.50 {
+		t.Fatalf("unexpected cpu aggregates: %+v", summary)
+	}
+	if summary.MemAvg != 2147483648 || summary.MemMax != 3221225472 || summary.MemMaxPercent != 75 {
+		t.Fatalf("unexpected memory aggregates: %+v", summary)
+	}
+}
+
+func TestVMMetricsSummaryRejectsUnknownTimeframe(t *testing.T) {
+	client := &testClient{}
+	s := newTestServer(client)
+
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, newAuthedRequest(http.MethodGet, "/v1/vm/metrics/summary?environment=home&node=pve&vmid=101&timeframe=decade", ""))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if client.calls != 0 {
+		t.Fatalf("expected no upstream call, got %d", client.calls)
+	}
+}
+
+func TestVMMetricsSummaryHonorsDebugCapture(t *testing.T) {
+	client := newUpstreamClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[{"time":1772366400,"cpu":0.10,"maxcpu":2,"mem":1073741824,"maxmem":4294967296}]}`))
+	})
+	s := newTestServer(client)
+	s.adminToken = "admin-secret"
+
+	req := newAuthedRequest(http.MethodGet, "/v1/vm/metrics/summary?environment=home&node=pve&vmid=101", "")
+	req.Header.Set("X-Debug-Capture", "admin-secret")
+	rr := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		DebugCapture []json.RawMessage `json:"debug_capture"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || len(body.DebugCapture) != 1 {
+		t.Fatalf("expected one captured exchange, got %s (%v)", rr.Body.String(), err)
+	}
+}
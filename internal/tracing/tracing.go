@@ -0,0 +1,201 @@
+// Package tracing records plan/apply activity as spans written one JSON
+// object per line. The format is the package's own, not OTLP: field names
+// and hex IDs follow OpenTelemetry's where they overlap, but a collector
+// needs the lines converted before it will accept them.
+package tracing
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span so children can be attached to it. The zero
+// value means "no parent".
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+}
+
+// Span is a finished span as handed to an Exporter.
+type Span struct {
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId,omitempty"`
+	Name         string         `json:"name"`
+	Start        time.Time      `json:"-"`
+	End          time.Time      `json:"-"`
+	StartUnixNs  int64          `json:"startTimeUnixNano"`
+	EndUnixNs    int64          `json:"endTimeUnixNano"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// Exporter receives every span when it ends.
+type Exporter interface {
+	ExportSpan(Span)
+}
+
+// Tracer starts spans and exports them on End. A nil *Tracer is valid and
+// records nothing, which is how tracing is disabled.
+type Tracer struct {
+	exporter Exporter
+	now      func() time.Time
+}
+
+// New returns a Tracer exporting to exp, or nil when exp is nil.
+func New(exp Exporter) *Tracer {
+	if exp == nil {
+		return nil
+	}
+	return &Tracer{exporter: exp, now: time.Now}
+}
+
+// ActiveSpan is a span in progress. All methods are no-ops on nil.
+type ActiveSpan struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	span   Span
+	ended  bool
+}
+
+// Start opens a span under parent. Without a parent trace ID a new trace is
+// started.
+func (t *Tracer) Start(name string, parent SpanContext) *ActiveSpan {
+	if t == nil {
+		return nil
+	}
+	traceID := parent.TraceID
+	if traceID == "" {
+		traceID = randomHex(16)
+	}
+	return &ActiveSpan{
+		tracer: t,
+		span: Span{
+			TraceID:      traceID,
+			SpanID:       randomHex(8),
+			ParentSpanID: parent.SpanID,
+			Name:         name,
+			Start:        t.now(),
+			Attributes:   map[string]any{},
+		},
+	}
+}
+
+// Context returns the span's identity for propagation to children.
+func (s *ActiveSpan) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.span.TraceID, SpanID: s.span.SpanID}
+}
+
+func (s *ActiveSpan) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.span.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// RecordError marks the span failed.
+func (s *ActiveSpan) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.span.Error = err.Error()
+	s.mu.Unlock()
+}
+
+// End stamps the end time, adds a duration_ms attribute, and exports the
+// span. Calls after the first are ignored.
+func (s *ActiveSpan) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.span.End = s.tracer.now()
+	s.span.StartUnixNs = s.span.Start.UnixNano()
+	s.span.EndUnixNs = s.span.End.UnixNano()
+	s.span.Attributes["duration_ms"] = s.span.End.Sub(s.span.Start).Milliseconds()
+	span := s.span
+	s.mu.Unlock()
+	s.tracer.exporter.ExportSpan(span)
+}
+
+var traceIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// TraceIDFromRequestID maps a request ID onto a trace ID so spans can be
+// found by the ID the caller already has. IDs that are already 32 lowercase
+// hex characters (a W3C trace ID) are used as-is; anything else is hashed.
+// An empty request ID yields "", leaving Start to pick a random trace.
+func TraceIDFromRequestID(requestID string) string {
+	requestID = strings.TrimSpace(requestID)
+	if requestID == "" {
+		return ""
+	}
+	if traceIDPattern.MatchString(requestID) {
+		return requestID
+	}
+	sum := sha256.Sum256([]byte(requestID))
+	return hex.EncodeToString(sum[:16])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// InMemoryExporter keeps spans in memory, mainly for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+func (e *InMemoryExporter) ExportSpan(span Span) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	e.mu.Unlock()
+}
+
+// Spans returns the exported spans in the order they ended.
+func (e *InMemoryExporter) Spans() []Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Span(nil), e.spans...)
+}
+
+// JSONExporter writes one JSON span per line, suitable for a collector's
+// file receiver.
+type JSONExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w}
+}
+
+func (e *JSONExporter) ExportSpan(span Span) {
+	line, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, _ = e.w.Write(append(line, '\n'))
+}
@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNilTracerIsNoOp(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.Start("apply", SpanContext{})
+	span.SetAttribute("action", "start_vm")
+	span.RecordError(errors.New("boom"))
+	span.End()
+	if got := span.Context(); got != (SpanContext{}) {
+		t.Fatalf("expected empty context from disabled tracer, got %+v", got)
+	}
+}
+
+func TestTraceIDFromRequestID(t *testing.T) {
+	w3c := "4bf92f3577b34da6a3ce929d0e0e4736"
+	if got := TraceIDFromRequestID(w3c); got != w3c {
+		t.Fatalf("expected W3C trace ID to pass through, got %q", got)
+	}
+	got := TraceIDFromRequestID("req-123")
+	if len(got) != 32 || got != TraceIDFromRequestID("req-123") {
+		t.Fatalf("expected stable 32-char trace ID, got %q", got)
+	}
+	if TraceIDFromRequestID("") != "" {
+		t.Fatal("expected empty request ID to yield no trace ID")
+	}
+}
+
+func TestEndExportsOnce(t *testing.T) {
+	exporter := &InMemoryExporter{}
+	span := New(exporter).Start("plan", SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"})
+	span.End()
+	span.End()
+
+	spans := exporter.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected one exported span, got %d", len(spans))
+	}
+	if spans[0].ParentSpanID != "00f067aa0ba902b7" || spans[0].TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected span under parent, got %+v", spans[0])
+	}
+}